@@ -2,16 +2,13 @@ package cmd
 
 import (
 	"context"
-	"net/http"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/metal-toolbox/audito-maldito/internal/health"
 	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
@@ -22,6 +19,24 @@ DESCRIPTION
   produces structured audit events describing what authenticated users
   did while logged in (e.g., what programs they executed).
 
+COMMANDS
+  verify    Verify the hash chain of audit events (see 'audito-maldito verify -h')
+  schema    Print the audit event schemas or validate events (see 'audito-maldito schema -h')
+  config    Print the effective configuration (see 'audito-maldito config validate -h')
+  sessions  List the audit sessions of the running daemon (see 'audito-maldito sessions -h')
+
+CONFIGURATION
+  Settings are read from, in increasing order of precedence, their
+  default values, a YAML configuration file (-config or ` + ConfigEnvVar + `),
+  environment variables (e.g., ` + EnvVarPrefix + `_TUNING_EVENT_TIMEOUT) and the
+  flags below. Flags' default values reflect the file and environment.
+
+  SIGHUP reloads the outputs, filters, target labels and log level
+  without restarting the daemon (refer to -config-watch).
+
+  SIGTERM stops the daemon once the events that were read have been
+  written, or once -shutdown-timeout expires.
+
 OPTIONS
 `
 
@@ -36,71 +51,28 @@ const (
 	DefaultAuditCheckInterval = 15 * time.Second
 	// DefaultAuditModifyTimeThreshold seconds since last write to audit.log before alerting.
 	DefaultAuditModifyTimeThreshold = 86400
+	// DefaultLivenessStallTimeout is how long a pipeline stage may have work
+	// to do without making progress before /livez reports it as stalled.
+	DefaultLivenessStallTimeout = time.Minute
+	// DefaultLivenessWriteTimeout is how long writing an event may block
+	// before /livez reports the writer as stalled.
+	DefaultLivenessWriteTimeout = 30 * time.Second
 )
 
-type metricsConfig struct {
-	enableMetrics                    bool
-	enableHealthz                    bool
-	enableAuditMetrics               bool
-	httpServerReadTimeout            time.Duration
-	httpServerReadHeaderTimeout      time.Duration
-	auditMetricsSecondsInterval      time.Duration
-	auditLogWriteTimeSecondThreshold int
-}
-
-// handleMetricsAndHealth starts a HTTP server on port 2112 to serve metrics
-// and health endpoints.
-//
-// If metrics are disabled, the /metrics endpoint will return 404.
-// If health is disabled, the /readyz endpoint will return 404.
-// If both are disabled, the HTTP server will not be started.
-func handleMetricsAndHealth(ctx context.Context, mc metricsConfig, eg *errgroup.Group, h *health.Health) {
-	server := &http.Server{
-		Addr:              ":2112",
-		ReadTimeout:       mc.httpServerReadTimeout,
-		ReadHeaderTimeout: mc.httpServerReadHeaderTimeout,
-	}
-
-	if mc.enableMetrics {
-		http.Handle("/metrics", promhttp.Handler())
-	}
-
-	if mc.enableHealthz {
-		http.Handle("/readyz", h.ReadyzHandler())
-		// TODO: Add livez endpoint
-	}
-
-	if mc.enableMetrics || mc.enableHealthz {
-		eg.Go(func() error {
-			logger.Infof("starting HTTP server on address '%s'...", server.Addr)
-			if err := server.ListenAndServe(); err != nil {
-				return err
-			}
-			return nil
-		})
-
-		eg.Go(func() error {
-			<-ctx.Done()
-			logger.Infoln("stopping HTTP server...")
-			return server.Shutdown(ctx)
-		})
-	}
-}
-
 func handleAuditLogMetrics(
 	ctx context.Context,
 	mc metricsConfig,
 	eg *errgroup.Group,
 	pprov *metrics.PrometheusMetricsProvider,
 ) {
-	if !mc.enableAuditMetrics {
+	if !mc.EnableAuditMetrics {
 		return
 	}
 
-	auditLogFilePath := "/var/log/audit/audit.log"
+	auditLogFilePath := mc.AuditLogPath
 
 	eg.Go(func() error {
-		ticker := time.NewTicker(mc.auditMetricsSecondsInterval)
+		ticker := time.NewTicker(mc.AuditMetricsInterval)
 		defer ticker.Stop()
 
 		for {
@@ -112,10 +84,10 @@ func handleAuditLogMetrics(
 					continue
 				}
 
-				if time.Since(s.ModTime()).Seconds() > float64(mc.auditLogWriteTimeSecondThreshold) {
-					pprov.SetAuditLogCheck(0, strconv.Itoa(mc.auditLogWriteTimeSecondThreshold))
+				if time.Since(s.ModTime()).Seconds() > float64(mc.AuditLogWriteTimeSecThreshold) {
+					pprov.SetAuditLogCheck(0, strconv.Itoa(mc.AuditLogWriteTimeSecThreshold))
 				} else {
-					pprov.SetAuditLogCheck(1, strconv.Itoa(mc.auditLogWriteTimeSecondThreshold))
+					pprov.SetAuditLogCheck(1, strconv.Itoa(mc.AuditLogWriteTimeSecThreshold))
 				}
 
 				pprov.SetAuditLogModifyTime(float64(s.ModTime().Unix()))
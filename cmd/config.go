@@ -0,0 +1,861 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+const (
+	// ConfigEnvVar is the environment variable that specifies the
+	// path to the configuration file, if -config is not specified.
+	ConfigEnvVar = "AUDITO_MALDITO_CONFIG"
+
+	// EnvVarPrefix prefixes the names of the environment
+	// variables that override configuration file settings.
+	EnvVarPrefix = "AUDITO_MALDITO"
+
+	// DefaultMetricsAddress is the default address of the
+	// metrics and health endpoints HTTP server.
+	DefaultMetricsAddress = ":2112"
+
+	// DefaultAuditLogPath is the default path of the audit
+	// log whose modification time is checked by audit metrics.
+	DefaultAuditLogPath = "/var/log/audit/audit.log"
+
+	// DefaultAuditLogBufferSize is the default number of
+	// audit log lines buffered between the auditd named pipe
+	// ingester and the auditd processor.
+	DefaultAuditLogBufferSize = 10000
+
+	// DefaultReloadDrainTimeout is the default maximum duration
+	// for which the previous outputs' queued events are written
+	// after the configuration is reloaded.
+	DefaultReloadDrainTimeout = 10 * time.Second
+
+	// DefaultLifecycleHeartbeatInterval is the default interval
+	// at which AgentLifecycle heartbeat events are written.
+	DefaultLifecycleHeartbeatInterval = 15 * time.Minute
+
+	// DefaultShutdownTimeout is the default maximum duration of a
+	// graceful shutdown, after which the pipeline is stopped even
+	// if events are still being processed.
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// config is the configuration of the audito-maldito daemon. Settings
+// are read from (in increasing order of precedence) their default
+// values, a YAML configuration file, environment variables and
+// command line flags (refer to loadConfig).
+type config struct {
+	LogLevel    zapcore.Level     `yaml:"logLevel"`
+	Inputs      inputsConfig      `yaml:"inputs"`
+	Outputs     outputsConfig     `yaml:"outputs"`
+	Filters     filtersConfig     `yaml:"filters"`
+	Enrichment  enrichmentConfig  `yaml:"enrichment"`
+	Transcripts transcriptsConfig `yaml:"transcripts"`
+	Metrics     metricsConfig     `yaml:"metrics"`
+	Tuning      tuningConfig      `yaml:"tuning"`
+	Reload      reloadConfig      `yaml:"reload"`
+	Debug       debugConfig       `yaml:"debug"`
+	Lifecycle   lifecycleConfig   `yaml:"lifecycle"`
+	Shutdown    shutdownConfig    `yaml:"shutdown"`
+
+	// path is the path to the configuration
+	// file, or an empty string if there is none.
+	path string
+}
+
+type inputsConfig struct {
+	SshdPipePath   string `yaml:"sshdPipePath"`
+	AuditdPipePath string `yaml:"auditdPipePath"`
+
+	// PipeMode is the octal permissions (e.g., "0600") of
+	// the named pipes that are created if they do not exist.
+	PipeMode string `yaml:"pipeMode"`
+}
+
+// pipeMode returns the permissions of the named pipes.
+func (o inputsConfig) pipeMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(o.PipeMode, 8, 32)
+	if err != nil || os.FileMode(mode) != os.FileMode(mode).Perm() {
+		return 0, fmt.Errorf("invalid pipe mode: %q (expected octal permissions, e.g., \"0600\")", o.PipeMode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+type outputsConfig struct {
+	AppEvents appEventsConfig `yaml:"appEvents"`
+	Sinks     []string        `yaml:"sinks"`
+	HashChain hashChainConfig `yaml:"hashChain"`
+}
+
+type appEventsConfig struct {
+	Path      string        `yaml:"path"`
+	Format    string        `yaml:"format"`
+	MaxSize   int64         `yaml:"maxSize"`
+	MaxAge    time.Duration `yaml:"maxAge"`
+	Compress  bool          `yaml:"compress"`
+	MaxFiles  int           `yaml:"maxFiles"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (o appEventsConfig) rotation() sinks.RotationConfig {
+	return sinks.RotationConfig{
+		MaxSize:   o.MaxSize,
+		MaxAge:    o.MaxAge,
+		Compress:  o.Compress,
+		MaxFiles:  o.MaxFiles,
+		Retention: o.Retention,
+	}
+}
+
+type hashChainConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	SigningKey     string `yaml:"signingKey"`
+	AnchorInterval int    `yaml:"anchorInterval"`
+}
+
+type filtersConfig struct {
+	RedactionPolicy string `yaml:"redactionPolicy"`
+}
+
+type enrichmentConfig struct {
+	TargetLabels       map[string]string `yaml:"targetLabels"`
+	TargetLabelsConfig string            `yaml:"targetLabelsConfig"`
+	AuditRuleKeysFile  string            `yaml:"auditRuleKeysFile"`
+}
+
+type transcriptsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	Dir         string        `yaml:"dir"`
+	RedactAfter []string      `yaml:"redactAfter"`
+}
+
+func (o transcriptsConfig) transcriptConfig() sessiontracker.TranscriptConfig {
+	return sessiontracker.TranscriptConfig{
+		Enabled:     o.Enabled,
+		Interval:    o.Interval,
+		Dir:         o.Dir,
+		RedactAfter: o.RedactAfter,
+	}
+}
+
+type metricsConfig struct {
+	Address                       string          `yaml:"address"`
+	EnableMetrics                 bool            `yaml:"metrics"`
+	EnableHealthz                 bool            `yaml:"healthz"`
+	EnableAuditMetrics            bool            `yaml:"auditMetrics"`
+	HTTPServerReadTimeout         time.Duration   `yaml:"readTimeout"`
+	HTTPServerReadHeaderTimeout   time.Duration   `yaml:"readHeaderTimeout"`
+	AuditLogPath                  string          `yaml:"auditLogPath"`
+	AuditMetricsInterval          time.Duration   `yaml:"auditCheckInterval"`
+	AuditLogWriteTimeSecThreshold int             `yaml:"auditLogModifySecondsThreshold"`
+	LivenessStallTimeout          time.Duration   `yaml:"livenessStallTimeout"`
+	LivenessWriteTimeout          time.Duration   `yaml:"livenessWriteTimeout"`
+	Endpoints                     []string        `yaml:"endpoints"`
+	TLS                           serverTLSConfig `yaml:"tls"`
+}
+
+// serverTLSConfig configures the TLS of an HTTP server. Client
+// certificates are required and verified if ClientCAFile is set.
+type serverTLSConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// enabled returns true if the server uses TLS.
+func (o serverTLSConfig) enabled() bool {
+	return o.CertFile != ""
+}
+
+type tuningConfig struct {
+	AuditLogBufferSize       int           `yaml:"auditLogBufferSize"`
+	MaxEventsInFlight        int           `yaml:"maxEventsInFlight"`
+	EventTimeout             time.Duration `yaml:"eventTimeout"`
+	ReassemblerInterval      time.Duration `yaml:"reassemblerInterval"`
+	StaleDataCleanupInterval time.Duration `yaml:"staleDataCleanupInterval"`
+}
+
+func (o tuningConfig) auditdTuning() auditd.Tuning {
+	return auditd.Tuning{
+		MaxEventsInFlight:        o.MaxEventsInFlight,
+		EventTimeout:             o.EventTimeout,
+		ReassemblerInterval:      o.ReassemblerInterval,
+		StaleDataCleanupInterval: o.StaleDataCleanupInterval,
+	}
+}
+
+type reloadConfig struct {
+	Watch        bool          `yaml:"watch"`
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+}
+
+type debugConfig struct {
+	Socket string `yaml:"socket"`
+}
+
+type lifecycleConfig struct {
+	// HeartbeatInterval is the interval at which heartbeat
+	// events are written. Zero disables them.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+}
+
+type shutdownConfig struct {
+	// Timeout is the maximum duration of a graceful shutdown,
+	// starting when the signal to stop is received.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// defaultConfig returns the configuration used when
+// no file, environment variable or flag is specified.
+func defaultConfig() *config {
+	return &config{
+		LogLevel: zapcore.InfoLevel,
+		Inputs: inputsConfig{
+			SshdPipePath:   "/app-audit/sshd-pipe",
+			AuditdPipePath: "/app-audit/audit-pipe",
+			PipeMode:       fmt.Sprintf("%04o", namedpipe.DefaultMode),
+		},
+		Outputs: outputsConfig{
+			AppEvents: appEventsConfig{
+				Path:   "/app-audit/app-events-output.log",
+				Format: string(sinks.FormatJSON),
+			},
+			HashChain: hashChainConfig{
+				AnchorInterval: sinks.DefaultChainAnchorInterval,
+			},
+		},
+		Transcripts: transcriptsConfig{
+			RedactAfter: append([]string(nil), sessiontracker.DefaultTranscriptRedactAfter...),
+		},
+		Metrics: metricsConfig{
+			Address:                       DefaultMetricsAddress,
+			HTTPServerReadTimeout:         DefaultHTTPServerReadTimeout,
+			HTTPServerReadHeaderTimeout:   DefaultHTTPServerReadHeaderTimeout,
+			AuditLogPath:                  DefaultAuditLogPath,
+			AuditMetricsInterval:          DefaultAuditCheckInterval,
+			AuditLogWriteTimeSecThreshold: DefaultAuditModifyTimeThreshold,
+			LivenessStallTimeout:          DefaultLivenessStallTimeout,
+			LivenessWriteTimeout:          DefaultLivenessWriteTimeout,
+		},
+		Tuning: tuningConfig{
+			AuditLogBufferSize:       DefaultAuditLogBufferSize,
+			MaxEventsInFlight:        auditd.DefaultMaxEventsInFlight,
+			EventTimeout:             auditd.DefaultEventTimeout,
+			ReassemblerInterval:      auditd.DefaultReassemblerInterval,
+			StaleDataCleanupInterval: auditd.DefaultStaleDataCleanupInterval,
+		},
+		Reload: reloadConfig{
+			DrainTimeout: DefaultReloadDrainTimeout,
+		},
+		Lifecycle: lifecycleConfig{
+			HeartbeatInterval: DefaultLifecycleHeartbeatInterval,
+		},
+		Shutdown: shutdownConfig{
+			Timeout: DefaultShutdownTimeout,
+		},
+	}
+}
+
+// loadConfig returns the configuration described by the command line
+// arguments in osArgs, the configuration file (if any) and environment
+// variables (looked up using getenv). The returned FlagSet has parsed
+// osArgs, so its remaining arguments may be used by the caller.
+//
+// The arguments are parsed twice: once to find the configuration file,
+// and once more after the file and environment variables were applied,
+// so that flags take precedence over both.
+func loadConfig(osArgs []string, getenv func(string) string, usageText string) (*config, *flag.FlagSet, error) {
+	var configPath string
+
+	flagSet := defaultConfig().flagSet(osArgs[0], &configPath, usageText)
+
+	err := flagSet.Parse(osArgs[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(1)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if configPath == "" {
+		configPath = getenv(ConfigEnvVar)
+	}
+
+	cfg := defaultConfig()
+
+	if configPath != "" {
+		err = cfg.readFile(configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = cfg.applyEnv(getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flagSet = cfg.flagSet(osArgs[0], &configPath, usageText)
+
+	err = flagSet.Parse(osArgs[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg.path = configPath
+
+	return cfg, flagSet, nil
+}
+
+// readFile updates the configuration using the YAML file at filePath.
+// Settings that are not in the file are left unchanged.
+func (o *config) readFile(filePath string) error {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	err = dec.Decode(o)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %q: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// applyEnv updates the configuration using environment variables.
+// Each setting's variable is EnvVarPrefix followed by the upper-cased
+// path of the setting, where words are separated by underscores (e.g.,
+// "AUDITO_MALDITO_TUNING_EVENT_TIMEOUT" for tuning.eventTimeout).
+// Empty variables are ignored.
+func (o *config) applyEnv(getenv func(string) string) error {
+	return applyEnvToStruct(reflect.ValueOf(o).Elem(), EnvVarPrefix, getenv)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func applyEnvToStruct(v reflect.Value, prefix string, getenv func(string) string) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+
+		field := v.Field(i)
+		name := prefix + "_" + envVarWord(t.Field(i).Tag.Get("yaml"))
+
+		if field.Kind() == reflect.Struct && !reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+			err := applyEnvToStruct(field, name, getenv)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		err := setFromString(field, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s environment variable: %q - %w", name, value, err)
+		}
+	}
+
+	return nil
+}
+
+// envVarWord converts a camel case YAML key to upper
+// case words separated by underscores.
+func envVarWord(key string) string {
+	var b strings.Builder
+
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(runes[i-1]) {
+			b.WriteByte('_')
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// setFromString sets a configuration field from its string form. Lists
+// are comma-separated, and maps are comma-separated "key=value" pairs.
+func setFromString(field reflect.Value, value string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(d))
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case int, int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(i)
+	case []string:
+		field.Set(reflect.ValueOf(splitCommaList(value)))
+	case map[string]string:
+		m := make(map[string]string)
+		for _, pair := range splitCommaList(value) {
+			err := setKeyValue(m, pair)
+			if err != nil {
+				return err
+			}
+		}
+
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type: %s", field.Type())
+	}
+
+	return nil
+}
+
+func splitCommaList(s string) []string {
+	var list []string
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// setKeyValue parses a "key=value" pair and sets it in m.
+func setKeyValue(m map[string]string, pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid pair: %q (expected 'name=value')", pair)
+	}
+
+	m[key] = value
+
+	return nil
+}
+
+// validate checks the settings that do not require
+// reading files or connecting to other systems.
+func (o *config) validate() error {
+	if o.Outputs.AppEvents.Path == "" && len(o.Outputs.Sinks) == 0 {
+		return errors.New("please specify -app-events-output and/or -sink")
+	}
+
+	_, err := sinks.NewEncoder(sinks.Format(o.Outputs.AppEvents.Format))
+	if err != nil {
+		return fmt.Errorf("invalid -app-events-output-format: %w", err)
+	}
+
+	for _, spec := range o.Outputs.Sinks {
+		u, err := url.Parse(spec)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid -sink: %q (expected a URL)", spec)
+		}
+	}
+
+	if o.Outputs.HashChain.SigningKey != "" && !o.Outputs.HashChain.Enabled {
+		return errors.New("-hash-chain-signing-key requires -hash-chain")
+	}
+
+	if o.Inputs.SshdPipePath == "" || o.Inputs.AuditdPipePath == "" {
+		return errors.New("the sshd and auditd pipe paths must not be empty")
+	}
+
+	_, err = o.Inputs.pipeMode()
+	if err != nil {
+		return err
+	}
+
+	err = o.Metrics.validate()
+	if err != nil {
+		return err
+	}
+
+	if o.Metrics.LivenessStallTimeout <= 0 || o.Metrics.LivenessWriteTimeout <= 0 {
+		return errors.New("the liveness timeouts must be positive")
+	}
+
+	if o.Tuning.AuditLogBufferSize < 0 {
+		return errors.New("the audit log buffer size must not be negative")
+	}
+
+	if o.Reload.DrainTimeout <= 0 {
+		return errors.New("the reload drain timeout must be positive")
+	}
+
+	if o.Lifecycle.HeartbeatInterval < 0 {
+		return errors.New("the lifecycle heartbeat interval must not be negative")
+	}
+
+	if o.Shutdown.Timeout <= 0 {
+		return errors.New("the shutdown timeout must be positive")
+	}
+
+	return nil
+}
+
+// redacted returns a copy of the configuration in which
+// the passwords of the sinks' URLs are redacted.
+func (o *config) redacted() *config {
+	redacted := *o
+	redacted.Outputs.Sinks = make([]string, len(o.Outputs.Sinks))
+
+	for i, spec := range o.Outputs.Sinks {
+		u, err := url.Parse(spec)
+		if err != nil {
+			redacted.Outputs.Sinks[i] = spec
+			continue
+		}
+
+		redacted.Outputs.Sinks[i] = u.Redacted()
+	}
+
+	return &redacted
+}
+
+// flagSet returns a FlagSet whose flags update the configuration.
+// The current settings are the flags' default values. The -config
+// flag sets configPath.
+func (o *config) flagSet(name string, configPath *string, usageText string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flagSet.Usage = func() {
+		os.Stderr.WriteString(usageText)
+		flagSet.PrintDefaults()
+	}
+
+	flagSet.StringVar(
+		configPath,
+		"config",
+		*configPath,
+		"Optional path to a YAML configuration file (defaults to the "+ConfigEnvVar+" environment variable)")
+	flagSet.BoolVar(
+		&o.Reload.Watch,
+		"config-watch",
+		o.Reload.Watch,
+		"Reload the configuration when the configuration file or the files it refers to change\n"+
+			"(the configuration is always reloaded on SIGHUP)")
+	flagSet.DurationVar(
+		&o.Reload.DrainTimeout,
+		"reload-drain-timeout",
+		o.Reload.DrainTimeout,
+		"Maximum duration for which the previous outputs' queued events are written after a reload")
+	flagSet.Var(&o.LogLevel, "log-level", "Set the log level according to zapcore.Level")
+	flagSet.BoolVar(&o.Metrics.EnableMetrics, "metrics", o.Metrics.EnableMetrics, "Enable Prometheus HTTP /metrics server")
+	flagSet.BoolVar(&o.Metrics.EnableHealthz, "healthz", o.Metrics.EnableHealthz, "Enable HTTP health endpoints server")
+	flagSet.BoolVar(&o.Metrics.EnableAuditMetrics, "audit-metrics", o.Metrics.EnableAuditMetrics, "Enable Prometheus audit metrics")
+	flagSet.StringVar(&o.Metrics.Address, "metrics-address", o.Metrics.Address,
+		"Address of the HTTP server of the metrics and health endpoints\n"+
+			"(e.g., '127.0.0.1:2112' or 'unix:///run/audito-maldito/metrics.sock')")
+	flagSet.Var(
+		&commaListFlag{list: &o.Metrics.Endpoints},
+		"metrics-endpoints",
+		"Comma-separated list of the endpoints served by the metrics server ('metrics', 'readyz',\n"+
+			"'livez' and 'sessions'). Defaults to the endpoints enabled by -metrics and -healthz")
+	flagSet.StringVar(
+		&o.Metrics.TLS.CertFile,
+		"metrics-tls-cert-file",
+		o.Metrics.TLS.CertFile,
+		"Optional path to the PEM-encoded certificate of the metrics server, which enables TLS")
+	flagSet.StringVar(
+		&o.Metrics.TLS.KeyFile,
+		"metrics-tls-key-file",
+		o.Metrics.TLS.KeyFile,
+		"Path to the PEM-encoded private key of the metrics server's certificate")
+	flagSet.StringVar(
+		&o.Metrics.TLS.ClientCAFile,
+		"metrics-tls-client-ca-file",
+		o.Metrics.TLS.ClientCAFile,
+		"Optional path to PEM-encoded CA certificates that the metrics server's clients must present\n"+
+			"a certificate signed by")
+
+	flagSet.DurationVar(&o.Metrics.HTTPServerReadTimeout, "http-server-read-timeout",
+		o.Metrics.HTTPServerReadTimeout, "HTTP server read timeout")
+	flagSet.DurationVar(&o.Metrics.HTTPServerReadHeaderTimeout, "http-server-read-header-timeout",
+		o.Metrics.HTTPServerReadHeaderTimeout, "HTTP server read header timeout")
+	flagSet.DurationVar(
+		&o.Metrics.AuditMetricsInterval,
+		"audit-seconds-interval",
+		o.Metrics.AuditMetricsInterval,
+		"Interval in seconds to collect audit metrics")
+	flagSet.IntVar(
+		&o.Metrics.AuditLogWriteTimeSecThreshold,
+		"audit-log-last-modify-seconds-threshold",
+		o.Metrics.AuditLogWriteTimeSecThreshold,
+		"seconds since last write to audit.log before alerting")
+	flagSet.DurationVar(
+		&o.Metrics.LivenessStallTimeout,
+		"liveness-stall-timeout",
+		o.Metrics.LivenessStallTimeout,
+		"Duration for which a pipeline stage may have work to do without making progress before /livez fails")
+	flagSet.DurationVar(
+		&o.Metrics.LivenessWriteTimeout,
+		"liveness-write-timeout",
+		o.Metrics.LivenessWriteTimeout,
+		"Duration for which writing an event may block before /livez fails")
+
+	flagSet.StringVar(
+		&o.Debug.Socket,
+		"debug-socket",
+		o.Debug.Socket,
+		"Optional path to a unix socket serving the state of the active audit sessions\n"+
+			"(refer to 'audito-maldito sessions')")
+
+	flagSet.DurationVar(
+		&o.Lifecycle.HeartbeatInterval,
+		"lifecycle-heartbeat-interval",
+		o.Lifecycle.HeartbeatInterval,
+		"Interval at which AgentLifecycle heartbeat events are written (0 disables them)")
+
+	flagSet.DurationVar(
+		&o.Shutdown.Timeout,
+		"shutdown-timeout",
+		o.Shutdown.Timeout,
+		"Maximum duration for which queued events are processed and written after SIGTERM")
+
+	flagSet.StringVar(
+		&o.Outputs.AppEvents.Path,
+		"app-events-output",
+		o.Outputs.AppEvents.Path,
+		"Path to the app events output (an empty string disables it if -sink is specified)")
+	flagSet.StringVar(
+		&o.Outputs.AppEvents.Format,
+		"app-events-output-format",
+		o.Outputs.AppEvents.Format,
+		"Format of the app events output ('json', 'cef', 'leef' or 'ecs')")
+	flagSet.Int64Var(
+		&o.Outputs.AppEvents.MaxSize,
+		"app-events-output-max-size",
+		o.Outputs.AppEvents.MaxSize,
+		"Size in bytes at which the app events output file is rotated (0 disables rotation by size)")
+	flagSet.DurationVar(
+		&o.Outputs.AppEvents.MaxAge,
+		"app-events-output-max-age",
+		o.Outputs.AppEvents.MaxAge,
+		"Age at which the app events output file is rotated (0 disables rotation by age)")
+	flagSet.BoolVar(
+		&o.Outputs.AppEvents.Compress,
+		"app-events-output-compress",
+		o.Outputs.AppEvents.Compress,
+		"Compress rotated app events output files using gzip")
+	flagSet.IntVar(
+		&o.Outputs.AppEvents.MaxFiles,
+		"app-events-output-max-files",
+		o.Outputs.AppEvents.MaxFiles,
+		"Maximum number of rotated app events output files to keep (0 means no limit)")
+	flagSet.DurationVar(
+		&o.Outputs.AppEvents.Retention,
+		"app-events-output-retention",
+		o.Outputs.AppEvents.Retention,
+		"Duration for which rotated app events output files are kept (0 means no limit)")
+	flagSet.Var(
+		&stringListFlag{list: &o.Outputs.Sinks},
+		"sink",
+		"Additional event output URL (e.g., 'stdout:', 'tcp://host:port?buffer=1000&on-error=log'\n"+
+			"or 'otlp+grpcs://collector:4317'). May be specified more than once, in which case\n"+
+			"the sinks of the configuration file are replaced")
+	flagSet.BoolVar(
+		&o.Outputs.HashChain.Enabled,
+		"hash-chain",
+		o.Outputs.HashChain.Enabled,
+		"Link each event to the previous one using a hash chain (refer to 'audito-maldito verify')")
+	flagSet.StringVar(
+		&o.Outputs.HashChain.SigningKey,
+		"hash-chain-signing-key",
+		o.Outputs.HashChain.SigningKey,
+		"Optional path to a PEM-encoded ed25519 private key used to sign hash chain anchors")
+	flagSet.IntVar(
+		&o.Outputs.HashChain.AnchorInterval,
+		"hash-chain-anchor-interval",
+		o.Outputs.HashChain.AnchorInterval,
+		"Number of events between signed hash chain anchors")
+	flagSet.StringVar(
+		&o.Inputs.SshdPipePath,
+		"sshd-pipe-path",
+		o.Inputs.SshdPipePath,
+		"Path to the sshd log named pipe file")
+	flagSet.StringVar(
+		&o.Inputs.AuditdPipePath,
+		"auditd-pipe-path",
+		o.Inputs.AuditdPipePath,
+		"Path to the audit log named pipe file")
+	flagSet.StringVar(
+		&o.Inputs.PipeMode,
+		"pipe-mode",
+		o.Inputs.PipeMode,
+		"Octal permissions of the named pipes, which are created if they do not exist")
+	flagSet.StringVar(
+		&o.Filters.RedactionPolicy,
+		"redaction-policy",
+		o.Filters.RedactionPolicy,
+		"Optional path to a JSON file describing how events are redacted and pseudonymized before they are written")
+	flagSet.Var(
+		&keyValueFlag{m: &o.Enrichment.TargetLabels},
+		"target-label",
+		"Static label added to the target of every event (e.g., 'cluster=prod-1').\n"+
+			"May be specified more than once")
+	flagSet.StringVar(
+		&o.Enrichment.TargetLabelsConfig,
+		"target-labels-config",
+		o.Enrichment.TargetLabelsConfig,
+		"Optional path to a JSON file describing static and file-derived labels added to the target of every event")
+	flagSet.StringVar(
+		&o.Enrichment.AuditRuleKeysFile,
+		"audit-rule-keys-file",
+		o.Enrichment.AuditRuleKeysFile,
+		"Optional path to a JSON file mapping audit rule keys to a severity and category")
+	flagSet.BoolVar(
+		&o.Transcripts.Enabled,
+		"tty-transcripts",
+		o.Transcripts.Enabled,
+		"Collect TTY input (e.g., from pam_tty_audit) into per-session transcripts")
+	flagSet.DurationVar(
+		&o.Transcripts.Interval,
+		"tty-transcript-interval",
+		o.Transcripts.Interval,
+		"Interval at which transcripts of active sessions are written (0 means only at logout)")
+	flagSet.StringVar(
+		&o.Transcripts.Dir,
+		"tty-transcript-dir",
+		o.Transcripts.Dir,
+		"Optional directory to which per-session transcript files are written")
+	flagSet.Var(
+		&commaListFlag{list: &o.Transcripts.RedactAfter},
+		"tty-transcript-redact-after",
+		"Comma-separated list of programs after which the next line of TTY input is redacted")
+
+	return flagSet
+}
+
+// stringListFlag is a flag.Value that can be specified more than
+// once. The first occurrence replaces the list's current values.
+type stringListFlag struct {
+	list *[]string
+	set  bool
+}
+
+func (o *stringListFlag) String() string {
+	if o.list == nil {
+		return ""
+	}
+
+	return strings.Join(*o.list, ",")
+}
+
+func (o *stringListFlag) Set(s string) error {
+	if !o.set {
+		*o.list = nil
+		o.set = true
+	}
+
+	*o.list = append(*o.list, s)
+
+	return nil
+}
+
+// commaListFlag is a flag.Value that sets a list
+// from a comma-separated string.
+type commaListFlag struct {
+	list *[]string
+}
+
+func (o *commaListFlag) String() string {
+	if o.list == nil {
+		return ""
+	}
+
+	return strings.Join(*o.list, ",")
+}
+
+func (o *commaListFlag) Set(s string) error {
+	*o.list = splitCommaList(s)
+	return nil
+}
+
+// keyValueFlag is a flag.Value that adds "key=value"
+// pairs to a map. It can be specified more than once.
+type keyValueFlag struct {
+	m *map[string]string
+}
+
+func (o *keyValueFlag) String() string {
+	if o.m == nil {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(*o.m))
+	for k, v := range *o.m {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (o *keyValueFlag) Set(s string) error {
+	if *o.m == nil {
+		*o.m = make(map[string]string)
+	}
+
+	return setKeyValue(*o.m, s)
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `audito-maldito config validate
+
+DESCRIPTION
+  config validate loads the configuration in the same way as the daemon
+  and prints the effective configuration as YAML. Settings are read
+  from, in increasing order of precedence:
+
+    1. Default values
+    2. The YAML configuration file (-config or ` + ConfigEnvVar + `)
+    3. Environment variables (e.g., ` + EnvVarPrefix + `_TUNING_EVENT_TIMEOUT)
+    4. Command line flags
+
+  The files referred to by the configuration (e.g., the redaction
+  policy) are read and checked as well. Passwords in sink URLs are
+  redacted from the output.
+
+SYNOPSIS
+  audito-maldito config validate [options]
+
+OPTIONS
+`
+
+// RunConfig runs the config command using the arguments specified in
+// osArgs and writes the effective configuration to w. A non-nil error
+// is returned if the configuration is invalid.
+func RunConfig(osArgs []string, w io.Writer) error {
+	if len(osArgs) < 2 || osArgs[1] != "validate" {
+		os.Stderr.WriteString(configUsage)
+		return errors.New("please specify a config command (e.g., 'validate')")
+	}
+
+	args := append([]string{osArgs[0] + " " + osArgs[1]}, osArgs[2:]...)
+
+	cfg, flagSet, err := loadConfig(args, os.Getenv, configUsage)
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %q", flagSet.Args())
+	}
+
+	_, err = readConfigFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	// The metrics server's TLS files are not part of the reloaded
+	// files, as the server reloads them when they are modified.
+	if cfg.Metrics.TLS.enabled() {
+		_, err = loadServerTLSConfig(cfg.Metrics.TLS)
+		if err != nil {
+			return err
+		}
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err = enc.Encode(cfg.redacted())
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// The actions of AgentLifecycle events, which are
+// stored in their metadata.extra.action.
+const (
+	lifecycleStartAction            = "start"
+	lifecycleStopAction             = "stop"
+	lifecycleReloadAction           = "reload"
+	lifecycleEventsLostAction       = "events-lost"
+	lifecycleInputReconnectedAction = "input-reconnected"
+	lifecycleSinkFailureAction      = "sink-failure"
+	lifecycleHeartbeatAction        = "heartbeat"
+)
+
+const (
+	// lifecycleQueueSize is the number of events reported by
+	// other goroutines that may wait for the reloader to write
+	// them. Events are dropped (and counted) when it is full.
+	lifecycleQueueSize = 64
+
+	// sinkFailureReportInterval is the minimum interval between two
+	// sink-failure events of the same output. The failures in between
+	// are counted by the next event. This also keeps an output that
+	// cannot write sink-failure events from reporting them endlessly.
+	sinkFailureReportInterval = time.Minute
+)
+
+// lifecycle builds the AgentLifecycle events, which describe what
+// happened to audito-maldito itself, so that gaps in the event stream
+// are visible in the stream itself. The events reported by other
+// goroutines (e.g., lost events) are queued until the reloader
+// writes them, as they may be reported while an event is written.
+type lifecycle struct {
+	target  map[string]string
+	started time.Time
+	version string
+	l       *zap.SugaredLogger
+
+	events        chan *auditevent.AuditEvent
+	sessions      *sessiontracker.Introspector
+	eventsWritten func() int64
+
+	eventsLost      atomic.Int64
+	sinkFailures    atomic.Int64
+	inputReconnects atomic.Int64
+	reloads         atomic.Int64
+	dropped         atomic.Int64
+
+	mu         sync.Mutex
+	configHash string
+
+	// lastSinkFailure maps output names to the time of their last
+	// sink-failure event, and suppressed to the number of failures
+	// since then.
+	lastSinkFailure map[string]time.Time
+	suppressed      map[string]int
+}
+
+func newLifecycle(target map[string]string, configHash string, l *zap.SugaredLogger) *lifecycle {
+	return &lifecycle{
+		target:          target,
+		started:         time.Now(),
+		version:         common.Version(),
+		l:               l,
+		events:          make(chan *auditevent.AuditEvent, lifecycleQueueSize),
+		configHash:      configHash,
+		lastSinkFailure: make(map[string]time.Time),
+		suppressed:      make(map[string]int),
+	}
+}
+
+// WithSessions sets the sessions whose numbers are
+// reported by heartbeat and stop events.
+func (o *lifecycle) WithSessions(sessions *sessiontracker.Introspector) *lifecycle {
+	o.sessions = sessions
+	return o
+}
+
+// WithEventsWritten sets the function that returns the number of
+// events written, which is reported by heartbeat and stop events.
+func (o *lifecycle) WithEventsWritten(eventsWritten func() int64) *lifecycle {
+	o.eventsWritten = eventsWritten
+	return o
+}
+
+// Events returns the channel of the queued events.
+func (o *lifecycle) Events() <-chan *auditevent.AuditEvent {
+	return o.events
+}
+
+// EventsLost queues an events-lost event for count
+// audit events that the auditd processor lost.
+func (o *lifecycle) EventsLost(count int) {
+	o.eventsLost.Add(int64(count))
+
+	evt := o.newEvent(lifecycleEventsLostAction,
+		auditevent.EventSource{Type: "input", Value: "auditd"}, auditevent.OutcomeFailed)
+	evt.Metadata.Extra["count"] = count
+
+	o.enqueue(evt)
+}
+
+// InputReconnected queues an input-reconnected event for
+// the input (e.g., "auditd") whose file was reopened.
+func (o *lifecycle) InputReconnected(input string, filePath string) {
+	o.inputReconnects.Add(1)
+
+	evt := o.newEvent(lifecycleInputReconnectedAction,
+		auditevent.EventSource{Type: "input", Value: input}, auditevent.OutcomeSucceeded)
+	evt.Metadata.Extra["path"] = filePath
+
+	o.enqueue(evt)
+}
+
+// SinkFailed queues a sink-failure event for an output that failed to
+// write an event or dropped it. It implements sinks.FailureHandler.
+func (o *lifecycle) SinkFailed(output string, err error) {
+	o.sinkFailures.Add(1)
+
+	o.mu.Lock()
+
+	now := time.Now()
+	if now.Sub(o.lastSinkFailure[output]) < sinkFailureReportInterval {
+		o.suppressed[output]++
+		o.mu.Unlock()
+		return
+	}
+
+	suppressed := o.suppressed[output]
+	o.lastSinkFailure[output] = now
+	delete(o.suppressed, output)
+
+	o.mu.Unlock()
+
+	evt := o.newEvent(lifecycleSinkFailureAction,
+		auditevent.EventSource{Type: "output", Value: output}, auditevent.OutcomeFailed)
+	evt.Metadata.Extra["error"] = err.Error()
+	evt.Metadata.Extra["suppressed"] = suppressed
+
+	o.enqueue(evt)
+}
+
+func (o *lifecycle) enqueue(evt *auditevent.AuditEvent) {
+	select {
+	case o.events <- evt:
+	default:
+		o.dropped.Add(1)
+		o.l.Errorf("dropped %s lifecycle event: queue is full (size: %d)",
+			evt.Metadata.Extra["action"], cap(o.events))
+	}
+}
+
+// startEvent returns the event that describes the daemon starting.
+func (o *lifecycle) startEvent() *auditevent.AuditEvent {
+	evt := o.newEvent(lifecycleStartAction, o.agentSource(), auditevent.OutcomeSucceeded)
+	evt.Metadata.Extra["config_hash"] = o.currentConfigHash()
+
+	return evt
+}
+
+// stopEvent returns the event that describes the daemon stopping.
+// err is the reason it is stopping, if it is because of a failure.
+func (o *lifecycle) stopEvent(err error) *auditevent.AuditEvent {
+	outcome := auditevent.OutcomeSucceeded
+	if err != nil {
+		outcome = auditevent.OutcomeFailed
+	}
+
+	evt := o.newEvent(lifecycleStopAction, o.agentSource(), outcome)
+	evt.Metadata.Extra["counters"] = o.counters()
+
+	if err != nil {
+		evt.Metadata.Extra["error"] = err.Error()
+	}
+
+	return evt
+}
+
+// heartbeatEvent returns an event that shows that the daemon is
+// running, along with counters of what happened since it started.
+func (o *lifecycle) heartbeatEvent() *auditevent.AuditEvent {
+	evt := o.newEvent(lifecycleHeartbeatAction, o.agentSource(), auditevent.OutcomeSucceeded)
+	evt.Metadata.Extra["config_hash"] = o.currentConfigHash()
+	evt.Metadata.Extra["counters"] = o.counters()
+
+	return evt
+}
+
+// reloadEvent returns the event that describes the outcome of a
+// configuration reload. err is the reason the reload failed, if any.
+func (o *lifecycle) reloadEvent(trigger auditevent.EventSource, restartRequired []string,
+	err error,
+) *auditevent.AuditEvent {
+	outcome := auditevent.OutcomeSucceeded
+	if err != nil {
+		outcome = auditevent.OutcomeFailed
+	}
+
+	o.reloads.Add(1)
+
+	evt := o.newEvent(lifecycleReloadAction, trigger, outcome)
+	evt.Metadata.Extra["config_hash"] = o.currentConfigHash()
+
+	if err != nil {
+		evt.Metadata.Extra["error"] = err.Error()
+	}
+
+	if len(restartRequired) > 0 {
+		evt.Metadata.Extra["restart_required"] = restartRequired
+	}
+
+	return evt
+}
+
+func (o *lifecycle) agentSource() auditevent.EventSource {
+	return auditevent.EventSource{
+		Type:  "agent",
+		Value: agentComponentName,
+	}
+}
+
+// setConfigHash sets the hash of the configuration in use,
+// which is reported by the events that follow.
+func (o *lifecycle) setConfigHash(configHash string) {
+	o.mu.Lock()
+	o.configHash = configHash
+	o.mu.Unlock()
+}
+
+func (o *lifecycle) currentConfigHash() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.configHash
+}
+
+// counters returns the number of occurrences of what the
+// lifecycle events describe since the daemon started.
+func (o *lifecycle) counters() map[string]any {
+	counters := map[string]any{
+		"uptime_seconds":    int64(time.Since(o.started).Seconds()),
+		"events_lost":       o.eventsLost.Load(),
+		"sink_failures":     o.sinkFailures.Load(),
+		"input_reconnects":  o.inputReconnects.Load(),
+		"reloads":           o.reloads.Load(),
+		"lifecycle_dropped": o.dropped.Load(),
+	}
+
+	if o.eventsWritten != nil {
+		counters["events_written"] = o.eventsWritten()
+	}
+
+	stats, ok := o.sessions.Stats()
+	if ok {
+		counters["sessions"] = stats.Sessions
+		counters["pending_logins"] = stats.PendingLogins
+		counters["cached_events"] = stats.CachedEvents
+	}
+
+	return counters
+}
+
+// newEvent returns an AgentLifecycle event for the action. Every
+// event includes the daemon's version, so that the version that
+// produced the events that follow it is known.
+func (o *lifecycle) newEvent(action string, source auditevent.EventSource, outcome string) *auditevent.AuditEvent {
+	target := make(map[string]string, len(o.target))
+	for k, v := range o.target {
+		target[k] = v
+	}
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionAgentLifecycle,
+		source,
+		outcome,
+		map[string]string{
+			"pid": strconv.Itoa(os.Getpid()),
+		},
+		agentComponentName,
+	).WithTarget(target)
+
+	evt.Metadata.Extra = map[string]any{
+		"action":  action,
+		"version": o.version,
+	}
+
+	return evt
+}
+
+// configHash returns the SHA-256 hash of the configuration, which
+// identifies the settings in use. Passwords are not part of it.
+func configHash(cfg *config) string {
+	b, err := yaml.Marshal(cfg.redacted())
+	if err != nil {
+		// The configuration was unmarshaled from
+		// YAML, so it can be marshaled back to it.
+		return ""
+	}
+
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
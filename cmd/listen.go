@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// listenUnixSocket listens on a unix socket at socketPath whose
+// permissions are set to mode. A socket left behind by a previous
+// instance is replaced, unless it is still in use.
+func listenUnixSocket(socketPath string, mode fs.FileMode) (net.Listener, error) {
+	info, err := os.Lstat(socketPath)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSocket == 0:
+		return nil, fmt.Errorf("%q exists and is not a socket", socketPath)
+	case err == nil:
+		conn, dialErr := net.Dial("unix", socketPath)
+		if dialErr == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("socket %q is in use by another process", socketPath)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	// The socket is created under a temporary name, so that it
+	// cannot be connected to before its permissions are set.
+	tmpPath := socketPath + "." + strconv.Itoa(os.Getpid())
+	_ = os.Remove(tmpPath)
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is renamed, so its temporary
+	// name must not be removed when it is closed.
+	listener.SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, mode)
+	if err == nil {
+		err = os.Rename(tmpPath, socketPath)
+	}
+
+	if err != nil {
+		_ = listener.Close()
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// serveUntilDone serves HTTP requests accepted by listener until ctx
+// is marked as done, after which the server is closed.
+//
+// The returned error is always non-nil.
+func serveUntilDone(ctx context.Context, server *http.Server, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("HTTP server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	_ = server.Close()
+
+	return ctx.Err()
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+// The names of the event pipeline's stages in the /livez response.
+// The pipe readers are also listed by name in the /readyz response.
+const (
+	sshdPipeReaderComponentName   = "sshd-pipe-reader"
+	auditdPipeReaderComponentName = "auditd-pipe-reader"
+	sshdProcessorComponentName    = "sshd-processor"
+	reassemblerComponentName      = "reassembler"
+	sessionTrackerComponentName   = "session-tracker"
+	writerComponentName           = "writer"
+)
+
+var _ sinks.EventSink = &heartbeatSink{}
+
+// heartbeatSink is an EventSink that records the
+// progress of the writes to another EventSink.
+type heartbeatSink struct {
+	next sinks.EventSink
+	hb   *health.Heartbeat
+
+	written atomic.Int64
+}
+
+func (o *heartbeatSink) Write(event *auditevent.AuditEvent) error {
+	o.hb.Start()
+	defer o.hb.Done()
+
+	err := o.next.Write(event)
+	if err != nil {
+		return err
+	}
+
+	o.written.Add(1)
+
+	return nil
+}
+
+// Written returns the number of events written successfully.
+func (o *heartbeatSink) Written() int64 {
+	return o.written.Load()
+}
+
+var _ sshd.SshdProcessor = &heartbeatSshdProcessor{}
+
+// heartbeatSshdProcessor is a sshd.SshdProcessor that records
+// the progress of another sshd.SshdProcessor.
+type heartbeatSshdProcessor struct {
+	next sshd.SshdProcessor
+	hb   *health.Heartbeat
+}
+
+func (o *heartbeatSshdProcessor) ProcessSshdLogEntry(ctx context.Context, sm sshd.SshdLogEntry) error {
+	o.hb.Start()
+	defer o.hb.Done()
+
+	return o.next.ProcessSshdLogEntry(ctx, sm)
+}
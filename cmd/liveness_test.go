@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+type blockingSink struct {
+	unblock chan struct{}
+}
+
+func (o *blockingSink) Write(*auditevent.AuditEvent) error {
+	<-o.unblock
+	return nil
+}
+
+func TestHeartbeatSink_BlockedWriter(t *testing.T) {
+	t.Parallel()
+
+	h := health.NewHealth()
+
+	next := &blockingSink{unblock: make(chan struct{})}
+	w := &heartbeatSink{
+		next: next,
+		hb:   h.AddHeartbeat(writerComponentName, 10*time.Millisecond),
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		written <- w.Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build())
+	}()
+
+	require.Eventually(t, func() bool {
+		return h.GetLivezStatus().Overall == health.ComponentStalled
+	}, time.Second, 5*time.Millisecond)
+
+	close(next.unblock)
+	require.NoError(t, <-written)
+
+	status := h.GetLivezStatus()
+	assert.Equal(t, health.ComponentLive, status.Overall)
+	assert.Zero(t, status.Components[writerComponentName].InFlight)
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// The names of the endpoints that the metrics server may serve.
+const (
+	metricsEndpointName  = "metrics"
+	readyzEndpointName   = "readyz"
+	livezEndpointName    = "livez"
+	sessionsEndpointName = "sessions"
+)
+
+const (
+	// unixAddressPrefix prefixes metrics server addresses
+	// that are paths to unix sockets.
+	unixAddressPrefix = "unix:"
+
+	// metricsSocketMode is the permissions of the metrics server's
+	// unix socket, which allow the daemon's group to scrape it.
+	metricsSocketMode = 0o660
+)
+
+// endpoints returns the names of the endpoints served by the metrics
+// server. Unless they are listed explicitly, they are the endpoints
+// enabled by EnableMetrics and EnableHealthz.
+func (o metricsConfig) endpoints() []string {
+	if len(o.Endpoints) > 0 {
+		return o.Endpoints
+	}
+
+	var endpoints []string
+
+	if o.EnableMetrics {
+		endpoints = append(endpoints, metricsEndpointName)
+	}
+
+	if o.EnableHealthz {
+		endpoints = append(endpoints, readyzEndpointName, livezEndpointName)
+	}
+
+	return endpoints
+}
+
+// socketPath returns the path of the unix socket that the
+// metrics server listens on, or an empty string if it is
+// not listening on a unix socket.
+func (o metricsConfig) socketPath() string {
+	if !strings.HasPrefix(o.Address, unixAddressPrefix) {
+		return ""
+	}
+
+	// Both "unix:/path" and "unix:///path" are accepted.
+	return strings.TrimPrefix(strings.TrimPrefix(o.Address, unixAddressPrefix), "//")
+}
+
+func (o metricsConfig) validate() error {
+	endpoints := o.endpoints()
+
+	if o.Address == "" && len(endpoints) > 0 {
+		return errors.New("the metrics server address must not be empty")
+	}
+
+	if strings.HasPrefix(o.Address, unixAddressPrefix) && o.socketPath() == "" {
+		return fmt.Errorf("invalid metrics server address: %q (expected 'unix:///path/to/socket')", o.Address)
+	}
+
+	for _, endpoint := range endpoints {
+		switch endpoint {
+		case metricsEndpointName, readyzEndpointName, livezEndpointName:
+		case sessionsEndpointName:
+			// The sessions describe who is logged in, which
+			// must not be available to unauthenticated clients.
+			if o.socketPath() == "" && o.TLS.ClientCAFile == "" {
+				return errors.New("the sessions endpoint requires a unix socket metrics server address " +
+					"or -metrics-tls-client-ca-file")
+			}
+		default:
+			return fmt.Errorf("unknown metrics server endpoint: %q (expected 'metrics', 'readyz', "+
+				"'livez' or 'sessions')", endpoint)
+		}
+	}
+
+	if (o.TLS.CertFile == "") != (o.TLS.KeyFile == "") {
+		return errors.New("-metrics-tls-cert-file and -metrics-tls-key-file must be specified together")
+	}
+
+	if o.TLS.ClientCAFile != "" && !o.TLS.enabled() {
+		return errors.New("-metrics-tls-client-ca-file requires -metrics-tls-cert-file")
+	}
+
+	return nil
+}
+
+// metricsServer is the HTTP server of the metrics,
+// health and debug endpoints.
+type metricsServer struct {
+	server     *http.Server
+	listener   net.Listener
+	socketPath string
+	l          *zap.SugaredLogger
+}
+
+// newMetricsServer listens on the address of the metrics server
+// described by mc. It returns nil if no endpoints are enabled.
+func newMetricsServer(mc metricsConfig, h *health.Health, sessions *sessiontracker.Introspector,
+	l *zap.SugaredLogger,
+) (*metricsServer, error) {
+	endpoints := mc.endpoints()
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+
+	mux := http.NewServeMux()
+
+	for _, endpoint := range endpoints {
+		switch endpoint {
+		case metricsEndpointName:
+			mux.Handle("/metrics", promhttp.Handler())
+		case readyzEndpointName:
+			mux.Handle("/readyz", h.ReadyzHandler())
+		case livezEndpointName:
+			mux.Handle("/livez", h.LivezHandler())
+		case sessionsEndpointName:
+			mux.Handle("/debug"+debugSessionsPath, sessionsHandler(sessions))
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if mc.TLS.enabled() {
+		reloader, err := newTLSReloader(mc.TLS, l)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			GetConfigForClient: reloader.getConfigForClient,
+		}
+	}
+
+	var listener net.Listener
+	var err error
+
+	socketPath := mc.socketPath()
+	if socketPath != "" {
+		listener, err = listenUnixSocket(socketPath, metricsSocketMode)
+	} else {
+		listener, err = net.Listen("tcp", mc.Address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics server address: %w", err)
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	return &metricsServer{
+		server: &http.Server{
+			Handler:           mux,
+			ReadTimeout:       mc.HTTPServerReadTimeout,
+			ReadHeaderTimeout: mc.HTTPServerReadHeaderTimeout,
+			TLSConfig:         tlsConfig,
+		},
+		listener:   listener,
+		socketPath: socketPath,
+		l:          l,
+	}, nil
+}
+
+// Run serves the endpoints until ctx is marked as done.
+//
+// The returned error is always non-nil.
+func (o *metricsServer) Run(ctx context.Context) error {
+	if o.socketPath != "" {
+		defer os.Remove(o.socketPath)
+	}
+
+	o.l.Infof("starting HTTP server on address '%s'...", o.listener.Addr())
+
+	err := serveUntilDone(ctx, o.server, o.listener)
+
+	o.l.Infoln("stopped HTTP server")
+
+	return err
+}
+
+// tlsReloader provides the TLS configuration of a server, whose
+// certificate, key and client CAs are reloaded when their files
+// are modified.
+type tlsReloader struct {
+	files serverTLSConfig
+	l     *zap.SugaredLogger
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+}
+
+// newTLSReloader returns a tlsReloader for the files described
+// by files, which must be valid.
+func newTLSReloader(files serverTLSConfig, l *zap.SugaredLogger) (*tlsReloader, error) {
+	o := &tlsReloader{
+		files: files,
+		l:     l,
+	}
+
+	err := o.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// getConfigForClient implements tls.Config.GetConfigForClient. If the
+// files were modified but cannot be loaded, the previous configuration
+// keeps being used.
+func (o *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.modified() {
+		err := o.load()
+		if err != nil {
+			o.l.Errorf("failed to reload metrics server TLS files (keeping the previous ones) - %s", err)
+		} else {
+			o.l.Infoln("reloaded metrics server TLS files")
+		}
+	}
+
+	return o.config, nil
+}
+
+// modified returns true if one of the files was
+// modified since it was last loaded.
+func (o *tlsReloader) modified() bool {
+	for filePath, modTime := range o.modTimes {
+		info, err := os.Stat(filePath)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *tlsReloader) load() error {
+	modTimes := make(map[string]time.Time)
+
+	// The modification times are read first, so that changes made
+	// while the files are read are noticed next time. Files that
+	// fail to load are not reloaded until they are modified again.
+	for _, filePath := range []string{o.files.CertFile, o.files.KeyFile, o.files.ClientCAFile} {
+		if filePath == "" {
+			continue
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to stat metrics server TLS file: %w", err)
+		}
+
+		modTimes[filePath] = info.ModTime()
+	}
+
+	o.modTimes = modTimes
+
+	config, err := loadServerTLSConfig(o.files)
+	if err != nil {
+		return err
+	}
+
+	o.config = config
+
+	return nil
+}
+
+// loadServerTLSConfig reads the certificate, key and
+// client CAs of a server from the files described by files.
+func loadServerTLSConfig(files serverTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metrics server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if files.ClientCAFile != "" {
+		pem, err := os.ReadFile(files.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metrics server client ca file: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client ca file %q contains no certificates", files.ClientCAFile)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// testCert is a certificate and its key, which are
+// written to PEM files by writeFiles.
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate for commonName signed by parent,
+// or a self-signed CA certificate if parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, der: der, key: key}
+}
+
+func (o *testCert) writeFiles(t *testing.T, certPath string, keyPath string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(o.key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: o.der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func (o *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{o.der},
+		PrivateKey:  o.key,
+	}
+}
+
+// startTestMetricsServer runs a metrics server
+// until the test finishes and returns its address.
+func startTestMetricsServer(t *testing.T, mc metricsConfig, sessions *sessiontracker.Introspector) string {
+	t.Helper()
+
+	require.NoError(t, mc.validate())
+
+	server, err := newMetricsServer(mc, health.NewHealth(), sessions, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	require.NotNil(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-runErr, context.Canceled)
+	})
+
+	return server.listener.Addr().String()
+}
+
+func getStatus(t *testing.T, client *http.Client, url string) int {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode
+}
+
+func TestMetricsConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		config metricsConfig
+		errMsg string
+	}{
+		{
+			name:   "disabled",
+			config: metricsConfig{},
+		},
+		{
+			name:   "defaults",
+			config: defaultConfig().Metrics,
+		},
+		{
+			name:   "no address",
+			config: metricsConfig{EnableMetrics: true},
+			errMsg: "must not be empty",
+		},
+		{
+			name:   "unix socket",
+			config: metricsConfig{Address: "unix:///run/metrics.sock", Endpoints: []string{"sessions"}},
+		},
+		{
+			name:   "empty unix socket path",
+			config: metricsConfig{Address: "unix:", EnableMetrics: true},
+			errMsg: "invalid metrics server address",
+		},
+		{
+			name:   "unknown endpoint",
+			config: metricsConfig{Address: ":2112", Endpoints: []string{"metrics", "pprof"}},
+			errMsg: "unknown metrics server endpoint",
+		},
+		{
+			name:   "sessions without authentication",
+			config: metricsConfig{Address: ":2112", Endpoints: []string{"sessions"}},
+			errMsg: "sessions endpoint requires",
+		},
+		{
+			name: "sessions with client certificates",
+			config: metricsConfig{
+				Address:   ":2112",
+				Endpoints: []string{"sessions"},
+				TLS:       serverTLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"},
+			},
+		},
+		{
+			name: "certificate without key",
+			config: metricsConfig{
+				Address:       ":2112",
+				EnableMetrics: true,
+				TLS:           serverTLSConfig{CertFile: "cert.pem"},
+			},
+			errMsg: "must be specified together",
+		},
+		{
+			name: "client ca without certificate",
+			config: metricsConfig{
+				Address:       ":2112",
+				EnableMetrics: true,
+				TLS:           serverTLSConfig{ClientCAFile: "ca.pem"},
+			},
+			errMsg: "requires -metrics-tls-cert-file",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.config.validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestMetricsConfig_Endpoints(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, metricsConfig{}.endpoints())
+	assert.Equal(t, []string{"metrics", "readyz", "livez"},
+		metricsConfig{EnableMetrics: true, EnableHealthz: true}.endpoints())
+	assert.Equal(t, []string{"livez"},
+		metricsConfig{EnableMetrics: true, Endpoints: []string{"livez"}}.endpoints())
+}
+
+func TestNewMetricsServer_Disabled(t *testing.T) {
+	t.Parallel()
+
+	server, err := newMetricsServer(metricsConfig{Address: ":0"}, nil, nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	assert.Nil(t, server)
+}
+
+func TestMetricsServer_UnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "metrics.sock")
+
+	startTestMetricsServer(t, metricsConfig{
+		Address:   "unix://" + socketPath,
+		Endpoints: []string{"livez", "sessions"},
+	}, sessiontracker.NewIntrospector())
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(metricsSocketMode), info.Mode().Perm())
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	assert.Equal(t, http.StatusOK, getStatus(t, client, "http://audito-maldito/livez"))
+	assert.Equal(t, http.StatusServiceUnavailable, getStatus(t, client, "http://audito-maldito/debug/sessions"))
+	assert.Equal(t, http.StatusNotFound, getStatus(t, client, "http://audito-maldito/metrics"))
+	assert.Equal(t, http.StatusNotFound, getStatus(t, client, "http://audito-maldito/readyz"))
+}
+
+func TestMetricsServer_MutualTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.pem")
+	keyPath := filepath.Join(dir, "server-key.pem")
+	caPath := filepath.Join(dir, "ca.pem")
+	caKeyPath := filepath.Join(dir, "ca-key.pem")
+
+	ca := newTestCert(t, "ca", nil)
+	ca.writeFiles(t, caPath, caKeyPath)
+	newTestCert(t, "server", ca).writeFiles(t, certPath, keyPath)
+
+	addr := startTestMetricsServer(t, metricsConfig{
+		Address:   "127.0.0.1:0",
+		Endpoints: []string{"metrics"},
+		TLS: serverTLSConfig{
+			CertFile:     certPath,
+			KeyFile:      keyPath,
+			ClientCAFile: caPath,
+		},
+	}, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					MinVersion:   tls.VersionTLS12,
+					RootCAs:      roots,
+					Certificates: certs,
+				},
+			},
+		}
+	}
+
+	url := "https://" + addr + "/metrics"
+
+	//nolint:bodyclose // The request fails.
+	_, err := newClient().Get(url)
+	assert.Error(t, err)
+
+	client := newClient(newTestCert(t, "client", ca).tlsCertificate())
+	assert.Equal(t, http.StatusOK, getStatus(t, client, url))
+
+	// Clients signed by a different CA are rejected.
+	//nolint:bodyclose // The request fails.
+	_, err = newClient(newTestCert(t, "other", newTestCert(t, "other-ca", nil)).tlsCertificate()).Get(url)
+	assert.Error(t, err)
+}
+
+func TestTLSReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.pem")
+	keyPath := filepath.Join(dir, "server-key.pem")
+
+	first := newTestCert(t, "first", nil)
+	first.writeFiles(t, certPath, keyPath)
+
+	reloader, err := newTLSReloader(serverTLSConfig{CertFile: certPath, KeyFile: keyPath}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	leafOf := func() *x509.Certificate {
+		config, err := reloader.getConfigForClient(nil)
+		require.NoError(t, err)
+		require.Len(t, config.Certificates, 1)
+
+		leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		require.NoError(t, err)
+
+		return leaf
+	}
+
+	assert.Equal(t, "first", leafOf().Subject.CommonName)
+
+	// The modification times are set explicitly, as they may
+	// not change if the files are rewritten quickly enough.
+	setModTime := func(modTime time.Time) {
+		require.NoError(t, os.Chtimes(certPath, modTime, modTime))
+		require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+	}
+
+	newTestCert(t, "second", nil).writeFiles(t, certPath, keyPath)
+	setModTime(time.Now().Add(time.Minute))
+
+	assert.Equal(t, "second", leafOf().Subject.CommonName)
+
+	// An invalid certificate does not replace the current one.
+	require.NoError(t, os.WriteFile(certPath, []byte("not a certificate"), 0o600))
+	setModTime(time.Now().Add(2 * time.Minute))
+
+	assert.Equal(t, "second", leafOf().Subject.CommonName)
+}
@@ -2,15 +2,10 @@ package cmd
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"os"
 
-	"github.com/go-logr/zapr"
-	"github.com/metal-toolbox/auditevent"
-	"github.com/metal-toolbox/auditevent/helpers"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/auditlog"
@@ -19,72 +14,32 @@ import (
 	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
 	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/targetlabels"
 	"github.com/metal-toolbox/audito-maldito/processors/auditd"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
 	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+	"github.com/metal-toolbox/audito-maldito/sinks"
 )
 
 func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLoggerConfig *zap.Config) error {
-	var appEventsOutput string
-	var auditdLogFilePath string
-	var sshdLogFilePath string
-	var metricsConfig metricsConfig
-
-	logLevel := zapcore.InfoLevel
-
-	flagSet := flag.NewFlagSet(osArgs[0], flag.ContinueOnError)
-
-	flagSet.Var(&logLevel, "log-level", "Set the log level according to zapcore.Level")
-	flagSet.BoolVar(&metricsConfig.enableMetrics, "metrics", false, "Enable Prometheus HTTP /metrics server")
-	flagSet.BoolVar(&metricsConfig.enableHealthz, "healthz", false, "Enable HTTP health endpoints server")
-	flagSet.BoolVar(&metricsConfig.enableAuditMetrics, "audit-metrics", false, "Enable Prometheus audit metrics")
-
-	flagSet.DurationVar(&metricsConfig.httpServerReadTimeout, "http-server-read-timeout",
-		DefaultHTTPServerReadTimeout, "HTTP server read timeout")
-	flagSet.DurationVar(&metricsConfig.httpServerReadHeaderTimeout, "http-server-read-header-timeout",
-		DefaultHTTPServerReadHeaderTimeout, "HTTP server read header timeout")
-	flagSet.DurationVar(
-		&metricsConfig.auditMetricsSecondsInterval,
-		"audit-seconds-interval",
-		DefaultAuditCheckInterval,
-		"Interval in seconds to collect audit metrics")
-	flagSet.IntVar(
-		&metricsConfig.auditLogWriteTimeSecondThreshold,
-		"audit-log-last-modify-seconds-threshold",
-		DefaultAuditModifyTimeThreshold,
-		"seconds since last write to audit.log before alerting")
-
-	flagSet.StringVar(
-		&appEventsOutput,
-		"app-events-output",
-		"/app-audit/app-events-output.log",
-		"Path to the app events output")
-	flagSet.StringVar(
-		&sshdLogFilePath,
-		"sshd-pipe-path",
-		"/app-audit/sshd-pipe",
-		"Path to the sshd log named pipe file")
-	flagSet.StringVar(
-		&auditdLogFilePath,
-		"auditd-pipe-path",
-		"/app-audit/audit-pipe",
-		"Path to the audit log named pipe file")
-
-	flagSet.Usage = func() {
-		os.Stderr.WriteString(usage)
-		flagSet.PrintDefaults()
-		os.Exit(1)
-	}
-	err := flagSet.Parse(osArgs[1:])
+	cfg, _, err := loadConfig(osArgs, os.Getenv, usage)
+	if err != nil {
+		return err
+	}
+
+	files, err := readConfigFiles(cfg)
 	if err != nil {
 		return err
 	}
 
 	if optLoggerConfig == nil {
-		cfg := zap.NewProductionConfig()
-		optLoggerConfig = &cfg
+		loggerConfig := zap.NewProductionConfig()
+		optLoggerConfig = &loggerConfig
 	}
 
-	optLoggerConfig.Level = zap.NewAtomicLevelAt(logLevel)
+	// The level is kept so that it can be reloaded.
+	level := zap.NewAtomicLevelAt(cfg.LogLevel)
+	optLoggerConfig.Level = level
 
 	l, err := optLoggerConfig.Build()
 	if err != nil {
@@ -110,71 +65,180 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 		return fmt.Errorf("failed to get node name: %w", nodenameerr)
 	}
 
-	eg, groupCtx := errgroup.WithContext(ctx)
+	sessions := sessiontracker.NewIntrospector()
 
-	auf, auditfileerr := helpers.OpenAuditLogFileUntilSuccessWithContext(groupCtx, appEventsOutput, zapr.NewLogger(l))
-	if auditfileerr != nil {
-		return fmt.Errorf("failed to open audit log file: %w", auditfileerr)
+	// The metrics server listens before anything is started,
+	// so that an invalid address or certificate is fatal.
+	server, err := newMetricsServer(cfg.Metrics, h, sessions, logger)
+	if err != nil {
+		return err
 	}
 
-	eventWriter := auditevent.NewDefaultAuditEventWriter(auf)
-	logins := make(chan common.RemoteUserLogin)
+	notifier, err := newSystemdNotifier(os.Getenv, h, sessions, logger)
+	if err != nil {
+		return err
+	}
+
+	// Workers stop gracefully when ctx is marked as done,
+	// and immediately when one of them fails or when the
+	// shutdown timeout expires (refer to shutdown).
+	hardCtx, stopHard := context.WithCancel(context.Background())
+	defer stopHard()
+
+	eg, groupCtx := errgroup.WithContext(hardCtx)
+
+	stop := newShutdown(groupCtx, stopHard, cfg.Shutdown.Timeout, logger)
+	go stop.Run(ctx)
+
 	pprov := metrics.NewPrometheusMetricsProvider()
 
+	outputOptions := sinks.OutputOptions{
+		Logger:  logger,
+		Metrics: pprov,
+		ResourceAttributes: map[string]string{
+			"host.name": nodeName,
+			"host.id":   mid,
+		},
+	}
+
+	target := map[string]string{
+		"host":       nodeName,
+		"machine-id": mid,
+	}
+
+	pipeline, err := newReloader(groupCtx, cfg, files, osArgs, level, outputOptions, pprov, target)
+	if err != nil {
+		return err
+	}
+
+	// The writer is stalled if writing an event blocks
+	// (e.g., because an output's buffer is full).
+	eventWriter := &heartbeatSink{
+		next: pipeline.EventWriter(),
+		hb:   h.AddHeartbeat(writerComponentName, cfg.Metrics.LivenessWriteTimeout),
+	}
+
+	lifecycle := pipeline.Lifecycle().
+		WithSessions(sessions).
+		WithEventsWritten(eventWriter.Written)
+
+	eg.Go(func() error {
+		return stop.Stopped(stop.Pipeline(), pipeline.Run(stop.Pipeline()))
+	})
+
+	stallTimeout := cfg.Metrics.LivenessStallTimeout
+
+	logins := make(chan common.RemoteUserLogin)
+
 	logger.Infoln("starting workers...")
-	handleMetricsAndHealth(groupCtx, metricsConfig, eg, h)
-	handleAuditLogMetrics(groupCtx, metricsConfig, eg, pprov)
+	if server != nil {
+		eg.Go(func() error {
+			return stop.Stopped(stop.Pipeline(), server.Run(stop.Pipeline()))
+		})
+	}
+
+	handleAuditLogMetrics(stop.Pipeline(), cfg.Metrics, eg, pprov)
+	handleDebugSocket(stop.Pipeline(), cfg.Debug, eg, sessions)
+
+	if notifier != nil {
+		notifier.WithEventsWritten(eventWriter.Written)
+
+		// STOPPING=1 is sent when the shutdown starts.
+		eg.Go(func() error {
+			return stop.Stopped(stop.Ingestion(), notifier.Run(stop.Ingestion()))
+		})
+	}
+
+	pipeMode, err := cfg.Inputs.pipeMode()
+	if err != nil {
+		return err
+	}
 
-	h.AddReadiness(namedpipe.NamedPipeProcessorComponentName)
+	h.AddReadiness(sshdPipeReaderComponentName)
 	eg.Go(func() error {
-		err := common.IsNamedPipe(sshdLogFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to check if sshd log path is a named pipe: %q - %w",
-				sshdLogFilePath, err)
+		// No more logins are sent once the ingester returns.
+		defer close(logins)
+
+		sshdProcessor := &heartbeatSshdProcessor{
+			next: sshd.NewSshdProcessor(groupCtx, logins, nodeName, mid, eventWriter, pprov),
+			hb:   h.AddHeartbeat(sshdProcessorComponentName, stallTimeout),
 		}
 
-		sshdProcessor := sshd.NewSshdProcessor(groupCtx, logins, nodeName, mid, eventWriter, pprov)
 		npi := namedpipe.NewNamedPipeIngester(logger, h)
+		npi.ComponentName = sshdPipeReaderComponentName
+		npi.Mode = pipeMode
+		npi.Heartbeat = h.AddHeartbeat(sshdPipeReaderComponentName, stallTimeout)
+		npi.Metrics = pprov
+		npi.Input = metrics.SshdInput
+		npi.OnReopen = func(filePath string) {
+			lifecycle.InputReconnected(string(metrics.SshdInput), filePath)
+		}
 
-		sli := syslog.NewSyslogIngester(sshdLogFilePath, sshdProcessor, npi)
-		err = sli.Ingest(groupCtx)
+		sli := syslog.NewSyslogIngester(cfg.Inputs.SshdPipePath, sshdProcessor, npi)
+		sli.Metrics = pprov
+		err := sli.Ingest(stop.Ingestion())
 
 		if logger.Level().Enabled(zap.DebugLevel) {
 			logger.Debugf("syslog ingester exited (%v)", err)
 		}
-		return err
+		return stop.Stopped(stop.Ingestion(), err)
 	})
 
-	auditLogChanBufSize := 10000
-	auditLogChan := make(chan string, auditLogChanBufSize)
+	auditLogChan := make(chan string, cfg.Tuning.AuditLogBufferSize)
 
-	h.AddReadiness(namedpipe.NamedPipeProcessorComponentName)
+	h.AddReadiness(auditdPipeReaderComponentName)
 	eg.Go(func() error {
-		err := common.IsNamedPipe(auditdLogFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to check if auditd log path is a named pipe: %q - %w",
-				auditdLogFilePath, err)
-		}
+		// The auditd processor stops once it has processed the lines.
+		defer close(auditLogChan)
 
 		np := namedpipe.NewNamedPipeIngester(logger, h)
-		alp := auditlog.NewAuditLogIngester(auditdLogFilePath, auditLogChan, np)
+		np.ComponentName = auditdPipeReaderComponentName
+		np.Mode = pipeMode
+		np.Heartbeat = h.AddHeartbeat(auditdPipeReaderComponentName, stallTimeout)
+		np.Metrics = pprov
+		np.Input = metrics.AuditdInput
+		np.OnReopen = func(filePath string) {
+			lifecycle.InputReconnected(string(metrics.AuditdInput), filePath)
+		}
+
+		alp := auditlog.NewAuditLogIngester(cfg.Inputs.AuditdPipePath, auditLogChan, np)
+		alp.ReceiverDone = groupCtx.Done()
 
-		err = alp.Ingest(groupCtx)
+		err := alp.Ingest(stop.Ingestion())
 		if logger.Level().Enabled(zap.DebugLevel) {
 			logger.Debugf("audit log ingester exited (%v)", err)
 		}
-		return err
+		return stop.Stopped(stop.Ingestion(), err)
 	})
 
+	// The transcript files are redacted like the events.
+	transcripts := cfg.Transcripts.transcriptConfig()
+	transcripts.RedactFile = pipeline.RedactTranscript
+
 	h.AddReadiness(auditd.AuditdProcessorComponentName)
 	eg.Go(func() error {
 		ap := auditd.Auditd{
-			Audits: auditLogChan,
-			Logins: logins,
-			EventW: eventWriter,
-			Health: h,
+			Audits:      auditLogChan,
+			Logins:      logins,
+			EventW:      eventWriter,
+			RuleKeys:    files.ruleKeys,
+			Transcripts: transcripts,
+			Tuning:      cfg.Tuning.auditdTuning(),
+			Health:      h,
+			Sessions:    sessions,
+			Metrics:     pprov,
+
+			OnEventsLost: lifecycle.EventsLost,
+			Target:       target,
+
+			ReassemblerHeartbeat:    h.AddHeartbeat(reassemblerComponentName, stallTimeout),
+			SessionTrackerHeartbeat: h.AddHeartbeat(sessionTrackerComponentName, stallTimeout),
 		}
 
+		// The auditd processor is the last one to write events
+		// during a shutdown, as it processes the remaining logins.
+		defer stop.ProcessorsDone()
+
 		err := ap.Read(groupCtx)
 		if logger.Level().Enabled(zap.DebugLevel) {
 			logger.Debugf("audit worker exited (%v)", err)
@@ -183,6 +247,10 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 	})
 
 	if err := eg.Wait(); err != nil {
+		if stop.TimedOut() {
+			return fmt.Errorf("failed to shut down within %s - %w", cfg.Shutdown.Timeout, err)
+		}
+
 		// We cannot treat errors containing context.Canceled
 		// as non-errors because the errgroup.Group uses its
 		// own context, which is canceled if one of the Go
@@ -197,3 +265,99 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 
 	return nil
 }
+
+// configFiles holds the contents of the files referred
+// to by the configuration (e.g., the redaction policy).
+type configFiles struct {
+	chain           sinks.ChainConfig
+	redactionPolicy *sinks.RedactionPolicy
+	targetLabels    targetlabels.Config
+	ruleKeys        sessiontracker.RuleKeyClasses
+}
+
+// readConfigFiles reads the files referred to by the configuration.
+func readConfigFiles(cfg *config) (*configFiles, error) {
+	var err error
+
+	files := &configFiles{
+		chain: sinks.ChainConfig{
+			AnchorInterval: cfg.Outputs.HashChain.AnchorInterval,
+		},
+	}
+
+	if cfg.Outputs.HashChain.SigningKey != "" {
+		files.chain.SigningKey, err = sinks.ReadSigningKey(cfg.Outputs.HashChain.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hash chain signing key: %w", err)
+		}
+	}
+
+	if cfg.Filters.RedactionPolicy != "" {
+		files.redactionPolicy, err = sinks.ReadRedactionPolicy(cfg.Filters.RedactionPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files.targetLabels, err = readTargetLabelsConfig(
+		cfg.Enrichment.TargetLabelsConfig, cfg.Enrichment.TargetLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Enrichment.AuditRuleKeysFile != "" {
+		files.ruleKeys, err = readRuleKeyClasses(cfg.Enrichment.AuditRuleKeysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// readRuleKeyClasses reads the audit rule key classifications
+// from the JSON file at filePath.
+func readRuleKeyClasses(filePath string) (sessiontracker.RuleKeyClasses, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit rule keys file: %w", err)
+	}
+	defer f.Close()
+
+	classes, err := sessiontracker.ParseRuleKeyClasses(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse audit rule keys file %q: %w", filePath, err)
+	}
+
+	return classes, nil
+}
+
+// readTargetLabelsConfig reads the optional target labels config
+// file and adds the static labels to it. The static labels take
+// precedence over the config file's static labels.
+func readTargetLabelsConfig(filePath string, static map[string]string) (targetlabels.Config, error) {
+	var config targetlabels.Config
+
+	if filePath != "" {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return config, fmt.Errorf("failed to open target labels config file: %w", err)
+		}
+		defer f.Close()
+
+		config, err = targetlabels.ParseConfig(f)
+		if err != nil {
+			return config, fmt.Errorf("failed to parse target labels config file %q: %w", filePath, err)
+		}
+	}
+
+	for name, value := range static {
+		if config.Static == nil {
+			config.Static = make(map[string]string)
+		}
+
+		config.Static[name] = value
+	}
+
+	return config, nil
+}
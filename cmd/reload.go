@@ -0,0 +1,887 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/targetlabels"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+const (
+	// agentComponentName is the component of the
+	// events that describe audito-maldito's own actions.
+	agentComponentName = "audito-maldito"
+
+	// reloadDebounceDelay is the delay between a watched file
+	// changing and the configuration being reloaded, so that
+	// several changes made at once result in a single reload.
+	reloadDebounceDelay = time.Second
+)
+
+// newReloader builds the part of the event pipeline that can be
+// reloaded using the configuration cfg and the contents of its
+// files. The pipeline is, from the first to the last EventSink:
+//
+//	head (labels, redaction) -> hash chain (optional) -> tail (outputs)
+//
+// The head and tail are SwitchSinks, so that the labels, redaction
+// policy and outputs can be replaced without stopping the processors
+// that write events. The hash chain is not replaced, as its state
+// must be carried over from one event to the next.
+//
+// The returned reloader's Run method must be running for events
+// to reach buffered outputs.
+func newReloader(ctx context.Context, cfg *config, files *configFiles, osArgs []string,
+	level zap.AtomicLevel, outputOptions sinks.OutputOptions, pprov *metrics.PrometheusMetricsProvider,
+	target map[string]string,
+) (*reloader, error) {
+	o := &reloader{
+		osArgs:        osArgs,
+		getenv:        os.Getenv,
+		l:             outputOptions.Logger,
+		level:         level,
+		outputOptions: outputOptions,
+		metrics:       pprov,
+		running:       cfg,
+		lifecycle:     newLifecycle(target, configHash(cfg), outputOptions.Logger),
+		outputsDone:   make(chan struct{}),
+	}
+
+	outputs, _, err := o.startOutputs(ctx, outputSpecs(cfg, outputOptions), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o.outputs = outputs
+	o.tail = sinks.NewSwitchSink(outputs)
+
+	o.chained = o.tail
+	if cfg.Outputs.HashChain.Enabled {
+		o.chained = sinks.NewHashChain(o.tail, files.chain)
+	}
+
+	filters, stopFilters := o.startFilters(ctx, files)
+	o.head = sinks.NewSwitchSink(filters)
+	o.stopFilters = stopFilters
+	o.redactionPolicy.Store(files.redactionPolicy)
+
+	// The schema version is part of every event, including
+	// its hash, regardless of the outputs' formats.
+	o.eventW = sinks.NewSchemaVersionSink(o.head)
+
+	return o, nil
+}
+
+// reloader runs the reloadable part of the event pipeline and
+// replaces it each time the configuration is reloaded. A reload
+// either succeeds as a whole or leaves the pipeline unchanged.
+type reloader struct {
+	osArgs        []string
+	getenv        func(string) string
+	l             *zap.SugaredLogger
+	level         zap.AtomicLevel
+	outputOptions sinks.OutputOptions
+	metrics       *metrics.PrometheusMetricsProvider
+	lifecycle     *lifecycle
+
+	// running is the configuration that the daemon started with.
+	// Settings that cannot be reloaded are compared with it.
+	running *config
+
+	eventW      sinks.EventSink
+	head        *sinks.SwitchSink
+	chained     sinks.EventSink
+	tail        *sinks.SwitchSink
+	outputs     runningOutputs
+	stopFilters context.CancelFunc
+
+	// redactionPolicy is the redaction policy used by the
+	// head, if any. It is also applied to transcript files.
+	redactionPolicy atomic.Pointer[sinks.RedactionPolicy]
+
+	// outputsDone is closed when an output stops without being
+	// retired (e.g., because it failed). outputsErr is the error
+	// it returned, and may be read once outputsDone is closed.
+	outputsDone     chan struct{}
+	outputsErr      error
+	outputsDoneOnce sync.Once
+}
+
+// EventWriter returns the EventSink that processors write events to.
+func (o *reloader) EventWriter() sinks.EventSink {
+	return o.eventW
+}
+
+// RedactTranscript applies the current redaction policy to the text
+// of a transcript file, like it is applied to the transcripts of the
+// SessionTranscript events. It returns an empty string if the policy
+// drops the transcripts.
+func (o *reloader) RedactTranscript(text string) string {
+	policy := o.redactionPolicy.Load()
+	if policy == nil {
+		return text
+	}
+
+	redacted, _ := policy.ApplyToField("metadata.extra.transcript", text)
+
+	return redacted
+}
+
+// Lifecycle returns the lifecycle whose events are written by Run.
+func (o *reloader) Lifecycle() *lifecycle {
+	return o.lifecycle
+}
+
+// Run reloads the configuration each time a SIGHUP signal is received
+// or (if enabled) one of the watched files changes, until ctx is marked
+// as done. It returns a non-nil error if an output that uses
+// ErrorPolicyFail fails.
+//
+// Run also writes the lifecycle's events: the start event when it is
+// called, the queued and heartbeat events while it runs, and the stop
+// event when it returns. When ctx is marked as done, the events queued
+// for buffered outputs are written before the outputs are closed.
+func (o *reloader) Run(ctx context.Context) error {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	defer o.close()
+
+	err := o.writeLifecycleEvent(o.lifecycle.startEvent())
+	if err != nil {
+		return err
+	}
+
+	var heartbeat <-chan time.Time
+	if o.running.Lifecycle.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(o.running.Lifecycle.HeartbeatInterval)
+		defer ticker.Stop()
+
+		heartbeat = ticker.C
+	}
+
+	changes := make(chan string)
+
+	if o.running.Reload.Watch {
+		go func() {
+			err := watchConfigFiles(ctx, o.running, changes)
+			if ctx.Err() == nil {
+				// SIGHUP still reloads the configuration.
+				o.l.Errorf("stopped watching configuration files - %s", err)
+			}
+		}()
+	}
+
+	var debounce <-chan time.Time
+	var changed string
+
+	for {
+		var err error
+
+		select {
+		case <-ctx.Done():
+			o.stop(nil)
+			o.flush()
+			return ctx.Err()
+		case <-o.outputsDone:
+			o.stop(o.outputsErr)
+			return o.outputsErr
+		case evt := <-o.lifecycle.Events():
+			err = o.writeLifecycleEvent(evt)
+		case <-heartbeat:
+			err = o.writeLifecycleEvent(o.lifecycle.heartbeatEvent())
+		case <-sighup:
+			err = o.reload(ctx, auditevent.EventSource{Type: "signal", Value: "SIGHUP"})
+		case changed = <-changes:
+			debounce = time.After(reloadDebounceDelay)
+		case <-debounce:
+			debounce = nil
+			err = o.reload(ctx, auditevent.EventSource{Type: "file", Value: changed})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stop writes the queued lifecycle events and the stop event. cause
+// is the reason the daemon stops, if it is because of a failure. As
+// the outputs may be failing, write errors are only logged.
+func (o *reloader) stop(cause error) {
+	for drained := false; !drained; {
+		select {
+		case evt := <-o.lifecycle.Events():
+			err := o.writeLifecycleEvent(evt)
+			if err != nil {
+				o.l.Errorf("%s", err)
+			}
+		default:
+			drained = true
+		}
+	}
+
+	err := o.writeLifecycleEvent(o.lifecycle.stopEvent(cause))
+	if err != nil {
+		o.l.Errorf("%s", err)
+	}
+}
+
+// flush waits for the buffered outputs to write the queued events, for
+// up to the shutdown timeout or until the outputs stop (e.g., because
+// the shutdown timed out).
+func (o *reloader) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), o.running.Shutdown.Timeout)
+	defer cancel()
+
+	err := o.outputs.Flush(ctx)
+	if err != nil {
+		o.l.Errorf("failed to flush outputs - %s", err)
+	}
+}
+
+func (o *reloader) writeLifecycleEvent(evt *auditevent.AuditEvent) error {
+	err := o.eventW.Write(evt)
+	if err != nil {
+		return fmt.Errorf("failed to write %s lifecycle event: %w", evt.Metadata.Extra["action"], err)
+	}
+
+	return nil
+}
+
+// reload reloads the configuration and reports the outcome in the
+// logs, metrics and event outputs. The returned error is non-nil if
+// the event describing the outcome cannot be written.
+func (o *reloader) reload(ctx context.Context, trigger auditevent.EventSource) error {
+	o.l.Infof("reloading configuration (trigger: %s %s)...", trigger.Type, trigger.Value)
+
+	restartRequired, err := o.apply(ctx)
+	if err != nil {
+		o.metrics.IncConfigReloads(metrics.Failure)
+		o.l.Errorf("failed to reload configuration, keeping the current configuration - %s", err)
+	} else {
+		o.metrics.IncConfigReloads(metrics.Success)
+		o.l.Infoln("configuration reloaded")
+	}
+
+	// SIGHUP is also how the outputs are told that their files
+	// were moved (e.g., by logrotate), including the outputs
+	// that were kept by the reload.
+	if trigger.Type == "signal" {
+		reopenErr := o.outputs.Reopen()
+		if reopenErr != nil {
+			o.l.Errorf("failed to reopen outputs - %s", reopenErr)
+		}
+	}
+
+	if len(restartRequired) > 0 {
+		o.l.Warnf("the following settings changed but only take effect after a restart: %s",
+			strings.Join(restartRequired, ", "))
+	}
+
+	return o.writeLifecycleEvent(o.lifecycle.reloadEvent(trigger, restartRequired, err))
+}
+
+// apply reads the configuration again and replaces the reloadable part
+// of the pipeline. It returns the settings that changed but cannot be
+// reloaded. The pipeline is left unchanged if an error is returned.
+func (o *reloader) apply(ctx context.Context) ([]string, error) {
+	cfg, _, err := loadConfig(o.osArgs, o.getenv, usage)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := readConfigFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	restartRequired := settingsRequiringRestart(o.running, cfg)
+
+	// Without rotation, the app events output is not created and
+	// opening it blocks until it exists, which would block reloads.
+	appEvents := cfg.Outputs.AppEvents
+	if appEvents.Path != "" && !appEvents.rotation().Enabled() {
+		_, err = os.Stat(appEvents.Path)
+		if err != nil {
+			return restartRequired, fmt.Errorf("failed to open app events output: %w", err)
+		}
+	}
+
+	// The outputs whose configuration did not change are kept.
+	// The others are replaced, except that an output cannot be
+	// opened while a previous output uses its spool directory.
+	specs := outputSpecs(cfg, o.outputOptions)
+
+	outputs, blocked, err := o.startOutputs(ctx, specs, o.outputs)
+	if err != nil {
+		return restartRequired, err
+	}
+
+	filters, stopFilters := o.startFilters(ctx, files)
+
+	// Writes are paused while both ends of the pipeline are
+	// replaced, so that no event is written using a mix of the
+	// previous and new settings.
+	o.head.Replace(func(current sinks.EventSink) sinks.EventSink {
+		err = o.startBlockedOutputs(ctx, specs, outputs, blocked)
+		if err != nil {
+			return current
+		}
+
+		o.tail.Swap(outputs)
+		o.redactionPolicy.Store(files.redactionPolicy)
+
+		return filters
+	})
+
+	if err != nil {
+		stopFilters()
+		_ = outputs.retire(o.outputs, 0)
+
+		return restartRequired, err
+	}
+
+	o.level.SetLevel(cfg.LogLevel)
+	o.lifecycle.setConfigHash(configHash(cfg))
+
+	previousOutputs := o.outputs
+	o.outputs = outputs
+
+	o.stopFilters()
+	o.stopFilters = stopFilters
+
+	err = previousOutputs.retire(outputs, o.running.Reload.DrainTimeout)
+	if err != nil {
+		// The new configuration is in use at this point.
+		o.l.Warnf("failed to stop previous outputs - %s", err)
+	}
+
+	return restartRequired, nil
+}
+
+// close stops the outputs and the filters.
+func (o *reloader) close() {
+	o.stopFilters()
+
+	err := o.outputs.retire(nil, 0)
+	if err != nil {
+		o.l.Errorf("failed to close outputs - %s", err)
+	}
+}
+
+// startOutputs starts the outputs described by specs. The outputs of
+// previous whose configuration did not change are reused instead. The
+// outputs that use the spool directory of one of the other outputs
+// of previous are not started: their indexes are returned instead,
+// and they must be started by startBlockedOutputs. If an error is
+// returned, the outputs that were started are closed.
+func (o *reloader) startOutputs(ctx context.Context, specs []outputSpec,
+	previous runningOutputs,
+) (runningOutputs, []int, error) {
+	outputs := make(runningOutputs, len(specs))
+	reused := make(map[*runningOutput]struct{})
+
+	for i, spec := range specs {
+		for _, output := range previous {
+			if _, isReused := reused[output]; !isReused && output.spec.key == spec.key {
+				outputs[i] = output
+				reused[output] = struct{}{}
+
+				break
+			}
+		}
+	}
+
+	var blocked []int
+
+	for i, spec := range specs {
+		if outputs[i] != nil {
+			continue
+		}
+
+		if spec.spoolDir != "" && previous.usesSpoolDir(spec.spoolDir, outputs) {
+			blocked = append(blocked, i)
+			continue
+		}
+
+		output, err := o.startOutput(ctx, spec)
+		if err != nil {
+			_ = outputs.retire(previous, 0)
+			return nil, nil, err
+		}
+
+		outputs[i] = output
+	}
+
+	return outputs, blocked, nil
+}
+
+// startBlockedOutputs starts the outputs described by the specs whose
+// indexes are blocked (refer to startOutputs) and stores them in
+// outputs, after closing the current outputs that use their spool
+// directories. The batches queued in memory by the current outputs
+// are spooled when they are closed, so the new outputs deliver them.
+// If an error is returned, the closed outputs are started again and
+// the current outputs remain usable.
+//
+// It must be called while writes are paused.
+func (o *reloader) startBlockedOutputs(ctx context.Context, specs []outputSpec, outputs runningOutputs,
+	blocked []int,
+) error {
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	spoolDirs := make(map[string]struct{}, len(blocked))
+	for _, i := range blocked {
+		spoolDirs[specs[i].spoolDir] = struct{}{}
+	}
+
+	var closed []int
+
+	for i, output := range o.outputs {
+		_, uses := spoolDirs[output.spec.spoolDir]
+		if !uses || output.spec.spoolDir == "" || outputs.contains(output) {
+			continue
+		}
+
+		err := output.retire(o.running.Reload.DrainTimeout)
+		if err != nil {
+			o.l.Warnf("failed to stop previous output %q - %s", output.spec.key, err)
+		}
+
+		closed = append(closed, i)
+	}
+
+	var err error
+
+	for _, i := range blocked {
+		outputs[i], err = o.startOutput(ctx, specs[i])
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	// Without the new outputs, the previous
+	// ones keep their spool directories.
+	for _, i := range blocked {
+		if outputs[i] != nil {
+			_ = outputs[i].retire(0)
+		}
+	}
+
+	for _, i := range closed {
+		output, restartErr := o.startOutput(ctx, o.outputs[i].spec)
+		if restartErr != nil {
+			o.l.Errorf("failed to restart previous output %q - %s", o.outputs[i].spec.key, restartErr)
+			continue
+		}
+
+		o.outputs[i] = output
+	}
+
+	return err
+}
+
+// startOutput opens the output described by spec and
+// starts writing events to it (if it is buffered).
+func (o *reloader) startOutput(ctx context.Context, spec outputSpec) (*runningOutput, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	output, err := spec.open(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	running := &runningOutput{
+		spec: spec,
+		fanOut: sinks.NewFanOut(o.l, output).
+			WithMetrics(o.metrics).
+			WithFailureHandler(o.lifecycle.SinkFailed),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(running.done)
+
+		running.err = running.fanOut.Run(ctx)
+		if o.l.Level().Enabled(zap.DebugLevel) {
+			o.l.Debugf("event sink %q exited (%v)", output.Name, running.err)
+		}
+
+		if !running.retired.Load() {
+			o.outputsDoneOnce.Do(func() {
+				o.outputsErr = running.err
+				close(o.outputsDone)
+			})
+		}
+	}()
+
+	return running, nil
+}
+
+// startFilters returns the EventSinks that label and redact events
+// before writing them to the hash chain (or the outputs). The returned
+// function stops watching the target labels' files.
+func (o *reloader) startFilters(ctx context.Context, files *configFiles) (sinks.EventSink, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Events are redacted before they are linked to the hash
+	// chain, so the chain can be verified using any output.
+	filters := o.chained
+	if files.redactionPolicy != nil {
+		filters = sinks.NewRedactingSink(filters, files.redactionPolicy)
+	}
+
+	// Labels are added first, so they can be redacted as well.
+	if !files.targetLabels.Empty() {
+		targetLabels := targetlabels.NewLoader(files.targetLabels, o.l)
+		filters = sinks.NewLabelingSink(filters, targetLabels)
+
+		go func() {
+			err := targetLabels.Watch(ctx)
+			if ctx.Err() == nil {
+				// The labels remain usable without updates.
+				o.l.Errorf("stopped watching target labels files - %s", err)
+			}
+		}()
+	}
+
+	return filters, cancel
+}
+
+// outputSpec describes an output of the configuration.
+type outputSpec struct {
+	// key identifies the output's configuration. An output
+	// whose key did not change is kept by a reload.
+	key string
+
+	// spoolDir is the output's spool directory, if any.
+	spoolDir string
+
+	// open opens the output. It may block until ctx is marked as
+	// done (e.g., while waiting for a named pipe to exist).
+	open func(ctx context.Context) (*sinks.Output, error)
+}
+
+// outputSpecs returns the outputs described by cfg.
+func outputSpecs(cfg *config, options sinks.OutputOptions) []outputSpec {
+	var specs []outputSpec
+
+	if cfg.Outputs.AppEvents.Path != "" {
+		appEvents := cfg.Outputs.AppEvents
+
+		specs = append(specs, outputSpec{
+			key: fmt.Sprintf("app-events-output %+v", appEvents),
+			open: func(ctx context.Context) (*sinks.Output, error) {
+				appEventsEncoder, err := sinks.NewEncoder(sinks.Format(appEvents.Format))
+				if err != nil {
+					return nil, fmt.Errorf("invalid -app-events-output-format: %w", err)
+				}
+
+				auf, err := sinks.OpenRotatingFile(ctx, appEvents.Path, appEvents.rotation(), options.Logger)
+				if err != nil {
+					return nil, fmt.Errorf("failed to open audit log file: %w", err)
+				}
+
+				// Failing to write to the app events output is
+				// fatal (the default ErrorPolicy), as it always
+				// has been.
+				return &sinks.Output{
+					Name: appEvents.Path,
+					Sink: sinks.NewEncodingWriterSink(auf, appEventsEncoder),
+				}, nil
+			},
+		})
+	}
+
+	for _, spec := range cfg.Outputs.Sinks {
+		spec := spec
+
+		outputSpec := outputSpec{
+			key: "sink " + spec,
+			open: func(ctx context.Context) (*sinks.Output, error) {
+				return sinks.ParseOutput(ctx, spec, options)
+			},
+		}
+
+		// The specification was validated when the configuration
+		// was loaded, ParseOutput reports any other error.
+		if u, err := url.Parse(spec); err == nil {
+			if spoolDir := u.Query().Get("spool-dir"); spoolDir != "" {
+				outputSpec.spoolDir = filepath.Clean(spoolDir)
+			}
+		}
+
+		specs = append(specs, outputSpec)
+	}
+
+	return specs
+}
+
+// runningOutput is an output written to by its own FanOut, whose Run
+// method is running. Each output has its own FanOut so that it can be
+// kept when the configuration is reloaded.
+type runningOutput struct {
+	spec   outputSpec
+	fanOut *sinks.FanOut
+	cancel context.CancelFunc
+
+	// retired is set once the output is being stopped
+	// on purpose, rather than because it failed.
+	retired atomic.Bool
+
+	// done is closed when Run returns. err is
+	// Run's error, and may be read once done is closed.
+	done chan struct{}
+	err  error
+}
+
+// retire waits for up to timeout for the queued events to be
+// written, then stops and closes the output. Retiring an output
+// that was already retired has no effect.
+func (o *runningOutput) retire(timeout time.Duration) error {
+	if o.retired.Swap(true) {
+		return nil
+	}
+
+	var flushErr error
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		flushErr = o.fanOut.Flush(ctx)
+		cancel()
+	}
+
+	o.cancel()
+	<-o.done
+
+	if o.err != nil && !errors.Is(o.err, context.Canceled) {
+		_ = o.fanOut.Close()
+		return o.err
+	}
+
+	err := o.fanOut.Close()
+	if err != nil {
+		return err
+	}
+
+	return flushErr
+}
+
+var _ sinks.EventSink = runningOutputs{}
+
+// runningOutputs are the outputs that events are written to.
+type runningOutputs []*runningOutput
+
+// Write writes the event to each output. Refer to FanOut.Write.
+func (o runningOutputs) Write(event *auditevent.AuditEvent) error {
+	for _, output := range o {
+		err := output.fanOut.Write(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush waits until the events queued for the outputs have been
+// written or until ctx is marked as done. Refer to FanOut.Flush.
+func (o runningOutputs) Flush(ctx context.Context) error {
+	var firstErr error
+
+	for _, output := range o {
+		err := output.fanOut.Flush(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Reopen reopens the outputs' EventSinks. Refer to FanOut.Reopen.
+func (o runningOutputs) Reopen() error {
+	var firstErr error
+
+	for _, output := range o {
+		err := output.fanOut.Reopen()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// retire retires the outputs (refer to runningOutput.retire), except
+// for the ones that are part of kept. The outputs that are nil (i.e.,
+// that were not started) are skipped.
+func (o runningOutputs) retire(kept runningOutputs, timeout time.Duration) error {
+	var firstErr error
+
+	for _, output := range o {
+		if output == nil || kept.contains(output) {
+			continue
+		}
+
+		err := output.retire(timeout)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (o runningOutputs) contains(output *runningOutput) bool {
+	for _, other := range o {
+		if other == output {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usesSpoolDir returns true if one of the outputs that
+// are not part of kept uses the spool directory dir.
+func (o runningOutputs) usesSpoolDir(dir string, kept runningOutputs) bool {
+	for _, output := range o {
+		if output != nil && output.spec.spoolDir == dir && !kept.contains(output) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settingsRequiringRestart returns the names of the settings
+// that differ between the running and reloaded configurations
+// but are only read when the daemon starts.
+func settingsRequiringRestart(running, reloaded *config) []string {
+	var changed []string
+
+	for _, setting := range []struct {
+		name              string
+		running, reloaded any
+	}{
+		{"inputs", running.Inputs, reloaded.Inputs},
+		{"outputs.hashChain", running.Outputs.HashChain, reloaded.Outputs.HashChain},
+		{"enrichment.auditRuleKeysFile", running.Enrichment.AuditRuleKeysFile, reloaded.Enrichment.AuditRuleKeysFile},
+		{"transcripts", running.Transcripts, reloaded.Transcripts},
+		{"metrics", running.Metrics, reloaded.Metrics},
+		{"tuning", running.Tuning, reloaded.Tuning},
+		{"reload", running.Reload, reloaded.Reload},
+		{"debug", running.Debug, reloaded.Debug},
+		{"lifecycle", running.Lifecycle, reloaded.Lifecycle},
+		{"shutdown", running.Shutdown, reloaded.Shutdown},
+	} {
+		if !reflect.DeepEqual(setting.running, setting.reloaded) {
+			changed = append(changed, setting.name)
+		}
+	}
+
+	return changed
+}
+
+// watchConfigFiles sends the path of the configuration file, the
+// redaction policy or the target labels config file to changes each
+// time one of them changes, until ctx is marked as done. Like
+// targetlabels.Loader.Watch, it watches the files' directories so
+// that replaced files (e.g., Kubernetes ConfigMaps) are noticed.
+//
+// The returned error is always non-nil.
+func watchConfigFiles(ctx context.Context, cfg *config, changes chan<- string) error {
+	watched := make(map[string]struct{})
+	for _, filePath := range []string{cfg.path, cfg.Filters.RedactionPolicy, cfg.Enrichment.TargetLabelsConfig} {
+		if filePath != "" {
+			watched[filepath.Clean(filePath)] = struct{}{}
+		}
+	}
+
+	if len(watched) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create new fsnotify.Watcher - %w", err)
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]struct{})
+	for filePath := range watched {
+		dir := filepath.Dir(filePath)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+
+		err = watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("failed to watch configuration directory '%s' - %w", dir, err)
+		}
+
+		dirs[dir] = struct{}{}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			// Kubernetes replaces the "..data" symbolic
+			// link when a ConfigMap is updated.
+			_, isWatched := watched[filepath.Clean(event.Name)]
+			if !isWatched && filepath.Base(event.Name) != "..data" {
+				continue
+			}
+
+			select {
+			case changes <- event.Name:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+
+			return fmt.Errorf("configuration files watcher error - %w", err)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+var testReloadTrigger = auditevent.EventSource{Type: "signal", Value: "SIGHUP"}
+
+func createTestFiles(t *testing.T, filePaths ...string) {
+	t.Helper()
+
+	for _, filePath := range filePaths {
+		require.NoError(t, os.WriteFile(filePath, nil, 0o600))
+	}
+}
+
+// newTestReloader returns a reloader for the configuration file
+// at configPath, along with the registry of its metrics.
+func newTestReloader(t *testing.T, ctx context.Context, configPath string) (*reloader, *prometheus.Registry) {
+	t.Helper()
+
+	osArgs := []string{"audito-maldito", "-config", configPath}
+
+	cfg, _, err := loadConfig(osArgs, testGetenv(nil), usage)
+	require.NoError(t, err)
+
+	files, err := readConfigFiles(cfg)
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	pprov := metrics.NewPrometheusMetricsProviderForRegisterer(registry)
+
+	r, err := newReloader(ctx, cfg, files, osArgs, zap.NewAtomicLevelAt(cfg.LogLevel),
+		sinks.OutputOptions{Logger: zap.NewNop().Sugar(), Metrics: pprov}, pprov,
+		map[string]string{"host": "test"})
+	require.NoError(t, err)
+
+	r.getenv = testGetenv(nil)
+
+	t.Cleanup(r.close)
+
+	return r, registry
+}
+
+func readTestEvents(t *testing.T, filePath string) []*auditevent.AuditEvent {
+	t.Helper()
+
+	f, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []*auditevent.AuditEvent
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var evt auditevent.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt))
+
+		events = append(events, &evt)
+	}
+
+	require.NoError(t, scanner.Err())
+
+	return events
+}
+
+func lastReloadSuccessful(t *testing.T, registry *prometheus.Registry) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() == metrics.MetricsNamespace+"_config_last_reload_successful" {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	require.Fail(t, "config_last_reload_successful metric not found")
+
+	return 0
+}
+
+func TestReloader_Reload(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.log")
+	after := filepath.Join(dir, "after.log")
+	policyPath := filepath.Join(dir, "policy.json")
+
+	createTestFiles(t, before, after)
+	require.NoError(t, os.WriteFile(policyPath, []byte(
+		`{"redact": [{"pattern": "s3cret"}], "drop": ["subjects.loggedAs"]}`), 0o600))
+
+	configPath := writeTestConfig(t, "outputs:\n  appEvents:\n    path: "+before+"\n")
+
+	r, registry := newTestReloader(t, ctx, configPath)
+	hashBefore := r.Lifecycle().currentConfigHash()
+
+	require.NoError(t, r.EventWriter().Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+	assert.Equal(t, "--password s3cret", r.RedactTranscript("--password s3cret"))
+
+	require.NoError(t, os.WriteFile(configPath, []byte(
+		"logLevel: debug\n"+
+			"outputs:\n  appEvents:\n    path: "+after+"\n"+
+			"filters:\n  redactionPolicy: "+policyPath+"\n"+
+			"tuning:\n  eventTimeout: 1m\n"), 0o600))
+
+	require.NoError(t, r.reload(ctx, testReloadTrigger))
+
+	require.NoError(t, r.EventWriter().Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+
+	beforeEvents := readTestEvents(t, before)
+	require.Len(t, beforeEvents, 1)
+	assert.Equal(t, "root", beforeEvents[0].Subjects["loggedAs"])
+
+	afterEvents := readTestEvents(t, after)
+	require.Len(t, afterEvents, 2)
+
+	reloadEvent := afterEvents[0]
+	require.NoError(t, schemas.ValidateEvent(reloadEvent))
+	assert.Equal(t, common.ActionAgentLifecycle, reloadEvent.Type)
+	assert.Equal(t, auditevent.OutcomeSucceeded, reloadEvent.Outcome)
+	assert.Equal(t, testReloadTrigger, reloadEvent.Source)
+	assert.Equal(t, lifecycleReloadAction, reloadEvent.Metadata.Extra["action"])
+	assert.NotEqual(t, hashBefore, reloadEvent.Metadata.Extra["config_hash"])
+	assert.Equal(t, []any{"tuning"}, reloadEvent.Metadata.Extra["restart_required"])
+	assert.Equal(t, "test", reloadEvent.Target["host"])
+
+	assert.NotContains(t, afterEvents[1].Subjects, "loggedAs")
+	assert.Equal(t, "--password [redacted]", r.RedactTranscript("--password s3cret"))
+
+	assert.Equal(t, zapcore.DebugLevel, r.level.Level())
+	assert.Equal(t, 1.0, lastReloadSuccessful(t, registry))
+}
+
+func TestReloader_ReloadKeepsUnchangedOutputs(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	eventsPath := filepath.Join(dir, "events.log")
+	movedPath := filepath.Join(dir, "events.log.1")
+	createTestFiles(t, eventsPath)
+
+	configPath := writeTestConfig(t, "outputs:\n  appEvents:\n    path: "+eventsPath+"\n")
+
+	r, _ := newTestReloader(t, ctx, configPath)
+	output := r.outputs[0]
+
+	require.NoError(t, r.EventWriter().Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+
+	// The file is moved (e.g., by logrotate) and the configuration
+	// is reloaded by SIGHUP without any change.
+	require.NoError(t, os.Rename(eventsPath, movedPath))
+	createTestFiles(t, eventsPath)
+
+	require.NoError(t, r.reload(ctx, testReloadTrigger))
+	assert.Same(t, output, r.outputs[0])
+
+	require.NoError(t, r.EventWriter().Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+
+	// The output was reopened.
+	assert.Len(t, readTestEvents(t, movedPath), 1)
+
+	events := readTestEvents(t, eventsPath)
+	require.Len(t, events, 2)
+	assert.Equal(t, lifecycleReloadAction, events[0].Metadata.Extra["action"])
+	assert.Equal(t, common.ActionLoginIdentifier, events[1].Type)
+}
+
+// testSpoolCollector is an HTTP handler that records the audit IDs
+// of the events it receives, once it is available.
+type testSpoolCollector struct {
+	available atomic.Bool
+
+	mu       sync.Mutex
+	auditIDs []string
+}
+
+func (o *testSpoolCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !o.available.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var evt auditevent.AuditEvent
+		if json.Unmarshal(bytes.TrimSpace(scanner.Bytes()), &evt) == nil {
+			o.auditIDs = append(o.auditIDs, evt.Metadata.AuditID)
+		}
+	}
+}
+
+func (o *testSpoolCollector) received() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]string(nil), o.auditIDs...)
+}
+
+func TestReloader_ReloadSpooledOutput(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collector := &testSpoolCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	spoolDir := t.TempDir()
+
+	sinkConfig := func(batchSize string) string {
+		return "outputs:\n  appEvents:\n    path: \"\"\n  sinks:\n" +
+			"    - " + server.URL + "/?" + url.Values{
+			"spool-dir":          {spoolDir},
+			"gzip":               {"false"},
+			"batch-size":         {batchSize},
+			"flush-interval":     {"10ms"},
+			"max-retry-interval": {"10ms"},
+		}.Encode() + "\n"
+	}
+
+	configPath := writeTestConfig(t, sinkConfig("100"))
+
+	r, _ := newTestReloader(t, ctx, configPath)
+	output := r.outputs[0]
+
+	// The endpoint is unavailable, so the events
+	// are queued in the webhook's memory.
+	for _, auditID := range []string{"1", "2", "3"} {
+		evt := testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()
+		evt.Metadata.AuditID = auditID
+		require.NoError(t, r.EventWriter().Write(evt))
+	}
+
+	require.NoError(t, r.reload(ctx, testReloadTrigger))
+	require.Same(t, output, r.outputs[0])
+
+	// The previous webhook spools its events before the
+	// new one opens the spool directory, which delivers
+	// them once the endpoint is available.
+	require.NoError(t, os.WriteFile(configPath, []byte(sinkConfig("50")), 0o600))
+	require.NoError(t, r.reload(ctx, testReloadTrigger))
+	require.NotSame(t, output, r.outputs[0])
+
+	collector.available.Store(true)
+
+	assert.Eventually(t, func() bool {
+		received := collector.received()
+		return len(received) >= 3 && assert.ObjectsAreEqual([]string{"1", "2", "3"}, received[:3])
+	}, 5*time.Second, 10*time.Millisecond, "received: %v", collector.received())
+}
+
+func TestReloader_ReloadInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventsPath := filepath.Join(t.TempDir(), "events.log")
+	createTestFiles(t, eventsPath)
+	configPath := writeTestConfig(t, "outputs:\n  appEvents:\n    path: "+eventsPath+"\n")
+
+	r, registry := newTestReloader(t, ctx, configPath)
+	hashBefore := r.Lifecycle().currentConfigHash()
+
+	require.NoError(t, os.WriteFile(configPath, []byte("outputs:\n  appEvents:\n    format: xml\n"), 0o600))
+
+	require.NoError(t, r.reload(ctx, testReloadTrigger))
+
+	require.NoError(t, r.EventWriter().Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+
+	events := readTestEvents(t, eventsPath)
+	require.Len(t, events, 2)
+
+	require.NoError(t, schemas.ValidateEvent(events[0]))
+	assert.Equal(t, common.ActionAgentLifecycle, events[0].Type)
+	assert.Equal(t, auditevent.OutcomeFailed, events[0].Outcome)
+	assert.Contains(t, events[0].Metadata.Extra["error"], "xml")
+	assert.Equal(t, hashBefore, events[0].Metadata.Extra["config_hash"])
+
+	assert.Equal(t, common.ActionLoginIdentifier, events[1].Type)
+
+	assert.Equal(t, 0.0, lastReloadSuccessful(t, registry))
+}
+
+func TestSettingsRequiringRestart(t *testing.T) {
+	t.Parallel()
+
+	running := defaultConfig()
+
+	reloaded := defaultConfig()
+	reloaded.LogLevel = zapcore.DebugLevel
+	reloaded.Outputs.Sinks = []string{"stdout:"}
+	reloaded.Filters.RedactionPolicy = "/etc/audito-maldito/policy.json"
+
+	assert.Empty(t, settingsRequiringRestart(running, reloaded))
+
+	reloaded.Inputs.SshdPipePath = "/tmp/sshd-pipe"
+	reloaded.Outputs.HashChain.Enabled = true
+	reloaded.Tuning.EventTimeout = 0
+
+	assert.Equal(t, []string{"inputs", "outputs.hashChain", "tuning"},
+		settingsRequiringRestart(running, reloaded))
+}
+
+func TestWatchConfigFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configPath := writeTestConfig(t, "logLevel: info\n")
+
+	cfg := defaultConfig()
+	cfg.path = configPath
+
+	changes := make(chan string)
+	watchErr := make(chan error, 1)
+
+	go func() {
+		watchErr <- watchConfigFiles(ctx, cfg, changes)
+	}()
+
+	// Other files in the directory are ignored.
+	require.Eventually(t, func() bool {
+		otherPath := filepath.Join(filepath.Dir(configPath), "other.yaml")
+		require.NoError(t, os.WriteFile(otherPath, nil, 0o600))
+		require.NoError(t, os.WriteFile(configPath, []byte("logLevel: debug\n"), 0o600))
+
+		select {
+		case changed := <-changes:
+			assert.Equal(t, configPath, changed)
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-watchErr, context.Canceled)
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+)
+
+const schemaUsage = `audito-maldito schema
+
+DESCRIPTION
+  schema prints the JSON Schemas of the audit events. Without arguments,
+  it lists the event types and their schema versions. The schema of an
+  event type is printed if one is specified.
+
+  The validate command checks that each audit event in one or more JSON
+  output files conforms to the schema of its type. A file path of "-"
+  reads from stdin.
+
+SYNOPSIS
+  audito-maldito schema [TYPE]
+  audito-maldito schema validate FILE...
+`
+
+// maxValidatedEventSize is the maximum size of an
+// event that is read by "schema validate".
+const maxValidatedEventSize = 16 * 1024 * 1024
+
+// RunSchema runs the schema command using the arguments specified
+// in osArgs and writes its output to w. A non-nil error is returned
+// if an event does not conform to its schema.
+func RunSchema(osArgs []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet(osArgs[0], flag.ContinueOnError)
+
+	flagSet.Usage = func() {
+		os.Stderr.WriteString(schemaUsage)
+		os.Exit(1)
+	}
+
+	err := flagSet.Parse(osArgs[1:])
+	if err != nil {
+		return err
+	}
+
+	switch flagSet.Arg(0) {
+	case "":
+		for _, eventType := range schemas.Types() {
+			version, _ := schemas.Version(eventType)
+
+			_, err := fmt.Fprintf(w, "%s %s\n", eventType, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case "validate":
+		if flagSet.NArg() < 2 {
+			return errors.New("please specify at least one file to validate")
+		}
+
+		return validateFiles(flagSet.Args()[1:], w)
+	default:
+		schema, ok := schemas.Schema(flagSet.Arg(0))
+		if !ok {
+			return fmt.Errorf("unknown event type %q (known types: %q)",
+				flagSet.Arg(0), schemas.Types())
+		}
+
+		_, err := w.Write(schema)
+		return err
+	}
+}
+
+func validateFiles(filePaths []string, w io.Writer) error {
+	failed := false
+
+	for _, filePath := range filePaths {
+		invalid, total, err := validateFile(filePath, w)
+		if err != nil {
+			return err
+		}
+
+		status := "ok"
+		if invalid > 0 {
+			failed = true
+			status = "FAILED"
+		}
+
+		_, err = fmt.Fprintf(w, "%s: %s (events: %d, invalid: %d)\n",
+			filePath, status, total, invalid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errors.New("schema validation failed")
+	}
+
+	return nil
+}
+
+// validateFile validates each line of a file and writes the problems
+// it finds to w. It returns the number of invalid and total events.
+func validateFile(filePath string, w io.Writer) (int, int, error) {
+	r := io.Reader(os.Stdin)
+
+	if filePath != "-" {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxValidatedEventSize)
+
+	var invalid, total, line int
+
+	for scanner.Scan() {
+		line++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		total++
+
+		err := schemas.Validate(scanner.Bytes())
+		if err != nil {
+			invalid++
+
+			_, err = fmt.Fprintf(w, "%s:%d: %s\n", filePath, line, err)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read %q: %w", filePath, err)
+	}
+
+	return invalid, total, nil
+}
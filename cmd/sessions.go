@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+const sessionsUsage = `audito-maldito sessions
+
+DESCRIPTION
+  sessions prints the audit sessions tracked by a running daemon: their
+  audit session ID, the PID that started them, their age, the identity
+  of the credential they are bound to and the number of audit events
+  cached until they are bound to a remote user login. Remote user logins
+  that are waiting for their audit session are listed by PID.
+
+  The daemon serves this information on the unix socket specified using
+  its -debug-socket flag (or debug.socket). The socket is only accessible
+  to the user that the daemon runs as.
+
+SYNOPSIS
+  audito-maldito sessions [options]
+
+OPTIONS
+`
+
+const (
+	// DefaultDebugSocketPath is the path of the debug socket that
+	// the sessions command connects to unless -socket is specified.
+	DefaultDebugSocketPath = "/run/audito-maldito/debug.sock"
+
+	// debugSessionsPath is the HTTP path of the
+	// sessions endpoint of the debug socket.
+	debugSessionsPath = "/sessions"
+
+	// sessionsRequestTimeout is the maximum duration of
+	// the sessions command's request to the daemon.
+	sessionsRequestTimeout = 10 * time.Second
+)
+
+// handleDebugSocket serves the debug endpoints on the unix socket
+// specified by dc, unless its path is empty.
+func handleDebugSocket(ctx context.Context, dc debugConfig, eg *errgroup.Group,
+	sessions *sessiontracker.Introspector,
+) {
+	if dc.Socket == "" {
+		return
+	}
+
+	eg.Go(func() error {
+		return serveDebugSocket(ctx, dc.Socket, sessions, logger)
+	})
+}
+
+// serveDebugSocket serves the debug endpoints on the unix socket at
+// socketPath until ctx is marked as done. The socket is removed when
+// it returns.
+//
+// The returned error is always non-nil.
+func serveDebugSocket(ctx context.Context, socketPath string, sessions *sessiontracker.Introspector,
+	l *zap.SugaredLogger,
+) error {
+	listener, err := listenUnixSocket(socketPath, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to listen on debug socket: %w", err)
+	}
+
+	defer os.Remove(socketPath)
+
+	mux := http.NewServeMux()
+	mux.Handle(debugSessionsPath, sessionsHandler(sessions))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: DefaultHTTPServerReadHeaderTimeout,
+	}
+
+	l.Infof("serving debug endpoints on unix socket '%s'...", socketPath)
+
+	err = serveUntilDone(ctx, server, listener)
+
+	l.Infoln("stopped debug socket server")
+
+	return err
+}
+
+// sessionsHandler returns an http.Handler that responds with
+// a JSON sessiontracker.Snapshot of sessions. It responds with
+// status code 503 if the auditd processor is not running.
+func sessionsHandler(sessions *sessiontracker.Introspector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		snapshot, ok := sessions.Snapshot()
+		if !ok {
+			http.Error(w, "the auditd processor is not running", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck,errchkjson // Nothing can be done if the client went away.
+		_ = json.NewEncoder(w).Encode(snapshot)
+	})
+}
+
+// RunSessions runs the sessions command using the arguments specified
+// in osArgs and writes the sessions of the running daemon to w.
+func RunSessions(ctx context.Context, osArgs []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet(osArgs[0], flag.ContinueOnError)
+
+	flagSet.Usage = func() {
+		os.Stderr.WriteString(sessionsUsage)
+		flagSet.PrintDefaults()
+	}
+
+	socketPath := os.Getenv(EnvVarPrefix + "_DEBUG_SOCKET")
+	if socketPath == "" {
+		socketPath = DefaultDebugSocketPath
+	}
+
+	flagSet.StringVar(&socketPath, "socket", socketPath,
+		"Path to the daemon's debug socket (refer to the daemon's -debug-socket flag)")
+	printJSON := flagSet.Bool("json", false, "Print the sessions as JSON")
+
+	err := flagSet.Parse(osArgs[1:])
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %q", flagSet.Args())
+	}
+
+	snapshot, err := fetchSessions(ctx, socketPath)
+	if err != nil {
+		return err
+	}
+
+	if *printJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(snapshot)
+	}
+
+	return writeSessionsTable(w, snapshot)
+}
+
+// fetchSessions requests the sessions of the daemon
+// serving the debug socket at socketPath.
+func fetchSessions(ctx context.Context, socketPath string) (sessiontracker.Snapshot, error) {
+	var snapshot sessiontracker.Snapshot
+
+	client := &http.Client{
+		Timeout: sessionsRequestTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	// The host is ignored, as the request is sent to the socket.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://audito-maldito"+debugSessionsPath, nil)
+	if err != nil {
+		return snapshot, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to connect to debug socket %q: %w", socketPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return snapshot, fmt.Errorf("failed to get sessions: %s: %s", resp.Status, body)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// writeSessionsTable writes the snapshot's sessions and
+// pending remote user logins to w as aligned columns.
+func writeSessionsTable(w io.Writer, snapshot sessiontracker.Snapshot) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "SESSION\tPID\tAGE\tUSER ID\tLOGGED AS\tSOURCE\tCACHED EVENTS")
+
+	for _, session := range snapshot.Sessions {
+		userID := session.UserID
+		if !session.HasLogin {
+			userID = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%d\n", session.ID, session.PID, session.Age,
+			userID, orDash(session.LoggedAs), orDash(session.Source), session.CachedEvents)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PENDING LOGIN PID\tAGE\tUSER ID\tLOGGED AS\tSOURCE")
+
+	for _, login := range snapshot.PendingLogins {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", login.PID, orDash(login.Age),
+			login.UserID, orDash(login.LoggedAs), orDash(login.Source))
+	}
+
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
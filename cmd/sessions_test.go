@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socketPath := filepath.Join(t.TempDir(), "debug.sock")
+	sessions := sessiontracker.NewIntrospector()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveDebugSocket(ctx, socketPath, sessions, zap.NewNop().Sugar())
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = listenUnixSocket(socketPath, 0o600)
+	assert.ErrorContains(t, err, "in use")
+
+	osArgs := []string{"sessions", "-socket", socketPath}
+
+	// The auditd processor is not running.
+	err = RunSessions(ctx, osArgs, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "503")
+
+	tracker := sessiontracker.NewSessionTracker(nil, nil)
+	require.NoError(t, tracker.RemoteLogin(common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			LoggedAt: time.Now().Add(-time.Minute),
+			Subjects: map[string]string{"loggedAs": "core"},
+			Source:   auditevent.EventSource{Type: "IP", Value: "192.0.2.10"},
+		},
+		PID:        1234,
+		CredUserID: "alice@example.com",
+	}))
+
+	sessions.Attach(tracker)
+
+	var table bytes.Buffer
+	require.NoError(t, RunSessions(ctx, osArgs, &table))
+	assert.Equal(t,
+		"SESSION  PID  AGE  USER ID  LOGGED AS  SOURCE  CACHED EVENTS\n"+
+			"\n"+
+			"PENDING LOGIN PID  AGE   USER ID            LOGGED AS  SOURCE\n"+
+			"1234               1m0s  alice@example.com  core       192.0.2.10\n",
+		table.String())
+
+	var out bytes.Buffer
+	require.NoError(t, RunSessions(ctx, append(osArgs, "-json"), &out))
+
+	var snapshot sessiontracker.Snapshot
+	require.NoError(t, json.Unmarshal(out.Bytes(), &snapshot))
+	assert.Empty(t, snapshot.Sessions)
+	require.Len(t, snapshot.PendingLogins, 1)
+	assert.Equal(t, 1234, snapshot.PendingLogins[0].PID)
+	assert.Equal(t, "alice@example.com", snapshot.PendingLogins[0].UserID)
+
+	cancel()
+	assert.ErrorIs(t, <-serveErr, context.Canceled)
+
+	_, err = os.Stat(socketPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestListenUnixSocket_NotASocket(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "debug.sock")
+	createTestFiles(t, filePath)
+
+	_, err := listenUnixSocket(filePath, 0o600)
+	assert.ErrorContains(t, err, "is not a socket")
+}
+
+func TestListenUnixSocket_Stale(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "debug.sock")
+
+	stale, err := listenUnixSocket(socketPath, 0o600)
+	require.NoError(t, err)
+	require.NoError(t, stale.Close())
+
+	listener, err := listenUnixSocket(socketPath, 0o600)
+	require.NoError(t, err)
+	assert.NoError(t, listener.Close())
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// shutdown stops the daemon in stages once it is told to stop (e.g., on
+// SIGTERM), so that the events that were read are written:
+//
+//  1. the ingesters stop reading the named pipes (Ingestion is done),
+//  2. the auditd processor processes what they read and flushes the
+//     events it holds (e.g., those of sessions without a login),
+//  3. the reloader writes the stop event and flushes the outputs
+//     (Pipeline is done once the processors have returned).
+//
+// If the stages take longer than the timeout, the remaining ones are
+// stopped immediately. They are also stopped immediately if a worker
+// fails, as all of the contexts derive from the errgroup's.
+type shutdown struct {
+	timeout time.Duration
+	l       *zap.SugaredLogger
+
+	hard     context.Context
+	stopHard context.CancelFunc
+
+	ingestion     context.Context
+	stopIngestion context.CancelFunc
+
+	pipeline     context.Context
+	stopPipeline context.CancelFunc
+
+	timedOut atomic.Bool
+}
+
+// newShutdown returns a shutdown whose stages derive from hard, which
+// must be canceled by stopHard (e.g., the errgroup's context).
+func newShutdown(hard context.Context, stopHard context.CancelFunc, timeout time.Duration,
+	l *zap.SugaredLogger,
+) *shutdown {
+	o := &shutdown{
+		timeout:  timeout,
+		l:        l,
+		hard:     hard,
+		stopHard: stopHard,
+	}
+
+	o.ingestion, o.stopIngestion = context.WithCancel(hard)
+	o.pipeline, o.stopPipeline = context.WithCancel(hard)
+
+	return o
+}
+
+// Ingestion returns the context of the ingesters,
+// which is done when the shutdown starts.
+func (o *shutdown) Ingestion() context.Context {
+	return o.ingestion
+}
+
+// Pipeline returns the context of the reloader and the servers,
+// which is done once ProcessorsDone is called.
+func (o *shutdown) Pipeline() context.Context {
+	return o.pipeline
+}
+
+// ProcessorsDone tells the shutdown that the processors have written
+// their events, so the outputs may be flushed and stopped.
+func (o *shutdown) ProcessorsDone() {
+	o.stopPipeline()
+}
+
+// Run starts the shutdown when ctx is marked as done, and stops the
+// remaining stages once the timeout expires. It returns when the
+// shutdown's hard context is done.
+func (o *shutdown) Run(ctx context.Context) {
+	select {
+	case <-o.hard.Done():
+		return
+	case <-ctx.Done():
+	}
+
+	o.l.Infof("shutting down (timeout: %s)...", o.timeout)
+	o.stopIngestion()
+
+	timer := time.NewTimer(o.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-o.hard.Done():
+	case <-timer.C:
+		o.timedOut.Store(true)
+		o.l.Errorf("failed to shut down within %s, stopping immediately", o.timeout)
+		o.stopHard()
+	}
+}
+
+// TimedOut returns true if the timeout expired
+// before the stages stopped on their own.
+func (o *shutdown) TimedOut() bool {
+	return o.timedOut.Load()
+}
+
+// Stopped returns nil if err was returned by a stage whose context
+// (stage) was done because of the shutdown rather than a failure.
+func (o *shutdown) Stopped(stage context.Context, err error) error {
+	if err != nil && stage.Err() != nil && o.hard.Err() == nil {
+		return nil
+	}
+
+	return err
+}
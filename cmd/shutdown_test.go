@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+// slowSink is an EventSink that takes a while to write events.
+type slowSink struct {
+	mu     sync.Mutex
+	events []*auditevent.AuditEvent
+}
+
+func (o *slowSink) Write(event *auditevent.AuditEvent) error {
+	time.Sleep(10 * time.Millisecond)
+
+	o.mu.Lock()
+	o.events = append(o.events, event)
+	o.mu.Unlock()
+
+	return nil
+}
+
+func TestShutdown_Stages(t *testing.T) {
+	t.Parallel()
+
+	hard, stopHard := context.WithCancel(context.Background())
+	defer stopHard()
+
+	stop := newShutdown(hard, stopHard, time.Minute, zap.NewNop().Sugar())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		stop.Run(ctx)
+	}()
+
+	cancel()
+
+	<-stop.Ingestion().Done()
+	assert.NoError(t, stop.Pipeline().Err())
+
+	// Errors caused by the shutdown are not failures.
+	failed := errors.New("file already closed")
+	assert.NoError(t, stop.Stopped(stop.Ingestion(), failed))
+	assert.ErrorIs(t, stop.Stopped(stop.Pipeline(), failed), failed)
+
+	stop.ProcessorsDone()
+	assert.Error(t, stop.Pipeline().Err())
+
+	stopHard()
+	<-runDone
+
+	assert.False(t, stop.TimedOut())
+}
+
+func TestShutdown_TimedOut(t *testing.T) {
+	t.Parallel()
+
+	hard, stopHard := context.WithCancel(context.Background())
+	defer stopHard()
+
+	stop := newShutdown(hard, stopHard, 10*time.Millisecond, zap.NewNop().Sugar())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stop.Run(ctx)
+
+	assert.True(t, stop.TimedOut())
+	assert.Error(t, hard.Err())
+	assert.Error(t, stop.Pipeline().Err())
+
+	// Once stopped immediately, errors are failures.
+	assert.ErrorIs(t, stop.Stopped(stop.Pipeline(), context.Canceled), context.Canceled)
+}
+
+func TestShutdown_Failure(t *testing.T) {
+	t.Parallel()
+
+	hard, stopHard := context.WithCancel(context.Background())
+
+	stop := newShutdown(hard, stopHard, time.Minute, zap.NewNop().Sugar())
+
+	// A worker's failure stops every stage.
+	stopHard()
+	stop.Run(context.Background())
+
+	assert.Error(t, stop.Ingestion().Err())
+	assert.Error(t, stop.Pipeline().Err())
+	assert.False(t, stop.TimedOut())
+}
+
+func TestReloader_FlushesOutputsOnStop(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventsPath := filepath.Join(t.TempDir(), "events.log")
+	createTestFiles(t, eventsPath)
+
+	r, _ := newTestReloader(t, ctx, writeTestConfig(t, "outputs:\n  appEvents:\n    path: "+eventsPath+"\n"))
+
+	// The file output is replaced by a slow, buffered one.
+	slow := &slowSink{}
+	outputsCtx, stopOutputs := context.WithCancel(context.Background())
+	defer stopOutputs()
+
+	outputs, _, err := r.startOutputs(outputsCtx, []outputSpec{{
+		key: "slow",
+		open: func(context.Context) (*sinks.Output, error) {
+			return &sinks.Output{
+				Name:       "slow",
+				Sink:       slow,
+				BufferSize: 100,
+				OnError:    sinks.ErrorPolicyLog,
+			}, nil
+		},
+	}}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, r.outputs.retire(nil, 0))
+	r.outputs = outputs
+	r.tail.Swap(outputs)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run(ctx)
+	}()
+
+	const numEvents = 10
+	for i := 0; i < numEvents; i++ {
+		require.NoError(t, r.EventWriter().Write(auditevent.NewAuditEvent(common.ActionUserAction,
+			auditevent.EventSource{Type: "IP", Value: "127.0.0.1"}, auditevent.OutcomeSucceeded,
+			map[string]string{"loggedAs": "user"}, "auditd")))
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+
+	// The queued events, including the stop event,
+	// were written before the outputs were closed.
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+
+	require.Len(t, slow.events, numEvents+2)
+	assert.Equal(t, lifecycleStopAction, slow.events[len(slow.events)-1].Metadata.Extra["action"])
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/sdnotify"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// systemdStatusInterval is the interval at which the status
+// is sent to systemd once the daemon is ready.
+const systemdStatusInterval = 5 * time.Second
+
+// systemdNotifier reports the daemon's readiness and status to
+// systemd when it runs as a service of Type=notify. If the service
+// has a watchdog (WatchdogSec=), it also sends keep-alives while
+// the event pipeline makes progress, so that systemd restarts the
+// daemon if the pipeline is stalled.
+type systemdNotifier struct {
+	notifier        *sdnotify.Notifier
+	watchdogTimeout time.Duration
+	h               *health.Health
+	sessions        *sessiontracker.Introspector
+	eventsWritten   func() int64
+	l               *zap.SugaredLogger
+}
+
+// newSystemdNotifier returns a systemdNotifier configured by the
+// environment variables set by systemd, which are read using getenv.
+// It returns nil if the daemon is not run by systemd as a service
+// of Type=notify.
+func newSystemdNotifier(getenv func(string) string, h *health.Health, sessions *sessiontracker.Introspector,
+	l *zap.SugaredLogger,
+) (*systemdNotifier, error) {
+	notifier := sdnotify.NewNotifier(getenv)
+	if notifier == nil {
+		return nil, nil
+	}
+
+	watchdogTimeout, err := sdnotify.WatchdogTimeout(getenv, os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	return &systemdNotifier{
+		notifier:        notifier,
+		watchdogTimeout: watchdogTimeout,
+		h:               h,
+		sessions:        sessions,
+		l:               l,
+	}, nil
+}
+
+// WithEventsWritten sets the function that returns the number
+// of events written, which is reported in the status.
+func (o *systemdNotifier) WithEventsWritten(eventsWritten func() int64) *systemdNotifier {
+	o.eventsWritten = eventsWritten
+	return o
+}
+
+// interval returns the interval at which notifications are
+// sent once the daemon is ready. Keep-alives are sent every
+// half of the watchdog timeout, as recommended by systemd.
+func (o *systemdNotifier) interval() time.Duration {
+	if o.watchdogTimeout > 0 && o.watchdogTimeout/2 < systemdStatusInterval {
+		return o.watchdogTimeout / 2
+	}
+
+	return systemdStatusInterval
+}
+
+// Run sends notifications to systemd until ctx is marked as
+// done, at which point it tells systemd that the daemon is
+// stopping. Failures to send notifications are logged.
+//
+// The returned error is always non-nil.
+func (o *systemdNotifier) Run(ctx context.Context) error {
+	interval := o.interval()
+
+	// Readiness is checked more often until the daemon is ready.
+	ticker := time.NewTicker(minDuration(health.DefaultReadyCheckInterval, interval))
+	defer ticker.Stop()
+
+	if o.watchdogTimeout > 0 {
+		o.l.Infof("sending systemd watchdog keep-alives every %s", interval)
+	}
+
+	ready := false
+	live := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			o.notify(sdnotify.Stopping, sdnotify.Status("Stopping"))
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var notifications []string
+
+		if !ready && o.h.IsReady() {
+			ready = true
+			notifications = append(notifications, sdnotify.Ready)
+			ticker.Reset(interval)
+
+			o.l.Infoln("notified systemd that the daemon is ready")
+		}
+
+		livez := o.h.GetLivezStatus()
+		stalled := stalledComponents(livez)
+
+		switch {
+		case len(stalled) == 0 && !live:
+			o.l.Infoln("the event pipeline is making progress again, resuming systemd watchdog keep-alives")
+		case len(stalled) > 0 && live:
+			o.l.Warnf("the event pipeline is stalled (%s), suspending systemd watchdog keep-alives",
+				strings.Join(stalled, ", "))
+		}
+
+		live = len(stalled) == 0
+
+		if live && o.watchdogTimeout > 0 {
+			notifications = append(notifications, sdnotify.Watchdog)
+		}
+
+		notifications = append(notifications, sdnotify.Status(o.status(ready, stalled)))
+
+		o.notify(notifications...)
+	}
+}
+
+func (o *systemdNotifier) notify(notifications ...string) {
+	err := o.notifier.Notify(notifications...)
+	if err != nil {
+		o.l.Warnf("failed to notify systemd - %s", err)
+	}
+}
+
+// status returns the status displayed by "systemctl status",
+// e.g., "Processing: 1204 events written, 3 sessions, 0 cached
+// events, 1 pending logins".
+func (o *systemdNotifier) status(ready bool, stalled []string) string {
+	if !ready {
+		return "Starting: waiting for " + strings.Join(notReadyComponents(o.h), ", ")
+	}
+
+	state := "Processing"
+	if len(stalled) > 0 {
+		state = "Stalled (" + strings.Join(stalled, ", ") + ")"
+	}
+
+	var stats []string
+
+	if o.eventsWritten != nil {
+		stats = append(stats, fmt.Sprintf("%d events written", o.eventsWritten()))
+	}
+
+	sessionStats, ok := o.sessions.Stats()
+	if ok {
+		stats = append(stats, fmt.Sprintf("%d sessions, %d cached events, %d pending logins",
+			sessionStats.Sessions, sessionStats.CachedEvents, sessionStats.PendingLogins))
+	}
+
+	status := state
+	if len(stats) > 0 {
+		status += ": " + strings.Join(stats, ", ")
+	}
+
+	return status
+}
+
+// stalledComponents returns the sorted names
+// of the components that are stalled.
+func stalledComponents(livez health.LivezStatus) []string {
+	var stalled []string
+
+	for component, liveness := range livez.Components {
+		if liveness.Status != health.ComponentLive {
+			stalled = append(stalled, component)
+		}
+	}
+
+	sort.Strings(stalled)
+
+	return stalled
+}
+
+// notReadyComponents returns the sorted names
+// of the components that are not ready.
+func notReadyComponents(h *health.Health) []string {
+	var notReady []string
+
+	for component, status := range h.GetReadyzStatusMap() {
+		if component != health.OverallReady && status != health.ComponentReady {
+			notReady = append(notReady, component)
+		}
+	}
+
+	sort.Strings(notReady)
+
+	return notReady
+}
+
+func minDuration(a time.Duration, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+
+	return b
+}
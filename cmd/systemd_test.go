@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/sdnotify"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// listenTestNotifySocket returns a NOTIFY_SOCKET listener and
+// a function that returns the next notification it receives.
+func listenTestNotifySocket(t *testing.T) (socketPath string, next func() string) {
+	t.Helper()
+
+	socketPath = filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return socketPath, func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+
+		return string(buf[:n])
+	}
+}
+
+func TestNewSystemdNotifier_NotSystemd(t *testing.T) {
+	t.Parallel()
+
+	notifier, err := newSystemdNotifier(testGetenv(nil), health.NewHealth(), nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	assert.Nil(t, notifier)
+}
+
+func TestNewSystemdNotifier_InvalidWatchdog(t *testing.T) {
+	t.Parallel()
+
+	_, err := newSystemdNotifier(testGetenv(map[string]string{
+		sdnotify.SocketEnvVar:       "/run/systemd/notify",
+		sdnotify.WatchdogUsecEnvVar: "soon",
+	}), health.NewHealth(), nil, zap.NewNop().Sugar())
+	assert.Error(t, err)
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	t.Parallel()
+
+	socketPath, next := listenTestNotifySocket(t)
+
+	h := health.NewSingleReadinessHealth("input")
+	hb := h.AddHeartbeat(writerComponentName, 50*time.Millisecond)
+
+	sessions := sessiontracker.NewIntrospector()
+	sessions.Attach(sessiontracker.NewSessionTracker(nil, nil))
+
+	notifier, err := newSystemdNotifier(testGetenv(map[string]string{
+		sdnotify.SocketEnvVar:       socketPath,
+		sdnotify.WatchdogUsecEnvVar: "200000",
+	}), h, sessions, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	require.NotNil(t, notifier)
+
+	notifier.WithEventsWritten(func() int64 { return 12 })
+	assert.Equal(t, 100*time.Millisecond, notifier.interval())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- notifier.Run(ctx)
+	}()
+
+	// Keep-alives are sent while the pipeline makes progress,
+	// even before the daemon is ready.
+	assert.Equal(t, "WATCHDOG=1\nSTATUS=Starting: waiting for input", next())
+
+	h.OnReady("input")
+
+	notification := next()
+	for !strings.HasPrefix(notification, sdnotify.Ready) {
+		notification = next()
+	}
+
+	assert.Equal(t, "READY=1\nWATCHDOG=1\n"+
+		"STATUS=Processing: 12 events written, 0 sessions, 0 cached events, 0 pending logins", notification)
+
+	// Keep-alives stop while the writer is stalled.
+	hb.Start()
+
+	for !strings.HasPrefix(notification, "STATUS=Stalled") {
+		notification = next()
+	}
+
+	assert.Equal(t, "STATUS=Stalled (writer): 12 events written, 0 sessions, 0 cached events, "+
+		"0 pending logins", notification)
+
+	hb.Done()
+
+	for !strings.HasPrefix(notification, sdnotify.Watchdog) {
+		notification = next()
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+
+	for notification != "STOPPING=1\nSTATUS=Stopping" {
+		notification = next()
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+const verifyUsage = `audito-maldito verify
+
+DESCRIPTION
+  verify checks the hash chain of the audit events in one or more
+  JSON output files and reports events that were modified, removed,
+  reordered or that have an invalid anchor signature. A file path
+  of "-" reads from stdin.
+
+  If a public key is given, every anchor must be signed. A chain
+  that restarts in the middle of a file must start with a signed
+  anchor. The events after the last anchor of a chain are reported
+  as unanchored: their modification or removal cannot be detected.
+
+SYNOPSIS
+  audito-maldito verify [options] FILE...
+
+OPTIONS
+`
+
+// RunVerify verifies the hash chain of the audit events found in the
+// files specified in osArgs and writes a report to w. A non-nil error
+// is returned if a problem is found.
+func RunVerify(osArgs []string, w io.Writer) error {
+	var publicKeyPath string
+	var jsonOutput bool
+
+	flagSet := flag.NewFlagSet(osArgs[0], flag.ContinueOnError)
+
+	flagSet.StringVar(
+		&publicKeyPath,
+		"public-key",
+		"",
+		"Optional path to the PEM-encoded ed25519 public key used to verify anchor signatures")
+	flagSet.BoolVar(&jsonOutput, "json", false, "Write the report as JSON")
+
+	flagSet.Usage = func() {
+		os.Stderr.WriteString(verifyUsage)
+		flagSet.PrintDefaults()
+		os.Exit(1)
+	}
+
+	err := flagSet.Parse(osArgs[1:])
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() == 0 {
+		return errors.New("please specify at least one file to verify")
+	}
+
+	var publicKey ed25519.PublicKey
+	if publicKeyPath != "" {
+		publicKey, err = sinks.ReadVerifyingKey(publicKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+	}
+
+	verifier := sinks.NewChainVerifier(publicKey)
+	failed := false
+
+	for _, filePath := range flagSet.Args() {
+		report, err := verifyFile(verifier, filePath)
+		if err != nil {
+			return err
+		}
+
+		if !report.OK() {
+			failed = true
+		}
+
+		err = writeChainReport(w, filePath, report, jsonOutput)
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errors.New("hash chain verification failed")
+	}
+
+	return nil
+}
+
+func verifyFile(verifier *sinks.ChainVerifier, filePath string) (*sinks.ChainReport, error) {
+	if filePath == "-" {
+		return verifier.Verify(os.Stdin)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	report, err := verifier.Verify(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", filePath, err)
+	}
+
+	return report, nil
+}
+
+func writeChainReport(w io.Writer, filePath string, report *sinks.ChainReport, jsonOutput bool) error {
+	if jsonOutput {
+		return json.NewEncoder(w).Encode(struct {
+			File string `json:"file"`
+			*sinks.ChainReport
+		}{
+			File:        filePath,
+			ChainReport: report,
+		})
+	}
+
+	for _, problem := range report.Problems {
+		_, err := fmt.Fprintf(w, "%s: %s\n", filePath, problem)
+		if err != nil {
+			return err
+		}
+	}
+
+	status := "ok"
+	if !report.OK() {
+		status = "FAILED"
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %s (events: %d, chains: %d, verified anchors: %d, "+
+		"unverified anchors: %d, unanchored events: %d, problems: %d)\n",
+		filePath, status, report.Events, report.Chains, report.VerifiedAnchors,
+		report.UnverifiedAnchors, report.UnanchoredEvents, len(report.Problems))
+
+	return err
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+// writeTestChain writes numEvents events through a HashChain to
+// a file and returns the file's path.
+func writeTestChain(t *testing.T, numEvents int, config sinks.ChainConfig) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	chain := sinks.NewHashChain(sinks.NewWriterSink(&buf), config)
+
+	for i := 1; i <= numEvents; i++ {
+		evt := testtools.NewEventBuilder(common.ActionLoginIdentifier).
+			WithAuditID(strconv.Itoa(i)).
+			Build()
+		require.NoError(t, chain.Write(evt))
+	}
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+	require.NoError(t, os.WriteFile(filePath, buf.Bytes(), 0o600))
+
+	return filePath
+}
+
+// writeTestPublicKey writes a PEM-encoded public key
+// to a file and returns the file's path.
+func writeTestPublicKey(t *testing.T, publicKey ed25519.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	filePath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(filePath,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	return filePath
+}
+
+func TestRunVerify(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	filePath := writeTestChain(t, 5, sinks.ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 2,
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, RunVerify([]string{"verify", "-public-key", writeTestPublicKey(t, publicKey), filePath}, &out))
+
+	assert.Equal(t, filePath+": ok (events: 5, chains: 1, verified anchors: 3, "+
+		"unverified anchors: 0, unanchored events: 0, problems: 0)\n", out.String())
+}
+
+func TestRunVerify_Modified(t *testing.T) {
+	t.Parallel()
+
+	filePath := writeTestChain(t, 3, sinks.ChainConfig{})
+
+	contents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	modified := strings.Replace(string(contents), `"loggedAs":"root"`, `"loggedAs":"nobody"`, 1)
+	require.NotEqual(t, string(contents), modified)
+	require.NoError(t, os.WriteFile(filePath, []byte(modified), 0o600))
+
+	var out bytes.Buffer
+	err = RunVerify([]string{"verify", filePath}, &out)
+	assert.ErrorContains(t, err, "verification failed")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], filePath+": line 1: "+sinks.ChainProblemModified+": "), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], filePath+": FAILED "), lines[1])
+}
+
+func TestRunVerify_UnsignedAnchor(t *testing.T) {
+	t.Parallel()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	filePath := writeTestChain(t, 3, sinks.ChainConfig{})
+
+	// Without a key, unsigned anchors are accepted.
+	require.NoError(t, RunVerify([]string{"verify", filePath}, &bytes.Buffer{}))
+
+	var out bytes.Buffer
+	err = RunVerify([]string{"verify", "-public-key", writeTestPublicKey(t, publicKey), "-json", filePath}, &out)
+	assert.ErrorContains(t, err, "verification failed")
+
+	var report struct {
+		File string `json:"file"`
+		sinks.ChainReport
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+
+	assert.Equal(t, filePath, report.File)
+	assert.Equal(t, 3, report.Events)
+	require.NotEmpty(t, report.Problems)
+	assert.Equal(t, sinks.ChainProblemBadSignature, report.Problems[0].Kind)
+	assert.Equal(t, "anchor 1 is not signed", report.Problems[0].Message)
+}
+
+func TestRunVerify_Errors(t *testing.T) {
+	t.Parallel()
+
+	filePath := writeTestChain(t, 1, sinks.ChainConfig{})
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	err := RunVerify([]string{"verify"}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "at least one file")
+
+	err = RunVerify([]string{"verify", filePath, missing}, &bytes.Buffer{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	err = RunVerify([]string{"verify", "-public-key", missing, filePath}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "failed to read public key")
+}
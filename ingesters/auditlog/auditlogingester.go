@@ -6,10 +6,15 @@ package auditlog
 
 import (
 	"context"
+	"errors"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 )
 
+// errReceiverDone is returned by Process when
+// AuditLogChan is no longer read.
+var errReceiverDone = errors.New("audit log lines are no longer read")
+
 func NewAuditLogIngester(
 	filePath string,
 	auditLogChan chan string,
@@ -26,6 +31,12 @@ type AuditLogIngester struct {
 	namedPipeIngester namedpipe.NamedPipeIngester
 	FilePath          string
 	AuditLogChan      chan string
+
+	// ReceiverDone is optionally closed when AuditLogChan is no
+	// longer read, so that Process does not block forever. Lines
+	// are sent even after the context passed to Ingest is done,
+	// as they were read before ingestion stopped.
+	ReceiverDone <-chan struct{}
 }
 
 func (a *AuditLogIngester) Ingest(ctx context.Context) error {
@@ -33,6 +44,10 @@ func (a *AuditLogIngester) Ingest(ctx context.Context) error {
 }
 
 func (a *AuditLogIngester) Process(ctx context.Context, line string) error {
-	a.AuditLogChan <- line
-	return nil
+	select {
+	case a.AuditLogChan <- line:
+		return nil
+	case <-a.ReceiverDone:
+		return errReceiverDone
+	}
 }
@@ -61,3 +61,15 @@ func TestIngest(t *testing.T) {
 		}
 	}
 }
+
+func TestProcess_ReceiverDone(t *testing.T) {
+	t.Parallel()
+
+	receiverDone := make(chan struct{})
+	close(receiverDone)
+
+	ali := auditlog.NewAuditLogIngester("", make(chan string), namedpipe.NamedPipeIngester{})
+	ali.ReceiverDone = receiverDone
+
+	assert.Error(t, ali.Process(context.Background(), "foo bar\n"))
+}
@@ -3,17 +3,35 @@ package namedpipe
 import (
 	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"syscall"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"go.uber.org/zap"
 
+	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 const (
 	// NamedPipeProcessorComponentName is the name of the component
 	// that reads from a named pipe. This is used in the health check.
 	NamedPipeProcessorComponentName = "named-pipe-processor"
+
+	// DefaultMode is the default permissions of the
+	// named pipes that are created by the ingester.
+	DefaultMode os.FileMode = 0o600
+
+	// maxReopenInterval is the maximum delay between two attempts
+	// to reopen a named pipe whose writer keeps closing it without
+	// writing anything.
+	maxReopenInterval = 10 * time.Second
 )
 
 func NewNamedPipeIngester(logger *zap.SugaredLogger, h *health.Health) NamedPipeIngester {
@@ -26,21 +44,155 @@ func NewNamedPipeIngester(logger *zap.SugaredLogger, h *health.Health) NamedPipe
 type NamedPipeIngester struct {
 	Logger *zap.SugaredLogger
 	Health *health.Health
+
+	// ComponentName is the name of the ingester in the readiness
+	// check, so that each named pipe is reported separately.
+	// It defaults to NamedPipeProcessorComponentName.
+	ComponentName string
+
+	// Mode is the permissions of the named pipe, which is created
+	// if it does not exist. It defaults to DefaultMode.
+	Mode os.FileMode
+
+	// Heartbeat optionally records the progress of the ingester.
+	// Passing a line to the callback counts as progress.
+	Heartbeat *health.Heartbeat
+
+	// Metrics optionally counts the lines read by the
+	// ingester, which are labeled with Input.
+	Metrics *metrics.PrometheusMetricsProvider
+	Input   metrics.InputType
+
+	// OnReopen is optionally called with the named pipe's path
+	// each time it is reopened after its writer closed it.
+	OnReopen func(filePath string)
 }
 
 type Callback func(context.Context, string) error
 
+// Ingest passes the lines read from the named pipe at filePath to
+// callback until ctx is marked as done or callback returns an error.
+// The named pipe is created if it does not exist.
+//
+// When the writer closes the named pipe (e.g., because rsyslog
+// restarts), it is reopened. If the writer keeps closing it without
+// writing anything, it is reopened with an exponential backoff.
+//
+// The ingester is reported as ready while the named pipe is open,
+// and as not ready while waiting for a writer to (re)open it.
 func (n *NamedPipeIngester) Ingest(
 	ctx context.Context,
 	filePath string,
 	delim byte,
 	callback Callback,
 ) error {
+	err := n.createIfMissing(filePath)
+	if err != nil {
+		return err
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = maxReopenInterval
+	bo.MaxElapsedTime = 0
+
+	for reopened := false; ; reopened = true {
+		file, err := open(ctx, filePath)
+		if err != nil {
+			return err
+		}
+
+		n.Health.OnReady(n.componentName())
+
+		if reopened {
+			n.Logger.Infof("reopened %s", filePath)
+
+			if n.OnReopen != nil {
+				n.OnReopen(filePath)
+			}
+		} else {
+			n.Logger.Infof("Successfully opened %s", filePath)
+		}
+
+		numLines, err := n.read(ctx, file, delim, callback)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		n.Health.OnNotReady(n.componentName())
+
+		// The writer was restarted: it is
+		// reopened as soon as possible.
+		if numLines > 0 {
+			bo.Reset()
+			continue
+		}
+
+		wait := bo.NextBackOff()
+		n.Logger.Warnf("%s was closed by its writer without any data, reopening it in %s",
+			filePath, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (n *NamedPipeIngester) componentName() string {
+	if n.ComponentName == "" {
+		return NamedPipeProcessorComponentName
+	}
+
+	return n.ComponentName
+}
+
+// createIfMissing creates the named pipe at filePath if it does not
+// exist. It returns a non-nil error if filePath is not a named pipe.
+func (n *NamedPipeIngester) createIfMissing(filePath string) error {
+	err := common.IsNamedPipe(filePath)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to check if %q is a named pipe - %w", filePath, err)
+	}
+
+	mode := n.Mode
+	if mode == 0 {
+		mode = DefaultMode
+	}
+
+	err = syscall.Mkfifo(filePath, uint32(mode.Perm()))
+	if err != nil {
+		return fmt.Errorf("failed to create named pipe %q - %w", filePath, err)
+	}
+
+	// Mkfifo's mode is modified by the umask.
+	err = os.Chmod(filePath, mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to set the mode of named pipe %q - %w", filePath, err)
+	}
+
+	n.Logger.Infof("created named pipe %s (mode: %s)", filePath, mode.Perm())
+
+	return nil
+}
+
+// open opens the named pipe at filePath for reading. This blocks
+// until a writer opens it, unless ctx is marked as done first.
+func open(ctx context.Context, filePath string) (*os.File, error) {
 	var file *os.File
 	var err error
 	ready := make(chan struct{})
 
-	n.Health.OnReady(NamedPipeProcessorComponentName)
 	// os.OpenFile blocks. Put in go routine so we can gracefully exit.
 	go func() {
 		file, err = os.OpenFile(filePath, os.O_RDONLY, os.ModeNamedPipe)
@@ -49,33 +201,57 @@ func (n *NamedPipeIngester) Ingest(
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	case <-ready:
 	}
 
-	if err != nil {
-		return err
-	}
+	return file, err
+}
+
+// read passes the lines read from file to callback until reading
+// fails (e.g., io.EOF once the writer closes the named pipe) or
+// callback returns an error. It closes file, and returns the number
+// of lines read.
+func (n *NamedPipeIngester) read(ctx context.Context, file *os.File, delim byte, callback Callback) (int, error) {
+	done := make(chan struct{})
+	defer close(done)
 
 	go func() {
-		<-ctx.Done()
-		file.Close()
+		select {
+		case <-ctx.Done():
+			file.Close()
+		case <-done:
+		}
 	}()
 
-	n.Logger.Infof("Successfully opened %s", filePath)
 	defer file.Close()
 
 	r := bufio.NewReader(file)
 
+	var numLines int
+
 	for {
 		line, err := r.ReadString(delim)
 		if err != nil {
-			n.Logger.Errorf("error reading from ", file.Name())
-			return err
+			if line != "" {
+				n.Logger.Warnf("discarding incomplete line read from %s: %q", file.Name(), line)
+			}
+
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				n.Logger.Errorf("error reading from %s - %s", file.Name(), err)
+			}
+
+			return numLines, err
 		}
+
+		numLines++
+		n.Metrics.IncLinesRead(n.Input)
+
+		n.Heartbeat.Start()
 		err = callback(ctx, line)
+		n.Heartbeat.Done()
 		if err != nil {
-			return err
+			return numLines, err
 		}
 	}
 }
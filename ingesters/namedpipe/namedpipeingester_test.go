@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
@@ -58,3 +63,168 @@ func TestIngest(t *testing.T) {
 	<-done
 	assert.Equal(t, 5, callCount)
 }
+
+// writeTestPipe opens the named pipe at pipePath,
+// writes lines to it and closes it.
+func writeTestPipe(t *testing.T, pipePath string, lines ...string) {
+	t.Helper()
+
+	file, err := os.OpenFile(pipePath, os.O_WRONLY, os.ModeNamedPipe)
+	require.NoError(t, err)
+
+	for _, line := range lines {
+		_, err = file.WriteString(line)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, file.Close())
+}
+
+func TestIngest_CreatesNamedPipe(t *testing.T) {
+	t.Parallel()
+
+	pipePath := filepath.Join(t.TempDir(), "named-pipe")
+
+	h := health.NewSingleReadinessHealth("sshd-pipe-reader")
+	np := namedpipe.NewNamedPipeIngester(zap.NewNop().Sugar(), h)
+	np.ComponentName = "sshd-pipe-reader"
+	np.Mode = 0o640
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string, 1)
+	ingestErr := make(chan error, 1)
+	go func() {
+		ingestErr <- np.Ingest(ctx, pipePath, '\n', func(_ context.Context, line string) error {
+			lines <- line
+			return nil
+		})
+	}()
+
+	var info os.FileInfo
+	require.Eventually(t, func() bool {
+		var err error
+		info, err = os.Stat(pipePath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.False(t, h.IsReady(), "no writer opened the pipe yet")
+	assert.Equal(t, os.ModeNamedPipe, info.Mode().Type())
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	writeTestPipe(t, pipePath, "foo bar\n")
+	assert.Equal(t, "foo bar\n", <-lines)
+
+	cancel()
+	assert.ErrorIs(t, <-ingestErr, context.Canceled)
+}
+
+func TestIngest_NotNamedPipe(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "regular-file")
+	require.NoError(t, os.WriteFile(filePath, nil, 0o600))
+
+	h := health.NewSingleReadinessHealth(namedpipe.NamedPipeProcessorComponentName)
+	np := namedpipe.NewNamedPipeIngester(zap.NewNop().Sugar(), h)
+
+	err := np.Ingest(context.Background(), filePath, '\n', func(context.Context, string) error {
+		return nil
+	})
+	assert.Error(t, err)
+	assert.False(t, h.IsReady())
+}
+
+func TestIngest_ReopensAfterWriterCloses(t *testing.T) {
+	t.Parallel()
+
+	pipePath := filepath.Join(t.TempDir(), "named-pipe")
+	require.NoError(t, syscall.Mkfifo(pipePath, 0o600))
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	np := namedpipe.NewNamedPipeIngester(zap.New(core).Sugar(),
+		health.NewSingleReadinessHealth(namedpipe.NamedPipeProcessorComponentName))
+
+	reopened := make(chan string, 2)
+	np.OnReopen = func(filePath string) {
+		reopened <- filePath
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string, 3)
+	ingestErr := make(chan error, 1)
+	go func() {
+		ingestErr <- np.Ingest(ctx, pipePath, '\n', func(_ context.Context, line string) error {
+			lines <- line
+			return nil
+		})
+	}()
+
+	// The incomplete line is discarded when the writer closes the pipe.
+	writeTestPipe(t, pipePath, "first\n", "incomplete")
+	assert.Equal(t, "first\n", <-lines)
+
+	// The pipe must not be opened again before EOF is read.
+	require.Eventually(t, func() bool {
+		return logs.FilterMessageSnippet("incomplete line").Len() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	writeTestPipe(t, pipePath, "second\n")
+	assert.Equal(t, "second\n", <-lines)
+	assert.Equal(t, pipePath, <-reopened)
+
+	cancel()
+	assert.ErrorIs(t, <-ingestErr, context.Canceled)
+	assert.Empty(t, lines)
+}
+
+func TestIngest_ReadyWhileOpen(t *testing.T) {
+	t.Parallel()
+
+	pipePath := filepath.Join(t.TempDir(), "named-pipe")
+	require.NoError(t, syscall.Mkfifo(pipePath, 0o600))
+
+	h := health.NewSingleReadinessHealth(namedpipe.NamedPipeProcessorComponentName)
+	np := namedpipe.NewNamedPipeIngester(zap.NewNop().Sugar(), h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string, 1)
+	ingestErr := make(chan error, 1)
+	go func() {
+		ingestErr <- np.Ingest(ctx, pipePath, '\n', func(_ context.Context, line string) error {
+			lines <- line
+			return nil
+		})
+	}()
+
+	// The ingester is not ready until a writer opens the pipe.
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, h.IsReady())
+
+	file, err := os.OpenFile(pipePath, os.O_WRONLY, os.ModeNamedPipe)
+	require.NoError(t, err)
+
+	require.Eventually(t, h.IsReady, 5*time.Second, 10*time.Millisecond)
+
+	_, err = file.WriteString("foo bar\n")
+	require.NoError(t, err)
+	assert.Equal(t, "foo bar\n", <-lines)
+
+	// It is not ready anymore while waiting for
+	// the writer to reopen the pipe.
+	require.NoError(t, file.Close())
+	require.Eventually(t, func() bool {
+		return !h.IsReady()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	writeTestPipe(t, pipePath, "baz\n")
+	assert.Equal(t, "baz\n", <-lines)
+
+	cancel()
+	assert.ErrorIs(t, <-ingestErr, context.Canceled)
+}
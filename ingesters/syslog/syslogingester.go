@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 	"github.com/metal-toolbox/audito-maldito/processors/sshd"
 )
 
@@ -24,6 +25,10 @@ type SyslogIngester struct {
 	namedPipeIngester namedpipe.NamedPipeIngester
 	FilePath          string
 	SshdProcessor     sshd.SshdProcessor
+
+	// Metrics optionally counts the lines that are not
+	// in the form expected by ParseSyslogMessage.
+	Metrics *metrics.PrometheusMetricsProvider
 }
 
 func (s *SyslogIngester) Ingest(ctx context.Context) error {
@@ -32,6 +37,10 @@ func (s *SyslogIngester) Ingest(ctx context.Context) error {
 
 func (s *SyslogIngester) Process(ctx context.Context, line string) error {
 	sm := s.ParseSyslogMessage(line)
+	if sm.PID == "" {
+		s.Metrics.IncParseFailures(metrics.ParseFailureSyslogMessage)
+	}
+
 	return s.SshdProcessor.ProcessSshdLogEntry(ctx, sm)
 }
 
@@ -1,9 +1,12 @@
 package common
 
 const (
-	ActionLoginIdentifier = "UserLogin"
-	ActionUserAction      = "UserAction"
-	ActionSystemAction    = "SystemAction"
+	ActionLoginIdentifier     = "UserLogin"
+	ActionUserAction          = "UserAction"
+	ActionUserNetworkActivity = "UserNetworkActivity"
+	ActionSessionTranscript   = "SessionTranscript"
+	ActionSystemAction        = "SystemAction"
+	ActionAgentLifecycle      = "AgentLifecycle"
 )
 
 const (
@@ -0,0 +1,17 @@
+package common
+
+import (
+	"runtime/debug"
+)
+
+// Version returns the application's version as recorded in
+// the binary's build information. It returns "(devel)" for
+// binaries built from a local checkout.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
@@ -24,13 +24,15 @@ var DefaultReadyCheckInterval = 500 * time.Millisecond
 // NewHealth returns a *Health.
 func NewHealth() *Health {
 	return &Health{
-		readyMap: common.NewGenericSyncMap[string, bool](),
+		readyMap:   common.NewGenericSyncMap[string, bool](),
+		heartbeats: common.NewGenericSyncMap[string, *Heartbeat](),
 	}
 }
 
 // Health represents the application's health.
 type Health struct {
-	readyMap *common.GenericSyncMap[string, bool]
+	readyMap   *common.GenericSyncMap[string, bool]
+	heartbeats *common.GenericSyncMap[string, *Heartbeat]
 }
 
 // NewSingleReadinessHealth returns a *Health with its readiness counter
@@ -57,6 +59,13 @@ func (o *Health) OnReady(component string) {
 	o.readyMap.Store(component, true)
 }
 
+// OnNotReady marks an item that was ready as not ready anymore
+// (e.g., while it reconnects to its source). OnReady should be
+// called again once it recovers.
+func (o *Health) OnNotReady(component string) {
+	o.readyMap.Store(component, false)
+}
+
 // WaitForReady returns a channel that is closed when the readiness counter
 // hits zero, signalling that all internal application services are ready.
 func (o *Health) WaitForReady(ctx context.Context) <-chan error {
@@ -91,3 +91,22 @@ func TestHealth_WaitForReadyCtxOrTimeout_TimedOut(t *testing.T) {
 	assert.ErrorIs(t, err, context.DeadlineExceeded)
 	assert.False(t, h.IsReady(), "health should not be ready")
 }
+
+func TestHealth_OnNotReady(t *testing.T) {
+	t.Parallel()
+
+	h := NewSingleReadinessHealth("test")
+
+	h.OnReady("test")
+	assert.True(t, h.IsReady())
+
+	h.OnNotReady("test")
+	assert.False(t, h.IsReady())
+	assert.Equal(t, map[string]string{
+		"test":       ComponentNotReady,
+		OverallReady: ComponentNotReady,
+	}, h.GetReadyzStatusMap())
+
+	h.OnReady("test")
+	assert.True(t, h.IsReady())
+}
@@ -0,0 +1,235 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// ComponentLive is the value indicating that a component is making progress.
+	ComponentLive = "ok"
+	// ComponentStalled is the value indicating that a component stopped making
+	// progress while it has work to do.
+	ComponentStalled = "stalled"
+)
+
+// newHeartbeat returns a new Heartbeat whose component is considered
+// stalled if it does not make progress for timeout while it has work
+// to do.
+func newHeartbeat(timeout time.Duration) *Heartbeat {
+	return &Heartbeat{
+		timeout:  timeout,
+		lastBeat: time.Now(),
+	}
+}
+
+// Heartbeat records the progress of one stage of the event pipeline
+// (e.g., a named pipe reader or the event writer). A stage has work to
+// do when its input is not empty (refer to WithPending) or when it is
+// in the middle of an operation (refer to Start). It is stalled if it
+// has had work to do for longer than its timeout without beating.
+//
+// The methods of a nil *Heartbeat do nothing, so that stages
+// do not need to check whether liveness is being monitored.
+type Heartbeat struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending func() int
+	// lastBeat is when the stage last made progress.
+	lastBeat time.Time
+	// inFlight is the number of operations in progress,
+	// and busySince is when the first of them started.
+	inFlight  int
+	busySince time.Time
+	// pendingSince is when the stage's input was first
+	// seen to be non-empty, or zero if it was empty.
+	pendingSince time.Time
+}
+
+// WithPending sets the function that returns the number of items
+// waiting in the stage's input (e.g., the length of a channel).
+func (o *Heartbeat) WithPending(fn func() int) *Heartbeat {
+	if o != nil {
+		o.mu.Lock()
+		o.pending = fn
+		o.mu.Unlock()
+	}
+
+	return o
+}
+
+// Beat records that the stage made progress.
+func (o *Heartbeat) Beat() {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	o.lastBeat = time.Now()
+	o.mu.Unlock()
+}
+
+// Start records that an operation started (e.g., writing an event).
+// Done must be called once the operation is complete. Operations may
+// run concurrently.
+func (o *Heartbeat) Start() {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.inFlight == 0 {
+		o.busySince = time.Now()
+	}
+
+	o.inFlight++
+}
+
+// Done records that an operation started by Start is complete,
+// which counts as progress.
+func (o *Heartbeat) Done() {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lastBeat = time.Now()
+	o.inFlight--
+}
+
+// ComponentLiveness describes the progress of a component.
+type ComponentLiveness struct {
+	Status        string    `json:"status"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	// Pending is the number of items waiting in the component's input.
+	Pending int `json:"pending"`
+	// InFlight is the number of operations in progress.
+	InFlight int `json:"inFlight"`
+	// Waiting is for how long the component has had work
+	// to do without making progress.
+	Waiting string `json:"waiting,omitempty"`
+	Timeout string `json:"timeout"`
+}
+
+// status returns the component's liveness at the given time.
+func (o *Heartbeat) status(now time.Time) ComponentLiveness {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := 0
+	if o.pending != nil {
+		pending = o.pending()
+	}
+
+	if pending == 0 {
+		o.pendingSince = time.Time{}
+	} else if o.pendingSince.IsZero() {
+		o.pendingSince = now
+	}
+
+	liveness := ComponentLiveness{
+		Status:        ComponentLive,
+		LastHeartbeat: o.lastBeat,
+		Pending:       pending,
+		InFlight:      o.inFlight,
+		Timeout:       o.timeout.String(),
+	}
+
+	// The stage is only expected to make progress
+	// since it has had work to do.
+	var waitingSince time.Time
+	if pending > 0 {
+		waitingSince = o.pendingSince
+	}
+
+	if o.inFlight > 0 && (waitingSince.IsZero() || o.busySince.Before(waitingSince)) {
+		waitingSince = o.busySince
+	}
+
+	if waitingSince.IsZero() {
+		return liveness
+	}
+
+	if o.lastBeat.After(waitingSince) {
+		waitingSince = o.lastBeat
+	}
+
+	waiting := now.Sub(waitingSince)
+	liveness.Waiting = waiting.Round(time.Millisecond).String()
+
+	if waiting > o.timeout {
+		liveness.Status = ComponentStalled
+	}
+
+	return liveness
+}
+
+// AddHeartbeat adds a component whose progress is reflected by the
+// liveness endpoint and returns its Heartbeat. The component is
+// considered stalled if it has work to do and does not beat for
+// longer than timeout.
+func (o *Health) AddHeartbeat(component string, timeout time.Duration) *Heartbeat {
+	hb := newHeartbeat(timeout)
+	o.heartbeats.Store(component, hb)
+
+	return hb
+}
+
+// LivezStatus is the liveness of each component
+// and of the application as a whole.
+type LivezStatus struct {
+	Overall    string                       `json:"overall"`
+	Components map[string]ComponentLiveness `json:"components"`
+}
+
+// GetLivezStatus returns the liveness of each component added
+// using AddHeartbeat. The application is live if none of its
+// components are stalled.
+func (o *Health) GetLivezStatus() LivezStatus {
+	now := time.Now()
+
+	status := LivezStatus{
+		Overall:    ComponentLive,
+		Components: make(map[string]ComponentLiveness, o.heartbeats.Len()),
+	}
+
+	o.heartbeats.Iterate(func(component string, hb *Heartbeat) bool {
+		liveness := hb.status(now)
+		if liveness.Status != ComponentLive {
+			status.Overall = ComponentStalled
+		}
+
+		status.Components[component] = liveness
+		return true
+	})
+
+	return status
+}
+
+func (o *Health) livezHandler(w http.ResponseWriter, _ *http.Request) {
+	status := o.GetLivezStatus()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if status.Overall == ComponentLive {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	//nolint:errcheck,errchkjson // Nothing can be done if the client went away.
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// LivezHandler returns an http.Handler that responds with the
+// LivezStatus, using status code 503 if a component is stalled.
+func (o *Health) LivezHandler() http.Handler {
+	return http.HandlerFunc(o.livezHandler)
+}
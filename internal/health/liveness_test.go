@@ -0,0 +1,110 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat_IdleIsLive(t *testing.T) {
+	t.Parallel()
+
+	hb := newHeartbeat(time.Second).WithPending(func() int { return 0 })
+
+	liveness := hb.status(time.Now().Add(time.Hour))
+	assert.Equal(t, ComponentLive, liveness.Status)
+	assert.Empty(t, liveness.Waiting)
+}
+
+func TestHeartbeat_StalledWithPendingInput(t *testing.T) {
+	t.Parallel()
+
+	pending := 0
+	hb := newHeartbeat(time.Second).WithPending(func() int { return pending })
+
+	now := time.Now()
+	pending = 3
+
+	// The stage is not expected to have made progress
+	// before its input was seen to be non-empty.
+	liveness := hb.status(now.Add(time.Hour))
+	assert.Equal(t, ComponentLive, liveness.Status)
+	assert.Equal(t, 3, liveness.Pending)
+
+	liveness = hb.status(now.Add(time.Hour + 2*time.Second))
+	assert.Equal(t, ComponentStalled, liveness.Status)
+	assert.Equal(t, "2s", liveness.Waiting)
+
+	pending = 0
+	assert.Equal(t, ComponentLive, hb.status(now.Add(time.Hour+3*time.Second)).Status)
+}
+
+func TestHeartbeat_StalledOperation(t *testing.T) {
+	t.Parallel()
+
+	hb := newHeartbeat(time.Second)
+
+	hb.Start()
+	hb.Start()
+
+	now := time.Now()
+	assert.Equal(t, ComponentLive, hb.status(now).Status)
+
+	liveness := hb.status(now.Add(2 * time.Second))
+	assert.Equal(t, ComponentStalled, liveness.Status)
+	assert.Equal(t, 2, liveness.InFlight)
+
+	// Completing one of the operations is progress.
+	hb.Done()
+	now = time.Now()
+	assert.Equal(t, ComponentLive, hb.status(now.Add(500*time.Millisecond)).Status)
+	assert.Equal(t, ComponentStalled, hb.status(now.Add(2*time.Second)).Status)
+
+	hb.Done()
+	assert.Equal(t, ComponentLive, hb.status(now.Add(time.Hour)).Status)
+}
+
+func TestHeartbeat_Nil(t *testing.T) {
+	t.Parallel()
+
+	var hb *Heartbeat
+
+	assert.NotPanics(t, func() {
+		hb.WithPending(func() int { return 0 })
+		hb.Beat()
+		hb.Start()
+		hb.Done()
+	})
+}
+
+func TestHealth_LivezHandler(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealth()
+	h.AddHeartbeat("reader", time.Hour)
+	writer := h.AddHeartbeat("writer", time.Nanosecond)
+
+	rec := httptest.NewRecorder()
+	h.LivezHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	writer.Start()
+	time.Sleep(time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	h.LivezHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var status LivezStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+
+	assert.Equal(t, ComponentStalled, status.Overall)
+	assert.Equal(t, ComponentLive, status.Components["reader"].Status)
+	assert.Equal(t, ComponentStalled, status.Components["writer"].Status)
+	assert.Equal(t, 1, status.Components["writer"].InFlight)
+}
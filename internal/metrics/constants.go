@@ -34,4 +34,51 @@ type ErrorType string
 const (
 	// ErrorTypeJournaldWait is the error type for errors waiting for journald.
 	ErrorTypeJournaldWait ErrorType = "journald_wait"
+	// ErrorTypeSinkWrite is the error type for events that an output
+	// failed to write, if the output's errors are only logged.
+	ErrorTypeSinkWrite ErrorType = "sink_write"
+	// ErrorTypeEventDropped is the error type for events that were
+	// dropped because an output's buffer was full.
+	ErrorTypeEventDropped ErrorType = "event_dropped"
+)
+
+// InputType is the type of input that lines are read from.
+type InputType string
+
+const (
+	// SshdInput is the input type for sshd log lines.
+	SshdInput InputType = "sshd"
+	// AuditdInput is the input type for audit log lines.
+	AuditdInput InputType = "auditd"
+)
+
+// ParseFailureReason is the reason an input line could not be parsed.
+type ParseFailureReason string
+
+const (
+	// ParseFailureSyslogMessage is the reason for sshd log lines
+	// that are not in the form of "<PID> <Message>".
+	ParseFailureSyslogMessage ParseFailureReason = "syslog_message"
+	// ParseFailureSshdPID is the reason for sshd log
+	// lines whose PID is not a number.
+	ParseFailureSshdPID ParseFailureReason = "sshd_pid"
+	// ParseFailureSshdFields is the reason for sshd log lines that were
+	// recognized, but whose fields could not be extracted.
+	ParseFailureSshdFields ParseFailureReason = "sshd_fields"
+	// ParseFailureAuditLogLine is the reason for audit
+	// log lines that go-libaudit failed to parse.
+	ParseFailureAuditLogLine ParseFailureReason = "audit_log_line"
+)
+
+// ExpiredCorrelationType is the type of data that was
+// deleted because it was never correlated.
+type ExpiredCorrelationType string
+
+const (
+	// ExpiredAuditSession is the type for audit sessions
+	// that were never bound to a remote user login.
+	ExpiredAuditSession ExpiredCorrelationType = "audit_session"
+	// ExpiredRemoteLogin is the type for remote user logins
+	// whose audit session never started.
+	ExpiredRemoteLogin ExpiredCorrelationType = "remote_login"
 )
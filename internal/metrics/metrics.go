@@ -2,15 +2,35 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // PrometheusMetricsProvider is a metrics provider that uses Prometheus.
+//
+// The methods of a nil *PrometheusMetricsProvider do nothing, so that
+// the components of the event pipeline work without metrics as well.
 type PrometheusMetricsProvider struct {
 	auditLogCheck      *prometheus.GaugeVec
 	auditLogModifyTime *prometheus.GaugeVec
 	errors             *prometheus.CounterVec
 	remoteLogins       *prometheus.CounterVec
+	sinkQueueDepth     *prometheus.GaugeVec
+	sinkDeliveryLag    *prometheus.GaugeVec
+	configReloads      *prometheus.CounterVec
+	lastReloadSuccess  *prometheus.GaugeVec
+	linesRead          *prometheus.CounterVec
+	parseFailures      *prometheus.CounterVec
+	unmatchedSshdLines *prometheus.CounterVec
+	eventsWritten      *prometheus.CounterVec
+	writeLatency       *prometheus.HistogramVec
+	correlations       *prometheus.CounterVec
+	expiredCorrelation *prometheus.CounterVec
+	auditLogChanDepth  *prometheus.GaugeVec
+	trackedSessions    *prometheus.GaugeVec
+	pendingLogins      *prometheus.GaugeVec
+	cachedEvents       *prometheus.GaugeVec
 }
 
 // NewPrometheusMetricsProvider returns a new PrometheusMetricsProvider.
@@ -28,6 +48,54 @@ func NewPrometheusMetricsProvider() *PrometheusMetricsProvider {
 // - errors_total (counter) - The total number of errors.
 //   - Labels: type
 //   - For more information about the labels, see the `ErrorType`
+//
+// - sink_queue_depth (gauge) - The number of events waiting to be delivered.
+//   - Labels: sink
+//
+// - sink_delivery_lag_seconds (gauge) - The age of the oldest event in
+// the most recently delivered batch.
+//   - Labels: sink
+//
+// - config_reloads_total (counter) - The total number of configuration reloads.
+//   - Labels: outcome
+//
+// - config_last_reload_successful (gauge) - Whether the most recent
+// configuration reload succeeded. 1 for success, 0 for failure. It is
+// not reported until the configuration is reloaded.
+//
+// - input_lines_total (counter) - The total number of lines read from an input.
+//   - Labels: input
+//   - For more information about the labels, see the `InputType`
+//
+// - parse_failures_total (counter) - The total number of input lines
+// that could not be parsed.
+//   - Labels: reason
+//   - For more information about the labels, see the `ParseFailureReason`
+//
+// - sshd_unmatched_lines_total (counter) - The total number of sshd log
+// lines that did not match any known log message.
+//
+// - events_written_total (counter) - The total number of events written by an output.
+//   - Labels: type, sink
+//
+// - event_write_latency_seconds (histogram) - The duration between an
+// event's timestamp and its write by an output.
+//   - Labels: sink
+//
+// - correlations_total (counter) - The total number of audit sessions
+// bound to a remote user login.
+//
+// - correlations_expired_total (counter) - The total number of audit
+// sessions and remote user logins deleted without being correlated.
+//   - Labels: type
+//   - For more information about the labels, see the `ExpiredCorrelationType`
+//
+// - audit_log_channel_depth, tracked_sessions, pending_logins and
+// cached_events (gauges) - The number of audit log lines waiting to be
+// parsed, of active audit sessions, of remote user logins waiting for
+// their audit session and of audit events waiting for their session's
+// remote user login. They are not reported until the auditd processor
+// starts.
 func NewPrometheusMetricsProviderForRegisterer(r prometheus.Registerer) *PrometheusMetricsProvider {
 	p := &PrometheusMetricsProvider{
 		auditLogCheck: prometheus.NewGaugeVec(
@@ -63,29 +131,292 @@ func NewPrometheusMetricsProviderForRegisterer(r prometheus.Registerer) *Prometh
 			},
 			[]string{"method", "outcome"},
 		),
+		sinkQueueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "sink_queue_depth",
+				Namespace: MetricsNamespace,
+				Help:      "The number of events waiting to be delivered by a sink.",
+			},
+			[]string{"sink"},
+		),
+		sinkDeliveryLag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "sink_delivery_lag_seconds",
+				Namespace: MetricsNamespace,
+				Help:      "The age of the oldest event in the most recently delivered batch.",
+			},
+			[]string{"sink"},
+		),
+		configReloads: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "config_reloads_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of configuration reloads.",
+			},
+			[]string{"outcome"},
+		),
+		lastReloadSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "config_last_reload_successful",
+				Namespace: MetricsNamespace,
+				Help:      "Whether the most recent configuration reload succeeded. 1 for success, 0 for failure.",
+			},
+			[]string{},
+		),
+		linesRead: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "input_lines_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of lines read from an input.",
+			},
+			[]string{"input"},
+		),
+		parseFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "parse_failures_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of input lines that could not be parsed.",
+			},
+			[]string{"reason"},
+		),
+		unmatchedSshdLines: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "sshd_unmatched_lines_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of sshd log lines that did not match any known log message.",
+			},
+			[]string{},
+		),
+		eventsWritten: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "events_written_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of events written by an output.",
+			},
+			[]string{"type", "sink"},
+		),
+		writeLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:      "event_write_latency_seconds",
+				Namespace: MetricsNamespace,
+				Help:      "The duration between an event's timestamp and its write by an output.",
+				Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+			},
+			[]string{"sink"},
+		),
+		correlations: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "correlations_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of audit sessions bound to a remote user login.",
+			},
+			[]string{},
+		),
+		expiredCorrelation: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "correlations_expired_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of audit sessions and remote user logins deleted without being correlated.",
+			},
+			[]string{"type"},
+		),
+		auditLogChanDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "audit_log_channel_depth",
+				Namespace: MetricsNamespace,
+				Help:      "The number of audit log lines waiting to be parsed.",
+			},
+			[]string{},
+		),
+		trackedSessions: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "tracked_sessions",
+				Namespace: MetricsNamespace,
+				Help:      "The number of active audit sessions.",
+			},
+			[]string{},
+		),
+		pendingLogins: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "pending_logins",
+				Namespace: MetricsNamespace,
+				Help:      "The number of remote user logins waiting for their audit session.",
+			},
+			[]string{},
+		),
+		cachedEvents: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "cached_events",
+				Namespace: MetricsNamespace,
+				Help:      "The number of audit events waiting for their session's remote user login.",
+			},
+			[]string{},
+		),
 	}
 
 	// This is variadic function so we can pass as many metrics as we want
-	r.MustRegister(p.remoteLogins, p.auditLogCheck, p.auditLogModifyTime)
+	r.MustRegister(p.remoteLogins, p.errors, p.auditLogCheck, p.auditLogModifyTime,
+		p.sinkQueueDepth, p.sinkDeliveryLag, p.configReloads, p.lastReloadSuccess,
+		p.linesRead, p.parseFailures, p.unmatchedSshdLines, p.eventsWritten, p.writeLatency,
+		p.correlations, p.expiredCorrelation, p.auditLogChanDepth, p.trackedSessions,
+		p.pendingLogins, p.cachedEvents)
+
 	return p
 }
 
 // IncLogins increments the number of logins by the given type.
 func (p *PrometheusMetricsProvider) IncLogins(loginType LoginType, outcome OutcomeType) {
+	if p == nil {
+		return
+	}
+
 	p.remoteLogins.WithLabelValues(string(loginType), string(outcome)).Inc()
 }
 
 // IncErrors increments the number of errors by the given type.
 func (p *PrometheusMetricsProvider) IncErrors(errorType ErrorType) {
+	if p == nil {
+		return
+	}
+
 	p.errors.WithLabelValues(string(errorType)).Inc()
 }
 
 // SetAuditCheck sets status of audit.log writes. 0 for negative, 1 for positive.
 func (p *PrometheusMetricsProvider) SetAuditLogCheck(result float64, threshold string) {
+	if p == nil {
+		return
+	}
+
 	p.auditLogCheck.WithLabelValues(threshold).Set(result)
 }
 
 // SetAuditLogModifyTime sets last modify time in seconds.
 func (p *PrometheusMetricsProvider) SetAuditLogModifyTime(result float64) {
+	if p == nil {
+		return
+	}
+
 	p.auditLogModifyTime.WithLabelValues().Set(result)
 }
+
+// SetSinkQueueDepth sets the number of events waiting to be delivered by a sink.
+func (p *PrometheusMetricsProvider) SetSinkQueueDepth(sink string, depth int) {
+	if p == nil {
+		return
+	}
+
+	p.sinkQueueDepth.WithLabelValues(sink).Set(float64(depth))
+}
+
+// SetSinkDeliveryLag sets the age of the oldest event in the batch
+// most recently delivered by a sink.
+func (p *PrometheusMetricsProvider) SetSinkDeliveryLag(sink string, lag time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.sinkDeliveryLag.WithLabelValues(sink).Set(lag.Seconds())
+}
+
+// IncConfigReloads increments the number of configuration reloads by
+// the given outcome and records whether the reload succeeded.
+func (p *PrometheusMetricsProvider) IncConfigReloads(outcome OutcomeType) {
+	if p == nil {
+		return
+	}
+
+	p.configReloads.WithLabelValues(string(outcome)).Inc()
+
+	if outcome == Success {
+		p.lastReloadSuccess.WithLabelValues().Set(1)
+	} else {
+		p.lastReloadSuccess.WithLabelValues().Set(0)
+	}
+}
+
+// IncLinesRead increments the number of lines read from the given input.
+func (p *PrometheusMetricsProvider) IncLinesRead(input InputType) {
+	if p == nil {
+		return
+	}
+
+	p.linesRead.WithLabelValues(string(input)).Inc()
+}
+
+// IncParseFailures increments the number of input
+// lines that failed to parse for the given reason.
+func (p *PrometheusMetricsProvider) IncParseFailures(reason ParseFailureReason) {
+	if p == nil {
+		return
+	}
+
+	p.parseFailures.WithLabelValues(string(reason)).Inc()
+}
+
+// IncUnmatchedSshdLines increments the number of sshd log
+// lines that did not match any known log message.
+func (p *PrometheusMetricsProvider) IncUnmatchedSshdLines() {
+	if p == nil {
+		return
+	}
+
+	p.unmatchedSshdLines.WithLabelValues().Inc()
+}
+
+// ObserveEventWritten increments the number of events of the given type
+// written by a sink, and observes the duration since the event's
+// timestamp. The duration is not observed if loggedAt is zero.
+func (p *PrometheusMetricsProvider) ObserveEventWritten(eventType string, sink string, loggedAt time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.eventsWritten.WithLabelValues(eventType, sink).Inc()
+
+	if !loggedAt.IsZero() {
+		p.writeLatency.WithLabelValues(sink).Observe(time.Since(loggedAt).Seconds())
+	}
+}
+
+// IncCorrelations increments the number of audit
+// sessions bound to a remote user login.
+func (p *PrometheusMetricsProvider) IncCorrelations() {
+	if p == nil {
+		return
+	}
+
+	p.correlations.WithLabelValues().Inc()
+}
+
+// AddExpiredCorrelations adds n to the number of audit sessions or remote
+// user logins (depending on the type) deleted without being correlated.
+func (p *PrometheusMetricsProvider) AddExpiredCorrelations(expiredType ExpiredCorrelationType, n int) {
+	if p == nil {
+		return
+	}
+
+	p.expiredCorrelation.WithLabelValues(string(expiredType)).Add(float64(n))
+}
+
+// SetAuditLogChannelDepth sets the number of audit log lines waiting to be parsed.
+func (p *PrometheusMetricsProvider) SetAuditLogChannelDepth(depth int) {
+	if p == nil {
+		return
+	}
+
+	p.auditLogChanDepth.WithLabelValues().Set(float64(depth))
+}
+
+// SetSessionTrackerState sets the number of active audit sessions, of
+// remote user logins waiting for their audit session and of audit events
+// waiting for their session's remote user login.
+func (p *PrometheusMetricsProvider) SetSessionTrackerState(sessions, pendingLogins, cachedEvents int) {
+	if p == nil {
+		return
+	}
+
+	p.trackedSessions.WithLabelValues().Set(float64(sessions))
+	p.pendingLogins.WithLabelValues().Set(float64(pendingLogins))
+	p.cachedEvents.WithLabelValues().Set(float64(cachedEvents))
+}
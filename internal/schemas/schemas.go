@@ -0,0 +1,193 @@
+// Package schemas provides the JSON Schemas of the audit events produced
+// by audito-maldito. There is one schema per event type, each with its
+// own version. The version is written to each event's metadata (refer
+// to VersionExtraKey) so that consumers can tell which layout of the
+// event's data and metadata to expect.
+//
+// Versions follow semantic versioning: the minor version is incremented
+// when properties are added, and the major version is incremented when
+// properties are removed, renamed or change type.
+package schemas
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+// VersionExtraKey is the key of the schema version
+// in the Metadata.Extra of audit events.
+const VersionExtraKey = "schemaVersion"
+
+//go:embed events/*.json
+var eventsFS embed.FS
+
+// eventSchemas maps event types to their compiled schema.
+var eventSchemas = mustCompileEventSchemas()
+
+// eventSchema is the schema of an event type.
+type eventSchema struct {
+	raw     []byte
+	version string
+	schema  *schema
+}
+
+func mustCompileEventSchemas() map[string]*eventSchema {
+	entries, err := eventsFS.ReadDir("events")
+	if err != nil {
+		panic(err)
+	}
+
+	compiled := make(map[string]*eventSchema, len(entries))
+
+	for _, entry := range entries {
+		eventType := strings.TrimSuffix(entry.Name(), ".json")
+
+		raw, err := eventsFS.ReadFile(path.Join("events", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		s, err := compileSchema(raw)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s schema: %s", eventType, err))
+		}
+
+		// The version is the value that the schema
+		// requires in the event's metadata.
+		version, _ := s.lookup("metadata", "extra", VersionExtraKey)["const"].(string)
+		if version == "" {
+			panic(fmt.Sprintf("%s schema does not specify a version", eventType))
+		}
+
+		compiled[eventType] = &eventSchema{
+			raw:     raw,
+			version: version,
+			schema:  s,
+		}
+	}
+
+	return compiled
+}
+
+// Types returns the event types that have a schema, sorted by name.
+func Types() []string {
+	types := make([]string, 0, len(eventSchemas))
+	for eventType := range eventSchemas {
+		types = append(types, eventType)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// Schema returns the JSON Schema of an event type. The boolean
+// is false if the event type does not have a schema.
+func Schema(eventType string) ([]byte, bool) {
+	s, ok := eventSchemas[eventType]
+	if !ok {
+		return nil, false
+	}
+
+	return s.raw, true
+}
+
+// Version returns the schema version of an event type. The boolean
+// is false if the event type does not have a schema.
+func Version(eventType string) (string, bool) {
+	s, ok := eventSchemas[eventType]
+	if !ok {
+		return "", false
+	}
+
+	return s.version, true
+}
+
+// Stamp returns a copy of the event with the version of its type's
+// schema set in Metadata.Extra. The event itself is returned if its
+// type does not have a schema.
+func Stamp(event *auditevent.AuditEvent) *auditevent.AuditEvent {
+	version, ok := Version(event.Type)
+	if !ok {
+		return event
+	}
+
+	stamped := *event
+	stamped.Metadata.Extra = make(map[string]any, len(event.Metadata.Extra)+1)
+
+	for k, v := range event.Metadata.Extra {
+		stamped.Metadata.Extra[k] = v
+	}
+
+	stamped.Metadata.Extra[VersionExtraKey] = version
+
+	return &stamped
+}
+
+// Validate validates a JSON-encoded audit event against the
+// schema of its type. A *ValidationError is returned if the
+// event does not conform to the schema.
+func Validate(b []byte) error {
+	doc, err := decodeJSON(b)
+	if err != nil {
+		return fmt.Errorf("failed to decode event - %w", err)
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return &ValidationError{Message: "event must be an object"}
+	}
+
+	eventType, _ := obj["type"].(string)
+
+	s, ok := eventSchemas[eventType]
+	if !ok {
+		return &ValidationError{
+			Pointer: "/type",
+			Message: fmt.Sprintf("event type %q does not have a schema", eventType),
+		}
+	}
+
+	return s.schema.validate(doc)
+}
+
+// ValidateEvent stamps a copy of the event with its schema version
+// (refer to Stamp) and validates it. It is meant to be used by tests
+// to check the events produced by processors.
+func ValidateEvent(event *auditevent.AuditEvent) error {
+	b, err := json.Marshal(Stamp(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode event - %w", err)
+	}
+
+	return Validate(b)
+}
+
+// decodeJSON decodes a single JSON value. Numbers
+// are decoded as json.Number to keep their precision.
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	return value, nil
+}
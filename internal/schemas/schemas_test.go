@@ -0,0 +1,171 @@
+package schemas
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+// newTestLoginEvent returns a login event as written
+// by the sshd processor, which conforms to its schema.
+func newTestLoginEvent() *auditevent.AuditEvent {
+	return testtools.NewEventBuilder(common.ActionLoginIdentifier).
+		WithSource(auditevent.EventSource{
+			Type:  "IP",
+			Value: "192.0.2.10",
+			Extra: map[string]any{"port": "2222"},
+		}).
+		WithSubjects(map[string]string{
+			"loggedAs": "core",
+			"userID":   "user@example.com",
+			"pid":      "1234",
+		}).
+		WithTarget(map[string]string{
+			"host":       "node-1",
+			"machine-id": "abc",
+			"cluster":    "prod-1",
+		}).
+		WithData(json.RawMessage(
+			`{"Alg":"ED25519-CERT","SSHKeySum":"SHA256:abc","Serial":"1","CA":"ED25519 SHA256:def"}`)).
+		Build()
+}
+
+func TestTypes(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		common.ActionAgentLifecycle,
+		common.ActionSessionTranscript,
+		common.ActionSystemAction,
+		common.ActionUserAction,
+		common.ActionLoginIdentifier,
+		common.ActionUserNetworkActivity,
+	}, Types())
+
+	for _, eventType := range Types() {
+		version, ok := Version(eventType)
+		assert.True(t, ok)
+		assert.Regexp(t, `^\d+\.\d+\.\d+$`, version, eventType)
+
+		schema, ok := Schema(eventType)
+		assert.True(t, ok)
+		assert.True(t, json.Valid(schema), eventType)
+	}
+
+	_, ok := Version("UnknownAction")
+	assert.False(t, ok)
+}
+
+func TestStamp(t *testing.T) {
+	t.Parallel()
+
+	evt := newTestLoginEvent()
+
+	stamped := Stamp(evt)
+	assert.Equal(t, "1.0.0", stamped.Metadata.Extra[VersionExtraKey])
+	assert.Nil(t, evt.Metadata.Extra)
+
+	evt.Type = "SomethingElse"
+	assert.Same(t, evt, Stamp(evt))
+}
+
+func TestValidateEvent(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateEvent(newTestLoginEvent()))
+
+	tests := []struct {
+		name    string
+		modify  func(*auditevent.AuditEvent)
+		pointer string
+	}{
+		{
+			name: "unknown type",
+			modify: func(evt *auditevent.AuditEvent) {
+				evt.Type = "SomethingElse"
+			},
+			pointer: "/type",
+		},
+		{
+			name: "unknown outcome",
+			modify: func(evt *auditevent.AuditEvent) {
+				evt.Outcome = auditevent.OutcomeApproved
+			},
+			pointer: "/outcome",
+		},
+		{
+			name: "missing subject",
+			modify: func(evt *auditevent.AuditEvent) {
+				delete(evt.Subjects, "pid")
+			},
+			pointer: "/subjects",
+		},
+		{
+			name: "unknown source extra",
+			modify: func(evt *auditevent.AuditEvent) {
+				evt.Source.Extra["user"] = "root"
+			},
+			pointer: "/source/extra/user",
+		},
+		{
+			name: "data of two kinds",
+			modify: func(evt *auditevent.AuditEvent) {
+				data := json.RawMessage(`{"Alg":"ED25519","SSHKeySum":"SHA256:abc","reason":"expired"}`)
+				evt.Data = &data
+			},
+			pointer: "/data",
+		},
+		{
+			name: "wrong component",
+			modify: func(evt *auditevent.AuditEvent) {
+				evt.Component = "auditd"
+			},
+			pointer: "/component",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			evt := newTestLoginEvent()
+			tt.modify(evt)
+
+			err := ValidateEvent(evt)
+
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.pointer, validationErr.Pointer, err.Error())
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	evt := newTestLoginEvent()
+	evt.LoggedAt = time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	b, err := json.Marshal(evt)
+	require.NoError(t, err)
+
+	// Events are not stamped by Validate.
+	var validationErr *ValidationError
+	require.ErrorAs(t, Validate(b), &validationErr)
+	assert.Equal(t, "/metadata", validationErr.Pointer)
+
+	b, err = json.Marshal(Stamp(evt))
+	require.NoError(t, err)
+	require.NoError(t, Validate(b))
+
+	assert.Error(t, Validate(append(b, "{}"...)))
+	assert.Error(t, Validate([]byte(`[]`)))
+}
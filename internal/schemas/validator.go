@@ -0,0 +1,519 @@
+package schemas
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError describes why a JSON document does
+// not conform to a schema.
+type ValidationError struct {
+	// Pointer is the JSON pointer (RFC 6901) of the value
+	// that is invalid (e.g., "/metadata/extra/paths/0").
+	Pointer string
+
+	// Message describes the problem.
+	Message string
+}
+
+func (o *ValidationError) Error() string {
+	pointer := o.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+
+	return pointer + ": " + o.Message
+}
+
+// schema is a JSON Schema document. Only the subset of the 2020-12
+// vocabulary used by the embedded schemas is supported: type, enum,
+// const, properties, required, additionalProperties, items, minItems,
+// anyOf, oneOf, pattern, minLength, format "date-time" and "$ref"s
+// to "#/$defs/...". Annotations (e.g., "description") are ignored.
+type schema struct {
+	root     map[string]any
+	patterns map[string]*regexp.Regexp
+}
+
+// compileSchema parses a JSON Schema document and checks that
+// it only uses supported keywords.
+func compileSchema(b []byte) (*schema, error) {
+	root, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	rootObj, ok := root.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema must be an object, got %T", root)
+	}
+
+	s := &schema{
+		root:     rootObj,
+		patterns: make(map[string]*regexp.Regexp),
+	}
+
+	err = s.compile(rootObj, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// compile checks the keywords of a (sub-)schema and compiles its patterns.
+func (o *schema) compile(node map[string]any, pointer string) error {
+	for keyword, value := range node {
+		kwPointer := pointer + "/" + keyword
+
+		switch keyword {
+		case "$schema", "$id", "$comment", "title", "description", "examples",
+			"type", "enum", "const", "required", "minItems", "minLength", "format":
+		case "$ref":
+			ref, _ := value.(string)
+			if _, err := o.resolve(ref); err != nil {
+				return fmt.Errorf("%s: %w", kwPointer, err)
+			}
+		case "pattern":
+			pattern, _ := value.(string)
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: %w", kwPointer, err)
+			}
+
+			o.patterns[pattern] = re
+		case "properties", "$defs":
+			children, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: must be an object", kwPointer)
+			}
+
+			for name, child := range children {
+				err := o.compileChild(child, kwPointer+"/"+name)
+				if err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items":
+			if _, isBool := value.(bool); isBool {
+				continue
+			}
+
+			err := o.compileChild(value, kwPointer)
+			if err != nil {
+				return err
+			}
+		case "anyOf", "oneOf":
+			children, ok := value.([]any)
+			if !ok {
+				return fmt.Errorf("%s: must be an array", kwPointer)
+			}
+
+			for i, child := range children {
+				err := o.compileChild(child, kwPointer+"/"+strconv.Itoa(i))
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%s: unsupported keyword", kwPointer)
+		}
+	}
+
+	return nil
+}
+
+func (o *schema) compileChild(child any, pointer string) error {
+	node, ok := child.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be a schema object", pointer)
+	}
+
+	return o.compile(node, pointer)
+}
+
+// resolve returns the sub-schema referred to by a local "$ref".
+func (o *schema) resolve(ref string) (map[string]any, error) {
+	name, ok := cutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+
+	defs, _ := o.root["$defs"].(map[string]any)
+
+	node, ok := defs[name].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unknown $ref %q", ref)
+	}
+
+	return node, nil
+}
+
+// lookup returns the sub-schema found by following the "properties"
+// of each name in turn, or nil if there is no such sub-schema.
+func (o *schema) lookup(names ...string) map[string]any {
+	node := o.root
+
+	for _, name := range names {
+		properties, _ := node["properties"].(map[string]any)
+
+		node, _ = properties[name].(map[string]any)
+		if node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+// validate validates a value decoded by decodeJSON.
+func (o *schema) validate(value any) error {
+	return o.validateNode(o.root, value, "")
+}
+
+//nolint:gocyclo // One branch per keyword reads better than a dispatch table.
+func (o *schema) validateNode(node map[string]any, value any, pointer string) error {
+	if ref, ok := node["$ref"].(string); ok {
+		target, err := o.resolve(ref)
+		if err != nil {
+			return &ValidationError{Pointer: pointer, Message: err.Error()}
+		}
+
+		err = o.validateNode(target, value, pointer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if types, ok := node["type"]; ok && !hasType(value, types) {
+		return &ValidationError{
+			Pointer: pointer,
+			Message: fmt.Sprintf("expected type %s, got %s", formatTypes(types), typeOf(value)),
+		}
+	}
+
+	if expected, ok := node["const"]; ok && !jsonEqual(expected, value) {
+		return &ValidationError{
+			Pointer: pointer,
+			Message: fmt.Sprintf("expected %s, got %s", formatValue(expected), formatValue(value)),
+		}
+	}
+
+	if enum, ok := node["enum"].([]any); ok {
+		found := false
+		for _, expected := range enum {
+			if jsonEqual(expected, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("%s is not one of %s", formatValue(value), formatValue(enum)),
+			}
+		}
+	}
+
+	if s, isString := value.(string); isString {
+		err := o.validateString(node, s, pointer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if obj, isObject := value.(map[string]any); isObject {
+		err := o.validateObject(node, obj, pointer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if arr, isArray := value.([]any); isArray {
+		err := o.validateArray(node, arr, pointer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if anyOf, ok := node["anyOf"].([]any); ok {
+		var firstErr error
+		for _, child := range anyOf {
+			childNode, _ := child.(map[string]any)
+			err := o.validateNode(childNode, value, pointer)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if firstErr != nil {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: "does not match any of the allowed schemas (first mismatch: " + firstErr.Error() + ")",
+			}
+		}
+	}
+
+	if oneOf, ok := node["oneOf"].([]any); ok {
+		matches := 0
+		var firstErr error
+		for _, child := range oneOf {
+			childNode, _ := child.(map[string]any)
+			err := o.validateNode(childNode, value, pointer)
+			if err == nil {
+				matches++
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		switch {
+		case matches == 0:
+			return &ValidationError{
+				Pointer: pointer,
+				Message: "does not match any of the allowed schemas (first mismatch: " + firstErr.Error() + ")",
+			}
+		case matches > 1:
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("matches %d schemas, expected exactly one", matches),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *schema) validateString(node map[string]any, s string, pointer string) error {
+	if minLength, ok := node["minLength"].(json.Number); ok {
+		n, _ := minLength.Int64()
+		if int64(len([]rune(s))) < n {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("must be at least %d characters long", n),
+			}
+		}
+	}
+
+	if pattern, ok := node["pattern"].(string); ok && !o.patterns[pattern].MatchString(s) {
+		return &ValidationError{
+			Pointer: pointer,
+			Message: fmt.Sprintf("%q does not match pattern %q", s, pattern),
+		}
+	}
+
+	if format, _ := node["format"].(string); format == "date-time" {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("%q is not an RFC 3339 date-time", s),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *schema) validateObject(node map[string]any, obj map[string]any, pointer string) error {
+	required, _ := node["required"].([]any)
+	for _, r := range required {
+		name, _ := r.(string)
+		if _, ok := obj[name]; !ok {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("missing required property %q", name),
+			}
+		}
+	}
+
+	properties, _ := node["properties"].(map[string]any)
+	additional, hasAdditional := node["additionalProperties"]
+
+	// Properties are validated in a stable order, so the
+	// reported error does not change from one run to another.
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPointer := pointer + "/" + escapePointer(name)
+
+		if child, ok := properties[name].(map[string]any); ok {
+			err := o.validateNode(child, obj[name], childPointer)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !hasAdditional {
+			continue
+		}
+
+		switch a := additional.(type) {
+		case bool:
+			if !a {
+				return &ValidationError{
+					Pointer: childPointer,
+					Message: "additional property is not allowed",
+				}
+			}
+		case map[string]any:
+			err := o.validateNode(a, obj[name], childPointer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *schema) validateArray(node map[string]any, arr []any, pointer string) error {
+	if minItems, ok := node["minItems"].(json.Number); ok {
+		n, _ := minItems.Int64()
+		if int64(len(arr)) < n {
+			return &ValidationError{
+				Pointer: pointer,
+				Message: fmt.Sprintf("must contain at least %d items", n),
+			}
+		}
+	}
+
+	items, ok := node["items"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	for i, item := range arr {
+		err := o.validateNode(items, item, pointer+"/"+strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasType returns true if value is of one of the JSON
+// Schema types (a string or an array of strings).
+func hasType(value any, types any) bool {
+	switch t := types.(type) {
+	case string:
+		return isType(value, t)
+	case []any:
+		for _, name := range t {
+			if s, _ := name.(string); isType(value, s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isType(value any, name string) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+
+		// Numbers with a zero fractional part (e.g., 1.0) are integers.
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	default:
+		return typeOf(value) == name
+	}
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func formatTypes(types any) string {
+	if arr, ok := types.([]any); ok {
+		names := make([]string, len(arr))
+		for i, name := range arr {
+			names[i] = fmt.Sprint(name)
+		}
+
+		return strings.Join(names, " or ")
+	}
+
+	return fmt.Sprint(types)
+}
+
+func formatValue(value any) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(b)
+}
+
+// jsonEqual compares two values decoded by decodeJSON. Numbers are
+// compared by value, so 1 and 1.0 are equal.
+func jsonEqual(a, b any) bool {
+	an, aIsNumber := a.(json.Number)
+	bn, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		af, aErr := an.Float64()
+		bf, bErr := bn.Float64()
+		if aErr == nil && bErr == nil {
+			return af == bf
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// escapePointer escapes a JSON pointer reference token.
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+
+	return s[len(prefix):], true
+}
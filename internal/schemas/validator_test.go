@@ -0,0 +1,88 @@
+package schemas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["id"],
+  "additionalProperties": false,
+  "properties": {
+    "id": {"type": "integer"},
+    "name": {"type": "string", "pattern": "^[a-z]+$", "minLength": 2},
+    "when": {"type": "string", "format": "date-time"},
+    "tags": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/tag"}},
+    "value": {"anyOf": [{"type": "number"}, {"const": "none"}]},
+    "kind": {"oneOf": [{"enum": ["a", "b"]}, {"enum": ["b", "c"]}]},
+    "nullable": {"type": ["string", "null"]}
+  },
+  "$defs": {
+    "tag": {"type": "string", "enum": ["x", "y"]}
+  }
+}`
+
+func TestSchema_Validate(t *testing.T) {
+	t.Parallel()
+
+	s, err := compileSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	valid := []string{
+		`{"id": 1}`,
+		`{"id": 1.0, "name": "abc", "tags": ["x", "y"], "value": 1.5, "kind": "a", "nullable": null}`,
+		`{"id": 1, "value": "none", "kind": "c", "when": "2023-01-02T03:04:05.123Z"}`,
+	}
+
+	for _, doc := range valid {
+		value, err := decodeJSON([]byte(doc))
+		require.NoError(t, err)
+		assert.NoError(t, s.validate(value), doc)
+	}
+
+	invalid := map[string]string{
+		`[]`:                       "/",
+		`{}`:                       "/",
+		`{"id": 1.5}`:              "/id",
+		`{"id": 1, "other": true}`: "/other",
+		`{"id": 1, "name": "ABC"}`: "/name",
+		`{"id": 1, "name": "a"}`:   "/name",
+		`{"id": 1, "tags": []}`:    "/tags",
+		`{"id": 1, "tags": ["z"]}`: "/tags/0",
+		`{"id": 1, "value": "x"}`:  "/value",
+		`{"id": 1, "kind": "b"}`:   "/kind",
+		`{"id": 1, "nullable": 1}`: "/nullable",
+		`{"id": 1, "when": "now"}`: "/when",
+	}
+
+	for doc, pointer := range invalid {
+		value, err := decodeJSON([]byte(doc))
+		require.NoError(t, err)
+
+		err = s.validate(value)
+
+		var validationErr *ValidationError
+		require.ErrorAs(t, err, &validationErr, doc)
+		assert.Equal(t, pointer, validationErr.Error()[:len(pointer)], doc)
+	}
+}
+
+func TestCompileSchema_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, schema := range []string{
+		`[]`,
+		`{"if": {}}`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "other.json"}`,
+		`{"properties": {"a": true}}`,
+		`{"oneOf": {}}`,
+	} {
+		_, err := compileSchema([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
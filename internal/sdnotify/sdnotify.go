@@ -0,0 +1,129 @@
+// Package sdnotify implements the client side of systemd's service
+// notification protocol, which lets a service of Type=notify report its
+// readiness, its status and watchdog keep-alives (refer to sd_notify(3)).
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SocketEnvVar is the environment variable that systemd sets
+	// to the path of the socket that notifications are sent to.
+	SocketEnvVar = "NOTIFY_SOCKET"
+
+	// WatchdogUsecEnvVar is the environment variable that systemd
+	// sets to the watchdog timeout in microseconds (WatchdogSec=).
+	WatchdogUsecEnvVar = "WATCHDOG_USEC"
+
+	// WatchdogPIDEnvVar is the environment variable that systemd sets
+	// to the PID of the process that must send the keep-alives.
+	WatchdogPIDEnvVar = "WATCHDOG_PID"
+)
+
+// The notifications understood by systemd.
+const (
+	// Ready tells systemd that the service finished starting up.
+	Ready = "READY=1"
+
+	// Stopping tells systemd that the service is shutting down.
+	Stopping = "STOPPING=1"
+
+	// Watchdog resets the service's watchdog timer.
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns a notification that sets the free-form status
+// displayed by "systemctl status". status must be a single line.
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// Notifier sends notifications to systemd.
+//
+// The methods of a nil *Notifier do nothing, which
+// is the case when the process is not run by systemd.
+type Notifier struct {
+	addr *net.UnixAddr
+}
+
+// NewNotifier returns a Notifier that sends notifications to the socket
+// specified by the NOTIFY_SOCKET environment variable, which is read
+// using getenv. It returns nil if the variable is not set.
+func NewNotifier(getenv func(string) string) *Notifier {
+	socketPath := getenv(SocketEnvVar)
+	if socketPath == "" {
+		return nil
+	}
+
+	// Paths starting with "@" refer to abstract sockets.
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	return &Notifier{
+		addr: &net.UnixAddr{Name: socketPath, Net: "unixgram"},
+	}
+}
+
+// Notify sends the specified notifications (e.g., Ready and a Status)
+// to systemd in a single datagram.
+func (o *Notifier) Notify(notifications ...string) error {
+	if o == nil || len(notifications) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, o.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notification socket: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(notifications, "\n")))
+	if err != nil {
+		return fmt.Errorf("failed to write systemd notification: %w", err)
+	}
+
+	return nil
+}
+
+// WatchdogTimeout returns the watchdog timeout of the process whose PID
+// is pid, as specified by the WATCHDOG_USEC and WATCHDOG_PID environment
+// variables, which are read using getenv. It returns zero if the
+// watchdog is not enabled for the process.
+//
+// systemd recommends sending keep-alives every half of the timeout.
+func WatchdogTimeout(getenv func(string) string, pid int) (time.Duration, error) {
+	usecStr := getenv(WatchdogUsecEnvVar)
+	if usecStr == "" {
+		return 0, nil
+	}
+
+	// The watchdog may be meant for another process
+	// (e.g., the one that started this process).
+	if pidStr := getenv(WatchdogPIDEnvVar); pidStr != "" {
+		watchdogPID, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %q - %w", WatchdogPIDEnvVar, pidStr, err)
+		}
+
+		if watchdogPID != pid {
+			return 0, nil
+		}
+	}
+
+	usec, err := strconv.ParseInt(usecStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %q - %w", WatchdogUsecEnvVar, usecStr, err)
+	}
+
+	if usec <= 0 {
+		return 0, fmt.Errorf("%s must be positive: %d", WatchdogUsecEnvVar, usec)
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
@@ -0,0 +1,117 @@
+package sdnotify
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testGetenv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	notifier := NewNotifier(testGetenv(map[string]string{SocketEnvVar: socketPath}))
+	require.NotNil(t, notifier)
+
+	require.NoError(t, notifier.Notify(Ready, Status("processing\nevents")))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=processing events", string(buf[:n]))
+}
+
+func TestNotifier_NoSocket(t *testing.T) {
+	t.Parallel()
+
+	notifier := NewNotifier(testGetenv(nil))
+	assert.Nil(t, notifier)
+	assert.NoError(t, notifier.Notify(Ready))
+}
+
+func TestNotifier_SocketGone(t *testing.T) {
+	t.Parallel()
+
+	notifier := NewNotifier(testGetenv(map[string]string{
+		SocketEnvVar: filepath.Join(t.TempDir(), "notify.sock"),
+	}))
+
+	assert.Error(t, notifier.Notify(Watchdog))
+}
+
+func TestWatchdogTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		exp     time.Duration
+		wantErr bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name: "enabled",
+			env:  map[string]string{WatchdogUsecEnvVar: "30000000"},
+			exp:  30 * time.Second,
+		},
+		{
+			name: "this process",
+			env:  map[string]string{WatchdogUsecEnvVar: "30000000", WatchdogPIDEnvVar: "42"},
+			exp:  30 * time.Second,
+		},
+		{
+			name: "another process",
+			env:  map[string]string{WatchdogUsecEnvVar: "30000000", WatchdogPIDEnvVar: "1"},
+		},
+		{
+			name:    "invalid timeout",
+			env:     map[string]string{WatchdogUsecEnvVar: "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "zero timeout",
+			env:     map[string]string{WatchdogUsecEnvVar: "0"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pid",
+			env:     map[string]string{WatchdogUsecEnvVar: "30000000", WatchdogPIDEnvVar: "me"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			timeout, err := WatchdogTimeout(testGetenv(tt.env), 42)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.exp, timeout)
+		})
+	}
+}
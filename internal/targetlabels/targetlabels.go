@@ -0,0 +1,271 @@
+// Package targetlabels loads the labels that are added to the Target
+// of audit events (e.g., the cluster, region or role of the host).
+package targetlabels
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// FileSource describes a file from which labels are read. The file
+// contains one "key=value" pair per line, where the value may be
+// quoted. This is the format of Kubernetes downward API label files
+// and of /etc/os-release. Empty lines and lines starting with "#"
+// are ignored.
+type FileSource struct {
+	// Path is the file's path.
+	Path string `json:"path"`
+
+	// Labels maps label names to keys in the file. If it is
+	// empty, every key in the file becomes a label.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Prefix is prepended to the names of the labels.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Config configures a Loader.
+type Config struct {
+	// Static are labels with a fixed value.
+	Static map[string]string `json:"static,omitempty"`
+
+	// Files are files from which labels are read. When a label
+	// is found in more than one source, the last file wins, and
+	// files win over static labels.
+	Files []FileSource `json:"files,omitempty"`
+}
+
+// Empty returns true if the Config does not produce any labels.
+func (o Config) Empty() bool {
+	return len(o.Static) == 0 && len(o.Files) == 0
+}
+
+// ParseConfig parses a JSON-encoded Config. For example:
+//
+//	{
+//	  "static": {"cluster": "prod-1", "role": "bastion"},
+//	  "files": [
+//	    {
+//	      "path": "/etc/podinfo/labels",
+//	      "labels": {"region": "topology.kubernetes.io/region", "rack": "rack"}
+//	    },
+//	    {"path": "/etc/os-release", "prefix": "os.", "labels": {"id": "ID"}}
+//	  ]
+//	}
+func ParseConfig(r io.Reader) (Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var config Config
+
+	err := dec.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to decode target labels config - %w", err)
+	}
+
+	for _, f := range config.Files {
+		if f.Path == "" {
+			return Config{}, errors.New("target labels file path is empty")
+		}
+	}
+
+	return config, nil
+}
+
+// NewLoader returns a new Loader and loads its labels. Files that
+// cannot be read are logged and skipped, as they may be created
+// later (refer to Loader.Watch).
+func NewLoader(config Config, l *zap.SugaredLogger) *Loader {
+	if l == nil {
+		l = zap.NewNop().Sugar()
+	}
+
+	o := &Loader{
+		config: config,
+		l:      l,
+	}
+
+	o.Reload()
+
+	return o
+}
+
+// Loader loads and caches the labels described by a Config.
+type Loader struct {
+	config Config
+	l      *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	labels map[string]string
+}
+
+// Labels returns the current labels. The map must not be modified.
+func (o *Loader) Labels() map[string]string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.labels
+}
+
+// Reload reads the labels' files again.
+func (o *Loader) Reload() {
+	labels := make(map[string]string, len(o.config.Static))
+	for k, v := range o.config.Static {
+		labels[k] = v
+	}
+
+	for _, source := range o.config.Files {
+		err := source.load(labels)
+		if err != nil {
+			o.l.Warnf("failed to read target labels from '%s' - %s", source.Path, err)
+		}
+	}
+
+	o.mu.Lock()
+	o.labels = labels
+	o.mu.Unlock()
+}
+
+// Watch reloads the labels each time one of the files changes, until
+// ctx is marked as done. The files' directories are watched, rather
+// than the files themselves, because files may be replaced (e.g., the
+// Kubernetes downward API updates files using symbolic links).
+//
+// The returned error is always non-nil.
+func (o *Loader) Watch(ctx context.Context) error {
+	if len(o.config.Files) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create new fsnotify.Watcher - %w", err)
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]struct{})
+	for _, source := range o.config.Files {
+		dir := filepath.Dir(source.Path)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+
+		err = watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("failed to watch target labels directory '%s' - %w", dir, err)
+		}
+
+		dirs[dir] = struct{}{}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			o.Reload()
+			o.l.Infof("reloaded target labels after '%s' changed", event.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+
+			o.l.Warnf("target labels watcher error - %s", err)
+		}
+	}
+}
+
+// load adds the labels found in the file to labels.
+func (o FileSource) load(labels map[string]string) error {
+	f, err := os.Open(o.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values, err := parseKeyValues(f)
+	if err != nil {
+		return err
+	}
+
+	if len(o.Labels) == 0 {
+		for k, v := range values {
+			labels[o.Prefix+k] = v
+		}
+
+		return nil
+	}
+
+	for name, key := range o.Labels {
+		if v, ok := values[key]; ok {
+			labels[o.Prefix+name] = v
+		}
+	}
+
+	return nil
+}
+
+// parseKeyValues parses "key=value" lines. Double-quoted values are
+// unquoted using Go syntax, which matches the escaping used by the
+// Kubernetes downward API and the common cases of /etc/os-release.
+func parseKeyValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		values[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+	}
+
+	return values, scanner.Err()
+}
+
+func unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err == nil {
+			return unquoted
+		}
+
+		return s[1 : len(s)-1]
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return s[1 : len(s)-1]
+	default:
+		return s
+	}
+}
@@ -0,0 +1,153 @@
+package targetlabels
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPodLabels = `app="audito-maldito"
+rack="r12"
+topology.kubernetes.io/region="us-east-1"
+escaped="a \"quoted\" value"
+`
+
+const testOSRelease = `# comment
+NAME="Ubuntu"
+ID=ubuntu
+VERSION_ID='22.04'
+`
+
+func writeFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0o600))
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := ParseConfig(strings.NewReader(`{
+		"static": {"cluster": "prod-1"},
+		"files": [{"path": "/etc/os-release", "prefix": "os.", "labels": {"id": "ID"}}]
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "prod-1", config.Static["cluster"])
+	assert.Equal(t, []FileSource{{
+		Path:   "/etc/os-release",
+		Prefix: "os.",
+		Labels: map[string]string{"id": "ID"},
+	}}, config.Files)
+	assert.False(t, config.Empty())
+	assert.True(t, Config{}.Empty())
+
+	for _, invalid := range []string{`{"files": [{}]}`, `{"unknown": 1}`, `[]`} {
+		_, err := ParseConfig(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoader_Labels(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	podLabels := filepath.Join(dir, "labels")
+	osRelease := filepath.Join(dir, "os-release")
+
+	writeFile(t, podLabels, testPodLabels)
+	writeFile(t, osRelease, testOSRelease)
+
+	loader := NewLoader(Config{
+		Static: map[string]string{
+			"cluster": "prod-1",
+			"region":  "overridden",
+		},
+		Files: []FileSource{
+			{
+				Path: podLabels,
+				Labels: map[string]string{
+					"region":  "topology.kubernetes.io/region",
+					"rack":    "rack",
+					"escaped": "escaped",
+					"missing": "missing",
+				},
+			},
+			{Path: osRelease, Prefix: "os."},
+			{Path: filepath.Join(dir, "does-not-exist")},
+		},
+	}, nil)
+
+	assert.Equal(t, map[string]string{
+		"cluster":       "prod-1",
+		"region":        "us-east-1",
+		"rack":          "r12",
+		"escaped":       `a "quoted" value`,
+		"os.NAME":       "Ubuntu",
+		"os.ID":         "ubuntu",
+		"os.VERSION_ID": "22.04",
+	}, loader.Labels())
+}
+
+func TestLoader_Watch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// The Kubernetes downward API updates files by replacing
+	// a symbolic link to a directory containing the files.
+	dataV1 := filepath.Join(dir, "..v1")
+	require.NoError(t, os.Mkdir(dataV1, 0o700))
+	writeFile(t, filepath.Join(dataV1, "labels"), `role="bastion"`)
+	require.NoError(t, os.Symlink(dataV1, filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "labels"), filepath.Join(dir, "labels")))
+
+	loader := NewLoader(Config{
+		Files: []FileSource{{Path: filepath.Join(dir, "labels")}},
+	}, nil)
+
+	assert.Equal(t, "bastion", loader.Labels()["role"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- loader.Watch(ctx)
+	}()
+
+	// Give the watcher time to start.
+	time.Sleep(50 * time.Millisecond)
+
+	dataV2 := filepath.Join(dir, "..v2")
+	require.NoError(t, os.Mkdir(dataV2, 0o700))
+	writeFile(t, filepath.Join(dataV2, "labels"), `role="jumphost"`)
+	require.NoError(t, os.Symlink(dataV2, filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	require.Eventually(t, func() bool {
+		return loader.Labels()["role"] == "jumphost"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestParseKeyValues(t *testing.T) {
+	t.Parallel()
+
+	values, err := parseKeyValues(strings.NewReader("a=1\n\nno-separator\n b = \"x=y\" \nc=''\nd=\"\"\"\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"a": "1",
+		"b": "x=y",
+		"c": "",
+		"d": `"`,
+	}, values)
+}
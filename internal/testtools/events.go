@@ -0,0 +1,94 @@
+package testtools
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+// EventBuilder builds audit events for testing purposes. Unless its
+// methods say otherwise, the event describes a successful sshd event
+// of the "root" local user, coming from 127.0.0.1.
+type EventBuilder struct {
+	event *auditevent.AuditEvent
+}
+
+// NewEventBuilder returns an EventBuilder for an event of the given
+// type (e.g., "UserLogin").
+func NewEventBuilder(eventType string) *EventBuilder {
+	return &EventBuilder{
+		event: auditevent.NewAuditEvent(
+			eventType,
+			auditevent.EventSource{
+				Type:  "IP",
+				Value: "127.0.0.1",
+			},
+			auditevent.OutcomeSucceeded,
+			map[string]string{
+				"loggedAs": "root",
+			},
+			"sshd",
+		),
+	}
+}
+
+// WithAuditID sets the event's metadata.auditId.
+func (o *EventBuilder) WithAuditID(auditID string) *EventBuilder {
+	o.event.Metadata.AuditID = auditID
+	return o
+}
+
+// WithSource sets the event's source.
+func (o *EventBuilder) WithSource(source auditevent.EventSource) *EventBuilder {
+	o.event.Source = source
+	return o
+}
+
+// WithSubjects sets the event's subjects.
+func (o *EventBuilder) WithSubjects(subjects map[string]string) *EventBuilder {
+	o.event.Subjects = subjects
+	return o
+}
+
+// WithOutcome sets the event's outcome (e.g., auditevent.OutcomeFailed).
+func (o *EventBuilder) WithOutcome(outcome string) *EventBuilder {
+	o.event.Outcome = outcome
+	return o
+}
+
+// WithComponent sets the event's component.
+func (o *EventBuilder) WithComponent(component string) *EventBuilder {
+	o.event.Component = component
+	return o
+}
+
+// WithTarget sets the event's target.
+func (o *EventBuilder) WithTarget(target map[string]string) *EventBuilder {
+	o.event.Target = target
+	return o
+}
+
+// WithExtra sets the event's metadata.extra.
+func (o *EventBuilder) WithExtra(extra map[string]any) *EventBuilder {
+	o.event.Metadata.Extra = extra
+	return o
+}
+
+// WithData sets the event's data.
+func (o *EventBuilder) WithData(data json.RawMessage) *EventBuilder {
+	o.event.Data = &data
+	return o
+}
+
+// WithLoggedAt sets the time at which the event was logged.
+func (o *EventBuilder) WithLoggedAt(loggedAt time.Time) *EventBuilder {
+	o.event.LoggedAt = loggedAt
+	return o
+}
+
+// Build returns the event. The EventBuilder must
+// not be used once the event is built.
+func (o *EventBuilder) Build() *auditevent.AuditEvent {
+	return o.event
+}
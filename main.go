@@ -24,5 +24,18 @@ func main() {
 func mainWithError() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			return cmd.RunVerify(os.Args[1:], os.Stdout)
+		case "schema":
+			return cmd.RunSchema(os.Args[1:], os.Stdout)
+		case "config":
+			return cmd.RunConfig(os.Args[1:], os.Stdout)
+		case "sessions":
+			return cmd.RunSessions(ctx, os.Args[1:], os.Stdout)
+		}
+	}
+
 	return cmd.RunNamedPipe(ctx, os.Args, health.NewHealth(), nil)
 }
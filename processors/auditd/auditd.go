@@ -7,28 +7,87 @@ import (
 
 	"github.com/elastic/go-libaudit/v2"
 	"github.com/elastic/go-libaudit/v2/auparse"
-	"github.com/metal-toolbox/auditevent"
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+	"github.com/metal-toolbox/audito-maldito/sinks"
 )
 
 const (
 	// AuditdProcessorComponentName is the name of the component
 	// that reads from auditd. This is used in the health check.
 	AuditdProcessorComponentName = "auditd-processor"
+
+	// stateMetricsInterval is the interval at which the metrics
+	// describing the processor's queued and cached data are set.
+	stateMetricsInterval = 5 * time.Second
 )
 
-// libaudit variables.
+// Default values of Tuning.
 const (
-	maxEventsInFlight        = 1000
-	eventTimeout             = 2 * time.Second
-	reassemblerInterval      = 500 * time.Millisecond
-	staleDataCleanupInterval = 1 * time.Minute
+	// DefaultMaxEventsInFlight is the default maximum number of
+	// audit events that the libaudit reassembler buffers.
+	DefaultMaxEventsInFlight = 1000
+
+	// DefaultEventTimeout is the default duration after which the
+	// libaudit reassembler gives up waiting for an event's messages.
+	DefaultEventTimeout = 2 * time.Second
+
+	// DefaultReassemblerInterval is the default interval at which
+	// the libaudit reassembler's state is maintained.
+	DefaultReassemblerInterval = 500 * time.Millisecond
+
+	// DefaultStaleDataCleanupInterval is the default interval at which
+	// logins and users without a matching audit session are deleted.
+	DefaultStaleDataCleanupInterval = 1 * time.Minute
 )
 
+// Tuning adjusts the reassembly of audit messages and the cleanup
+// of stale session data. Zero values are replaced by the defaults.
+type Tuning struct {
+	// MaxEventsInFlight is the maximum number of audit events
+	// that are buffered while their messages are reassembled.
+	MaxEventsInFlight int
+
+	// EventTimeout is the duration after which an audit event
+	// is considered complete, even if messages are missing.
+	EventTimeout time.Duration
+
+	// ReassemblerInterval is the interval at which timed out
+	// audit events are flushed from the reassembler.
+	ReassemblerInterval time.Duration
+
+	// StaleDataCleanupInterval is the interval at which remote user
+	// logins and users without a matching audit session are deleted.
+	// Data older than the interval is considered stale.
+	StaleDataCleanupInterval time.Duration
+}
+
+// withDefaults returns a copy of o in which zero
+// values are replaced by the defaults.
+func (o Tuning) withDefaults() Tuning {
+	if o.MaxEventsInFlight <= 0 {
+		o.MaxEventsInFlight = DefaultMaxEventsInFlight
+	}
+
+	if o.EventTimeout <= 0 {
+		o.EventTimeout = DefaultEventTimeout
+	}
+
+	if o.ReassemblerInterval <= 0 {
+		o.ReassemblerInterval = DefaultReassemblerInterval
+	}
+
+	if o.StaleDataCleanupInterval <= 0 {
+		o.StaleDataCleanupInterval = DefaultStaleDataCleanupInterval
+	}
+
+	return o
+}
+
 var logger *zap.SugaredLogger
 
 func SetLogger(l *zap.SugaredLogger) {
@@ -52,23 +111,79 @@ type Auditd struct {
 	// remotely through a service like sshd.
 	Logins <-chan common.RemoteUserLogin
 
-	// EventW is the auditevent.EventWriter to write events to.
-	EventW *auditevent.EventWriter
+	// EventW is the sinks.EventSink to write events to.
+	EventW sinks.EventSink
+
+	// RuleKeys optionally classifies audit events by the audit
+	// rule keys they were tagged with. It may be nil.
+	RuleKeys sessiontracker.RuleKeyClasses
+
+	// Transcripts configures the collection of TTY input
+	// into per-session keystroke transcripts.
+	Transcripts sessiontracker.TranscriptConfig
+
+	// Tuning optionally adjusts the reassembly of audit
+	// messages and the cleanup of stale session data.
+	Tuning Tuning
 
 	Health *health.Health
+
+	// ReassemblerHeartbeat optionally records the progress of
+	// the reassembly of audit messages read from Audits.
+	ReassemblerHeartbeat *health.Heartbeat
+
+	// SessionTrackerHeartbeat optionally records the progress
+	// of the correlation of audit events and remote logins.
+	SessionTrackerHeartbeat *health.Heartbeat
+
+	// Sessions optionally provides snapshots of the active audit
+	// sessions and pending remote logins while Read is running.
+	Sessions *sessiontracker.Introspector
+
+	// Metrics optionally counts parse failures and correlations,
+	// and reports the amount of queued and cached data.
+	Metrics *metrics.PrometheusMetricsProvider
+
+	// OnEventsLost is optionally called with the number of audit
+	// events that the reassembler lost (e.g., because of gaps in
+	// their sequence numbers). It must not block.
+	OnEventsLost func(count int)
+
+	// Target is the target (e.g., the host name) of the events of
+	// audit sessions that were not bound to a remote user login when
+	// Read stopped. Other events have the target of their login.
+	Target map[string]string
 }
 
 // Read reads Linux audit messages from Auditd.Logins, parsing them into
 // Linux audit messages. It correlates the Linux audit events and their
 // session IDs with remote user logins sourced from Auditd.Logins.
+//
+// Closing Audits stops Read gracefully: the lines already sent are
+// parsed, the remaining logins are correlated until Logins is closed
+// (a nil Logins is considered closed), the events in the reassembler
+// are flushed, and the events cached for audit sessions without a
+// remote user login are written. Read then returns nil. When ctx is
+// marked as done, Read returns ctx.Err() without waiting.
 func (o *Auditd) Read(ctx context.Context) error {
+	tuning := o.Tuning.withDefaults()
+
 	reassemblerErrors := make(chan error, 1)
-	tracker := sessiontracker.NewSessionTracker(o.EventW, logger)
+	tracker := sessiontracker.NewSessionTracker(o.EventW, logger).
+		WithRuleKeyClasses(o.RuleKeys).
+		WithTranscripts(o.Transcripts).
+		WithMetrics(o.Metrics).
+		WithTarget(o.Target)
+
+	o.Sessions.Attach(tracker)
+	defer o.Sessions.Attach(nil)
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
-		au:     tracker,
-		errors: reassemblerErrors,
-		after:  o.After,
+	reassembler, err := libaudit.NewReassembler(tuning.MaxEventsInFlight, tuning.EventTimeout, &reassemblerCB{
+		au:        tracker,
+		errors:    reassemblerErrors,
+		after:     o.After,
+		heartbeat: o.SessionTrackerHeartbeat,
+		onLost:    o.OnEventsLost,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create new auditd message resassembler - %w", err)
@@ -76,39 +191,154 @@ func (o *Auditd) Read(ctx context.Context) error {
 
 	defer reassembler.Close()
 
-	go maintainReassemblerLoop(ctx, reassembler, reassemblerInterval)
+	go maintainReassemblerLoop(ctx, reassembler, tuning.ReassemblerInterval)
+
+	// The reassembler is stalled if audit log
+	// lines are waiting and none are parsed.
+	o.ReassemblerHeartbeat.WithPending(func() int {
+		return len(o.Audits)
+	})
 
 	parseAuditLogsDone := make(chan error, 1)
 	go func() {
-		parseAuditLogsDone <- parseAuditLogs(ctx, o.Audits, reassembler)
+		parseAuditLogsDone <- parseAuditLogs(ctx, o.Audits, reassembler, o.ReassemblerHeartbeat, o.Metrics)
 	}()
 
-	staleDataTicker := time.NewTicker(staleDataCleanupInterval)
+	staleDataTicker := time.NewTicker(tuning.StaleDataCleanupInterval)
 	defer staleDataTicker.Stop()
 
+	stateMetricsTicker := time.NewTicker(stateMetricsInterval)
+	defer stateMetricsTicker.Stop()
+
+	// A nil channel blocks forever, which disables
+	// the periodic writing of transcripts.
+	var transcriptsTick <-chan time.Time
+	if o.Transcripts.Enabled && o.Transcripts.Interval > 0 {
+		transcriptsTicker := time.NewTicker(o.Transcripts.Interval)
+		defer transcriptsTicker.Stop()
+
+		transcriptsTick = transcriptsTicker.C
+	}
+
 	o.Health.OnReady(AuditdProcessorComponentName)
 
+	logins := o.Logins
+
+	handleLogin := func(remoteLogin common.RemoteUserLogin) error {
+		o.SessionTrackerHeartbeat.Start()
+		err := tracker.RemoteLogin(remoteLogin)
+		o.SessionTrackerHeartbeat.Done()
+		if err != nil {
+			return fmt.Errorf("failed to handle remote user login - %w", err)
+		}
+
+		return nil
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-staleDataTicker.C:
-			aMinuteAgo := time.Now().Add(-staleDataCleanupInterval)
+			staleBefore := time.Now().Add(-tuning.StaleDataCleanupInterval)
+
+			o.SessionTrackerHeartbeat.Start()
+			tracker.DeleteUsersWithoutLoginsBefore(staleBefore)
+			tracker.DeleteRemoteUserLoginsBefore(staleBefore)
+			o.SessionTrackerHeartbeat.Done()
+		case <-stateMetricsTicker.C:
+			if o.Metrics != nil {
+				stats := tracker.Stats()
+				o.Metrics.SetSessionTrackerState(stats.Sessions, stats.PendingLogins, stats.CachedEvents)
+				o.Metrics.SetAuditLogChannelDepth(len(o.Audits))
+			}
+		case <-transcriptsTick:
+			o.SessionTrackerHeartbeat.Start()
+			err := tracker.WriteTranscripts(false)
+			o.SessionTrackerHeartbeat.Done()
+			if err != nil {
+				return fmt.Errorf("failed to write session transcripts - %w", err)
+			}
+		case remoteLogin, ok := <-logins:
+			if !ok {
+				logins = nil
+				continue
+			}
 
-			tracker.DeleteUsersWithoutLoginsBefore(aMinuteAgo)
-			tracker.DeleteRemoteUserLoginsBefore(aMinuteAgo)
-		case remoteLogin := <-o.Logins:
-			if err := tracker.RemoteLogin(remoteLogin); err != nil {
-				return fmt.Errorf("failed to handle remote user login - %w", err)
+			err := handleLogin(remoteLogin)
+			if err != nil {
+				return err
 			}
 		case err := <-parseAuditLogsDone:
-			return fmt.Errorf("audit log parser exited unexpectedly with error - %w", err)
+			if err != nil {
+				return fmt.Errorf("audit log parser exited unexpectedly with error - %w", err)
+			}
+
+			// Audits was closed and all of its lines were parsed.
+			for logins != nil {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case remoteLogin, ok := <-logins:
+					if !ok {
+						logins = nil
+						continue
+					}
+
+					err := handleLogin(remoteLogin)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return o.flush(reassembler, tracker, reassemblerErrors)
 		case err := <-reassemblerErrors:
 			return fmt.Errorf("failed to reassemble auditd event - %w", err)
 		}
 	}
 }
 
+// flusher writes the data that the session tracker holds
+// until audit sessions are bound to remote user logins.
+type flusher interface {
+	Flush() error
+	WriteTranscripts(final bool) error
+}
+
+// flush writes the events that remain once no more audit log lines
+// or logins are received. The reassembler is closed, so the events
+// whose messages are incomplete are passed to the tracker, then the
+// tracker's cached events and pending transcripts are written.
+func (o *Auditd) flush(reassembler *libaudit.Reassembler, tracker flusher, reassemblerErrors <-chan error) error {
+	o.SessionTrackerHeartbeat.Start()
+	defer o.SessionTrackerHeartbeat.Done()
+
+	_ = reassembler.Close()
+
+	select {
+	case err := <-reassemblerErrors:
+		return fmt.Errorf("failed to reassemble auditd event - %w", err)
+	default:
+	}
+
+	err := tracker.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to flush cached audit events - %w", err)
+	}
+
+	// The transcripts are final, as the sessions' remaining
+	// input (if any) will not be read.
+	if o.Transcripts.Enabled {
+		err = tracker.WriteTranscripts(true)
+		if err != nil {
+			return fmt.Errorf("failed to write session transcripts - %w", err)
+		}
+	}
+
+	return nil
+}
+
 // maintainReassemblerLoop calls libaudit.Reassembler.Maintain in a loop
 // at an interval specified by d.
 func maintainReassemblerLoop(ctx context.Context, reassembler *libaudit.Reassembler, d time.Duration) {
@@ -132,13 +362,24 @@ func maintainReassemblerLoop(ctx context.Context, reassembler *libaudit.Reassemb
 }
 
 // parseAuditLogs parses audit log lines read from lines and pushes them
-// to reass until the provided context is marked as done.
-func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Reassembler) error {
+// to reass until lines is closed (in which case it returns nil) or the
+// provided context is marked as done. Each line read from lines is
+// recorded as progress by hb, and lines that fail to parse are counted
+// by m.
+func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Reassembler,
+	hb *health.Heartbeat, m *metrics.PrometheusMetricsProvider,
+) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case line := <-lines:
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+
+			hb.Beat()
+
 			if line == "" {
 				// Parsing an empty line results in this error:
 				//    invalid audit message header
@@ -151,6 +392,8 @@ func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Re
 
 			auditMsg, err := auparse.ParseLogLine(line)
 			if err != nil {
+				m.IncParseFailures(metrics.ParseFailureAuditLogLine)
+
 				return &parseAuditLogsError{
 					message: fmt.Sprintf("failed to parse auditd log line '%s' - %s",
 						line, err),
@@ -26,6 +26,25 @@ import (
 	fakest "github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker/fakes"
 )
 
+func TestTuning_WithDefaults(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, Tuning{
+		MaxEventsInFlight:        DefaultMaxEventsInFlight,
+		EventTimeout:             DefaultEventTimeout,
+		ReassemblerInterval:      DefaultReassemblerInterval,
+		StaleDataCleanupInterval: DefaultStaleDataCleanupInterval,
+	}, Tuning{}.withDefaults())
+
+	custom := Tuning{
+		MaxEventsInFlight:        10,
+		EventTimeout:             time.Second,
+		ReassemblerInterval:      time.Second,
+		StaleDataCleanupInterval: time.Hour,
+	}
+	assert.Equal(t, custom, custom.withDefaults())
+}
+
 func TestAuditd_Read_RemoteLoginError(t *testing.T) {
 	t.Parallel()
 
@@ -155,13 +174,161 @@ func TestAuditd_Read_AuditEventWriterError(t *testing.T) {
 	assert.ErrorIs(t, err, expInnerErr)
 }
 
+// closedTestLogLines returns a closed channel containing the
+// lines of the audit log line sets.
+func closedTestLogLines(lineSets ...string) <-chan string {
+	var all []string
+	for _, lineSet := range lineSets {
+		all = append(all, strings.Split(lineSet, "\n")...)
+	}
+
+	lines := make(chan string, len(all))
+	for _, line := range all {
+		lines <- line
+	}
+
+	close(lines)
+
+	return lines
+}
+
+func TestAuditd_Read_AuditsClosed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	logins := make(chan common.RemoteUserLogin)
+	events := make(chan *auditevent.AuditEvent, goodAuditdMaxResultingEvents)
+
+	a := Auditd{
+		Audits: closedTestLogLines(goodAuditd00, goodAuditd01, goodAuditd02, goodAuditd03),
+		Logins: logins,
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: events,
+			T:      t,
+		}),
+		Health: health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Read(ctx)
+	}()
+
+	// Logins are correlated until Logins is closed,
+	// including the events that are still reassembled.
+	logins <- newSshdJournaldAuditEvent("user", goodAuditdSshdPid)
+	close(logins)
+
+	require.NoError(t, <-errs)
+	require.NotEmpty(t, events)
+
+	var commands []string
+	for len(events) > 0 {
+		evt := <-events
+		assert.Equal(t, "foo@bar.com", evt.Subjects["userID"])
+		assert.NotContains(t, evt.Metadata.Extra, "uncorrelated")
+
+		if commandLine, ok := evt.Metadata.Extra["command_line"].(string); ok {
+			commands = append(commands, commandLine)
+		}
+	}
+
+	assert.Contains(t, commands, "ls --color=auto /root")
+}
+
+func TestAuditd_Read_AuditsClosed_FinalTranscripts(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	logins := make(chan common.RemoteUserLogin)
+	events := make(chan *auditevent.AuditEvent, goodAuditdMaxResultingEvents)
+
+	// The session's input ends with an unterminated line ("ls\rexi").
+	ttyInput := `type=TTY msg=audit(1668460958.300:30364): tty pid=25011 uid=0 auid=1000 ses=499 ` +
+		`major=136 minor=0 comm="bash" data=6C730D657869`
+
+	a := Auditd{
+		Audits: closedTestLogLines(goodAuditd00, goodAuditd01, ttyInput),
+		Logins: logins,
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: events,
+			T:      t,
+		}),
+		Health:      health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		Transcripts: sessiontracker.TranscriptConfig{Enabled: true},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Read(ctx)
+	}()
+
+	logins <- newSshdJournaldAuditEvent("user", goodAuditdSshdPid)
+	close(logins)
+
+	require.NoError(t, <-errs)
+
+	var transcripts []*auditevent.AuditEvent
+	for len(events) > 0 {
+		evt := <-events
+		if evt.Type == common.ActionSessionTranscript {
+			transcripts = append(transcripts, evt)
+		}
+	}
+
+	require.Len(t, transcripts, 1)
+	assert.Equal(t, "ls\nexi", transcripts[0].Metadata.Extra["transcript"])
+	assert.Equal(t, true, transcripts[0].Metadata.Extra["final"])
+	assert.Equal(t, "foo@bar.com", transcripts[0].Subjects["userID"])
+}
+
+func TestAuditd_Read_AuditsClosed_Uncorrelated(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, goodAuditdMaxResultingEvents)
+
+	a := Auditd{
+		Audits: closedTestLogLines(goodAuditd00, goodAuditd01, goodAuditd02, goodAuditd03),
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: events,
+			T:      t,
+		}),
+		Health: health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		Target: map[string]string{"host": "test"},
+	}
+
+	// Without a login, the cached events are written
+	// with the local user known from the audit records.
+	require.NoError(t, a.Read(ctx))
+	require.NotEmpty(t, events)
+
+	for len(events) > 0 {
+		evt := <-events
+		assert.Equal(t, true, evt.Metadata.Extra["uncorrelated"])
+		// The name depends on the local users, as IDs are resolved.
+		assert.NotEqual(t, common.UnknownUser, evt.Subjects["loggedAs"])
+		assert.Equal(t, common.UnknownUser, evt.Subjects["userID"])
+		assert.Equal(t, "test", evt.Target["host"])
+	}
+}
+
 func TestMaintainReassemblerLoop_Cancel(t *testing.T) {
 	t.Parallel()
 
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -183,7 +350,7 @@ func TestMaintainReassemblerLoop_Maintain(t *testing.T) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 50*maintainDuration)
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -203,7 +370,7 @@ func TestMaintainReassemblerLoop_ReasemblerClosed(t *testing.T) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 50*maintainDuration)
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -223,7 +390,7 @@ func TestParseAuditLogs_Cancel(t *testing.T) {
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -236,7 +403,7 @@ func TestParseAuditLogs_Cancel(t *testing.T) {
 
 	cancelFn()
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil, nil)
 
 	assert.ErrorIs(t, err, context.Canceled)
 }
@@ -247,7 +414,7 @@ func TestParseAuditLogs_EmptyAuditLine(t *testing.T) {
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -269,9 +436,44 @@ func TestParseAuditLogs_EmptyAuditLine(t *testing.T) {
 		}
 	}()
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil, nil)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseAuditLogs_Heartbeat(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
 
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
+		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
+			return nil
+		}),
+		errors: make(chan error, 1),
+		after:  time.Time{},
+	})
+	require.NoError(t, err, "failed to create reassembler")
+
+	h := health.NewHealth()
+	hb := h.AddHeartbeat("reassembler", time.Hour)
+	started := h.GetLivezStatus().Components["reassembler"].LastHeartbeat
+
+	lines := make(chan string)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case lines <- "":
+			cancelFn()
+		}
+	}()
+
+	err = parseAuditLogs(ctx, lines, reassembler, hb, nil)
 	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.True(t, h.GetLivezStatus().Components["reassembler"].LastHeartbeat.After(started))
 }
 
 func TestParseAuditLogs_LogParseFailure(t *testing.T) {
@@ -280,7 +482,7 @@ func TestParseAuditLogs_LogParseFailure(t *testing.T) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
 	defer cancelFn()
 
-	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			return nil
 		}),
@@ -292,7 +494,7 @@ func TestParseAuditLogs_LogParseFailure(t *testing.T) {
 	lines := make(chan string, 1)
 	lines <- "foobar"
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil, nil)
 
 	var expErr *parseAuditLogsError
 
@@ -348,6 +550,25 @@ func TestReassemblerCB_ReassemblyComplete_EventIsBefore(t *testing.T) {
 	require.Empty(t, rcbErrs, "errors chan should be empty because event occurred before filter")
 }
 
+func TestReassemblerCB_EventsLost(t *testing.T) {
+	t.Parallel()
+
+	var lost []int
+	rcb := &reassemblerCB{
+		onLost: func(count int) {
+			lost = append(lost, count)
+		},
+	}
+
+	rcb.EventsLost(3)
+	rcb.EventsLost(1)
+
+	assert.Equal(t, []int{3, 1}, lost)
+
+	// The callback is optional.
+	(&reassemblerCB{}).EventsLost(1)
+}
+
 // Refer to the following GitHub issue for details:
 // https://github.com/elastic/go-libaudit/issues/127
 //
@@ -389,7 +610,7 @@ type=PROCTITLE msg=audit(1671230063.745:657579): proctitle=2F7573722F7362696E2F6
 			rcbErrors := make(chan error, 1)
 			called := false
 
-			reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+			reassembler, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 				au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 					called = true
 					return nil
@@ -459,7 +680,7 @@ func TestReassemblerCloseFlushesEvents(t *testing.T) {
 	var aucounter atomic.Int32
 
 	// create reassembler
-	reas, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+	reas, err := libaudit.NewReassembler(DefaultMaxEventsInFlight, DefaultEventTimeout, &reassemblerCB{
 		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
 			aucounter.Add(1)
 			return nil
@@ -483,7 +704,7 @@ func TestReassemblerCloseFlushesEvents(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		err := parseAuditLogs(ctx, lines, reas)
+		err := parseAuditLogs(ctx, lines, reas, nil, nil)
 		assert.ErrorIs(t, err, context.Canceled, "expected context to be cancelled")
 	}()
 
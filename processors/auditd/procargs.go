@@ -0,0 +1,217 @@
+package auditd
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+// maxProctitleLen is the maximum number of bytes the kernel includes
+// in a PROCTITLE record (MAX_PROCTITLE_AUDIT_LEN in kernel/audit.c).
+const maxProctitleLen = 128
+
+// annotateProcessArgs reconstructs the process arguments of an event
+// from its raw EXECVE records, falling back to the PROCTITLE record
+// when no EXECVE record exists.
+//
+// go-libaudit fails to parse EXECVE records containing arguments that
+// the kernel split into several fragments (e.g., "a1_len=9000 a1[0]=..."),
+// which results in the event having no arguments at all. This function
+// joins such fragments back together.
+//
+// The origin of the arguments and whether they are known to be
+// incomplete are stored in the event's Data map using the keys
+// defined by the sessiontracker package.
+func annotateProcessArgs(msgs []*auparse.AuditMessage, event *aucoalesce.Event) {
+	var execves []*auparse.AuditMessage
+	var proctitle *auparse.AuditMessage
+
+	for _, msg := range msgs {
+		switch msg.RecordType { //nolint:exhaustive // We only care about two types.
+		case auparse.AUDIT_EXECVE:
+			execves = append(execves, msg)
+		case auparse.AUDIT_PROCTITLE:
+			proctitle = msg
+		}
+	}
+
+	if event.Data == nil {
+		event.Data = make(map[string]string)
+	}
+
+	if len(execves) > 0 {
+		args, truncated := execveArgs(execves)
+		if len(args) > 0 {
+			event.Process.Args = args
+			event.Data[sessiontracker.ArgsSourceDataKey] = sessiontracker.ArgsSourceExecve
+		}
+
+		if truncated {
+			event.Data[sessiontracker.ArgsTruncatedDataKey] = "true"
+		}
+
+		if len(args) > 0 {
+			return
+		}
+	}
+
+	if proctitle == nil || len(event.Process.Args) > 0 {
+		return
+	}
+
+	args, truncated := proctitleArgs(proctitle)
+	if len(args) == 0 {
+		return
+	}
+
+	event.Process.Args = args
+	event.Data[sessiontracker.ArgsSourceDataKey] = sessiontracker.ArgsSourceProctitle
+
+	if truncated {
+		event.Data[sessiontracker.ArgsTruncatedDataKey] = "true"
+	}
+}
+
+// execveArgs returns the arguments found in one or more EXECVE records
+// belonging to the same event. The boolean is true if the arguments
+// are known to be incomplete (e.g., an argument is missing or one of
+// its fragments is shorter than its advertised length).
+func execveArgs(execves []*auparse.AuditMessage) ([]string, bool) {
+	fields := make(map[string]string)
+	for _, execve := range execves {
+		for k, v := range rawFields(execve) {
+			fields[k] = v
+		}
+	}
+
+	argc, err := strconv.Atoi(fields["argc"])
+	if err != nil || argc <= 0 {
+		return nil, false
+	}
+
+	var truncated bool
+	args := make([]string, 0, argc)
+
+	for i := 0; i < argc; i++ {
+		key := "a" + strconv.Itoa(i)
+
+		if v, hasIt := fields[key]; hasIt {
+			args = append(args, decodeArg(v))
+			continue
+		}
+
+		lenStr, hasLen := fields[key+"_len"]
+		if !hasLen {
+			// The remaining arguments were not logged.
+			truncated = true
+			break
+		}
+
+		var sb strings.Builder
+		for j := 0; ; j++ {
+			fragment, hasIt := fields[key+"["+strconv.Itoa(j)+"]"]
+			if !hasIt {
+				break
+			}
+
+			sb.WriteString(decodeArg(fragment))
+		}
+
+		// The kernel reports the length of the encoded argument,
+		// meaning hex-encoded arguments are twice as long.
+		expLen, err := strconv.Atoi(lenStr)
+		if err != nil || (sb.Len() != expLen && sb.Len()*2 != expLen) {
+			truncated = true
+		}
+
+		args = append(args, sb.String())
+	}
+
+	return args, truncated
+}
+
+// proctitleArgs returns the arguments found in a PROCTITLE record.
+// The boolean is true if the kernel may have truncated the record.
+func proctitleArgs(proctitle *auparse.AuditMessage) ([]string, bool) {
+	raw, hasIt := rawFields(proctitle)["proctitle"]
+	if !hasIt || raw == "" || raw == "(null)" {
+		return nil, false
+	}
+
+	var title []byte
+	if strings.HasPrefix(raw, `"`) {
+		title = []byte(strings.Trim(raw, `"`))
+	} else {
+		var err error
+		title, err = hex.DecodeString(raw)
+		if err != nil {
+			return []string{raw}, false
+		}
+	}
+
+	truncated := len(title) >= maxProctitleLen
+
+	var args []string
+	for _, arg := range strings.Split(string(title), "\x00") {
+		if arg != "" {
+			args = append(args, arg)
+		}
+	}
+
+	return args, truncated
+}
+
+// decodeArg decodes an EXECVE argument value. The kernel logs arguments
+// as quoted strings unless they contain special characters, in which
+// case they are hex-encoded.
+func decodeArg(v string) string {
+	if strings.HasPrefix(v, `"`) {
+		return strings.Trim(v, `"`)
+	}
+
+	if v == "(null)" {
+		return ""
+	}
+
+	decoded, err := hex.DecodeString(v)
+	if err != nil {
+		return v
+	}
+
+	return string(decoded)
+}
+
+// rawFields splits the raw data of an audit message into key-value
+// pairs without modifying the values (quotes are preserved). This
+// allows us to handle records that go-libaudit fails to parse.
+func rawFields(msg *auparse.AuditMessage) map[string]string {
+	raw := msg.RawData
+
+	headerEnd := strings.Index(raw, "):")
+	if headerEnd > -1 {
+		raw = raw[headerEnd+2:]
+	}
+
+	// Enriched audit logs append resolved values after
+	// a group separator character.
+	if i := strings.IndexByte(raw, 0x1d); i > -1 {
+		raw = raw[:i]
+	}
+
+	fields := make(map[string]string)
+	for _, token := range strings.Fields(raw) {
+		k, v, found := strings.Cut(token, "=")
+		if !found {
+			continue
+		}
+
+		fields[k] = v
+	}
+
+	return fields
+}
@@ -0,0 +1,141 @@
+package auditd
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+)
+
+func TestAnnotateProcessArgs_Execve(t *testing.T) {
+	t.Parallel()
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=59 success=yes exit=0 ses=499`,
+		`type=EXECVE msg=audit(1668460935.049:30362): argc=2 a0="cat" a1="/etc/resolv.conf"`,
+		`type=PROCTITLE msg=audit(1668460935.049:30362): proctitle=636174002F6574632F7265736F6C762E636F6E66`)
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"cat", "/etc/resolv.conf"}, event.Process.Args)
+	assert.Equal(t, sessiontracker.ArgsSourceExecve, event.Data[sessiontracker.ArgsSourceDataKey])
+	assert.NotContains(t, event.Data, sessiontracker.ArgsTruncatedDataKey)
+}
+
+func TestAnnotateProcessArgs_ExecveFragments(t *testing.T) {
+	t.Parallel()
+
+	longArg := strings.Repeat("A", 40) + " " + strings.Repeat("B", 40)
+	encoded := strings.ToUpper(hex.EncodeToString([]byte(longArg)))
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=59 success=yes exit=0 ses=499`,
+		`type=EXECVE msg=audit(1668460935.049:30362): argc=3 a0="bash" a1="-c" a2_len=162 a2[0]=`+encoded[:80],
+		`type=EXECVE msg=audit(1668460935.049:30362): a2[1]=`+encoded[80:])
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"bash", "-c", longArg}, event.Process.Args)
+	assert.Equal(t, sessiontracker.ArgsSourceExecve, event.Data[sessiontracker.ArgsSourceDataKey])
+	assert.NotContains(t, event.Data, sessiontracker.ArgsTruncatedDataKey)
+}
+
+func TestAnnotateProcessArgs_ExecveMissingFragment(t *testing.T) {
+	t.Parallel()
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=59 success=yes exit=0 ses=499`,
+		`type=EXECVE msg=audit(1668460935.049:30362): argc=2 a0="echo" a1_len=10000 a1[0]="foo"`)
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"echo", "foo"}, event.Process.Args)
+	assert.Equal(t, "true", event.Data[sessiontracker.ArgsTruncatedDataKey])
+}
+
+func TestAnnotateProcessArgs_ExecveMissingArgs(t *testing.T) {
+	t.Parallel()
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=59 success=yes exit=0 ses=499`,
+		`type=EXECVE msg=audit(1668460935.049:30362): argc=3 a0="echo" a1="foo"`)
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"echo", "foo"}, event.Process.Args)
+	assert.Equal(t, "true", event.Data[sessiontracker.ArgsTruncatedDataKey])
+}
+
+func TestAnnotateProcessArgs_ProctitleFallback(t *testing.T) {
+	t.Parallel()
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=257 success=yes exit=3 ses=499`,
+		`type=PROCTITLE msg=audit(1668460935.049:30362): proctitle=636174002F6574632F7265736F6C762E636F6E66`)
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"cat", "/etc/resolv.conf"}, event.Process.Args)
+	assert.Equal(t, sessiontracker.ArgsSourceProctitle, event.Data[sessiontracker.ArgsSourceDataKey])
+	assert.NotContains(t, event.Data, sessiontracker.ArgsTruncatedDataKey)
+}
+
+func TestAnnotateProcessArgs_ProctitleTruncated(t *testing.T) {
+	t.Parallel()
+
+	title := "python3\x00" + strings.Repeat("x", maxProctitleLen-len("python3\x00"))
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=257 success=yes exit=3 ses=499`,
+		`type=PROCTITLE msg=audit(1668460935.049:30362): proctitle=`+
+			strings.ToUpper(hex.EncodeToString([]byte(title))))
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	require.Len(t, event.Process.Args, 2)
+	assert.Equal(t, "python3", event.Process.Args[0])
+	assert.Equal(t, "true", event.Data[sessiontracker.ArgsTruncatedDataKey])
+}
+
+func TestAnnotateProcessArgs_ProctitleQuoted(t *testing.T) {
+	t.Parallel()
+
+	msgs := parseAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=257 success=yes exit=3 ses=499`,
+		`type=PROCTITLE msg=audit(1668460935.049:30362): proctitle="bash"`)
+
+	event := coalesceAndAnnotate(t, msgs)
+
+	assert.Equal(t, []string{"bash"}, event.Process.Args)
+}
+
+func parseAuditLines(t *testing.T, lines ...string) []*auparse.AuditMessage {
+	t.Helper()
+
+	msgs := make([]*auparse.AuditMessage, len(lines))
+	for i, line := range lines {
+		msg, err := auparse.ParseLogLine(line)
+		require.NoError(t, err)
+
+		msgs[i] = msg
+	}
+
+	return msgs
+}
+
+func coalesceAndAnnotate(t *testing.T, msgs []*auparse.AuditMessage) *aucoalesce.Event {
+	t.Helper()
+
+	event, err := aucoalesce.CoalesceMessages(msgs)
+	require.NoError(t, err)
+
+	annotateProcessArgs(msgs, event)
+
+	return event
+}
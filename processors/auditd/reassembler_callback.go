@@ -8,6 +8,7 @@ import (
 	"github.com/elastic/go-libaudit/v2/aucoalesce"
 	"github.com/elastic/go-libaudit/v2/auparse"
 
+	"github.com/metal-toolbox/audito-maldito/internal/health"
 	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
 )
 
@@ -18,6 +19,12 @@ type reassemblerCB struct {
 	au     sessiontracker.Auditor
 	errors chan<- error
 	after  time.Time
+
+	// heartbeat optionally records the progress of au.
+	heartbeat *health.Heartbeat
+
+	// onLost is optionally called when events are lost.
+	onLost func(count int)
 }
 
 func (s *reassemblerCB) ReassemblyComplete(msgs []*auparse.AuditMessage) {
@@ -40,7 +47,13 @@ func (s *reassemblerCB) ReassemblyComplete(msgs []*auparse.AuditMessage) {
 
 	aucoalesce.ResolveIDs(event)
 
-	if err := s.au.AuditdEvent(event); err != nil {
+	annotateProcessArgs(msgs, event)
+
+	s.heartbeat.Start()
+	err = s.au.AuditdEvent(event)
+	s.heartbeat.Done()
+
+	if err != nil {
 		select {
 		case s.errors <- &reassemblerCBError{
 			message: fmt.Sprintf("failed to audit audit event - %s", err),
@@ -53,4 +66,8 @@ func (s *reassemblerCB) ReassemblyComplete(msgs []*auparse.AuditMessage) {
 
 func (s *reassemblerCB) EventsLost(count int) {
 	logger.Errorf("lost %d auditd events during reassembly", count)
+
+	if s.onLost != nil {
+		s.onLost(count)
+	}
 }
@@ -0,0 +1,86 @@
+package auditd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+// TestAuditd_Read_ConformsToSchemas checks that the events written
+// for the good audit logs conform to the schema of their type (refer
+// to the schemas package), whether or not they are correlated with
+// a login.
+func TestAuditd_Read_ConformsToSchemas(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		correlated bool
+	}{
+		{
+			name:       "Correlated",
+			correlated: true,
+		},
+		{
+			name: "Uncorrelated",
+		},
+	} {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancelFn()
+
+			events := make(chan *auditevent.AuditEvent, goodAuditdMaxResultingEvents)
+
+			a := Auditd{
+				Audits: closedTestLogLines(goodAuditd00, goodAuditd01, goodAuditd02,
+					goodAuditd03, goodAuditd04, goodAuditd05),
+				EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+					Ctx:    ctx,
+					Events: events,
+					T:      t,
+				}),
+				Health: health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+				Target: map[string]string{"host": "test"},
+			}
+
+			errs := make(chan error, 1)
+
+			if tt.correlated {
+				logins := make(chan common.RemoteUserLogin)
+				a.Logins = logins
+
+				go func() {
+					errs <- a.Read(ctx)
+				}()
+
+				logins <- newSshdJournaldAuditEvent("user", goodAuditdSshdPid)
+				close(logins)
+			} else {
+				go func() {
+					errs <- a.Read(ctx)
+				}()
+			}
+
+			require.NoError(t, <-errs)
+			require.NotEmpty(t, events)
+
+			for len(events) > 0 {
+				event := <-events
+				assert.NoError(t, schemas.ValidateEvent(event), "%s event", event.Type)
+			}
+		})
+	}
+}
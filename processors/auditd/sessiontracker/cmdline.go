@@ -0,0 +1,74 @@
+package sessiontracker
+
+import (
+	"strings"
+)
+
+// The following keys may be set in an aucoalesce.Event's Data map by
+// the code that reassembles audit events. They describe the event's
+// process arguments (i.e., aucoalesce.Process.Args).
+const (
+	// ArgsSourceDataKey is set to the name of the audit record the
+	// process arguments were sourced from (refer to ArgsSourceExecve
+	// and ArgsSourceProctitle).
+	ArgsSourceDataKey = "audito_maldito_args_source"
+
+	// ArgsTruncatedDataKey is set to "true" if the process
+	// arguments are known to be incomplete.
+	ArgsTruncatedDataKey = "audito_maldito_args_truncated"
+)
+
+const (
+	// ArgsSourceExecve indicates the process arguments were
+	// reconstructed from one or more EXECVE records.
+	ArgsSourceExecve = "execve"
+
+	// ArgsSourceProctitle indicates the process arguments were
+	// decoded from a PROCTITLE record.
+	ArgsSourceProctitle = "proctitle"
+)
+
+// commandLine joins args into a single string that can be pasted into
+// a POSIX shell. Arguments containing characters that have a special
+// meaning to the shell are single-quoted.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote single-quotes s if it contains characters that are not
+// considered safe in an unquoted POSIX shell word.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune("@%+=:,./-_", r):
+		return true
+	default:
+		return false
+	}
+}
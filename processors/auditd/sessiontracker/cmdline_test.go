@@ -0,0 +1,53 @@
+package sessiontracker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+		exp  string
+	}{
+		{
+			name: "SafeArgs",
+			args: []string{"cat", "/etc/resolv.conf"},
+			exp:  "cat /etc/resolv.conf",
+		},
+		{
+			name: "Whitespace",
+			args: []string{"bash", "-c", "echo hello world"},
+			exp:  "bash -c 'echo hello world'",
+		},
+		{
+			name: "SingleQuote",
+			args: []string{"echo", "it's"},
+			exp:  `echo 'it'"'"'s'`,
+		},
+		{
+			name: "EmptyArg",
+			args: []string{"printf", ""},
+			exp:  "printf ''",
+		},
+		{
+			name: "NoArgs",
+			args: nil,
+			exp:  "",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.exp, commandLine(test.args))
+		})
+	}
+}
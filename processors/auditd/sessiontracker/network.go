@@ -0,0 +1,93 @@
+package sessiontracker
+
+import (
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// Network directions reported in NetworkActivity.Direction.
+const (
+	// NetworkDirectionEgress indicates the process initiated
+	// a connection to (or sent data to) the address.
+	NetworkDirectionEgress = "egress"
+
+	// NetworkDirectionIngress indicates the process accepted
+	// a connection from (or received data from) the address.
+	NetworkDirectionIngress = "ingress"
+
+	// NetworkDirectionLocal indicates the address is a local
+	// address the process bound a socket to.
+	NetworkDirectionLocal = "local"
+)
+
+// NetworkActivity describes the socket address found in an audit
+// event's SOCKADDR record.
+type NetworkActivity struct {
+	// Family is the address family (e.g., "ipv4", "ipv6" or "unix").
+	Family string `json:"family"`
+
+	// Address is the IP address. It is empty for unix sockets.
+	Address string `json:"address,omitempty"`
+
+	// Port is the port number. It is empty for unix sockets.
+	Port string `json:"port,omitempty"`
+
+	// Path is the unix socket path. It is empty for IP sockets.
+	Path string `json:"path,omitempty"`
+
+	// Direction is one of the NetworkDirection constants.
+	Direction string `json:"direction"`
+
+	// Syscall is the name of the syscall that produced the event.
+	Syscall string `json:"syscall"`
+}
+
+// networkActivity returns the NetworkActivity of an audit event.
+// The boolean is false if the event does not describe network
+// activity that we know how to interpret.
+func networkActivity(ae *aucoalesce.Event) (NetworkActivity, bool) {
+	family := ae.Data["socket_family"]
+	switch family {
+	case "ipv4", "ipv6", "unix":
+	default:
+		return NetworkActivity{}, false
+	}
+
+	syscall := ae.Data["syscall"]
+
+	var direction string
+	switch syscall {
+	case "connect", "sendto", "sendmsg":
+		direction = NetworkDirectionEgress
+	case "accept", "accept4", "recvfrom", "recvmsg":
+		direction = NetworkDirectionIngress
+	case "bind":
+		direction = NetworkDirectionLocal
+	default:
+		return NetworkActivity{}, false
+	}
+
+	return NetworkActivity{
+		Family:    family,
+		Address:   ae.Data["socket_addr"],
+		Port:      ae.Data["socket_port"],
+		Path:      ae.Data["socket_path"],
+		Direction: direction,
+		Syscall:   syscall,
+	}, true
+}
+
+// addNetworkDetails sets the network activity of the coalesced event
+// in the audit event's metadata. Events that describe network activity
+// use the common.ActionUserNetworkActivity event type.
+func addNetworkDetails(ae *aucoalesce.Event, evt *auditevent.AuditEvent) {
+	activity, isNetwork := networkActivity(ae)
+	if !isNetwork {
+		return
+	}
+
+	evt.Type = common.ActionUserNetworkActivity
+	evt.Metadata.Extra["network"] = activity
+}
@@ -0,0 +1,135 @@
+package sessiontracker
+
+import (
+	"testing"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+func TestUser_ToAuditEvent_Connect(t *testing.T) {
+	t.Parallel()
+
+	ae := coalesceAuditLines(t,
+		`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=42 success=yes exit=0 `+
+			`a0=3 a1=7ffd a2=10 a3=0 items=0 ppid=1 pid=2 auid=1000 uid=1000 gid=1000 euid=1000 suid=1000 `+
+			`fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts0 ses=499 comm="psql" exe="/usr/bin/psql" key="egress"`,
+		`type=SOCKADDR msg=audit(1668460935.049:30362): saddr=020015380A0000050000000000000000`)
+
+	u := user{
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	}
+
+	event := u.toAuditEvent(ae)
+
+	assert.Equal(t, common.ActionUserNetworkActivity, event.Type)
+	assert.Equal(t, NetworkActivity{
+		Family:    "ipv4",
+		Address:   "10.0.0.5",
+		Port:      "5432",
+		Direction: NetworkDirectionEgress,
+		Syscall:   "connect",
+	}, event.Metadata.Extra["network"])
+}
+
+func TestNetworkActivity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    map[string]string
+		exp     NetworkActivity
+		isValid bool
+	}{
+		{
+			name: "Accept",
+			data: map[string]string{
+				"syscall":       "accept4",
+				"socket_family": "ipv6",
+				"socket_addr":   "::1",
+				"socket_port":   "22",
+			},
+			exp: NetworkActivity{
+				Family:    "ipv6",
+				Address:   "::1",
+				Port:      "22",
+				Direction: NetworkDirectionIngress,
+				Syscall:   "accept4",
+			},
+			isValid: true,
+		},
+		{
+			name: "BindUnixSocket",
+			data: map[string]string{
+				"syscall":       "bind",
+				"socket_family": "unix",
+				"socket_path":   "/tmp/foo.sock",
+			},
+			exp: NetworkActivity{
+				Family:    "unix",
+				Path:      "/tmp/foo.sock",
+				Direction: NetworkDirectionLocal,
+				Syscall:   "bind",
+			},
+			isValid: true,
+		},
+		{
+			name: "Netlink",
+			data: map[string]string{
+				"syscall":       "sendto",
+				"socket_family": "netlink",
+			},
+		},
+		{
+			name: "UnknownSyscall",
+			data: map[string]string{
+				"syscall":       "getsockname",
+				"socket_family": "ipv4",
+			},
+		},
+		{
+			name: "NoSockaddr",
+			data: map[string]string{
+				"syscall": "execve",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			activity, isValid := networkActivity(&aucoalesce.Event{Data: test.data})
+			assert.Equal(t, test.isValid, isValid)
+			assert.Equal(t, test.exp, activity)
+		})
+	}
+}
+
+func coalesceAuditLines(t *testing.T, lines ...string) *aucoalesce.Event {
+	t.Helper()
+
+	msgs := make([]*auparse.AuditMessage, len(lines))
+	for i, line := range lines {
+		msg, err := auparse.ParseLogLine(line)
+		require.NoError(t, err)
+
+		msgs[i] = msg
+	}
+
+	event, err := aucoalesce.CoalesceMessages(msgs)
+	require.NoError(t, err)
+
+	return event
+}
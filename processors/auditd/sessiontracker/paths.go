@@ -0,0 +1,85 @@
+package sessiontracker
+
+import (
+	"path/filepath"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+)
+
+// PathItem describes a file referenced by an audit event. Each PathItem
+// corresponds to one of the event's PATH records.
+type PathItem struct {
+	// Name is the file path as it was passed to the kernel.
+	Name string `json:"name"`
+
+	// Resolved is the absolute file path. Relative names are
+	// resolved against the working directory of the process.
+	Resolved string `json:"resolved,omitempty"`
+
+	// NameType is the kind of PATH record (e.g., "NORMAL",
+	// "PARENT", "CREATE" or "DELETE").
+	NameType string `json:"nametype,omitempty"`
+
+	// Inode is the file's inode number.
+	Inode string `json:"inode,omitempty"`
+
+	// Mode is the file's type and permissions in octal.
+	Mode string `json:"mode,omitempty"`
+
+	// OUID is the user ID of the file's owner.
+	OUID string `json:"ouid,omitempty"`
+}
+
+// pathItems converts the PATH records of an audit event into PathItem.
+// Relative paths are resolved against cwd if it is an absolute path.
+func pathItems(paths []map[string]string, cwd string) []PathItem {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	items := make([]PathItem, 0, len(paths))
+
+	for _, p := range paths {
+		item := PathItem{
+			Name:     p["name"],
+			NameType: p["nametype"],
+			Inode:    p["inode"],
+			Mode:     p["mode"],
+			OUID:     p["ouid"],
+		}
+
+		item.Resolved = resolvePath(item.Name, cwd)
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// resolvePath returns name as an absolute, cleaned path. An empty
+// string is returned if name cannot be resolved.
+func resolvePath(name string, cwd string) string {
+	switch {
+	case name == "" || name == "(null)":
+		return ""
+	case filepath.IsAbs(name):
+		return filepath.Clean(name)
+	case filepath.IsAbs(cwd):
+		return filepath.Join(cwd, name)
+	default:
+		return ""
+	}
+}
+
+// addFileDetails sets the working directory and the PATH records of
+// the coalesced event in the audit event's metadata.
+func addFileDetails(ae *aucoalesce.Event, evt *auditevent.AuditEvent) {
+	if ae.Process.CWD != "" {
+		evt.Metadata.Extra["cwd"] = ae.Process.CWD
+	}
+
+	if items := pathItems(ae.Paths, ae.Process.CWD); len(items) > 0 {
+		evt.Metadata.Extra["paths"] = items
+	}
+}
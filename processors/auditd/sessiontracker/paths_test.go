@@ -0,0 +1,115 @@
+package sessiontracker
+
+import (
+	"testing"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathItems(t *testing.T) {
+	t.Parallel()
+
+	items := pathItems([]map[string]string{
+		{
+			"item":     "0",
+			"name":     "/usr/bin/cat",
+			"inode":    "1442317",
+			"mode":     "0100755",
+			"ouid":     "0",
+			"nametype": "NORMAL",
+		},
+		{
+			"item":     "1",
+			"name":     "../etc/./shadow",
+			"inode":    "1448144",
+			"mode":     "0100640",
+			"ouid":     "0",
+			"nametype": "NORMAL",
+		},
+		{
+			"item":     "2",
+			"name":     "(null)",
+			"nametype": "PARENT",
+		},
+	}, "/home")
+
+	assert.Equal(t, []PathItem{
+		{
+			Name:     "/usr/bin/cat",
+			Resolved: "/usr/bin/cat",
+			NameType: "NORMAL",
+			Inode:    "1442317",
+			Mode:     "0100755",
+			OUID:     "0",
+		},
+		{
+			Name:     "../etc/./shadow",
+			Resolved: "/etc/shadow",
+			NameType: "NORMAL",
+			Inode:    "1448144",
+			Mode:     "0100640",
+			OUID:     "0",
+		},
+		{
+			Name:     "(null)",
+			NameType: "PARENT",
+		},
+	}, items)
+}
+
+func TestPathItems_NoPaths(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, pathItems(nil, "/"))
+}
+
+func TestResolvePath(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "/etc/passwd", resolvePath("/etc//passwd", "/home/foo"))
+	assert.Equal(t, "/home/foo/.ssh/authorized_keys", resolvePath(".ssh/authorized_keys", "/home/foo"))
+	assert.Equal(t, "", resolvePath("relative", ""))
+	assert.Equal(t, "", resolvePath("", "/home/foo"))
+}
+
+func TestAddFileDetails(t *testing.T) {
+	t.Parallel()
+
+	evt := &auditevent.AuditEvent{
+		Metadata: auditevent.EventMetadata{
+			Extra: map[string]any{},
+		},
+	}
+
+	addFileDetails(&aucoalesce.Event{
+		Process: aucoalesce.Process{
+			CWD: "/root",
+		},
+		Paths: []map[string]string{
+			{
+				"name":     "secret.txt",
+				"nametype": "NORMAL",
+			},
+		},
+	}, evt)
+
+	assert.Equal(t, "/root", evt.Metadata.Extra["cwd"])
+	assert.Equal(t, []PathItem{
+		{
+			Name:     "secret.txt",
+			Resolved: "/root/secret.txt",
+			NameType: "NORMAL",
+		},
+	}, evt.Metadata.Extra["paths"])
+
+	empty := &auditevent.AuditEvent{
+		Metadata: auditevent.EventMetadata{
+			Extra: map[string]any{},
+		},
+	}
+
+	addFileDetails(&aucoalesce.Event{}, empty)
+	assert.Empty(t, empty.Metadata.Extra)
+}
@@ -0,0 +1,52 @@
+package sessiontracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// RuleKeyClass describes how audit events tagged with a particular
+// audit rule key (i.e., the "-k" argument in audit.rules) should be
+// classified in the resulting audit event.
+type RuleKeyClass struct {
+	// Severity is an arbitrary severity string (e.g., "high").
+	Severity string `json:"severity"`
+
+	// Category is an arbitrary category string (e.g., "identity").
+	Category string `json:"category"`
+}
+
+// RuleKeyClasses maps audit rule keys to their RuleKeyClass.
+type RuleKeyClasses map[string]RuleKeyClass
+
+// ParseRuleKeyClasses parses a JSON object that maps audit rule keys
+// to their corresponding RuleKeyClass. For example:
+//
+//	{
+//	  "identity": {"severity": "high", "category": "identity"},
+//	  "privileged": {"severity": "medium", "category": "privilege-escalation"}
+//	}
+func ParseRuleKeyClasses(r io.Reader) (RuleKeyClasses, error) {
+	var classes RuleKeyClasses
+
+	err := json.NewDecoder(r).Decode(&classes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audit rule key classes - %w", err)
+	}
+
+	return classes, nil
+}
+
+// classify returns the RuleKeyClass of the first key in keys that has
+// an entry in the map. The boolean is false if none of the keys match.
+func (o RuleKeyClasses) classify(keys []string) (RuleKeyClass, bool) {
+	for _, key := range keys {
+		class, hasIt := o[key]
+		if hasIt {
+			return class, true
+		}
+	}
+
+	return RuleKeyClass{}, false
+}
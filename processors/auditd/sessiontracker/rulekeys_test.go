@@ -0,0 +1,62 @@
+package sessiontracker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRuleKeyClasses(t *testing.T) {
+	t.Parallel()
+
+	classes, err := ParseRuleKeyClasses(strings.NewReader(`{
+		"identity": {"severity": "high", "category": "identity"},
+		"privileged": {"severity": "medium", "category": "privilege-escalation"}
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, RuleKeyClasses{
+		"identity": {
+			Severity: "high",
+			Category: "identity",
+		},
+		"privileged": {
+			Severity: "medium",
+			Category: "privilege-escalation",
+		},
+	}, classes)
+}
+
+func TestParseRuleKeyClasses_BadJSON(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseRuleKeyClasses(strings.NewReader(`["identity"]`))
+	assert.Error(t, err)
+}
+
+func TestRuleKeyClasses_Classify(t *testing.T) {
+	t.Parallel()
+
+	classes := RuleKeyClasses{
+		"identity": {
+			Severity: "high",
+			Category: "identity",
+		},
+		"privileged": {
+			Severity: "medium",
+			Category: "privilege-escalation",
+		},
+	}
+
+	class, hasIt := classes.classify([]string{"foo", "privileged", "identity"})
+	require.True(t, hasIt)
+	assert.Equal(t, "privilege-escalation", class.Category)
+
+	_, hasIt = classes.classify([]string{"foo"})
+	assert.False(t, hasIt)
+
+	_, hasIt = RuleKeyClasses(nil).classify([]string{"identity"})
+	assert.False(t, hasIt)
+}
@@ -0,0 +1,133 @@
+package sessiontracker
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+// TestSessionTracker_ConformsToSchemas checks that the events written
+// for correlated and uncorrelated sessions (user actions, network
+// activity and transcripts) conform to the schema of their type
+// (refer to the schemas package).
+func TestSessionTracker_ConformsToSchemas(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 20)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled:     true,
+		RedactAfter: []string{"su"},
+	}).WithTarget(map[string]string{
+		"host":       "localhost",
+		"machine-id": "foobar",
+	})
+
+	// The session of sshd's pid 999 is correlated with
+	// a login, while the session of pid 1000 is not.
+	require.NoError(t, st.RemoteLogin(newSchemaTestLogin(999)))
+
+	for _, session := range []struct {
+		id  string
+		pid int
+	}{
+		{id: "499", pid: 999},
+		{id: "500", pid: 1000},
+	} {
+		pid := strconv.Itoa(session.pid)
+
+		require.NoError(t, st.AuditdEvent(coalesceAuditLines(t,
+			`type=LOGIN msg=audit(1668460935.001:30360): pid=`+pid+` uid=0 subj=unconfined `+
+				`old-auid=4294967295 auid=1000 tty=(none) old-ses=4294967295 ses=`+session.id+` res=1`)))
+
+		require.NoError(t, st.AuditdEvent(coalesceAuditLines(t,
+			`type=SYSCALL msg=audit(1668460935.002:30361): arch=c000003e syscall=59 success=yes exit=0 `+
+				`a0=55d1 a1=55d2 a2=55d3 a3=0 items=2 ppid=`+pid+` pid=2 auid=1000 uid=1000 gid=1000 euid=1000 `+
+				`suid=1000 fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts0 ses=`+session.id+` comm="ls" `+
+				`exe="/usr/bin/ls" key="exec"`,
+			`type=EXECVE msg=audit(1668460935.002:30361): argc=2 a0="ls" a1="/root"`)))
+
+		require.NoError(t, st.AuditdEvent(coalesceAuditLines(t,
+			`type=SYSCALL msg=audit(1668460935.049:30362): arch=c000003e syscall=42 success=yes exit=0 `+
+				`a0=3 a1=7ffd a2=10 a3=0 items=0 ppid=1 pid=2 auid=1000 uid=1000 gid=1000 euid=1000 suid=1000 `+
+				`fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts0 ses=`+session.id+` comm="psql" `+
+				`exe="/usr/bin/psql" key="egress"`,
+			`type=SOCKADDR msg=audit(1668460935.049:30362): saddr=020015380A0000050000000000000000`)))
+
+		ttyEvent := newTTYEvent("su -\rhunter2\rid\r")
+		ttyEvent.Session = session.id
+		require.NoError(t, st.AuditdEvent(ttyEvent))
+
+		require.NoError(t, st.AuditdEvent(coalesceAuditLines(t,
+			`type=CRED_DISP msg=audit(1668460936.000:30363): pid=`+pid+` uid=0 auid=1000 ses=`+session.id+` `+
+				`subj=unconfined msg='op=PAM:setcred grantors=pam_unix acct="user" exe="/usr/sbin/sshd" `+
+				`hostname=127.0.0.1 addr=127.0.0.1 terminal=ssh res=success'`)))
+	}
+
+	require.NoError(t, st.Flush())
+
+	types := make(map[string]int)
+
+	for len(events) > 0 {
+		event := <-events
+		types[event.Type]++
+
+		assert.NoError(t, schemas.ValidateEvent(event), "%s event of session %s",
+			event.Type, event.Metadata.AuditID)
+	}
+
+	assert.Equal(t, map[string]int{
+		common.ActionUserAction:          6,
+		common.ActionUserNetworkActivity: 2,
+		common.ActionSessionTranscript:   2,
+	}, types)
+}
+
+// newSchemaTestLogin returns a login like the ones
+// written by the sshd processor.
+func newSchemaTestLogin(pid int) common.RemoteUserLogin {
+	event := auditevent.NewAuditEvent(
+		common.ActionLoginIdentifier,
+		auditevent.EventSource{
+			Type:  "IP",
+			Value: "127.0.0.1",
+			Extra: map[string]any{
+				"port": "666",
+			},
+		},
+		auditevent.OutcomeSucceeded,
+		map[string]string{
+			"userID":   "foo@bar.com",
+			"loggedAs": "user",
+			"pid":      strconv.Itoa(pid),
+		},
+		"sshd",
+	).WithTarget(map[string]string{
+		"host":       "localhost",
+		"machine-id": "foobar",
+	})
+
+	event.LoggedAt = time.Now()
+
+	return common.RemoteUserLogin{
+		Source:     event,
+		PID:        pid,
+		CredUserID: "foo@bar.com",
+	}
+}
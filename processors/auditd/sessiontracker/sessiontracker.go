@@ -11,13 +11,15 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/sinks"
 )
 
 // Implement Auditor interface.
 var _ Auditor = &sessionTracker{}
 
 // NewSessionTracker returns a new instance of a sessionTracker.
-func NewSessionTracker(eventWriter *auditevent.EventWriter, l *zap.SugaredLogger) *sessionTracker {
+func NewSessionTracker(eventWriter sinks.EventSink, l *zap.SugaredLogger) *sessionTracker {
 	if l == nil {
 		l = zap.NewNop().Sugar()
 	}
@@ -51,14 +53,63 @@ type sessionTracker struct {
 	// associated with the remote login.
 	pidsToRULs *common.GenericSyncMap[int, common.RemoteUserLogin]
 
-	// eventWriter is the auditevent.EventWriter to write
+	// eventWriter is the sinks.EventSink to write
 	// the resulting audit event to.
-	eventWriter *auditevent.EventWriter
+	eventWriter sinks.EventSink
+
+	// ruleKeys optionally classifies audit events according
+	// to the audit rule keys they were tagged with.
+	ruleKeys RuleKeyClasses
+
+	// transcripts configures the collection of TTY input
+	// into per-session transcripts.
+	transcripts TranscriptConfig
+
+	// metrics optionally counts correlations.
+	metrics *metrics.PrometheusMetricsProvider
+
+	// target is the target of the events that are not
+	// attributed to a remote user login (refer to unboundLogin).
+	target map[string]string
 
 	// l is the logger to use.
 	l *zap.SugaredLogger
 }
 
+// WithRuleKeyClasses sets the RuleKeyClasses used to classify audit
+// events by their audit rule keys. It returns the sessionTracker
+// for ease of use as a builder.
+func (o *sessionTracker) WithRuleKeyClasses(classes RuleKeyClasses) *sessionTracker {
+	o.ruleKeys = classes
+	return o
+}
+
+// WithTranscripts sets the TranscriptConfig used to collect TTY input
+// into per-session transcripts. It returns the sessionTracker for ease
+// of use as a builder.
+func (o *sessionTracker) WithTranscripts(config TranscriptConfig) *sessionTracker {
+	o.transcripts = config
+	return o
+}
+
+// WithMetrics sets the metrics provider used to count the audit sessions
+// that are bound to remote user logins and the data deleted without
+// being correlated. It returns the sessionTracker for ease of use as
+// a builder.
+func (o *sessionTracker) WithMetrics(m *metrics.PrometheusMetricsProvider) *sessionTracker {
+	o.metrics = m
+	return o
+}
+
+// WithTarget sets the target (e.g., the host name) of the events
+// that are not attributed to a remote user login, such as those
+// written by Flush. It returns the sessionTracker for ease of use
+// as a builder.
+func (o *sessionTracker) WithTarget(target map[string]string) *sessionTracker {
+	o.target = target
+	return o
+}
+
 // RemoteLogin validates and checks if there is an auditd session already present for the
 // RemoteLogin passed as parameter. It modifies the user object by setting the remote login information.
 func (o *sessionTracker) RemoteLogin(rul common.RemoteUserLogin) error {
@@ -94,6 +145,7 @@ func (o *sessionTracker) RemoteLogin(rul common.RemoteUserLogin) error {
 			// We modify the user object in-place, in this section
 			// since it's thread-safe (i.e., it's a pointer).
 			u.setRemoteUserLoginInfo(rul)
+			o.metrics.IncCorrelations()
 
 			found = true
 			writeErr = u.writeAndClearCache(o.eventWriter)
@@ -171,6 +223,14 @@ func (o *sessionTracker) auditEventWithSession(event *aucoalesce.Event, debugLog
 			"hasRUL", u.hasRemoteUserLoginInfo()).
 			Debugln("found existing audit session for audit event")
 
+		if o.transcripts.Enabled && isTTYEvent(event) {
+			debugLogger.Debugln("adding tty input to session transcript")
+
+			o.recordTTYInput(u, event)
+
+			return nil
+		}
+
 		if !u.hasRemoteUserLoginInfo() {
 			debugLogger.Debugln("caching audit event")
 
@@ -178,6 +238,13 @@ func (o *sessionTracker) auditEventWithSession(event *aucoalesce.Event, debugLog
 			// any associated common.RemoteUserLogin object.
 			u.cached = append(u.cached, event)
 
+			// The session ended before it was bound to a login,
+			// its transcript is written with the identity that
+			// is known rather than kept until it expires.
+			if event.Type == auparse.AUDIT_CRED_DISP {
+				return o.writeTranscript(event.Session, u, true)
+			}
+
 			return nil
 		}
 
@@ -207,6 +274,10 @@ func (o *sessionTracker) auditEventWithSession(event *aucoalesce.Event, debugLog
 			}
 		}
 
+		if event.Type == auparse.AUDIT_CRED_DISP {
+			return o.writeTranscript(event.Session, u, true)
+		}
+
 		return nil
 	})
 }
@@ -241,8 +312,9 @@ func (o *sessionTracker) auditEventWithoutSession(event *aucoalesce.Event, debug
 	}
 
 	u := &user{
-		added:  time.Now(),
-		srcPID: srcPID,
+		added:    time.Now(),
+		srcPID:   srcPID,
+		ruleKeys: o.ruleKeys,
 	}
 
 	if o.pidsToRULs.Has(srcPID) {
@@ -252,6 +324,7 @@ func (o *sessionTracker) auditEventWithoutSession(event *aucoalesce.Event, debug
 			o.pidsToRULs.DeleteUnsafe(srcPID)
 
 			u.setRemoteUserLoginInfo(rul)
+			o.metrics.IncCorrelations()
 
 			o.sessIDsToUsers.Store(event.Session, u)
 
@@ -289,8 +362,15 @@ func (o *sessionTracker) DeleteUsersWithoutLoginsBefore(t time.Time) {
 			"before", t.String())
 	}
 
+	var expired int
+	defer func() {
+		o.metrics.AddExpiredCorrelations(metrics.ExpiredAuditSession, expired)
+	}()
+
 	o.sessIDsToUsers.Iterate(func(id string, u *user) bool {
 		if !u.hasRUL && u.added.Before(t) {
+			expired++
+
 			if debugLogger != nil {
 				debugLogger.With(
 					"auditSessionID", id,
@@ -318,8 +398,15 @@ func (o *sessionTracker) DeleteRemoteUserLoginsBefore(t time.Time) {
 			"before", t.String())
 	}
 
+	var expired int
+	defer func() {
+		o.metrics.AddExpiredCorrelations(metrics.ExpiredRemoteLogin, expired)
+	}()
+
 	o.pidsToRULs.Iterate(func(pid int, userLogin common.RemoteUserLogin) bool {
 		if userLogin.Source.LoggedAt.Before(t) {
+			expired++
+
 			if debugLogger != nil {
 				debugLogger.With(
 					"pid", pid,
@@ -333,12 +420,97 @@ func (o *sessionTracker) DeleteRemoteUserLoginsBefore(t time.Time) {
 	})
 }
 
+// Flush writes the events cached for the audit sessions that do not
+// have a remote user login, as no more logins will be correlated
+// (e.g., because the daemon is stopping). The events are attributed
+// to the local user known from their audit records, and their metadata
+// is marked as uncorrelated. The sessions' transcripts are written as
+// well. The sessions are deleted once written.
+func (o *sessionTracker) Flush() error {
+	var err error
+
+	o.sessIDsToUsers.Iterate(func(id string, u *user) bool {
+		if u.hasRUL || (len(u.cached) == 0 && u.transcript == nil) {
+			return true
+		}
+
+		u.login = o.unboundLogin(u)
+
+		for _, ae := range u.cached {
+			evt := u.toAuditEvent(ae)
+			evt.Metadata.Extra["uncorrelated"] = true
+
+			err = o.eventWriter.Write(evt)
+			if err != nil {
+				err = &SessionTrackerError{
+					auditWriteFail: true,
+					message: fmt.Sprintf("failed to flush cached events of audit session '%s' - %s",
+						id, err),
+					inner: err,
+				}
+
+				return false
+			}
+		}
+
+		err = o.writeTranscript(id, u, true)
+		if err != nil {
+			return false
+		}
+
+		o.sessIDsToUsers.DeleteUnsafe(id)
+
+		return true
+	})
+
+	return err
+}
+
+// unboundLogin returns the remote user login that the cached events
+// (and the transcript) of u are attributed to when u never had one.
+// The local user is the audit user of its first event, while the
+// client's address and identity are unknown.
+func (o *sessionTracker) unboundLogin(u *user) common.RemoteUserLogin {
+	loggedAs := common.UnknownUser
+	if len(u.cached) > 0 {
+		for _, id := range []string{"auid", "uid"} {
+			if name := u.cached[0].User.Names[id]; name != "" {
+				loggedAs = name
+				break
+			}
+		}
+	}
+
+	source := auditevent.NewAuditEvent(
+		common.ActionLoginIdentifier,
+		auditevent.EventSource{
+			Type:  "IP",
+			Value: common.UnknownAddr,
+		},
+		auditevent.OutcomeSucceeded,
+		map[string]string{
+			"loggedAs": loggedAs,
+			"userID":   common.UnknownUser,
+			"pid":      strconv.Itoa(u.srcPID),
+		},
+		"sshd",
+	).WithTarget(o.target)
+
+	return common.RemoteUserLogin{
+		Source:     source,
+		PID:        u.srcPID,
+		CredUserID: common.UnknownUser,
+	}
+}
+
 type user struct {
-	added  time.Time              // the time when user was added
-	srcPID int                    // source PID
-	hasRUL bool                   // true if there is a remote user login
-	login  common.RemoteUserLogin // current remote user login
-	cached []*aucoalesce.Event    // list of events tied to the user
+	added      time.Time              // the time when user was added
+	srcPID     int                    // source PID
+	hasRUL     bool                   // true if there is a remote user login
+	login      common.RemoteUserLogin // current remote user login
+	cached     []*aucoalesce.Event    // list of events tied to the user
+	ruleKeys   RuleKeyClasses         // optional audit rule key classifications
+	transcript *transcript            // tty input typed during the session
 }
 
 // setRemoteUserLoginInfo sets the remote user login for a user.
@@ -355,8 +527,10 @@ func (o *user) hasRemoteUserLoginInfo() bool {
 // toAuditEvent takes an array of coalesced events and returns and audit event
 // it maps the coalesced event to audit event and populates various fields like
 // outcome, login source, subjects from login source, component.
-// The event type is always User Action.
-// Process args is set in the event metadata.
+// The event type is User Action, unless the event describes network
+// activity (refer to addNetworkDetails).
+// Process args, audit rule keys, syscall details, the working directory,
+// file paths and socket addresses are set in the event metadata.
 func (o *user) toAuditEvent(ae *aucoalesce.Event) *auditevent.AuditEvent {
 	outcome := auditevent.OutcomeFailed
 	switch ae.Result {
@@ -395,15 +569,55 @@ func (o *user) toAuditEvent(ae *aucoalesce.Event) *auditevent.AuditEvent {
 	// set the process arguments in the event metadata
 	if len(ae.Process.Args) > 0 {
 		evt.Metadata.Extra["process_args"] = ae.Process.Args
+		evt.Metadata.Extra["command_line"] = commandLine(ae.Process.Args)
+
+		if source := ae.Data[ArgsSourceDataKey]; source != "" {
+			evt.Metadata.Extra["process_args_source"] = source
+		}
 	}
 
+	if ae.Data[ArgsTruncatedDataKey] == "true" {
+		evt.Metadata.Extra["process_args_truncated"] = true
+	}
+
+	o.addRecordDetails(ae, evt)
+	addFileDetails(ae, evt)
+	addNetworkDetails(ae, evt)
+
 	return evt
 }
 
+// addRecordDetails copies the audit record type, the audit rule keys
+// and syscall-related data from the coalesced event into the audit
+// event's metadata. If any of the rule keys are classified by the
+// user's RuleKeyClasses, the severity and category are set as well.
+func (o *user) addRecordDetails(ae *aucoalesce.Event, evt *auditevent.AuditEvent) {
+	evt.Metadata.Extra["record_type"] = ae.Type.String()
+
+	for _, dataKey := range []string{"syscall", "arch", "exit", "tty"} {
+		if v := ae.Data[dataKey]; v != "" {
+			evt.Metadata.Extra[dataKey] = v
+		}
+	}
+
+	if len(ae.Tags) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ae.Tags))
+	copy(keys, ae.Tags)
+	evt.Metadata.Extra["rule_keys"] = keys
+
+	if class, hasIt := o.ruleKeys.classify(keys); hasIt {
+		evt.Metadata.Extra["severity"] = class.Severity
+		evt.Metadata.Extra["category"] = class.Category
+	}
+}
+
 // writeAndClearCache takes an event writer as parameter.
 // It processes the cached coalesced events of the user and converts that to an audit event.
 // It then writes the audit event to the audit logs and then cleans the event cache of the user.
-func (o *user) writeAndClearCache(writer *auditevent.EventWriter) error {
+func (o *user) writeAndClearCache(writer sinks.EventSink) error {
 	if len(o.cached) == 0 {
 		return nil
 	}
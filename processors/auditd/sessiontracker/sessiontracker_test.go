@@ -10,10 +10,12 @@ import (
 	"github.com/elastic/go-libaudit/v2/aucoalesce"
 	"github.com/elastic/go-libaudit/v2/auparse"
 	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 	"github.com/metal-toolbox/audito-maldito/internal/testtools"
 )
 
@@ -657,6 +659,65 @@ func TestSessionTracker_DeleteUsersWithoutLoginsBefore(t *testing.T) {
 	assert.Equal(t, st.sessIDsToUsers.Len(), 0)
 }
 
+func TestSessionTracker_Flush(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTarget(map[string]string{"host": "test"})
+
+	// Session "123" is not bound to a login.
+	unbound := newAucoalesceEvent(t, "123", "success", time.Now())
+	unbound.Type = auparse.AUDIT_LOGIN
+	unbound.Process.PID = "999"
+	unbound.User.Names = map[string]string{"auid": "someuser"}
+	require.NoError(t, st.AuditdEvent(unbound))
+	require.NoError(t, st.AuditdEvent(newAucoalesceEvent(t, "123", "success", time.Now())))
+
+	// Session "456" is bound to a login, so its events were written.
+	require.NoError(t, st.RemoteLogin(common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Subjects: map[string]string{"loggedAs": "other"},
+			Source:   auditevent.EventSource{Type: "IP", Value: "127.0.0.1"},
+		},
+		PID:        1000,
+		CredUserID: "foo",
+	}))
+
+	bound := newAucoalesceEvent(t, "456", "success", time.Now())
+	bound.Type = auparse.AUDIT_LOGIN
+	bound.Process.PID = "1000"
+	require.NoError(t, st.AuditdEvent(bound))
+	require.Len(t, events, 1)
+	<-events
+
+	require.NoError(t, st.Flush())
+
+	require.Len(t, events, 2)
+	for i := 0; i < 2; i++ {
+		evt := <-events
+		assert.Equal(t, "123", evt.Metadata.AuditID)
+		assert.Equal(t, true, evt.Metadata.Extra["uncorrelated"])
+		assert.Equal(t, auditevent.EventSource{Type: "IP", Value: common.UnknownAddr}, evt.Source)
+		assert.Equal(t, map[string]string{
+			"loggedAs": "someuser",
+			"userID":   common.UnknownUser,
+			"pid":      "999",
+		}, evt.Subjects)
+		assert.Equal(t, "test", evt.Target["host"])
+	}
+
+	assert.False(t, st.sessIDsToUsers.Has("123"))
+	assert.True(t, st.sessIDsToUsers.Has("456"))
+}
+
 func TestUser_ToAuditEvent(t *testing.T) {
 	t.Parallel()
 
@@ -702,12 +763,13 @@ func TestUser_ToAuditEvent(t *testing.T) {
 	assert.Equal(t, ae.Timestamp, event.LoggedAt)
 	assert.Equal(t, ae.Session, event.Metadata.AuditID)
 
-	assert.Len(t, event.Metadata.Extra, 4)
+	assert.Len(t, event.Metadata.Extra, 6)
 	assert.Equal(t, ae.Summary.Action, event.Metadata.Extra["action"])
 	assert.Equal(t, ae.Summary.How, event.Metadata.Extra["how"])
 	assert.Equal(t, ae.Summary.Object, event.Metadata.Extra["object"])
 	assert.NotNil(t, event.Metadata.Extra["object"])
 	assert.Equal(t, ae.Process.Args, event.Metadata.Extra["process_args"])
+	assert.Equal(t, "foo bar", event.Metadata.Extra["command_line"])
 	assert.Len(t, event.Subjects, len(u.login.Source.Subjects))
 	for k, v := range u.login.Source.Subjects {
 		x, hasIt := event.Subjects[k]
@@ -719,6 +781,107 @@ func TestUser_ToAuditEvent(t *testing.T) {
 	}
 }
 
+func TestUser_ToAuditEvent_RecordDetails(t *testing.T) {
+	t.Parallel()
+
+	u := user{
+		added:  time.Now(),
+		srcPID: 666,
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+		ruleKeys: RuleKeyClasses{
+			"identity": {
+				Severity: "high",
+				Category: "identity",
+			},
+		},
+	}
+
+	ae := &aucoalesce.Event{
+		Type:      auparse.AUDIT_SYSCALL,
+		Result:    "success",
+		Session:   "123",
+		Timestamp: time.Now(),
+		Tags:      []string{"ssh_keys", "identity"},
+		Data: map[string]string{
+			"syscall": "openat",
+			"arch":    "x86_64",
+			"exit":    "3",
+			"tty":     "pts0",
+		},
+	}
+
+	event := u.toAuditEvent(ae)
+
+	assert.Equal(t, "SYSCALL", event.Metadata.Extra["record_type"])
+	assert.Equal(t, "openat", event.Metadata.Extra["syscall"])
+	assert.Equal(t, "x86_64", event.Metadata.Extra["arch"])
+	assert.Equal(t, "3", event.Metadata.Extra["exit"])
+	assert.Equal(t, "pts0", event.Metadata.Extra["tty"])
+	assert.Equal(t, []string{"ssh_keys", "identity"}, event.Metadata.Extra["rule_keys"])
+	assert.Equal(t, "high", event.Metadata.Extra["severity"])
+	assert.Equal(t, "identity", event.Metadata.Extra["category"])
+}
+
+func TestUser_ToAuditEvent_UnclassifiedRuleKey(t *testing.T) {
+	t.Parallel()
+
+	u := user{
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	}
+
+	ae := &aucoalesce.Event{
+		Type:    auparse.AUDIT_SYSCALL,
+		Result:  "success",
+		Session: "123",
+		Tags:    []string{"privileged"},
+	}
+
+	event := u.toAuditEvent(ae)
+
+	assert.Equal(t, []string{"privileged"}, event.Metadata.Extra["rule_keys"])
+	assert.NotContains(t, event.Metadata.Extra, "severity")
+	assert.NotContains(t, event.Metadata.Extra, "category")
+	assert.NotContains(t, event.Metadata.Extra, "tty")
+}
+
+func TestUser_ToAuditEvent_TruncatedArgs(t *testing.T) {
+	t.Parallel()
+
+	u := user{
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	}
+
+	ae := &aucoalesce.Event{
+		Result:  "success",
+		Session: "123",
+		Process: aucoalesce.Process{
+			Args: []string{"bash", "-c", "echo hello"},
+		},
+		Data: map[string]string{
+			ArgsSourceDataKey:    ArgsSourceProctitle,
+			ArgsTruncatedDataKey: "true",
+		},
+	}
+
+	event := u.toAuditEvent(ae)
+
+	assert.Equal(t, "bash -c 'echo hello'", event.Metadata.Extra["command_line"])
+	assert.Equal(t, ArgsSourceProctitle, event.Metadata.Extra["process_args_source"])
+	assert.Equal(t, true, event.Metadata.Extra["process_args_truncated"])
+}
+
 func TestUser_ToAuditEvent_Fail(t *testing.T) {
 	t.Parallel()
 
@@ -759,6 +922,8 @@ func TestUser_ToAuditEvent_Fail(t *testing.T) {
 
 	event := u.toAuditEvent(ae)
 	assert.Nil(t, event.Metadata.Extra["process_args"])
+	assert.Nil(t, event.Metadata.Extra["command_line"])
+	assert.Nil(t, event.Metadata.Extra["process_args_truncated"])
 	assert.Equal(t, event.Outcome, auditevent.OutcomeFailed)
 }
 
@@ -873,3 +1038,73 @@ func newAucoalesceEvent(t *testing.T, sessionID, outcome string, timestamp time.
 
 	return ae
 }
+
+func counterValue(t *testing.T, registry *prometheus.Registry, name string, labelValue string) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != metrics.MetricsNamespace+"_"+name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if labelValue == "" || metric.GetLabel()[0].GetValue() == labelValue {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	t.Fatalf("metric %q (%q) not found", name, labelValue)
+
+	return 0
+}
+
+func TestSessionTracker_Metrics(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	registry := prometheus.NewRegistry()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: make(chan *auditevent.AuditEvent, 2),
+		T:      t,
+	}), nil).WithMetrics(metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+
+	// The login arrives before and after its audit session.
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(200, "alice@example.com", time.Now())))
+
+	login := newAucoalesceEvent(t, "1", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "200"
+	require.NoError(t, st.AuditdEvent(login))
+
+	login = newAucoalesceEvent(t, "2", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "300"
+	require.NoError(t, st.AuditdEvent(login))
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(300, "bob@example.com", time.Now())))
+
+	assert.Equal(t, 2.0, counterValue(t, registry, "correlations_total", ""))
+
+	// Neither of these is ever correlated.
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(400, "carol@example.com", time.Now())))
+
+	login = newAucoalesceEvent(t, "3", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "500"
+	require.NoError(t, st.AuditdEvent(login))
+
+	st.DeleteUsersWithoutLoginsBefore(time.Now().Add(time.Second))
+	st.DeleteRemoteUserLoginsBefore(time.Now().Add(time.Second))
+
+	assert.Equal(t, 1.0, counterValue(t, registry, "correlations_expired_total",
+		string(metrics.ExpiredAuditSession)))
+	assert.Equal(t, 1.0, counterValue(t, registry, "correlations_expired_total",
+		string(metrics.ExpiredRemoteLogin)))
+}
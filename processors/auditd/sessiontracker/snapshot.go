@@ -0,0 +1,220 @@
+package sessiontracker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// Snapshot describes the state of a session tracker at a point in time.
+// It is meant for troubleshooting the correlation of audit sessions and
+// remote user logins.
+type Snapshot struct {
+	// Taken is when the snapshot was taken.
+	Taken time.Time `json:"taken"`
+
+	// Sessions are the active audit sessions, sorted by ID.
+	Sessions []SessionInfo `json:"sessions"`
+
+	// PendingLogins are the remote user logins that are waiting
+	// for their audit session to start, sorted by PID.
+	PendingLogins []PendingLogin `json:"pendingLogins"`
+}
+
+// SessionInfo describes an active audit session.
+type SessionInfo struct {
+	// ID is the audit session ID.
+	ID string `json:"id"`
+
+	// PID is the PID of the process that started the session.
+	PID int `json:"pid"`
+
+	// Started is when the session was first seen.
+	Started time.Time `json:"started"`
+	Age     string    `json:"age"`
+
+	// HasLogin is true if the session is bound to a remote user
+	// login. Its events are cached until it is.
+	HasLogin bool `json:"hasLogin"`
+
+	// UserID is the identity of the credential that the user
+	// logged in with (e.g., a SSH certificate's key ID).
+	UserID   string `json:"userID,omitempty"`
+	LoggedAs string `json:"loggedAs,omitempty"`
+	Source   string `json:"source,omitempty"`
+
+	// CachedEvents is the number of audit events
+	// waiting for the session's remote user login.
+	CachedEvents int `json:"cachedEvents"`
+}
+
+// PendingLogin describes a remote user login that has no audit session.
+type PendingLogin struct {
+	PID      int       `json:"pid"`
+	UserID   string    `json:"userID"`
+	LoggedAs string    `json:"loggedAs,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	LoggedAt time.Time `json:"loggedAt"`
+	Age      string    `json:"age"`
+}
+
+// Snapshot returns the current state of the session tracker.
+func (o *sessionTracker) Snapshot() Snapshot {
+	now := time.Now()
+
+	snapshot := Snapshot{
+		Taken:         now,
+		Sessions:      []SessionInfo{},
+		PendingLogins: []PendingLogin{},
+	}
+
+	// The maps are locked one after the other, because
+	// auditEventWithoutSession locks pidsToRULs first.
+	o.sessIDsToUsers.Iterate(func(id string, u *user) bool {
+		info := SessionInfo{
+			ID:           id,
+			PID:          u.srcPID,
+			Started:      u.added,
+			Age:          snapshotAge(now, u.added),
+			HasLogin:     u.hasRUL,
+			CachedEvents: len(u.cached),
+		}
+
+		if u.hasRUL {
+			info.UserID = u.login.CredUserID
+			info.LoggedAs, info.Source = loginSubject(u.login)
+		}
+
+		snapshot.Sessions = append(snapshot.Sessions, info)
+		return true
+	})
+
+	o.pidsToRULs.Iterate(func(pid int, rul common.RemoteUserLogin) bool {
+		login := PendingLogin{
+			PID:    pid,
+			UserID: rul.CredUserID,
+		}
+
+		login.LoggedAs, login.Source = loginSubject(rul)
+
+		if rul.Source != nil {
+			login.LoggedAt = rul.Source.LoggedAt
+			login.Age = snapshotAge(now, rul.Source.LoggedAt)
+		}
+
+		snapshot.PendingLogins = append(snapshot.PendingLogins, login)
+		return true
+	})
+
+	sort.Slice(snapshot.Sessions, func(i, j int) bool {
+		return snapshot.Sessions[i].ID < snapshot.Sessions[j].ID
+	})
+
+	sort.Slice(snapshot.PendingLogins, func(i, j int) bool {
+		return snapshot.PendingLogins[i].PID < snapshot.PendingLogins[j].PID
+	})
+
+	return snapshot
+}
+
+// Stats are the numbers of items held by a session tracker.
+type Stats struct {
+	Sessions      int
+	PendingLogins int
+	CachedEvents  int
+}
+
+// Stats returns the number of active audit sessions, of pending
+// remote user logins and of audit events cached by the sessions.
+// Unlike Snapshot, it does not copy the sessions' details.
+func (o *sessionTracker) Stats() Stats {
+	var stats Stats
+
+	o.sessIDsToUsers.Iterate(func(_ string, u *user) bool {
+		stats.Sessions++
+		stats.CachedEvents += len(u.cached)
+		return true
+	})
+
+	stats.PendingLogins = o.pidsToRULs.Len()
+
+	return stats
+}
+
+// loginSubject returns the local user name and the source
+// address of the remote user login's audit event.
+func loginSubject(rul common.RemoteUserLogin) (loggedAs string, source string) {
+	if rul.Source == nil {
+		return "", ""
+	}
+
+	return rul.Source.Subjects["loggedAs"], rul.Source.Source.Value
+}
+
+func snapshotAge(now time.Time, since time.Time) string {
+	return now.Sub(since).Round(time.Second).String()
+}
+
+// Introspector provides snapshots of the session tracker that
+// is attached to it, which may be replaced or detached at any
+// time (e.g., because the auditd processor restarted).
+//
+// The methods of a nil *Introspector do nothing.
+type Introspector struct {
+	mu      sync.RWMutex
+	tracker *sessionTracker
+}
+
+// NewIntrospector returns a new Introspector
+// with no attached session tracker.
+func NewIntrospector() *Introspector {
+	return &Introspector{}
+}
+
+// Attach sets the session tracker whose snapshots are returned by
+// Snapshot. A nil tracker detaches the current session tracker.
+func (o *Introspector) Attach(tracker *sessionTracker) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	o.tracker = tracker
+	o.mu.Unlock()
+}
+
+// Snapshot returns a snapshot of the attached session tracker.
+// The ok result is false if no session tracker is attached.
+func (o *Introspector) Snapshot() (snapshot Snapshot, ok bool) {
+	if o == nil {
+		return Snapshot{}, false
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.tracker == nil {
+		return Snapshot{}, false
+	}
+
+	return o.tracker.Snapshot(), true
+}
+
+// Stats returns the Stats of the attached session tracker.
+// The ok result is false if no session tracker is attached.
+func (o *Introspector) Stats() (stats Stats, ok bool) {
+	if o == nil {
+		return Stats{}, false
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.tracker == nil {
+		return Stats{}, false
+	}
+
+	return o.tracker.Stats(), true
+}
@@ -0,0 +1,154 @@
+package sessiontracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func newTestRemoteUserLogin(pid int, userID string, loggedAt time.Time) common.RemoteUserLogin {
+	return common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			LoggedAt: loggedAt,
+			Subjects: map[string]string{
+				"loggedAs": "core",
+			},
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: "192.0.2.10",
+			},
+		},
+		PID:        pid,
+		CredUserID: userID,
+	}
+}
+
+func TestSessionTracker_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: make(chan *auditevent.AuditEvent, 1),
+		T:      t,
+	}), nil)
+
+	snapshot := st.Snapshot()
+	assert.Empty(t, snapshot.Sessions)
+	assert.Empty(t, snapshot.PendingLogins)
+
+	loggedAt := time.Now().Add(-time.Minute)
+
+	// Session "1" is bound to the login of PID 200.
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(200, "alice@example.com", loggedAt)))
+
+	login := newAucoalesceEvent(t, "1", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "200"
+	require.NoError(t, st.AuditdEvent(login))
+
+	// Session "2" caches its events until its login arrives.
+	login = newAucoalesceEvent(t, "2", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "100"
+	require.NoError(t, st.AuditdEvent(login))
+	require.NoError(t, st.AuditdEvent(newAucoalesceEvent(t, "2", "success", time.Now())))
+
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(300, "bob@example.com", loggedAt)))
+
+	snapshot = st.Snapshot()
+
+	require.Len(t, snapshot.Sessions, 2)
+
+	bound := snapshot.Sessions[0]
+	assert.Equal(t, "1", bound.ID)
+	assert.Equal(t, 200, bound.PID)
+	assert.True(t, bound.HasLogin)
+	assert.Equal(t, "alice@example.com", bound.UserID)
+	assert.Equal(t, "core", bound.LoggedAs)
+	assert.Equal(t, "192.0.2.10", bound.Source)
+	assert.Equal(t, 0, bound.CachedEvents)
+	assert.NotEmpty(t, bound.Age)
+
+	unbound := snapshot.Sessions[1]
+	assert.Equal(t, "2", unbound.ID)
+	assert.Equal(t, 100, unbound.PID)
+	assert.False(t, unbound.HasLogin)
+	assert.Empty(t, unbound.UserID)
+	assert.Equal(t, 2, unbound.CachedEvents)
+
+	assert.Equal(t, []PendingLogin{{
+		PID:      300,
+		UserID:   "bob@example.com",
+		LoggedAs: "core",
+		Source:   "192.0.2.10",
+		LoggedAt: loggedAt,
+		Age:      "1m0s",
+	}}, snapshot.PendingLogins)
+}
+
+func TestIntrospector(t *testing.T) {
+	t.Parallel()
+
+	var nilIntrospector *Introspector
+	nilIntrospector.Attach(nil)
+
+	_, ok := nilIntrospector.Snapshot()
+	assert.False(t, ok)
+
+	_, ok = nilIntrospector.Stats()
+	assert.False(t, ok)
+
+	introspector := NewIntrospector()
+
+	_, ok = introspector.Snapshot()
+	assert.False(t, ok)
+
+	st := NewSessionTracker(nil, nil)
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(300, "bob@example.com", time.Now())))
+
+	introspector.Attach(st)
+
+	snapshot, ok := introspector.Snapshot()
+	require.True(t, ok)
+	assert.Len(t, snapshot.PendingLogins, 1)
+
+	stats, ok := introspector.Stats()
+	require.True(t, ok)
+	assert.Equal(t, Stats{PendingLogins: 1}, stats)
+
+	introspector.Attach(nil)
+
+	_, ok = introspector.Snapshot()
+	assert.False(t, ok)
+
+	_, ok = introspector.Stats()
+	assert.False(t, ok)
+}
+
+func TestSessionTracker_Stats(t *testing.T) {
+	t.Parallel()
+
+	st := NewSessionTracker(nil, nil)
+	assert.Equal(t, Stats{}, st.Stats())
+
+	login := newAucoalesceEvent(t, "2", "success", time.Now())
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "100"
+	require.NoError(t, st.AuditdEvent(login))
+	require.NoError(t, st.AuditdEvent(newAucoalesceEvent(t, "2", "success", time.Now())))
+
+	require.NoError(t, st.RemoteLogin(newTestRemoteUserLogin(300, "bob@example.com", time.Now())))
+
+	assert.Equal(t, Stats{Sessions: 1, PendingLogins: 1, CachedEvents: 2}, st.Stats())
+}
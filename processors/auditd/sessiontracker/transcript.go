@@ -0,0 +1,322 @@
+package sessiontracker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+const (
+	// redactedLine replaces input lines that were
+	// typed in response to a password prompt.
+	redactedLine = "[redacted]"
+
+	transcriptFilePerms = 0o600
+)
+
+// DefaultTranscriptRedactAfter is the default list of programs that
+// typically prompt for a password after they are executed.
+var DefaultTranscriptRedactAfter = []string{
+	"sudo", "su", "passwd", "ssh", "scp", "sftp", "mysql", "psql", "kinit",
+}
+
+// TranscriptConfig configures the collection of TTY input (TTY and
+// USER_TTY audit records, as produced by pam_tty_audit) into
+// per-session keystroke transcripts.
+type TranscriptConfig struct {
+	// Enabled enables transcript collection. When disabled,
+	// TTY records are treated like any other audit event.
+	Enabled bool
+
+	// Interval is the interval at which the transcripts of active
+	// sessions are written. A zero value means transcripts are only
+	// written when the session ends.
+	Interval time.Duration
+
+	// RedactAfter is a list of program names. The input line typed
+	// after a command line that executes one of these programs (i.e.,
+	// whose argv[0] is one of them) is redacted, as it is likely
+	// a response to a password prompt.
+	RedactAfter []string
+
+	// Dir is an optional directory path. If set, each session's
+	// transcript is also appended to a file in this directory.
+	Dir string
+
+	// RedactFile optionally redacts the text appended to the
+	// transcript files (e.g., using the redaction policy that
+	// applies to the SessionTranscript events). Nothing is
+	// appended if it returns an empty string.
+	RedactFile func(text string) string
+}
+
+// transcript accumulates the TTY input of an audit session.
+type transcript struct {
+	// started is the timestamp of the first TTY record.
+	started time.Time
+
+	// file is the transcript's file name when TranscriptConfig.Dir
+	// is set. It is set when the transcript is first written.
+	file string
+
+	// pending is input that has not been split into lines yet.
+	pending strings.Builder
+
+	// lines are complete (and possibly redacted) input
+	// lines that have not been written yet.
+	lines []string
+
+	// numRedacted is the number of redacted lines
+	// that have not been written yet.
+	numRedacted int
+
+	// redactNext is true if the next complete line
+	// should be redacted.
+	redactNext bool
+}
+
+// isTTYEvent returns true if the event contains TTY input.
+func isTTYEvent(event *aucoalesce.Event) bool {
+	return event.Type == auparse.AUDIT_TTY || event.Type == auparse.AUDIT_USER_TTY
+}
+
+// add appends the input found in a TTY or USER_TTY audit
+// event to the transcript.
+func (o *transcript) add(event *aucoalesce.Event, redactAfter []string) {
+	if o.started.IsZero() {
+		o.started = event.Timestamp
+	}
+
+	o.pending.WriteString(event.Data["data"])
+
+	input := o.pending.String()
+	o.pending.Reset()
+
+	for {
+		i := strings.IndexAny(input, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		o.addLine(input[:i], redactAfter)
+		input = input[i+1:]
+	}
+
+	o.pending.WriteString(input)
+}
+
+// addLine adds a complete input line to the transcript, redacting
+// it if the previous line executed a program found in redactAfter.
+func (o *transcript) addLine(line string, redactAfter []string) {
+	if line == "" {
+		return
+	}
+
+	if o.redactNext {
+		o.lines = append(o.lines, redactedLine)
+		o.numRedacted++
+		o.redactNext = false
+		return
+	}
+
+	o.lines = append(o.lines, line)
+	o.redactNext = executesAny(line, redactAfter)
+}
+
+// flush returns the transcript's unwritten lines and clears them.
+// If final is true, any partial line is included as well.
+func (o *transcript) flush(final bool, redactAfter []string) (string, int) {
+	if final && o.pending.Len() > 0 {
+		line := o.pending.String()
+		o.pending.Reset()
+		o.addLine(line, redactAfter)
+	}
+
+	text := strings.Join(o.lines, "\n")
+	numRedacted := o.numRedacted
+
+	o.lines = nil
+	o.numRedacted = 0
+
+	return text, numRedacted
+}
+
+// executesAny returns true if one of the commands in line (e.g., the
+// commands of a pipeline) executes a program in programs, meaning
+// that its first word is the program's name (or path). Arguments
+// that happen to be program names (e.g., "ssh" in "man ssh") are
+// not taken into account.
+func executesAny(line string, programs []string) bool {
+	commands := strings.FieldsFunc(line, func(r rune) bool {
+		return r == '|' || r == ';' || r == '&'
+	})
+
+	for _, command := range commands {
+		words := strings.Fields(command)
+		if len(words) == 0 {
+			continue
+		}
+
+		for _, program := range programs {
+			if filepath.Base(words[0]) == program {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// recordTTYInput adds the input found in a TTY audit event
+// to the user's transcript.
+func (o *sessionTracker) recordTTYInput(u *user, event *aucoalesce.Event) {
+	if u.transcript == nil {
+		u.transcript = &transcript{}
+	}
+
+	u.transcript.add(event, o.transcripts.RedactAfter)
+}
+
+// writeTranscript writes the user's transcript as a SessionTranscript
+// audit event. It is a no-op if there is nothing to write.
+//
+// The transcript of a session that does not have a remote user login
+// is only written once the session ends (i.e., if final is true). It
+// is then attributed to the local user known from the session's audit
+// events, and marked as uncorrelated.
+func (o *sessionTracker) writeTranscript(sessID string, u *user, final bool) error {
+	if u.transcript == nil {
+		return nil
+	}
+
+	login := u.login
+	uncorrelated := !u.hasRemoteUserLoginInfo()
+	if uncorrelated {
+		if !final {
+			return nil
+		}
+
+		login = o.unboundLogin(u)
+	}
+
+	text, numRedacted := u.transcript.flush(final, o.transcripts.RedactAfter)
+	if text == "" && !final {
+		return nil
+	}
+
+	evt := u.transcript.toEvent(login, sessID, text, numRedacted, final)
+	if uncorrelated {
+		evt.Metadata.Extra["uncorrelated"] = true
+	}
+
+	if o.transcripts.Dir != "" {
+		fileText := text
+		if o.transcripts.RedactFile != nil && fileText != "" {
+			fileText = o.transcripts.RedactFile(fileText)
+		}
+
+		err := u.transcript.appendToFile(o.transcripts.Dir, sessID, fileText)
+		if err != nil {
+			o.l.Errorf("failed to write transcript for audit session '%s' to file - %s",
+				sessID, err)
+		} else {
+			evt.Metadata.Extra["transcript_file"] = u.transcript.file
+		}
+	}
+
+	err := o.eventWriter.Write(evt)
+	if err != nil {
+		return &SessionTrackerError{
+			auditWriteFail: true,
+			message: fmt.Sprintf("failed to write transcript for user '%s' - %s",
+				login.CredUserID, err),
+			inner: err,
+		}
+	}
+
+	if final {
+		u.transcript = nil
+	}
+
+	return nil
+}
+
+// WriteTranscripts writes the transcripts of all active audit
+// sessions that have a remote user login and pending TTY input.
+// The transcripts of the other sessions are written when the
+// sessions end, or by Flush.
+//
+// If final is true (e.g., because the daemon is stopping), the
+// transcripts are written as final ones, including their partial
+// input line, even though the sessions have not ended.
+func (o *sessionTracker) WriteTranscripts(final bool) error {
+	var err error
+
+	o.sessIDsToUsers.Iterate(func(sessID string, u *user) bool {
+		err = o.writeTranscript(sessID, u, final)
+		return err == nil
+	})
+
+	return err
+}
+
+// appendToFile appends text to the transcript's file in dir.
+func (o *transcript) appendToFile(dir string, sessID string, text string) error {
+	if o.file == "" {
+		o.file = filepath.Join(dir, fmt.Sprintf("session-%s-%d.txt", sessID, o.started.Unix()))
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(o.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, transcriptFilePerms)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(text + "\n")
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// toEvent returns a SessionTranscript audit event
+// attributed to the given remote user login.
+func (o *transcript) toEvent(login common.RemoteUserLogin, sessID string, text string, numRedacted int,
+	final bool,
+) *auditevent.AuditEvent {
+	subjectsCopy := make(map[string]string, len(login.Source.Subjects))
+	for k, v := range login.Source.Subjects {
+		subjectsCopy[k] = v
+	}
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionSessionTranscript,
+		login.Source.Source,
+		auditevent.OutcomeSucceeded,
+		subjectsCopy,
+		"auditd",
+	).WithTarget(login.Source.Target)
+
+	evt.Metadata.AuditID = sessID
+	evt.Metadata.Extra = map[string]any{
+		"transcript":     text,
+		"final":          final,
+		"redacted_lines": numRedacted,
+		"started":        o.started,
+	}
+
+	return evt
+}
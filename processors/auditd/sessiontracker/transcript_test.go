@@ -0,0 +1,385 @@
+package sessiontracker
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+	"github.com/metal-toolbox/audito-maldito/sinks"
+)
+
+func TestTranscript_Add(t *testing.T) {
+	t.Parallel()
+
+	tr := &transcript{}
+	redactAfter := []string{"sudo"}
+
+	tr.add(newTTYEvent("ls -la\rsud"), redactAfter)
+	tr.add(newTTYEvent("o id\rhunter2\rwhoami\r"), redactAfter)
+	tr.add(newTTYEvent("exi"), redactAfter)
+
+	text, numRedacted := tr.flush(false, redactAfter)
+	assert.Equal(t, "ls -la\nsudo id\n[redacted]\nwhoami", text)
+	assert.Equal(t, 1, numRedacted)
+
+	text, numRedacted = tr.flush(true, redactAfter)
+	assert.Equal(t, "exi", text)
+	assert.Equal(t, 0, numRedacted)
+}
+
+func TestTranscript_Add_NoRedaction(t *testing.T) {
+	t.Parallel()
+
+	tr := &transcript{}
+
+	tr.add(newTTYEvent("/usr/bin/sudo id\rhunter2\r"), nil)
+
+	text, numRedacted := tr.flush(false, nil)
+	assert.Equal(t, "/usr/bin/sudo id\nhunter2", text)
+	assert.Equal(t, 0, numRedacted)
+}
+
+func TestExecutesAny(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, executesAny("sudo ls", []string{"sudo"}))
+	assert.True(t, executesAny("echo foo | /usr/bin/sudo tee x", []string{"sudo"}))
+	assert.True(t, executesAny("cd /tmp && su -", []string{"su"}))
+	assert.False(t, executesAny("sudoku", []string{"sudo"}))
+	assert.False(t, executesAny("man ssh", []string{"ssh"}))
+	assert.False(t, executesAny("git commit -m ssh", []string{"ssh"}))
+	assert.False(t, executesAny("ls", nil))
+}
+
+func TestSessionTracker_Transcript(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+	dir := t.TempDir()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled:     true,
+		RedactAfter: []string{"su"},
+		Dir:         dir,
+	})
+
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		hasRUL: true,
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{
+					"userID": "foo@bar.com",
+				},
+				Source: auditevent.EventSource{
+					Type:  "IP",
+					Value: "127.0.0.1",
+				},
+			},
+			PID:        999,
+			CredUserID: "foo@bar.com",
+		},
+	})
+
+	ttyEvent := newTTYEvent("su -\rhunter2\rid\r")
+	ttyEvent.Session = "123"
+
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+	assert.Len(t, events, 0, "tty input should not be written as a user action")
+
+	require.NoError(t, st.WriteTranscripts(false))
+	require.Len(t, events, 1)
+
+	evt := <-events
+	assert.Equal(t, common.ActionSessionTranscript, evt.Type)
+	assert.Equal(t, "foo@bar.com", evt.Subjects["userID"])
+	assert.Equal(t, "123", evt.Metadata.AuditID)
+	assert.Equal(t, "su -\n[redacted]\nid", evt.Metadata.Extra["transcript"])
+	assert.Equal(t, 1, evt.Metadata.Extra["redacted_lines"])
+	assert.Equal(t, false, evt.Metadata.Extra["final"])
+
+	transcriptFile, ok := evt.Metadata.Extra["transcript_file"].(string)
+	require.True(t, ok)
+
+	credDisp := newAucoalesceEvent(t, "123", "success", time.Now())
+	credDisp.Type = auparse.AUDIT_CRED_DISP
+	require.NoError(t, st.AuditdEvent(credDisp))
+
+	require.Len(t, events, 2)
+	<-events
+
+	evt = <-events
+	assert.Equal(t, common.ActionSessionTranscript, evt.Type)
+	assert.Equal(t, true, evt.Metadata.Extra["final"])
+	assert.False(t, st.sessIDsToUsers.Has("123"))
+
+	contents, err := os.ReadFile(transcriptFile)
+	require.NoError(t, err)
+	assert.Equal(t, "su -\n[redacted]\nid\n", string(contents))
+}
+
+func TestSessionTracker_WriteTranscripts_Final(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled: true,
+	})
+
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		hasRUL: true,
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	})
+
+	ttyEvent := newTTYEvent("ls\rcat /etc/host")
+	ttyEvent.Session = "123"
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+
+	// The partial line is kept until the transcript is final.
+	require.NoError(t, st.WriteTranscripts(false))
+	require.Len(t, events, 1)
+
+	evt := <-events
+	assert.Equal(t, "ls", evt.Metadata.Extra["transcript"])
+	assert.Equal(t, false, evt.Metadata.Extra["final"])
+
+	require.NoError(t, st.WriteTranscripts(true))
+	require.Len(t, events, 1)
+
+	evt = <-events
+	assert.Equal(t, "cat /etc/host", evt.Metadata.Extra["transcript"])
+	assert.Equal(t, true, evt.Metadata.Extra["final"])
+
+	require.NoError(t, st.WriteTranscripts(true))
+	assert.Len(t, events, 0, "a final transcript should only be written once")
+}
+
+func TestSessionTracker_Transcript_RedactsFile(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	policy, err := sinks.ParseRedactionPolicy(strings.NewReader(
+		`{"redact": [{"pattern": "(--password[= ])\\S+", "replacement": "${1}[redacted]"}]}`))
+	require.NoError(t, err)
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled: true,
+		Dir:     t.TempDir(),
+		RedactFile: func(text string) string {
+			text, _ = policy.ApplyToField("metadata.extra.transcript", text)
+			return text
+		},
+	})
+
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		hasRUL: true,
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	})
+
+	ttyEvent := newTTYEvent("mysql --password=x\rls\r")
+	ttyEvent.Session = "123"
+
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+	require.NoError(t, st.WriteTranscripts(false))
+	require.Len(t, events, 1)
+
+	// The event is redacted by the outputs' RedactingSink.
+	evt := <-events
+	assert.Equal(t, "mysql --password=x\nls", evt.Metadata.Extra["transcript"])
+
+	transcriptFile, ok := evt.Metadata.Extra["transcript_file"].(string)
+	require.True(t, ok)
+
+	contents, err := os.ReadFile(transcriptFile)
+	require.NoError(t, err)
+	assert.Equal(t, "mysql --password=[redacted]\nls\n", string(contents))
+}
+
+func TestSessionTracker_Transcript_Uncorrelated(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled: true,
+	}).WithTarget(map[string]string{"host": "test"})
+
+	login := newAucoalesceEvent(t, "123", "success", time.Now())
+	login.User.Names = map[string]string{"auid": "root"}
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		cached: []*aucoalesce.Event{login},
+	})
+
+	ttyEvent := newTTYEvent("id\r")
+	ttyEvent.Session = "123"
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+
+	// The transcript is kept until the session ends,
+	// in case it is bound to a login in the meantime.
+	require.NoError(t, st.WriteTranscripts(false))
+	assert.Len(t, events, 0)
+
+	credDisp := newAucoalesceEvent(t, "123", "success", time.Now())
+	credDisp.Type = auparse.AUDIT_CRED_DISP
+	require.NoError(t, st.AuditdEvent(credDisp))
+
+	require.Len(t, events, 1)
+
+	evt := <-events
+	assert.Equal(t, common.ActionSessionTranscript, evt.Type)
+	assert.Equal(t, "root", evt.Subjects["loggedAs"])
+	assert.Equal(t, common.UnknownUser, evt.Subjects["userID"])
+	assert.Equal(t, common.UnknownAddr, evt.Source.Value)
+	assert.Equal(t, "test", evt.Target["host"])
+	assert.Equal(t, "id", evt.Metadata.Extra["transcript"])
+	assert.Equal(t, true, evt.Metadata.Extra["final"])
+	assert.Equal(t, true, evt.Metadata.Extra["uncorrelated"])
+
+	// The cached events are written by Flush,
+	// without writing the transcript again.
+	require.NoError(t, st.Flush())
+	require.Len(t, events, 2)
+
+	for i := 0; i < 2; i++ {
+		evt = <-events
+		assert.Equal(t, common.ActionUserAction, evt.Type)
+	}
+}
+
+func TestSessionTracker_Flush_Transcript(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil).WithTranscripts(TranscriptConfig{
+		Enabled: true,
+	})
+
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		cached: []*aucoalesce.Event{newAucoalesceEvent(t, "123", "success", time.Now())},
+	})
+
+	ttyEvent := newTTYEvent("uptime")
+	ttyEvent.Session = "123"
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+
+	require.NoError(t, st.Flush())
+	require.Len(t, events, 2)
+	assert.False(t, st.sessIDsToUsers.Has("123"))
+
+	assert.Equal(t, common.ActionUserAction, (<-events).Type)
+
+	evt := <-events
+	assert.Equal(t, common.ActionSessionTranscript, evt.Type)
+	assert.Equal(t, "uptime", evt.Metadata.Extra["transcript"])
+	assert.Equal(t, true, evt.Metadata.Extra["uncorrelated"])
+}
+
+func TestSessionTracker_Transcript_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+
+	st.sessIDsToUsers.Store("123", &user{
+		added:  time.Now(),
+		srcPID: 999,
+		hasRUL: true,
+		login: common.RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Subjects: map[string]string{},
+			},
+		},
+	})
+
+	ttyEvent := newTTYEvent("id\r")
+	ttyEvent.Session = "123"
+
+	require.NoError(t, st.AuditdEvent(ttyEvent))
+	require.Len(t, events, 1)
+
+	evt := <-events
+	assert.Equal(t, common.ActionUserAction, evt.Type)
+}
+
+func newTTYEvent(data string) *aucoalesce.Event {
+	return &aucoalesce.Event{
+		Type:      auparse.AUDIT_TTY,
+		Timestamp: time.Now(),
+		Data: map[string]string{
+			"data": data,
+		},
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"github.com/metal-toolbox/auditevent"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 func nastyPTRRecord(config *SshdProcessorer) error {
 	matches := nastyPTRRecordRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got nastyPTRRecord log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -40,6 +42,7 @@ func reverseMappingCheckFailed(config *SshdProcessorer) error {
 	matches := reverseMappingCheckFailedRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got reverseMappingCheckFailed log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -68,6 +71,7 @@ func doesNotMapBackToAddr(config *SshdProcessorer) error {
 	matches := doesNotMapBackToAddrRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got doesNotMapBackToAddr log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
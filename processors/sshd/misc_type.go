@@ -6,12 +6,14 @@ import (
 	"github.com/metal-toolbox/auditevent"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 func rootLoginRefused(config *SshdProcessorer) error {
 	matches := rootLoginRefusedRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got rootLoginRefused log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -61,6 +63,7 @@ func badOwnerOrModesForHostFile(config *SshdProcessorer) error {
 	matches := badOwnerOrModesForHostFileRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got badOwnerOrModesForHostFile log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -109,6 +112,7 @@ func maxAuthAttemptsExceeded(config *SshdProcessorer) error {
 	matches := maxAuthAttemptsExceededRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got maxAuthAttemptsExceeded log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -165,6 +169,7 @@ func failedPasswordAuth(config *SshdProcessorer) error {
 	matches := failedPasswordAuthRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got failedPasswordAuth log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
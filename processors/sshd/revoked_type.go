@@ -6,12 +6,14 @@ import (
 	"github.com/metal-toolbox/auditevent"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 func revokedPublicKeyByFile(config *SshdProcessorer) error {
 	matches := revokedPublicKeyByFileRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got revokedPublicKeyByFile log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -46,6 +48,7 @@ func revokedPublicKeyByFileErr(config *SshdProcessorer) error {
 	matches := revokedPublicKeyByFileErrRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got revokedPublicKeyByFileErr log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -0,0 +1,73 @@
+package sshd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+)
+
+// TestEntryProcessing_ConformsToSchemas checks that the events
+// written for each kind of sshd log entry conform to the schema
+// of their type (refer to the schemas package).
+func TestEntryProcessing_ConformsToSchemas(t *testing.T) {
+	t.Parallel()
+
+	for _, logEntry := range []string{
+		"Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519-CERT SHA256:qM6MXh9sUr+*****+IAML33tDEADBEEF " +
+			"ID satanic@panic.com (serial 1) CA ED25519 SHA256:ThisISACAChecksum+Right?",
+		"Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519-CERT SHA256:qM6MXh9sUr+*****+IAML33tDEADBEEF",
+		"Accepted password for auditomalditotesting from 127.0.0.1 port 666 ssh2",
+		"Failed password for core from 127.0.0.1 port 666 ssh2",
+		"Certificate invalid: expired",
+		"Invalid user cow from 47.8.6.9 port 64433",
+		"User walrus from 47.28.136.9 not allowed because not listed in AllowUsers",
+		"User walrus not allowed because shell /bin/nope does not exist",
+		"User walrus not allowed because shell /bin/nope is not executable",
+		"User walrus from 47.28.136.9 not allowed because listed in DenyUsers",
+		"User walrus from 47.28.136.9 not allowed because not in any group",
+		"User walrus from 47.28.136.9 not allowed because a group is listed in DenyGroups",
+		"User walrus from 47.28.136.9 not allowed because none of user's groups are listed in AllowGroups",
+		"ROOT LOGIN REFUSED FROM 47.28.136.9 port 64433",
+		"Authentication refused for walrus: bad owner or modes for /home/walrus/.ssh/authorized_keys",
+		`Nasty PTR record "evil.example.com" is set up for 47.28.136.9, ignoring`,
+		"reverse mapping checking getaddrinfo for evil.example.com [47.28.136.9] failed.",
+		"Address 47.28.136.9 maps to evil.example.com, but this does not map back to the address.",
+		"maximum authentication attempts exceeded for walrus from 47.28.136.9 port 64433 ssh2",
+		"Authentication key ED25519 SHA256:qM6MXh9sUr+*****+IAML33tDEADBEEF revoked by file /etc/ssh/revoked_keys",
+		"Error checking authentication key ED25519 SHA256:qM6MXh9sUr+*****+IAML33tDEADBEEF " +
+			"in revoked keys file /etc/ssh/revoked_keys",
+	} {
+		logEntry := logEntry
+
+		t.Run(logEntry, func(t *testing.T) {
+			t.Parallel()
+
+			enc := &testAuditEventEncoder{t: t}
+
+			err := ProcessEntry(&SshdProcessorer{
+				ctx:       context.Background(),
+				logins:    make(chan common.RemoteUserLogin, 1),
+				logEntry:  logEntry,
+				nodeName:  "testnode",
+				machineID: "testmid",
+				when:      time.Now(),
+				pid:       "666",
+				eventW:    auditevent.NewAuditEventWriter(enc),
+				metrics:   metrics.NewPrometheusMetricsProviderForRegisterer(prometheus.NewRegistry()),
+			})
+			require.NoError(t, err)
+			require.NotNil(t, enc.evt, "no event was written")
+
+			assert.NoError(t, schemas.ValidateEvent(enc.evt))
+		})
+	}
+}
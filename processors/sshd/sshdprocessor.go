@@ -13,6 +13,7 @@ import (
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/sinks"
 )
 
 type SshdProcessor interface {
@@ -24,7 +25,7 @@ func NewSshdProcessor(
 	logins chan<- common.RemoteUserLogin,
 	nodeName string,
 	machineID string,
-	eventW *auditevent.EventWriter,
+	eventW sinks.EventSink,
 	m *metrics.PrometheusMetricsProvider,
 ) SshdProcessor {
 	return &SshdProcessorer{
@@ -45,7 +46,7 @@ type SshdProcessorer struct {
 	machineID string
 	when      time.Time
 	pid       string
-	eventW    *auditevent.EventWriter
+	eventW    sinks.EventSink
 	metrics   *metrics.PrometheusMetricsProvider
 }
 
@@ -164,6 +165,8 @@ func ProcessEntry(config *SshdProcessorer) error {
 		return entryFunc(config)
 	}
 
+	config.metrics.IncUnmatchedSshdLines()
+
 	if logger.Level().Enabled(zap.DebugLevel) {
 		logger.Debugf("sshd log line did not match any regex, line: '%s'", config.logEntry)
 	}
@@ -185,6 +188,7 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 	matches := loginRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got login entry with no regular expression matches for identifiers")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -192,6 +196,7 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 	if err != nil {
 		logger.Errorf("failed to convert pid string to int ('%s') - %s",
 			config.pid, err)
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdPID)
 		return nil
 	}
 
@@ -331,12 +336,14 @@ func processAcceptedPasswordEntry(config *SshdProcessorer) error {
 	if err != nil {
 		logger.Errorf("failed to convert pid string to int ('%s') - %s",
 			config.pid, err)
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdPID)
 		return nil
 	}
 
 	matches := passwordLoginRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got processAcceptedPasswordEntry log with no string sub-matches")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
@@ -471,6 +478,7 @@ func processInvalidUserEntry(config *SshdProcessorer) error {
 	matches := invalidUserRE.FindStringSubmatch(config.logEntry)
 	if matches == nil {
 		logger.Infoln("got login entry with no regular expression matches for invalid-user")
+		config.metrics.IncParseFailures(metrics.ParseFailureSshdFields)
 		return nil
 	}
 
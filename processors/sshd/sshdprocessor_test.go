@@ -430,3 +430,43 @@ func TestEntryProcessing(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessEntry_ParseMetrics(t *testing.T) {
+	t.Parallel()
+
+	pr := prometheus.NewRegistry()
+	pprov := metrics.NewPrometheusMetricsProviderForRegisterer(pr)
+
+	for _, entry := range []struct{ logEntry, pid string }{
+		{logEntry: "Connection closed by 127.0.0.1 port 22", pid: "1"},
+		{logEntry: "Accepted password for root from 127.0.0.1 port 22 ssh2", pid: "abc"},
+		{logEntry: "Invalid user", pid: "1"},
+	} {
+		err := ProcessEntry(&SshdProcessorer{
+			ctx:      context.Background(),
+			logEntry: entry.logEntry,
+			pid:      entry.pid,
+			metrics:  pprov,
+		})
+		require.NoError(t, err)
+	}
+
+	gatheredMetrics, err := pr.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, family := range gatheredMetrics {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				name += "/" + label.GetValue()
+			}
+
+			values[name] = metric.GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, float64(1), values["audito_maldito_sshd_unmatched_lines_total"])
+	assert.Equal(t, float64(1), values["audito_maldito_parse_failures_total/"+string(metrics.ParseFailureSshdPID)])
+	assert.Equal(t, float64(1), values["audito_maldito_parse_failures_total/"+string(metrics.ParseFailureSshdFields)])
+}
@@ -0,0 +1,422 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const (
+	// DefaultBatchSize is the default maximum
+	// number of events in a request.
+	DefaultBatchSize = 100
+
+	// DefaultFlushInterval is the default interval at which
+	// incomplete batches are sent.
+	DefaultFlushInterval = 5 * time.Second
+
+	// DefaultRequestTimeout is the default timeout of a request.
+	DefaultRequestTimeout = 10 * time.Second
+
+	// DefaultMaxRetryInterval is the default maximum
+	// interval between delivery attempts.
+	DefaultMaxRetryInterval = time.Minute
+
+	// DefaultMaxQueuedBatches is the default number of
+	// batches that can be queued in memory.
+	DefaultMaxQueuedBatches = 100
+
+	// DefaultSpoolMaxBytes is the default maximum
+	// size of a spool directory.
+	DefaultSpoolMaxBytes = 100 * 1024 * 1024
+)
+
+// BatchConfig configures how the events written to a network
+// sink (such as Webhook or OTLPExporter) are batched and delivered.
+type BatchConfig struct {
+	// BatchSize is the maximum number of events in a request.
+	BatchSize int
+
+	// FlushInterval is the interval at which incomplete
+	// batches are sent.
+	FlushInterval time.Duration
+
+	// Gzip enables gzip compression of request bodies.
+	Gzip bool
+
+	// RequestTimeout is the timeout of a request.
+	RequestTimeout time.Duration
+
+	// MaxRetryInterval is the maximum interval between delivery
+	// attempts when the endpoint is unavailable. Delivery is
+	// retried with an exponential back-off.
+	MaxRetryInterval time.Duration
+
+	// MaxQueuedBatches is the number of batches that can be
+	// queued in memory. Once the memory queue is full, batches
+	// are written to SpoolDir (or dropped if SpoolDir is empty).
+	MaxQueuedBatches int
+
+	// SpoolDir is an optional directory in which batches are
+	// stored while the endpoint is unavailable. Batches found
+	// in the directory at startup are delivered first.
+	SpoolDir string
+
+	// SpoolMaxBytes is the maximum size of the batches stored
+	// in SpoolDir. Batches are dropped when the spool is full.
+	// Zero means no limit.
+	SpoolMaxBytes int64
+}
+
+func (o *BatchConfig) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = DefaultRequestTimeout
+	}
+
+	if o.MaxRetryInterval <= 0 {
+		o.MaxRetryInterval = DefaultMaxRetryInterval
+	}
+
+	if o.MaxQueuedBatches <= 0 {
+		o.MaxQueuedBatches = DefaultMaxQueuedBatches
+	}
+}
+
+// batchFormat encodes events into the body of a request.
+type batchFormat interface {
+	// appendEvent encodes the event and appends it to records.
+	appendEvent(records *bytes.Buffer, event *auditevent.AuditEvent) error
+
+	// batchBody returns the request body that contains records.
+	batchBody(records []byte) []byte
+
+	// spoolExt is the file extension of spooled batches.
+	spoolExt() string
+}
+
+// batchSender sends a batch to an endpoint. A *backoff.PermanentError
+// is returned if the endpoint rejected the batch, meaning that sending
+// it again would fail as well.
+type batchSender interface {
+	send(ctx context.Context, b *eventBatch) error
+	closeIdleConnections()
+}
+
+// batcher implements the batching, queueing and retry logic
+// that is shared by Webhook and OTLPExporter.
+type batcher struct {
+	// kind describes the sink in log messages (e.g., "webhook").
+	kind   string
+	name   string
+	config BatchConfig
+	format batchFormat
+	sender batchSender
+	l      *zap.SugaredLogger
+	pprov  *metrics.PrometheusMetricsProvider
+	notify chan struct{}
+
+	// mu protects the fields below it.
+	mu            sync.Mutex
+	queue         *batchQueue
+	nextSeq       uint64
+	pending       bytes.Buffer
+	pendingEvents int
+	pendingOldest time.Time
+}
+
+func newBatcher(kind string, name string, config BatchConfig, format batchFormat,
+	sender batchSender, l *zap.SugaredLogger, pprov *metrics.PrometheusMetricsProvider,
+) (*batcher, error) {
+	config.setDefaults()
+
+	if l == nil {
+		l = zap.NewNop().Sugar()
+	}
+
+	o := &batcher{
+		kind:   kind,
+		name:   name,
+		config: config,
+		format: format,
+		sender: sender,
+		l:      l,
+		pprov:  pprov,
+		notify: make(chan struct{}, 1),
+		queue: &batchQueue{
+			maxMemory: config.MaxQueuedBatches,
+		},
+	}
+
+	if config.SpoolDir != "" {
+		s, err := openSpool(config.SpoolDir, format.spoolExt(), config.SpoolMaxBytes, l)
+		if err != nil {
+			return nil, err
+		}
+
+		o.queue.spool = s
+		o.nextSeq = s.lastSeq() + 1
+
+		if s.len() > 0 {
+			l.Infof("%s %q found %d spooled events", kind, name, s.numEvents)
+		}
+	}
+
+	o.updateQueueDepth()
+
+	return o, nil
+}
+
+// Write adds the event to the current batch. The batch is queued
+// for delivery once it contains BatchConfig.BatchSize events.
+func (o *batcher) Write(event *auditevent.AuditEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := o.format.appendEvent(&o.pending, event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	o.pendingEvents++
+
+	if o.pendingOldest.IsZero() || event.LoggedAt.Before(o.pendingOldest) {
+		o.pendingOldest = event.LoggedAt
+	}
+
+	if o.pendingEvents >= o.config.BatchSize {
+		o.sealLocked()
+
+		select {
+		case o.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	o.updateQueueDepthLocked()
+
+	return nil
+}
+
+// Run delivers queued batches until ctx is marked as done. It then
+// makes a final attempt to deliver the remaining events, unless the
+// endpoint was unavailable, so Close only has to spool the events that
+// could not be delivered. It always returns a non-nil error.
+func (o *batcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.config.FlushInterval)
+	defer ticker.Stop()
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = o.config.MaxRetryInterval
+	bo.MaxElapsedTime = 0
+
+	// retry is non-nil while waiting to retry a failed delivery.
+	var retry <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if retry == nil {
+				o.deliverFinal()
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+			o.mu.Lock()
+			o.sealLocked()
+			o.mu.Unlock()
+
+			if retry != nil {
+				continue
+			}
+		case <-o.notify:
+			if retry != nil {
+				continue
+			}
+		case <-retry:
+			retry = nil
+		}
+
+		err := o.deliverQueued(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The endpoint may be healthy, and the delivery
+				// was only interrupted by ctx.
+				o.deliverFinal()
+
+				return ctx.Err()
+			}
+
+			wait := bo.NextBackOff()
+			o.l.Warnf("failed to deliver events to %s %q (retrying in %s) - %s",
+				o.kind, o.name, wait, err)
+
+			retry = time.After(wait)
+
+			continue
+		}
+
+		bo.Reset()
+	}
+}
+
+// deliverFinal queues the current batch and attempts to deliver the
+// queued batches once. The attempt is bounded by RequestTimeout.
+func (o *batcher) deliverFinal() {
+	o.mu.Lock()
+	o.sealLocked()
+	o.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.config.RequestTimeout)
+	defer cancel()
+
+	err := o.deliverQueued(ctx)
+	if err != nil {
+		o.l.Warnf("failed to deliver events to %s %q before stopping - %s", o.kind, o.name, err)
+	}
+}
+
+// Close queues the current batch. If a spool directory is configured,
+// batches that are queued in memory are written to it so they can be
+// delivered after a restart.
+func (o *batcher) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sealLocked()
+
+	err := o.queue.spill()
+	if err != nil {
+		return fmt.Errorf("failed to spool events for %s %q: %w", o.kind, o.name, err)
+	}
+
+	if n := len(o.queue.memory); n > 0 {
+		o.l.Warnf("%s %q is closing with %d undelivered batches", o.kind, o.name, n)
+	}
+
+	o.sender.closeIdleConnections()
+
+	return nil
+}
+
+// deliverQueued delivers queued batches until the queue is empty
+// or a delivery fails.
+func (o *batcher) deliverQueued(ctx context.Context) error {
+	for {
+		o.mu.Lock()
+		b, err := o.queue.front()
+		o.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if b == nil {
+			return nil
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, o.config.RequestTimeout)
+		err = o.sender.send(sendCtx, b)
+		cancel()
+
+		if err != nil {
+			var permanent *backoff.PermanentError
+			if !errors.As(err, &permanent) {
+				o.mu.Lock()
+				spillErr := o.queue.spill()
+				o.updateQueueDepthLocked()
+				o.mu.Unlock()
+
+				if spillErr != nil {
+					o.l.Errorf("failed to spool events for %s %q - %s", o.kind, o.name, spillErr)
+				}
+
+				return err
+			}
+
+			o.l.Errorf("%s %q rejected %d events, dropping them - %s",
+				o.kind, o.name, b.numEvents, permanent.Err)
+		} else if o.pprov != nil {
+			o.pprov.SetSinkDeliveryLag(o.name, time.Since(b.oldest))
+		}
+
+		o.mu.Lock()
+		err = o.queue.pop()
+		o.updateQueueDepthLocked()
+		o.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sealLocked turns the pending events into a batch and queues it.
+// The caller must hold mu.
+func (o *batcher) sealLocked() {
+	if o.pendingEvents == 0 {
+		return
+	}
+
+	b := &eventBatch{
+		seq:       o.nextSeq,
+		numEvents: o.pendingEvents,
+		oldest:    o.pendingOldest,
+		gzipped:   o.config.Gzip,
+	}
+
+	o.nextSeq++
+
+	body := o.format.batchBody(o.pending.Bytes())
+
+	if o.config.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write(body)
+		_ = gz.Close()
+		b.body = buf.Bytes()
+	} else {
+		b.body = append([]byte(nil), body...)
+	}
+
+	o.pending.Reset()
+	o.pendingEvents = 0
+	o.pendingOldest = time.Time{}
+
+	err := o.queue.push(b)
+	if err != nil {
+		o.l.Errorf("dropped %d events for %s %q - %s", b.numEvents, o.kind, o.name, err)
+	}
+}
+
+func (o *batcher) updateQueueDepth() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.updateQueueDepthLocked()
+}
+
+func (o *batcher) updateQueueDepthLocked() {
+	if o.pprov == nil {
+		return
+	}
+
+	o.pprov.SetSinkQueueDepth(o.name, o.queue.numEvents()+o.pendingEvents)
+}
@@ -0,0 +1,363 @@
+package sinks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	spoolDirPerms  = 0o700
+	spoolFilePerms = 0o600
+
+	spoolFileGzipExt = ".gz"
+)
+
+// eventBatch is a batch of encoded audit events.
+type eventBatch struct {
+	// seq orders batches. It increases with each new batch.
+	seq uint64
+
+	// numEvents is the number of events in body.
+	numEvents int
+
+	// oldest is the timestamp of the oldest event in body.
+	oldest time.Time
+
+	// gzipped is true if body is gzip-compressed.
+	gzipped bool
+
+	body []byte
+}
+
+// batchQueue is a FIFO queue of batches waiting to be delivered.
+// Batches are kept in memory until either the memory queue is full or
+// a delivery fails, at which point they are written to the optional
+// spool. Batches in memory are always older than the spooled ones,
+// which preserves the order in which events are delivered.
+type batchQueue struct {
+	memory    []*eventBatch
+	maxMemory int
+	spool     *spool
+}
+
+// push adds b to the back of the queue. It returns an error
+// if the queue is full, in which case b is dropped.
+func (o *batchQueue) push(b *eventBatch) error {
+	if o.spool != nil && (o.spool.len() > 0 || len(o.memory) >= o.maxMemory) {
+		return o.spool.push(b)
+	}
+
+	if len(o.memory) >= o.maxMemory {
+		return fmt.Errorf("queue is full (%d batches)", len(o.memory))
+	}
+
+	o.memory = append(o.memory, b)
+
+	return nil
+}
+
+// front returns the oldest batch without removing it
+// from the queue. It returns nil if the queue is empty.
+func (o *batchQueue) front() (*eventBatch, error) {
+	if len(o.memory) > 0 {
+		return o.memory[0], nil
+	}
+
+	if o.spool != nil && o.spool.len() > 0 {
+		return o.spool.front()
+	}
+
+	return nil, nil
+}
+
+// pop removes the oldest batch from the queue.
+func (o *batchQueue) pop() error {
+	if len(o.memory) > 0 {
+		o.memory[0] = nil
+		o.memory = o.memory[1:]
+		return nil
+	}
+
+	if o.spool != nil && o.spool.len() > 0 {
+		return o.spool.pop()
+	}
+
+	return nil
+}
+
+// spill moves the batches in memory to the spool, if any.
+func (o *batchQueue) spill() error {
+	if o.spool == nil {
+		return nil
+	}
+
+	for len(o.memory) > 0 {
+		err := o.spool.push(o.memory[0])
+		if err != nil {
+			return err
+		}
+
+		o.memory[0] = nil
+		o.memory = o.memory[1:]
+	}
+
+	return nil
+}
+
+// numEvents returns the number of events in the queue.
+func (o *batchQueue) numEvents() int {
+	n := 0
+	for _, b := range o.memory {
+		n += b.numEvents
+	}
+
+	if o.spool != nil {
+		n += o.spool.numEvents
+	}
+
+	return n
+}
+
+// openSpool opens the spool directory at dir, creating it if needed.
+// Batches that were spooled by a previous process are kept. Files
+// whose names do not describe a batch are logged and left alone.
+func openSpool(dir string, ext string, maxBytes int64, l *zap.SugaredLogger) (*spool, error) {
+	err := os.MkdirAll(dir, spoolDirPerms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &spool{
+		dir:      dir,
+		ext:      ext,
+		maxBytes: maxBytes,
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		f, ok := parseSpoolFileName(entry.Name(), ext)
+		if !ok {
+			l.Warnf("ignoring unexpected file %q in spool directory %s", entry.Name(), dir)
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spool file: %w", err)
+		}
+
+		f.size = info.Size()
+
+		s.files = append(s.files, f)
+		s.numBytes += f.size
+		s.numEvents += f.numEvents
+	}
+
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].seq < s.files[j].seq
+	})
+
+	return s, nil
+}
+
+// spool is a bounded on-disk queue of batches. Each batch is stored
+// in its own file, whose name describes the batch.
+type spool struct {
+	dir       string
+	ext       string
+	maxBytes  int64
+	files     []spoolFile
+	numBytes  int64
+	numEvents int
+}
+
+type spoolFile struct {
+	name      string
+	seq       uint64
+	numEvents int
+	oldest    time.Time
+	gzipped   bool
+	size      int64
+}
+
+// lastSeq returns the sequence number of the newest spooled batch.
+func (o *spool) lastSeq() uint64 {
+	if len(o.files) == 0 {
+		return 0
+	}
+
+	return o.files[len(o.files)-1].seq
+}
+
+func (o *spool) len() int {
+	return len(o.files)
+}
+
+func (o *spool) push(b *eventBatch) error {
+	if o.maxBytes > 0 && o.numBytes+int64(len(b.body)) > o.maxBytes {
+		return fmt.Errorf("spool is full (%d bytes)", o.numBytes)
+	}
+
+	f := spoolFile{
+		seq:       b.seq,
+		numEvents: b.numEvents,
+		oldest:    b.oldest,
+		gzipped:   b.gzipped,
+		size:      int64(len(b.body)),
+	}
+	f.name = f.fileName(o.ext)
+
+	err := writeFileSync(filepath.Join(o.dir, f.name), b.body)
+	if err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+
+	o.files = append(o.files, f)
+	o.numBytes += f.size
+	o.numEvents += f.numEvents
+
+	// Batches are pushed in order unless memory
+	// batches are spilled after newer batches.
+	if len(o.files) > 1 && o.files[len(o.files)-2].seq > f.seq {
+		sort.Slice(o.files, func(i, j int) bool {
+			return o.files[i].seq < o.files[j].seq
+		})
+	}
+
+	return nil
+}
+
+func (o *spool) front() (*eventBatch, error) {
+	f := o.files[0]
+
+	body, err := os.ReadFile(filepath.Join(o.dir, f.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool file: %w", err)
+	}
+
+	return &eventBatch{
+		seq:       f.seq,
+		numEvents: f.numEvents,
+		oldest:    f.oldest,
+		gzipped:   f.gzipped,
+		body:      body,
+	}, nil
+}
+
+func (o *spool) pop() error {
+	f := o.files[0]
+
+	o.files = o.files[1:]
+	o.numBytes -= f.size
+	o.numEvents -= f.numEvents
+
+	err := os.Remove(filepath.Join(o.dir, f.name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool file: %w", err)
+	}
+
+	return nil
+}
+
+// fileName returns the name of the file that stores the batch:
+// "<seq>-<number of events>-<oldest event unix nanoseconds><ext>[.gz]".
+// Events older than the Unix epoch (e.g., without a timestamp) are
+// written as zero, so the name does not contain a minus sign.
+func (o spoolFile) fileName(ext string) string {
+	if o.gzipped {
+		ext += spoolFileGzipExt
+	}
+
+	var oldest int64
+	if o.oldest.After(time.Unix(0, 0)) {
+		oldest = o.oldest.UnixNano()
+	}
+
+	return fmt.Sprintf("%020d-%d-%d%s", o.seq, o.numEvents, oldest, ext)
+}
+
+func parseSpoolFileName(name string, ext string) (spoolFile, bool) {
+	f := spoolFile{name: name}
+
+	base := name
+	if strings.HasSuffix(base, spoolFileGzipExt) {
+		f.gzipped = true
+		base = strings.TrimSuffix(base, spoolFileGzipExt)
+	}
+
+	if !strings.HasSuffix(base, ext) {
+		return spoolFile{}, false
+	}
+
+	base = strings.TrimSuffix(base, ext)
+
+	// Older versions wrote negative timestamps,
+	// which are kept in the last part.
+	parts := strings.SplitN(base, "-", 3)
+	if len(parts) != 3 {
+		return spoolFile{}, false
+	}
+
+	var err error
+
+	f.seq, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return spoolFile{}, false
+	}
+
+	f.numEvents, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return spoolFile{}, false
+	}
+
+	oldest, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return spoolFile{}, false
+	}
+
+	f.oldest = time.Unix(0, oldest)
+
+	return f, true
+}
+
+// writeFileSync atomically writes data to a new file at filePath.
+func writeFileSync(filePath string, data []byte) error {
+	tmpPath := filePath + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, spoolFilePerms)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
@@ -0,0 +1,66 @@
+package sinks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSpoolFile_FileName(t *testing.T) {
+	t.Parallel()
+
+	oldest := time.Unix(1700000000, 5)
+
+	for _, f := range []spoolFile{
+		{seq: 1, numEvents: 2, oldest: oldest, gzipped: true},
+		{seq: 2, numEvents: 1, oldest: time.Time{}},
+		{seq: 3, numEvents: 1, oldest: time.Date(1969, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		name := f.fileName(".ndjson")
+		assert.NotContains(t, name, "--", name)
+
+		parsed, ok := parseSpoolFileName(name, ".ndjson")
+		require.True(t, ok, name)
+		assert.Equal(t, f.seq, parsed.seq)
+		assert.Equal(t, f.numEvents, parsed.numEvents)
+		assert.Equal(t, f.gzipped, parsed.gzipped)
+	}
+
+	parsed, ok := parseSpoolFileName("00000000000000000001-2-1700000000000000005.ndjson.gz", ".ndjson")
+	require.True(t, ok)
+	assert.True(t, parsed.oldest.Equal(oldest))
+
+	// Names with a negative timestamp are still accepted.
+	parsed, ok = parseSpoolFileName("00000000000000000004-1--6795364578871345152.ndjson", ".ndjson")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4), parsed.seq)
+}
+
+func TestOpenSpool_LogsUnexpectedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := openSpool(dir, ".ndjson", 0, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	require.NoError(t, s.push(&eventBatch{seq: 1, numEvents: 1, body: []byte("{}\n")}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.ndjson"), []byte("{}\n"), spoolFilePerms))
+
+	core, logs := observer.New(zap.WarnLevel)
+
+	s, err = openSpool(dir, ".ndjson", 0, zap.New(core).Sugar())
+	require.NoError(t, err)
+
+	// The batch without a timestamp is found again.
+	require.Equal(t, 1, s.len())
+	assert.Equal(t, 1, s.numEvents)
+
+	require.Equal(t, 1, logs.Len())
+	assert.Contains(t, logs.All()[0].Message, "garbage.ndjson")
+}
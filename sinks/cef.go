@@ -0,0 +1,148 @@
+package sinks
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+var _ Encoder = &CEFEncoder{}
+
+// NewCEFEncoder returns a new CEFEncoder.
+func NewCEFEncoder() *CEFEncoder {
+	return &CEFEncoder{
+		version: common.Version(),
+	}
+}
+
+// CEFEncoder encodes audit events using the ArcSight Common Event
+// Format (CEF) version 0:
+//
+//	CEF:0|metal-toolbox|audito-maldito|<version>|<type>|<name>|<severity>|<extension>
+//
+// The event's type (e.g., "UserLogin") is the Device Event Class ID.
+// Audit event fields are mapped to the following extension keys:
+//
+//	rt          - loggedAt (milliseconds since the unix epoch)
+//	outcome     - outcome
+//	src         - source.value, if it is an IP address
+//	shost       - source.value, if it is not an IP address
+//	spt         - source.extra.port
+//	duser       - subjects.loggedAs (the local account)
+//	suser       - subjects.userID (the SSH certificate identity)
+//	dpid        - subjects.pid
+//	dhost       - target.host
+//	cs1         - target.machine-id (cs1Label=machineID)
+//	externalId  - metadata.auditId
+//	act         - metadata.extra.action
+//	filePath    - metadata.extra.object.primary
+//	cs2         - metadata.extra.command_line (cs2Label=commandLine)
+//	cat         - metadata.extra.category
+//
+// Unknown values (e.g., an "unknown" userID) are omitted.
+type CEFEncoder struct {
+	version string
+}
+
+func (o *CEFEncoder) Encode(event *auditevent.AuditEvent) ([]byte, error) {
+	f := newSIEMFields(event)
+
+	var sb strings.Builder
+
+	sb.WriteString("CEF:0|")
+	for _, v := range []string{siemVendor, siemProduct, o.version, f.eventType, f.name} {
+		sb.WriteString(cefEscapeHeader(v))
+		sb.WriteByte('|')
+	}
+	sb.WriteString(strconv.Itoa(f.severity))
+	sb.WriteByte('|')
+
+	ext := extensionWriter{
+		sb:     &sb,
+		sep:    " ",
+		escape: cefEscapeExtension,
+	}
+
+	ext.add("rt", strconv.FormatInt(f.timestampMillis, 10))
+	ext.add("outcome", f.outcome)
+	ext.add("src", f.sourceIP)
+	ext.add("shost", f.sourceHost)
+	ext.add("spt", f.sourcePort)
+	ext.add("duser", f.loggedAs)
+	ext.add("suser", f.userID)
+	ext.add("dpid", f.pid)
+	ext.add("dhost", f.host)
+	if f.machineID != "" {
+		ext.add("cs1Label", "machineID")
+		ext.add("cs1", f.machineID)
+	}
+	ext.add("externalId", f.auditID)
+	ext.add("act", f.action)
+	ext.add("filePath", f.object)
+	if f.commandLine != "" {
+		ext.add("cs2Label", "commandLine")
+		ext.add("cs2", f.commandLine)
+	}
+	ext.add("cat", f.category)
+
+	return []byte(sb.String()), nil
+}
+
+func (o *CEFEncoder) FileExt() string {
+	return ".cef"
+}
+
+func (o *CEFEncoder) ContentType() string {
+	return "text/plain"
+}
+
+// cefHeaderEscaper escapes CEF header fields. Newlines
+// are not allowed in a record and are replaced with spaces.
+var cefHeaderEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	"\r", " ",
+	"\n", " ",
+)
+
+func cefEscapeHeader(s string) string {
+	return cefHeaderEscaper.Replace(s)
+}
+
+var cefExtensionEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`=`, `\=`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+func cefEscapeExtension(s string) string {
+	return cefExtensionEscaper.Replace(s)
+}
+
+// extensionWriter writes key-value pairs separated by sep,
+// skipping pairs whose value is empty.
+type extensionWriter struct {
+	sb     *strings.Builder
+	sep    string
+	escape func(string) string
+	n      int
+}
+
+func (o *extensionWriter) add(key string, value string) {
+	if value == "" {
+		return
+	}
+
+	if o.n > 0 {
+		o.sb.WriteString(o.sep)
+	}
+
+	o.sb.WriteString(key)
+	o.sb.WriteByte('=')
+	o.sb.WriteString(o.escape(value))
+	o.n++
+}
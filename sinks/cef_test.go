@@ -0,0 +1,71 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCEFEncoder_Encode(t *testing.T) {
+	t.Parallel()
+
+	enc := &CEFEncoder{version: "1.0"}
+
+	tests := []struct {
+		name string
+		evt  *auditevent.AuditEvent
+		exp  string
+	}{
+		{
+			name: "login",
+			evt:  newSampleLoginEvent(),
+			exp: "CEF:0|metal-toolbox|audito-maldito|1.0|UserLogin|SSH login|3|" +
+				"rt=1679060221952 outcome=succeeded src=6.6.6.2 spt=59145 duser=core " +
+				"suser=user@foo.com dpid=3076344 dhost=blam cs1Label=machineID cs1=deadbeef " +
+				"externalId=ffffffff-ffff-ffff-ffff-ffffffffffff",
+		},
+		{
+			name: "failed login",
+			evt:  newSampleFailedLoginEvent(),
+			exp: "CEF:0|metal-toolbox|audito-maldito|1.0|UserLogin|SSH login|5|" +
+				"rt=1679060221952 outcome=failed src=6.6.6.2 spt=59145 duser=root " +
+				"dpid=3076344 dhost=blam cs1Label=machineID cs1=deadbeef " +
+				"externalId=ffffffff-ffff-ffff-ffff-ffffffffffff",
+		},
+		{
+			name: "action",
+			evt:  newSampleActionEvent(),
+			exp: "CEF:0|metal-toolbox|audito-maldito|1.0|UserAction|User action|8|" +
+				"rt=1679060221952 outcome=succeeded src=6.6.6.2 spt=59145 duser=core " +
+				"suser=user@foo.com dpid=3076344 dhost=blam cs1Label=machineID cs1=deadbeef " +
+				`externalId=67 act=executed filePath=/usr/bin/echo cs2Label=commandLine ` +
+				`cs2=echo 'a\=b|c\\d'\n cat=identity`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			record, err := enc.Encode(test.evt)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, string(record))
+		})
+	}
+}
+
+func TestCEFEncoder_EscapeHeader(t *testing.T) {
+	t.Parallel()
+
+	evt := newSampleLoginEvent()
+	evt.Type = `Weird|Type\`
+
+	record, err := (&CEFEncoder{version: "1.0"}).Encode(evt)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(record), `|1.0|Weird\|Type\\|Weird\|Type\\|3|`)
+}
@@ -0,0 +1,372 @@
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// The following are the kinds of ChainProblem.
+const (
+	// ChainProblemInvalid indicates a line that is not a JSON
+	// audit event or that has an invalid ChainLink.
+	ChainProblemInvalid = "invalid"
+
+	// ChainProblemUnchained indicates an event without a ChainLink.
+	ChainProblemUnchained = "unchained"
+
+	// ChainProblemModified indicates an event whose hash does
+	// not match its contents.
+	ChainProblemModified = "modified"
+
+	// ChainProblemBrokenLink indicates an event whose previous
+	// hash does not match the hash of the event before it, meaning
+	// that the previous event was modified and re-hashed.
+	ChainProblemBrokenLink = "broken-link"
+
+	// ChainProblemGap indicates missing sequence numbers.
+	ChainProblemGap = "gap"
+
+	// ChainProblemReordered indicates an event that appears after
+	// an event with a greater sequence number.
+	ChainProblemReordered = "reordered"
+
+	// ChainProblemBadSignature indicates an anchor whose signature
+	// is invalid, or that is not signed even though a key was given.
+	ChainProblemBadSignature = "bad-signature"
+
+	// ChainProblemRestart indicates a chain that starts in the middle
+	// of a file without a signature, meaning that the events before
+	// it may have been removed and the chain restarted.
+	ChainProblemRestart = "restart"
+)
+
+// ChainProblem describes a problem found by a ChainVerifier.
+type ChainProblem struct {
+	// Line is the line number of the event (starting at 1).
+	Line int `json:"line"`
+
+	// Kind is one of the ChainProblem* constants.
+	Kind string `json:"kind"`
+
+	Message string `json:"message"`
+}
+
+func (o ChainProblem) String() string {
+	return fmt.Sprintf("line %d: %s: %s", o.Line, o.Kind, o.Message)
+}
+
+// ChainReport is the result of verifying a hash chain.
+type ChainReport struct {
+	// Events is the number of events that were read.
+	Events int `json:"events"`
+
+	// Chains is the number of chains that were found. A new
+	// chain starts each time audito-maldito starts.
+	Chains int `json:"chains"`
+
+	// VerifiedAnchors is the number of anchors whose
+	// signatures were verified.
+	VerifiedAnchors int `json:"verifiedAnchors"`
+
+	// UnverifiedAnchors is the number of anchors whose
+	// signatures were not verified because no key was given.
+	UnverifiedAnchors int `json:"unverifiedAnchors"`
+
+	// UnanchoredEvents is the number of events that are not followed
+	// by a verified anchor in their chain (e.g., the events written
+	// since the last anchor). They are only protected by their hashes,
+	// which can be recomputed, so modifying them or removing them from
+	// the end of the file is not detected. It is only set if a key
+	// was given.
+	UnanchoredEvents int `json:"unanchoredEvents"`
+
+	Problems []ChainProblem `json:"problems"`
+}
+
+// OK returns true if no problems were found.
+func (o *ChainReport) OK() bool {
+	return len(o.Problems) == 0
+}
+
+// NewChainVerifier returns a new ChainVerifier. If key is not nil,
+// the signatures of anchors are verified using it, and every anchor
+// position of a chain (refer to ChainLink.AnchorInterval) must be
+// a signed anchor.
+func NewChainVerifier(key ed25519.PublicKey) *ChainVerifier {
+	return &ChainVerifier{
+		key: key,
+	}
+}
+
+// ChainVerifier verifies the hash chain of newline-delimited JSON
+// audit events written by a HashChain.
+type ChainVerifier struct {
+	key ed25519.PublicKey
+}
+
+// seqRange is an inclusive range of sequence numbers.
+type seqRange struct {
+	from, to uint64
+	line     int
+}
+
+// chainState is the state of the chain that is being verified.
+type chainState struct {
+	lastSeq  uint64
+	lastHash []byte
+	interval int
+
+	// unanchored is the number of events since
+	// the last verified anchor.
+	unanchored int
+
+	// missing are the ranges of sequence numbers that were
+	// skipped, in case they appear later on.
+	missing []seqRange
+}
+
+// Verify reads events from r and reports problems with their chain.
+// A non-nil error is returned only if reading from r fails.
+func (o *ChainVerifier) Verify(r io.Reader) (*ChainReport, error) {
+	report := &ChainReport{
+		Problems: []ChainProblem{},
+	}
+	state := &chainState{}
+
+	reader := bufio.NewReader(r)
+
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			report.Events++
+			o.verifyLine(lineNum, line, state, report)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o.endChain(state, report)
+
+	return report, nil
+}
+
+func (o *ChainVerifier) verifyLine(lineNum int, line []byte, state *chainState, report *ChainReport) {
+	addProblem := func(kind string, format string, a ...any) {
+		report.Problems = append(report.Problems, ChainProblem{
+			Line:    lineNum,
+			Kind:    kind,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	var event struct {
+		Metadata struct {
+			Extra map[string]json.RawMessage `json:"extra"`
+		} `json:"metadata"`
+	}
+
+	err := json.Unmarshal(line, &event)
+	if err != nil {
+		addProblem(ChainProblemInvalid, "failed to parse event - %s", err)
+		return
+	}
+
+	rawLink, hasIt := event.Metadata.Extra[ChainExtraKey]
+	if !hasIt {
+		addProblem(ChainProblemUnchained, "event has no chain link")
+		return
+	}
+
+	var link ChainLink
+	err = json.Unmarshal(rawLink, &link)
+	if err != nil || link.Seq == 0 {
+		addProblem(ChainProblemInvalid, "failed to parse chain link - %v", err)
+		return
+	}
+
+	if link.Version != chainVersion {
+		addProblem(ChainProblemInvalid, "unsupported chain version: %d", link.Version)
+		return
+	}
+
+	if link.AnchorInterval <= 0 {
+		addProblem(ChainProblemInvalid, "invalid anchor interval: %d", link.AnchorInterval)
+		return
+	}
+
+	prevHash, err := hex.DecodeString(link.Prev)
+	if err != nil {
+		addProblem(ChainProblemInvalid, "failed to decode previous hash - %s", err)
+		return
+	}
+
+	canonical, err := canonicalJSON(line)
+	if err != nil {
+		addProblem(ChainProblemInvalid, "failed to encode event - %s", err)
+		return
+	}
+
+	if hex.EncodeToString(chainHash(prevHash, link, canonical)) != link.Hash {
+		addProblem(ChainProblemModified, "event %d does not match its hash", link.Seq)
+	}
+
+	// The next event is linked to the hash recorded in this event,
+	// which avoids reporting a modified event twice.
+	hash, err := hex.DecodeString(link.Hash)
+	if err != nil {
+		addProblem(ChainProblemInvalid, "failed to decode hash - %s", err)
+		return
+	}
+
+	var signed, verified bool
+
+	switch {
+	case link.Sig != "":
+		verified = o.verifyAnchor(link, report, addProblem)
+		signed = verified || o.key == nil
+	case o.key != nil && link.isAnchor():
+		// Otherwise, an attacker could rewrite the
+		// chain and remove the signatures.
+		addProblem(ChainProblemBadSignature, "anchor %d is not signed", link.Seq)
+	}
+
+	switch {
+	case link.Seq == 1 && link.Prev == "":
+		// A new chain starts each time audito-maldito starts. In
+		// the middle of a file, it could also hide removed events
+		// unless it is signed.
+		if report.Chains > 0 && !signed {
+			addProblem(ChainProblemRestart, "a new chain starts without a signed anchor, "+
+				"the events before it may have been removed")
+		}
+
+		o.endChain(state, report)
+		*state = chainState{interval: link.AnchorInterval}
+		report.Chains++
+	case report.Chains == 0:
+		// The file starts in the middle of a chain (e.g., it was
+		// rotated). The first event is trusted as-is.
+		state.interval = link.AnchorInterval
+		report.Chains++
+	case link.AnchorInterval != state.interval:
+		addProblem(ChainProblemInvalid, "anchor interval of event %d changed from %d to %d",
+			link.Seq, state.interval, link.AnchorInterval)
+
+		return
+	case link.Seq <= state.lastSeq:
+		if state.fill(link.Seq) {
+			addProblem(ChainProblemReordered, "event %d appears after event %d",
+				link.Seq, state.lastSeq)
+		} else {
+			addProblem(ChainProblemReordered, "event %d is a duplicate or appears after event %d",
+				link.Seq, state.lastSeq)
+		}
+
+		// The chain continues from the last event in sequence.
+		return
+	case link.Seq > state.lastSeq+1:
+		state.missing = append(state.missing, seqRange{
+			from: state.lastSeq + 1,
+			to:   link.Seq - 1,
+			line: lineNum,
+		})
+	case !bytes.Equal(prevHash, state.lastHash):
+		addProblem(ChainProblemBrokenLink, "previous hash of event %d does not match event %d",
+			link.Seq, state.lastSeq)
+	}
+
+	state.lastSeq = link.Seq
+	state.lastHash = hash
+
+	// A verified anchor protects the events before it.
+	state.unanchored++
+	if verified {
+		state.unanchored = 0
+	}
+}
+
+// verifyAnchor verifies the signature of an anchor. It returns true
+// if the signature was verified using the ChainVerifier's key.
+func (o *ChainVerifier) verifyAnchor(link ChainLink, report *ChainReport,
+	addProblem func(kind string, format string, a ...any),
+) bool {
+	if o.key == nil {
+		report.UnverifiedAnchors++
+		return false
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(link.Sig)
+	if err != nil || !ed25519.Verify(o.key, anchorMessage(link.Seq, link.Hash), sig) {
+		addProblem(ChainProblemBadSignature, "anchor %d has an invalid signature", link.Seq)
+		return false
+	}
+
+	report.VerifiedAnchors++
+
+	return true
+}
+
+// endChain reports the problems and the unanchored
+// events of the chain that ends.
+func (o *ChainVerifier) endChain(state *chainState, report *ChainReport) {
+	o.reportGaps(state, report)
+
+	if o.key != nil {
+		report.UnanchoredEvents += state.unanchored
+	}
+}
+
+// reportGaps adds a problem for each range of sequence
+// numbers that are still missing.
+func (o *ChainVerifier) reportGaps(state *chainState, report *ChainReport) {
+	for _, r := range state.missing {
+		message := fmt.Sprintf("event %d is missing", r.from)
+		if r.from != r.to {
+			message = fmt.Sprintf("events %d to %d are missing", r.from, r.to)
+		}
+
+		report.Problems = append(report.Problems, ChainProblem{
+			Line:    r.line,
+			Kind:    ChainProblemGap,
+			Message: message,
+		})
+	}
+
+	state.missing = nil
+}
+
+// fill removes seq from the missing sequence numbers. It returns
+// false if seq was not missing.
+func (o *chainState) fill(seq uint64) bool {
+	for i, r := range o.missing {
+		if seq < r.from || seq > r.to {
+			continue
+		}
+
+		var replacement []seqRange
+		if seq > r.from {
+			replacement = append(replacement, seqRange{from: r.from, to: seq - 1, line: r.line})
+		}
+		if seq < r.to {
+			replacement = append(replacement, seqRange{from: seq + 1, to: r.to, line: r.line})
+		}
+
+		o.missing = append(o.missing[:i], append(replacement, o.missing[i+1:]...)...)
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,3 @@
+// Package sinks provides the destinations that audit events are written
+// to, such as files, the standard output and network collectors.
+package sinks
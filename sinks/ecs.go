@@ -0,0 +1,326 @@
+package sinks
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// ECSVersion is the version of the Elastic Common Schema
+// that ECSEncoder conforms to.
+const ECSVersion = "8.11.0"
+
+// ecsNamespace is the name of the custom field set containing
+// fields that have no equivalent in ECS.
+const ecsNamespace = "audito_maldito"
+
+var _ Encoder = ECSEncoder{}
+
+// ECSEncoder encodes audit events as JSON objects that conform
+// to the Elastic Common Schema (ECS). Audit event fields are
+// mapped to the following ECS fields:
+//
+//	@timestamp                - loggedAt
+//	event.action              - metadata.extra.action (e.g., "executed"),
+//	                            or type if there is no action
+//	event.category            - derived from type (refer to ecsCategorization)
+//	event.type                - derived from type and outcome
+//	event.outcome             - outcome ("success" or "failure")
+//	event.dataset             - "audito_maldito." followed by component
+//	source.ip                 - source.value, if it is an IP address
+//	source.domain             - source.value, if it is not an IP address
+//	source.port               - source.extra.port
+//	user.name                 - subjects.loggedAs
+//	user.id                   - subjects.userID (the SSH certificate identity)
+//	process.pid               - subjects.pid (UserLogin events only)
+//	process.executable        - metadata.extra.object.primary for executions,
+//	                            otherwise the first process argument
+//	process.args              - metadata.extra.process_args
+//	process.command_line      - metadata.extra.command_line
+//	process.working_directory - metadata.extra.cwd
+//	host.name                 - target.host
+//	host.id                   - target.machine-id
+//
+// Fields without an ECS equivalent are set in the "audito_maldito"
+// field set: the event type, the audit session ID, the SSH key and
+// certificate details (serial and CA) and the remaining metadata.
+type ECSEncoder struct{}
+
+type ecsDocument struct {
+	Timestamp time.Time      `json:"@timestamp"`
+	ECS       ecsVersion     `json:"ecs"`
+	Event     ecsEvent       `json:"event"`
+	Source    *ecsSource     `json:"source,omitempty"`
+	User      *ecsUser       `json:"user,omitempty"`
+	Process   *ecsProcess    `json:"process,omitempty"`
+	Host      *ecsHost       `json:"host,omitempty"`
+	Custom    ecsCustomField `json:"audito_maldito"`
+}
+
+type ecsVersion struct {
+	Version string `json:"version"`
+}
+
+type ecsEvent struct {
+	Kind     string   `json:"kind"`
+	Category []string `json:"category"`
+	Type     []string `json:"type"`
+	Action   string   `json:"action"`
+	Outcome  string   `json:"outcome"`
+	Dataset  string   `json:"dataset,omitempty"`
+	Module   string   `json:"module"`
+}
+
+type ecsSource struct {
+	IP     string `json:"ip,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	Port   int    `json:"port,omitempty"`
+}
+
+type ecsUser struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+type ecsProcess struct {
+	PID              int      `json:"pid,omitempty"`
+	Executable       string   `json:"executable,omitempty"`
+	Args             []string `json:"args,omitempty"`
+	ArgsCount        int      `json:"args_count,omitempty"`
+	CommandLine      string   `json:"command_line,omitempty"`
+	WorkingDirectory string   `json:"working_directory,omitempty"`
+}
+
+type ecsHost struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+type ecsCustomField struct {
+	EventType string         `json:"event_type"`
+	AuditID   string         `json:"audit_id,omitempty"`
+	SSH       *ecsSSH        `json:"ssh,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+}
+
+type ecsSSH struct {
+	Algorithm string          `json:"algorithm,omitempty"`
+	KeySum    string          `json:"key_sum,omitempty"`
+	Cert      *ecsCertificate `json:"cert,omitempty"`
+}
+
+type ecsCertificate struct {
+	Serial string `json:"serial,omitempty"`
+	CA     string `json:"ca,omitempty"`
+}
+
+// ecsMappedMetadata are the metadata.extra keys that are mapped to
+// ECS fields and are therefore not copied to the custom field set.
+var ecsMappedMetadata = map[string]struct{}{
+	"process_args": {},
+	"command_line": {},
+	"cwd":          {},
+}
+
+func (ECSEncoder) Encode(event *auditevent.AuditEvent) ([]byte, error) {
+	f := newSIEMFields(event)
+
+	doc := ecsDocument{
+		Timestamp: event.LoggedAt,
+		ECS:       ecsVersion{Version: ECSVersion},
+		Event: ecsEvent{
+			Kind:    "event",
+			Action:  f.action,
+			Outcome: ecsOutcome(event.Outcome),
+			Module:  ecsNamespace,
+		},
+		Custom: ecsCustomField{
+			EventType: event.Type,
+			AuditID:   event.Metadata.AuditID,
+		},
+	}
+
+	doc.Event.Category, doc.Event.Type = ecsCategorization(event.Type, event.Outcome, f.action)
+
+	if doc.Event.Action == "" {
+		doc.Event.Action = event.Type
+	}
+
+	if event.Component != "" {
+		doc.Event.Dataset = ecsNamespace + "." + event.Component
+	}
+
+	if f.sourceIP != "" || f.sourceHost != "" {
+		port, _ := strconv.Atoi(f.sourcePort)
+		doc.Source = &ecsSource{
+			IP:     f.sourceIP,
+			Domain: f.sourceHost,
+			Port:   port,
+		}
+	}
+
+	if f.loggedAs != "" || f.userID != "" {
+		doc.User = &ecsUser{
+			Name: f.loggedAs,
+			ID:   f.userID,
+		}
+	}
+
+	if f.host != "" || f.machineID != "" {
+		doc.Host = &ecsHost{
+			Name: f.host,
+			ID:   f.machineID,
+		}
+	}
+
+	doc.Process = ecsProcessFields(event, f)
+	doc.Custom.SSH = ecsSSHFields(event.Data)
+
+	for k, v := range event.Metadata.Extra {
+		if _, mapped := ecsMappedMetadata[k]; mapped {
+			continue
+		}
+
+		if doc.Custom.Metadata == nil {
+			doc.Custom.Metadata = make(map[string]any)
+		}
+
+		doc.Custom.Metadata[k] = v
+	}
+
+	return json.Marshal(doc)
+}
+
+func (ECSEncoder) FileExt() string {
+	return ".ndjson"
+}
+
+func (ECSEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// ecsCategorization returns the ECS event.category
+// and event.type values of an audit event.
+func ecsCategorization(eventType string, outcome string, action string) ([]string, []string) {
+	switch eventType {
+	case common.ActionLoginIdentifier:
+		if outcome == auditevent.OutcomeSucceeded {
+			return []string{"authentication", "session"}, []string{"start"}
+		}
+
+		return []string{"authentication"}, []string{"info"}
+	case common.ActionUserAction:
+		if action == "executed" {
+			return []string{"process"}, []string{"start"}
+		}
+
+		return []string{"process"}, []string{"info"}
+	case common.ActionUserNetworkActivity:
+		return []string{"network"}, []string{"connection"}
+	case common.ActionSessionTranscript:
+		return []string{"session"}, []string{"info"}
+	case common.ActionAgentLifecycle:
+		switch action {
+		case "start":
+			return []string{"process"}, []string{"start"}
+		case "stop":
+			return []string{"process"}, []string{"end"}
+		case "reload":
+			return []string{"configuration"}, []string{"change"}
+		}
+
+		return []string{"host"}, []string{"info"}
+	default:
+		return []string{"host"}, []string{"info"}
+	}
+}
+
+func ecsOutcome(outcome string) string {
+	switch outcome {
+	case auditevent.OutcomeSucceeded:
+		return "success"
+	case auditevent.OutcomeFailed:
+		return "failure"
+	default:
+		return "unknown"
+	}
+}
+
+func ecsProcessFields(event *auditevent.AuditEvent, f siemFields) *ecsProcess {
+	p := &ecsProcess{
+		CommandLine:      f.commandLine,
+		WorkingDirectory: stringField(event.Metadata.Extra, "cwd"),
+		Args:             stringsField(event.Metadata.Extra, "process_args"),
+	}
+
+	p.ArgsCount = len(p.Args)
+
+	if event.Type == common.ActionLoginIdentifier {
+		p.PID, _ = strconv.Atoi(f.pid)
+	}
+
+	switch {
+	case f.action == "executed" && f.object != "":
+		p.Executable = f.object
+	case len(p.Args) > 0:
+		p.Executable = p.Args[0]
+	}
+
+	if p.PID == 0 && p.Executable == "" && p.CommandLine == "" && p.WorkingDirectory == "" {
+		return nil
+	}
+
+	return p
+}
+
+// ecsSSHFields returns the SSH key and certificate
+// details found in a UserLogin event's data.
+func ecsSSHFields(data *json.RawMessage) *ecsSSH {
+	if data == nil {
+		return nil
+	}
+
+	var m map[string]string
+	if json.Unmarshal(*data, &m) != nil {
+		return nil
+	}
+
+	ssh := &ecsSSH{
+		Algorithm: m["Alg"],
+		KeySum:    m["SSHKeySum"],
+	}
+
+	if m["Serial"] != "" || m["CA"] != "" {
+		ssh.Cert = &ecsCertificate{
+			Serial: m["Serial"],
+			CA:     m["CA"],
+		}
+	}
+
+	if ssh.Algorithm == "" && ssh.KeySum == "" && ssh.Cert == nil {
+		return nil
+	}
+
+	return ssh
+}
+
+// stringsField returns m[key] as a string slice. It returns nil
+// if the key does not exist or its value is not a list.
+func stringsField(m map[string]any, key string) []string {
+	switch v := m[key].(type) {
+	case []string:
+		return v
+	case []any:
+		s := make([]string, len(v))
+		for i := range v {
+			s[i], _ = v[i].(string)
+		}
+		return s
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package sinks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECSEncoder_Login(t *testing.T) {
+	t.Parallel()
+
+	evt := newSampleLoginEvent()
+	data := json.RawMessage(
+		`{"Alg":"ECDSA-CERT SHA256","CA":"CA ED25519 SHA256:JKH45","SSHKeySum":"JKH45","Serial":"350"}`)
+	evt.Data = &data
+
+	record, err := ECSEncoder{}.Encode(evt)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"@timestamp": "2023-03-17T13:37:01.952Z",
+		"ecs": {"version": "8.11.0"},
+		"event": {
+			"kind": "event",
+			"category": ["authentication", "session"],
+			"type": ["start"],
+			"action": "UserLogin",
+			"outcome": "success",
+			"dataset": "audito_maldito.sshd",
+			"module": "audito_maldito"
+		},
+		"source": {"ip": "6.6.6.2", "port": 59145},
+		"user": {"name": "core", "id": "user@foo.com"},
+		"process": {"pid": 3076344},
+		"host": {"name": "blam", "id": "deadbeef"},
+		"audito_maldito": {
+			"event_type": "UserLogin",
+			"audit_id": "ffffffff-ffff-ffff-ffff-ffffffffffff",
+			"ssh": {
+				"algorithm": "ECDSA-CERT SHA256",
+				"key_sum": "JKH45",
+				"cert": {"serial": "350", "ca": "CA ED25519 SHA256:JKH45"}
+			}
+		}
+	}`, string(record))
+}
+
+func TestECSEncoder_FailedLogin(t *testing.T) {
+	t.Parallel()
+
+	record, err := ECSEncoder{}.Encode(newSampleFailedLoginEvent())
+	require.NoError(t, err)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(record, &doc))
+
+	event := doc["event"].(map[string]any)
+	assert.Equal(t, "failure", event["outcome"])
+	assert.Equal(t, []any{"authentication"}, event["category"])
+	assert.Equal(t, []any{"info"}, event["type"])
+
+	// The "unknown" user ID is omitted.
+	assert.Equal(t, map[string]any{"name": "root"}, doc["user"])
+}
+
+func TestECSEncoder_Action(t *testing.T) {
+	t.Parallel()
+
+	evt := newSampleActionEvent()
+	evt.Metadata.Extra["process_args"] = []string{"echo", "a=b|c\\d\n"}
+	evt.Metadata.Extra["cwd"] = "/home/core"
+
+	record, err := ECSEncoder{}.Encode(evt)
+	require.NoError(t, err)
+
+	var doc struct {
+		Event   ecsEvent       `json:"event"`
+		Process ecsProcess     `json:"process"`
+		Custom  ecsCustomField `json:"audito_maldito"`
+	}
+	require.NoError(t, json.Unmarshal(record, &doc))
+
+	assert.Equal(t, "executed", doc.Event.Action)
+	assert.Equal(t, []string{"process"}, doc.Event.Category)
+	assert.Equal(t, []string{"start"}, doc.Event.Type)
+
+	assert.Equal(t, ecsProcess{
+		Executable:       "/usr/bin/echo",
+		Args:             []string{"echo", "a=b|c\\d\n"},
+		ArgsCount:        2,
+		CommandLine:      "echo 'a=b|c\\d'\n",
+		WorkingDirectory: "/home/core",
+	}, doc.Process)
+
+	assert.Equal(t, "UserAction", doc.Custom.EventType)
+	assert.Equal(t, "67", doc.Custom.AuditID)
+	assert.Nil(t, doc.Custom.SSH)
+	assert.Equal(t, "high", doc.Custom.Metadata["severity"])
+	assert.NotContains(t, doc.Custom.Metadata, "process_args")
+	assert.NotContains(t, doc.Custom.Metadata, "cwd")
+}
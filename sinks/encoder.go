@@ -0,0 +1,105 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+// Format is the name of an audit event encoding.
+type Format string
+
+const (
+	// FormatJSON encodes events using the auditevent JSON schema.
+	FormatJSON Format = "json"
+
+	// FormatCEF encodes events using the ArcSight Common Event Format.
+	FormatCEF Format = "cef"
+
+	// FormatLEEF encodes events using the QRadar Log Event
+	// Extended Format.
+	FormatLEEF Format = "leef"
+
+	// FormatECS encodes events using the Elastic Common Schema.
+	FormatECS Format = "ecs"
+)
+
+// Encoder encodes an audit event into a single record. Records
+// must not contain newline characters, allowing them to be
+// delimited by newlines.
+type Encoder interface {
+	Encode(event *auditevent.AuditEvent) ([]byte, error)
+
+	// ContentType returns the MIME type of
+	// newline-delimited records.
+	ContentType() string
+
+	// FileExt returns the file name extension of
+	// newline-delimited records (e.g., ".ndjson").
+	FileExt() string
+}
+
+// NewEncoder returns the Encoder for the given Format.
+func NewEncoder(format Format) (Encoder, error) {
+	switch format {
+	case FormatJSON, "":
+		return JSONEncoder{}, nil
+	case FormatCEF:
+		return NewCEFEncoder(), nil
+	case FormatLEEF:
+		return NewLEEFEncoder(), nil
+	case FormatECS:
+		return ECSEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %q", format)
+	}
+}
+
+// JSONEncoder encodes audit events as JSON objects.
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(event *auditevent.AuditEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (JSONEncoder) FileExt() string {
+	return ".ndjson"
+}
+
+func (JSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// newLineEncoder returns an auditevent.EventEncoder that writes
+// records produced by enc to w, each followed by a newline.
+func newLineEncoder(w io.Writer, enc Encoder) *lineEncoder {
+	return &lineEncoder{
+		w:   w,
+		enc: enc,
+	}
+}
+
+type lineEncoder struct {
+	w   io.Writer
+	enc Encoder
+}
+
+func (o *lineEncoder) Encode(v any) error {
+	event, ok := v.(*auditevent.AuditEvent)
+	if !ok {
+		return fmt.Errorf("cannot encode %T", v)
+	}
+
+	record, err := o.enc.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	// A single call to Write ensures each record
+	// is sent in its own datagram by NetWriter.
+	_, err = o.w.Write(append(record, '\n'))
+
+	return err
+}
@@ -0,0 +1,63 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEncoder(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []Format{"", FormatJSON, FormatCEF, FormatLEEF, FormatECS} {
+		enc, err := NewEncoder(format)
+		require.NoError(t, err)
+		assert.NotNil(t, enc)
+	}
+
+	for format, ext := range map[Format]string{
+		FormatJSON: ".ndjson",
+		FormatCEF:  ".cef",
+		FormatLEEF: ".leef",
+		FormatECS:  ".ndjson",
+	} {
+		enc, err := NewEncoder(format)
+		require.NoError(t, err)
+		assert.Equal(t, ext, enc.FileExt(), format)
+	}
+
+	_, err := NewEncoder("xml")
+	assert.Error(t, err)
+}
+
+func TestEncodingWriterSink(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	sink := NewEncodingWriterSink(&buf, NewCEFEncoder())
+	require.NoError(t, sink.Write(newSampleActionEvent()))
+	require.NoError(t, sink.Write(newSampleLoginEvent()))
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.True(t, bytes.HasPrefix(lines[0], []byte("CEF:0|")))
+	assert.True(t, bytes.HasPrefix(lines[1], []byte("CEF:0|")))
+}
+
+func TestEncodingWriterSink_JSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	sink := NewEncodingWriterSink(&buf, JSONEncoder{})
+	require.NoError(t, sink.Write(newSampleLoginEvent()))
+
+	var evt auditevent.AuditEvent
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &evt))
+	assert.Equal(t, "UserLogin", evt.Type)
+}
@@ -0,0 +1,347 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+var _ EventSink = &FanOut{}
+
+// fanOutFlushPollInterval is the interval at which FanOut.Flush
+// checks whether the buffered outputs' queues are empty.
+const fanOutFlushPollInterval = 10 * time.Millisecond
+
+// ErrOutputBufferFull is the error passed to a FanOut's failure
+// handler when an event is dropped because an output's buffer is full.
+var ErrOutputBufferFull = errors.New("buffer is full")
+
+// ErrOutputStopped is returned (or passed to a FanOut's failure handler)
+// when an event is written to a buffered output after the FanOut's Run
+// method stopped writing to it.
+var ErrOutputStopped = errors.New("output is not running")
+
+// FailureHandler is called when an output that does not use
+// ErrorPolicyFail fails to write an event or drops it. It must
+// not block, and must not write events to the FanOut.
+type FailureHandler func(output string, err error)
+
+// NewFanOut returns a new FanOut that writes events to outputs.
+func NewFanOut(l *zap.SugaredLogger, outputs ...*Output) *FanOut {
+	if l == nil {
+		l = zap.NewNop().Sugar()
+	}
+
+	fanOutputs := make([]*fanOutput, len(outputs))
+	for i, output := range outputs {
+		fo := &fanOutput{
+			Output: output,
+		}
+
+		if output.BufferSize > 0 {
+			fo.events = make(chan *auditevent.AuditEvent, output.BufferSize)
+			fo.done = make(chan struct{})
+		}
+
+		fanOutputs[i] = fo
+	}
+
+	return &FanOut{
+		outputs: fanOutputs,
+		l:       l,
+	}
+}
+
+// FanOut is an EventSink that writes each audit event to
+// several Output. Each Output has its own filter, buffer and
+// error policy, which allows one output to fail without
+// affecting the others.
+//
+// Buffered outputs are written to by the Run method, which
+// must be running for events to reach them.
+type FanOut struct {
+	outputs   []*fanOutput
+	metrics   *metrics.PrometheusMetricsProvider
+	onFailure FailureHandler
+	l         *zap.SugaredLogger
+}
+
+// WithMetrics sets the metrics provider used to count the events written
+// by each output, their latency and the outputs' errors. It returns the
+// FanOut for ease of use as a builder.
+func (o *FanOut) WithMetrics(m *metrics.PrometheusMetricsProvider) *FanOut {
+	o.metrics = m
+	return o
+}
+
+// WithFailureHandler sets the function that is told about the events
+// that outputs failed to write or dropped, even though the failures are
+// not fatal. It returns the FanOut for ease of use as a builder.
+func (o *FanOut) WithFailureHandler(fn FailureHandler) *FanOut {
+	o.onFailure = fn
+	return o
+}
+
+type fanOutput struct {
+	*Output
+	events chan *auditevent.AuditEvent
+
+	// done is closed by Run once it stops writing
+	// the queued events to a buffered output.
+	done chan struct{}
+
+	// pending is the number of queued events that
+	// have not been written to the output yet.
+	pending atomic.Int64
+}
+
+// stopped returns true if the output is buffered and
+// Run no longer writes the queued events to it.
+func (o *fanOutput) stopped() bool {
+	if o.done == nil {
+		return false
+	}
+
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Write writes the event to each output that allows it. A non-nil
+// error is returned if an output that uses ErrorPolicyFail fails to
+// write the event, or if it is buffered and no longer running.
+func (o *FanOut) Write(event *auditevent.AuditEvent) error {
+	for _, output := range o.outputs {
+		if !output.allows(event) {
+			continue
+		}
+
+		var err error
+		if output.events != nil {
+			err = o.enqueue(output, event)
+		} else {
+			err = o.writeOutput(output, event)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOutput writes the event to the output's EventSink. A non-nil error
+// is returned if the write fails and the output uses ErrorPolicyFail.
+func (o *FanOut) writeOutput(output *fanOutput, event *auditevent.AuditEvent) error {
+	err := output.Sink.Write(event)
+	if err == nil {
+		o.metrics.ObserveEventWritten(event.Type, output.Name, event.LoggedAt)
+		return nil
+	}
+
+	if output.errorPolicy() == ErrorPolicyFail {
+		return fmt.Errorf("failed to write event to output %q: %w", output.Name, err)
+	}
+
+	o.metrics.IncErrors(metrics.ErrorTypeSinkWrite)
+	o.l.Errorf("failed to write event to output %q - %s", output.Name, err)
+	o.failed(output, err)
+
+	return nil
+}
+
+func (o *FanOut) failed(output *fanOutput, err error) {
+	if o.onFailure != nil {
+		o.onFailure(output.Name, err)
+	}
+}
+
+// enqueue adds the event to a buffered output's queue. Outputs that
+// use ErrorPolicyFail wait for room in the queue, unless Run stopped
+// writing to the output, in which case a non-nil error is returned.
+// Other outputs drop the event instead.
+func (o *FanOut) enqueue(output *fanOutput, event *auditevent.AuditEvent) error {
+	if output.stopped() {
+		return o.dropped(output, ErrOutputStopped)
+	}
+
+	output.pending.Add(1)
+
+	if output.errorPolicy() == ErrorPolicyFail {
+		select {
+		case output.events <- event:
+			return nil
+		case <-output.done:
+			output.pending.Add(-1)
+			return o.dropped(output, ErrOutputStopped)
+		}
+	}
+
+	select {
+	case output.events <- event:
+	default:
+		output.pending.Add(-1)
+
+		return o.dropped(output, ErrOutputBufferFull)
+	}
+
+	return nil
+}
+
+// dropped reports an event that could not be queued for a buffered
+// output. A non-nil error is returned if the output uses ErrorPolicyFail.
+func (o *FanOut) dropped(output *fanOutput, reason error) error {
+	if output.errorPolicy() == ErrorPolicyFail {
+		return fmt.Errorf("failed to write event to output %q: %w", output.Name, reason)
+	}
+
+	o.metrics.IncErrors(metrics.ErrorTypeEventDropped)
+
+	if errors.Is(reason, ErrOutputBufferFull) {
+		o.l.Errorf("dropped event for output %q: buffer is full (size: %d)",
+			output.Name, cap(output.events))
+	} else {
+		o.l.Errorf("dropped event for output %q - %s", output.Name, reason)
+	}
+
+	o.failed(output, reason)
+
+	return nil
+}
+
+// Run writes events to buffered outputs and runs each output's
+// EventSink that implements Runner until ctx is marked as done.
+// It returns a non-nil error if a buffered output that uses
+// ErrorPolicyFail fails to write an event.
+func (o *FanOut) Run(ctx context.Context) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	for _, output := range o.outputs {
+		output := output
+
+		if runner, ok := output.Sink.(Runner); ok {
+			eg.Go(func() error {
+				return runner.Run(egCtx)
+			})
+		}
+
+		if output.events == nil {
+			continue
+		}
+
+		eg.Go(func() error {
+			defer close(output.done)
+
+			return o.runOutput(egCtx, output)
+		})
+	}
+
+	eg.Go(func() error {
+		<-egCtx.Done()
+		return egCtx.Err()
+	})
+
+	return eg.Wait()
+}
+
+func (o *FanOut) runOutput(ctx context.Context, output *fanOutput) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-output.events:
+			err := o.writeOutput(output, event)
+			output.pending.Add(-1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Flush waits until the events queued for buffered outputs have been
+// written to them, or until ctx is marked as done. Run must be running
+// for the queues to be emptied: a non-nil error is returned as soon as
+// Run has stopped writing to the outputs that still have queued events.
+// Events written concurrently with Flush may or may not be waited for.
+func (o *FanOut) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(fanOutFlushPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var pending, lost int64
+		for _, output := range o.outputs {
+			n := output.pending.Load()
+			if n > 0 && output.stopped() {
+				lost += n
+			} else {
+				pending += n
+			}
+		}
+
+		if pending == 0 {
+			if lost > 0 {
+				return fmt.Errorf("failed to flush %d event(s): %w", lost, ErrOutputStopped)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to flush %d event(s): %w", pending, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reopen reopens each output's EventSink that implements Reopener.
+func (o *FanOut) Reopen() error {
+	var firstErr error
+
+	for _, output := range o.outputs {
+		reopener, ok := output.Sink.(Reopener)
+		if !ok {
+			continue
+		}
+
+		err := reopener.Reopen()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to reopen output %q: %w", output.Name, err)
+		}
+	}
+
+	return firstErr
+}
+
+// Close closes each output's EventSink that implements io.Closer.
+func (o *FanOut) Close() error {
+	var firstErr error
+
+	for _, output := range o.outputs {
+		closer, ok := output.Sink.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		err := closer.Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close output %q: %w", output.Name, err)
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,369 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+type testSink struct {
+	mu     sync.Mutex
+	events []*auditevent.AuditEvent
+	err    error
+	closed bool
+}
+
+func (o *testSink) Write(event *auditevent.AuditEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err != nil {
+		return o.err
+	}
+
+	o.events = append(o.events, event)
+
+	return nil
+}
+
+func (o *testSink) Close() error {
+	o.closed = true
+	return nil
+}
+
+func (o *testSink) numEvents() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.events)
+}
+
+func TestFanOut_Write(t *testing.T) {
+	t.Parallel()
+
+	all := &testSink{}
+	logins := &testSink{}
+
+	fo := NewFanOut(nil,
+		&Output{Name: "all", Sink: all},
+		&Output{Name: "logins", Sink: logins, Filter: NewTypeFilter([]string{"UserLogin"})},
+	)
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserLogin").Build()))
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	assert.Equal(t, 2, all.numEvents())
+	assert.Equal(t, 1, logins.numEvents())
+	assert.Equal(t, "UserLogin", logins.events[0].Type)
+}
+
+func TestFanOut_WriteErrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	failing := &testSink{err: errTest}
+	other := &testSink{}
+
+	fo := NewFanOut(nil,
+		&Output{Name: "failing", Sink: failing, OnError: ErrorPolicyLog},
+		&Output{Name: "other", Sink: other},
+	)
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserLogin").Build()))
+	assert.Equal(t, 1, other.numEvents())
+
+	fo = NewFanOut(nil,
+		&Output{Name: "failing", Sink: failing, OnError: ErrorPolicyFail},
+	)
+
+	err := fo.Write(testtools.NewEventBuilder("UserLogin").Build())
+	assert.ErrorIs(t, err, errTest)
+}
+
+func TestFanOut_RunBuffered(t *testing.T) {
+	t.Parallel()
+
+	buffered := &testSink{}
+
+	fo := NewFanOut(nil, &Output{Name: "buffered", Sink: buffered, BufferSize: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- fo.Run(ctx)
+	}()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+	}
+
+	require.Eventually(t, func() bool {
+		return buffered.numEvents() == 5
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+}
+
+func TestFanOut_RunBufferedFail(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	fo := NewFanOut(nil, &Output{
+		Name:       "buffered",
+		Sink:       &testSink{err: errTest},
+		BufferSize: 1,
+		OnError:    ErrorPolicyFail,
+	})
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	err := fo.Run(context.Background())
+	assert.ErrorIs(t, err, errTest)
+}
+
+func TestFanOut_WriteAfterBufferedFail(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	fo := NewFanOut(nil, &Output{
+		Name:       "buffered",
+		Sink:       &testSink{err: errTest},
+		BufferSize: 1,
+		OnError:    ErrorPolicyFail,
+	})
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+	assert.ErrorIs(t, fo.Run(context.Background()), errTest)
+
+	// Writes do not block once the buffer is full,
+	// since Run no longer empties it.
+	writeErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := fo.Write(testtools.NewEventBuilder("UserAction").Build())
+			if err != nil {
+				writeErr <- err
+				return
+			}
+		}
+
+		writeErr <- nil
+	}()
+
+	select {
+	case err := <-writeErr:
+		assert.ErrorIs(t, err, ErrOutputStopped)
+	case <-time.After(time.Second):
+		t.Fatal("write blocked after the output failed")
+	}
+
+	// Flush does not wait for the deadline either.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	assert.NoError(t, fo.Flush(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFanOut_FlushAfterRun(t *testing.T) {
+	t.Parallel()
+
+	fo := NewFanOut(nil, &Output{
+		Name:       "buffered",
+		Sink:       &testSink{},
+		BufferSize: 10,
+		OnError:    ErrorPolicyLog,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, fo.Run(ctx), context.Canceled)
+
+	// The event is dropped rather than queued.
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+	assert.Len(t, fo.outputs[0].events, 0)
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelFlush()
+
+	assert.NoError(t, fo.Flush(flushCtx))
+}
+
+func TestFanOut_BufferFullDropsEvents(t *testing.T) {
+	t.Parallel()
+
+	buffered := &testSink{}
+
+	fo := NewFanOut(nil, &Output{
+		Name:       "buffered",
+		Sink:       buffered,
+		BufferSize: 1,
+		OnError:    ErrorPolicyLog,
+	})
+
+	// Run is not running, so the second event does not fit.
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	assert.Len(t, fo.outputs[0].events, 1)
+}
+
+func TestFanOut_Flush(t *testing.T) {
+	t.Parallel()
+
+	buffered := &testSink{}
+
+	fo := NewFanOut(nil, &Output{Name: "buffered", Sink: buffered, BufferSize: 10})
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = fo.Run(ctx)
+	}()
+
+	require.NoError(t, fo.Flush(ctx))
+	assert.Equal(t, 5, buffered.numEvents())
+}
+
+func TestFanOut_FlushTimeout(t *testing.T) {
+	t.Parallel()
+
+	fo := NewFanOut(nil, &Output{Name: "buffered", Sink: &testSink{}, BufferSize: 10})
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	// Run is not running, so the queue is never emptied.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, fo.Flush(ctx), context.DeadlineExceeded)
+}
+
+func TestFanOut_Close(t *testing.T) {
+	t.Parallel()
+
+	sink := &testSink{}
+
+	fo := NewFanOut(nil, &Output{Name: "sink", Sink: sink})
+
+	require.NoError(t, fo.Close())
+	assert.True(t, sink.closed)
+}
+
+// metricValue returns the value of the counter or the sample count
+// of the histogram with the given name and labels.
+func metricValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			metricLabels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				metricLabels[label.GetName()] = label.GetValue()
+			}
+
+			if !reflect.DeepEqual(labels, metricLabels) {
+				continue
+			}
+
+			if metric.GetHistogram() != nil {
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+
+			return metric.GetCounter().GetValue()
+		}
+	}
+
+	t.Fatalf("metric %q with labels %v not found", name, labels)
+
+	return 0
+}
+
+func TestFanOut_Metrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+
+	fo := NewFanOut(nil,
+		&Output{Name: "ok", Sink: &testSink{}},
+		&Output{Name: "failing", Sink: &testSink{err: errors.New("oops")}, OnError: ErrorPolicyLog},
+		&Output{Name: "full", Sink: &testSink{}, BufferSize: 1, OnError: ErrorPolicyLog},
+	).WithMetrics(metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+
+	evt := testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()
+	evt.LoggedAt = time.Now().Add(-time.Second)
+
+	// The buffered output's queue is not emptied,
+	// as Run is not running.
+	require.NoError(t, fo.Write(evt))
+	require.NoError(t, fo.Write(evt))
+
+	prefix := metrics.MetricsNamespace + "_"
+
+	assert.Equal(t, 2.0, metricValue(t, registry, prefix+"events_written_total",
+		map[string]string{"type": common.ActionLoginIdentifier, "sink": "ok"}))
+	assert.Equal(t, 2.0, metricValue(t, registry, prefix+"event_write_latency_seconds",
+		map[string]string{"sink": "ok"}))
+	assert.Equal(t, 2.0, metricValue(t, registry, prefix+"errors_total",
+		map[string]string{"type": string(metrics.ErrorTypeSinkWrite)}))
+	assert.Equal(t, 1.0, metricValue(t, registry, prefix+"errors_total",
+		map[string]string{"type": string(metrics.ErrorTypeEventDropped)}))
+}
+
+func TestFanOut_FailureHandler(t *testing.T) {
+	t.Parallel()
+
+	writeErr := errors.New("oops")
+
+	var failures []string
+	fo := NewFanOut(nil,
+		&Output{Name: "ok", Sink: &testSink{}},
+		&Output{Name: "failing", Sink: &testSink{err: writeErr}, OnError: ErrorPolicyLog},
+		&Output{Name: "full", Sink: &testSink{}, BufferSize: 1, OnError: ErrorPolicyLog},
+	).WithFailureHandler(func(output string, err error) {
+		failures = append(failures, output+": "+err.Error())
+	})
+
+	// The buffered output's queue is not emptied,
+	// as Run is not running.
+	require.NoError(t, fo.Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+	require.NoError(t, fo.Write(testtools.NewEventBuilder(common.ActionLoginIdentifier).Build()))
+
+	assert.Equal(t, []string{
+		"failing: oops",
+		"failing: oops",
+		"full: " + ErrOutputBufferFull.Error(),
+	}, failures)
+}
@@ -0,0 +1,263 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+const (
+	// ChainExtraKey is the metadata.extra key
+	// containing an event's ChainLink.
+	ChainExtraKey = "chain"
+
+	// chainVersion is the version of the hash chain format.
+	chainVersion = 2
+
+	// DefaultChainAnchorInterval is the default number
+	// of events between signed anchors.
+	DefaultChainAnchorInterval = 100
+)
+
+// ChainLink links an audit event to the event that preceded it.
+type ChainLink struct {
+	// Version is the version of the hash chain format.
+	Version int `json:"v"`
+
+	// Seq is the event's sequence number. The first event
+	// of a chain has a sequence number of 1.
+	Seq uint64 `json:"seq"`
+
+	// AnchorInterval is the chain's ChainConfig.AnchorInterval.
+	// It does not change within a chain, and tells a ChainVerifier
+	// which events must be signed anchors.
+	AnchorInterval int `json:"interval"`
+
+	// Prev is the Hash of the previous event. It is empty
+	// for the first event of a chain.
+	Prev string `json:"prev"`
+
+	// Hash is the hex-encoded SHA-256 hash of the previous event's
+	// hash (decoded), the link's version, sequence number and anchor
+	// interval, and the event's canonical JSON encoding (refer to
+	// chainHash and CanonicalEventJSON).
+	Hash string `json:"hash"`
+
+	// Sig is set for anchors. It is the base64-encoded ed25519
+	// signature of the message returned by anchorMessage.
+	Sig string `json:"sig,omitempty"`
+}
+
+// isAnchor returns true if the event must be a signed anchor
+// when the chain is signed.
+func (o ChainLink) isAnchor() bool {
+	return o.AnchorInterval > 0 && (o.Seq-1)%uint64(o.AnchorInterval) == 0
+}
+
+// ChainConfig configures a HashChain.
+type ChainConfig struct {
+	// SigningKey optionally signs anchors.
+	SigningKey ed25519.PrivateKey
+
+	// AnchorInterval is the number of events between signed anchors.
+	// The first event of the chain is always an anchor.
+	AnchorInterval int
+}
+
+var _ EventSink = &HashChain{}
+
+// NewHashChain returns a new HashChain that writes events to next.
+func NewHashChain(next EventSink, config ChainConfig) *HashChain {
+	if config.AnchorInterval <= 0 {
+		config.AnchorInterval = DefaultChainAnchorInterval
+	}
+
+	return &HashChain{
+		next:   next,
+		config: config,
+	}
+}
+
+// HashChain is an EventSink that makes the events written to another
+// EventSink tamper-evident. A copy of each event is linked to the
+// previous event using a ChainLink, which is stored in the event's
+// metadata under ChainExtraKey. Modifying, removing or reordering
+// events breaks the chain, which is detected by a ChainVerifier.
+//
+// If a signing key is configured, anchors are signed periodically,
+// which prevents an attacker from rewriting the chain.
+type HashChain struct {
+	next   EventSink
+	config ChainConfig
+
+	mu       sync.Mutex
+	seq      uint64
+	prevHash []byte
+}
+
+// Write links the event to the chain and writes a copy of it to
+// the next EventSink. The original event is not modified.
+func (o *HashChain) Write(event *auditevent.AuditEvent) error {
+	canonical, err := CanonicalEventJSON(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event for hash chain: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+
+	link := ChainLink{
+		Version:        chainVersion,
+		Seq:            o.seq,
+		AnchorInterval: o.config.AnchorInterval,
+		Prev:           hex.EncodeToString(o.prevHash),
+	}
+
+	hash := chainHash(o.prevHash, link, canonical)
+	link.Hash = hex.EncodeToString(hash)
+
+	if o.config.SigningKey != nil && link.isAnchor() {
+		sig := ed25519.Sign(o.config.SigningKey, anchorMessage(link.Seq, link.Hash))
+		link.Sig = base64.StdEncoding.EncodeToString(sig)
+	}
+
+	linked := *event
+	linked.Metadata.Extra = make(map[string]any, len(event.Metadata.Extra)+1)
+	for k, v := range event.Metadata.Extra {
+		linked.Metadata.Extra[k] = v
+	}
+	linked.Metadata.Extra[ChainExtraKey] = link
+
+	// The event may have been written even if an error occurred,
+	// so the chain continues from it either way.
+	o.prevHash = hash
+
+	return o.next.Write(&linked)
+}
+
+// CanonicalEventJSON returns the canonical JSON encoding of an audit
+// event, which is the input of the event's hash. The event's ChainLink
+// is excluded. Object keys are sorted and numbers are kept as-is, which
+// allows the encoding to be reproduced from an event's JSON encoding.
+func CanonicalEventJSON(event *auditevent.AuditEvent) ([]byte, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return canonicalJSON(b)
+}
+
+// canonicalJSON re-encodes a JSON-encoded audit event with sorted
+// object keys and without its ChainLink.
+func canonicalJSON(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var doc map[string]any
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata, ok := doc["metadata"].(map[string]any); ok {
+		if extra, ok := metadata["extra"].(map[string]any); ok {
+			delete(extra, ChainExtraKey)
+
+			if len(extra) == 0 {
+				delete(metadata, "extra")
+			}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// chainHash returns the hash of an event. The link's version, sequence
+// number and anchor interval are hashed along with the event, so they
+// cannot be modified without breaking the chain (and the signature of
+// the next anchor).
+func chainHash(prevHash []byte, link ChainLink, canonical []byte) []byte {
+	h := sha256.New()
+	h.Write(prevHash)
+	fmt.Fprintf(h, "v%d\n%d\n%d\n", link.Version, link.Seq, link.AnchorInterval)
+	h.Write(canonical)
+
+	return h.Sum(nil)
+}
+
+// anchorMessage returns the message that is signed for an anchor.
+func anchorMessage(seq uint64, hash string) []byte {
+	return []byte("audito-maldito-chain-v" + strconv.Itoa(chainVersion) + "\n" +
+		strconv.FormatUint(seq, 10) + "\n" + hash)
+}
+
+// ReadSigningKey reads a PEM-encoded PKCS #8 ed25519 private key
+// (e.g., created by "openssl genpkey -algorithm ed25519").
+func ReadSigningKey(filePath string) (ed25519.PrivateKey, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, not an ed25519 key", key)
+	}
+
+	return edKey, nil
+}
+
+// ReadVerifyingKey reads a PEM-encoded PKIX ed25519 public key
+// (e.g., created by "openssl pkey -pubout").
+func ReadVerifyingKey(filePath string) (ed25519.PublicKey, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is a %T, not an ed25519 key", key)
+	}
+
+	return edKey, nil
+}
+
+func readPEMFile(filePath string) (*pem.Block, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("failed to find a pem block in " + filePath)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,391 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeChain writes numEvents events through a HashChain
+// and returns the resulting lines.
+func writeChain(t *testing.T, numEvents int, config ChainConfig) []string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	chain := NewHashChain(NewWriterSink(&buf), config)
+
+	for i := 1; i <= numEvents; i++ {
+		evt := newSampleActionEvent()
+		evt.Metadata.AuditID = strconv.Itoa(i)
+		require.NoError(t, chain.Write(evt))
+	}
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+
+	return lines[:len(lines)-1]
+}
+
+func verifyLines(t *testing.T, key ed25519.PublicKey, lines []string) *ChainReport {
+	t.Helper()
+
+	report, err := NewChainVerifier(key).Verify(strings.NewReader(strings.Join(lines, "")))
+	require.NoError(t, err)
+
+	return report
+}
+
+// rechain rewrites lines as if they were written by a HashChain whose
+// next event has the sequence number seq and that follows prevHash.
+// The anchors' signatures are removed.
+func rechain(t *testing.T, lines []string, seq uint64, prevHash []byte) []string {
+	t.Helper()
+
+	rewritten := make([]string, len(lines))
+
+	for i, line := range lines {
+		var link ChainLink
+		require.NoError(t, json.Unmarshal([]byte(chainLinkJSON(t, line)), &link))
+
+		link.Seq = seq
+		link.Prev = hex.EncodeToString(prevHash)
+		link.Sig = ""
+
+		canonical, err := canonicalJSON([]byte(line))
+		require.NoError(t, err)
+
+		hash := chainHash(prevHash, link, canonical)
+		link.Hash = hex.EncodeToString(hash)
+
+		var doc map[string]any
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		require.NoError(t, dec.Decode(&doc))
+
+		metadata, ok := doc["metadata"].(map[string]any)
+		require.True(t, ok)
+		extra, ok := metadata["extra"].(map[string]any)
+		require.True(t, ok)
+		extra[ChainExtraKey] = link
+
+		b, err := json.Marshal(doc)
+		require.NoError(t, err)
+
+		rewritten[i] = string(b) + "\n"
+		prevHash = hash
+		seq++
+	}
+
+	return rewritten
+}
+
+func problemKinds(report *ChainReport) []string {
+	var kinds []string
+	for _, problem := range report.Problems {
+		kinds = append(kinds, problem.Kind)
+	}
+
+	return kinds
+}
+
+func TestHashChain_Valid(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 5, ChainConfig{})
+
+	report := verifyLines(t, nil, lines)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 5, report.Events)
+	assert.Equal(t, 1, report.Chains)
+	assert.Zero(t, report.UnverifiedAnchors)
+}
+
+func TestHashChain_DoesNotModifyEvent(t *testing.T) {
+	t.Parallel()
+
+	evt := newSampleActionEvent()
+	numExtra := len(evt.Metadata.Extra)
+
+	chain := NewHashChain(NewWriterSink(&bytes.Buffer{}), ChainConfig{})
+	require.NoError(t, chain.Write(evt))
+
+	assert.Len(t, evt.Metadata.Extra, numExtra)
+	assert.NotContains(t, evt.Metadata.Extra, ChainExtraKey)
+}
+
+func TestHashChain_Modified(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 5, ChainConfig{})
+	lines[2] = strings.Replace(lines[2], `"loggedAs":"core"`, `"loggedAs":"nobody"`, 1)
+
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemModified}, problemKinds(report))
+	assert.Equal(t, 3, report.Problems[0].Line)
+}
+
+func TestHashChain_RehashedEvent(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 5, ChainConfig{})
+
+	// Modify the third event and update its hash.
+	var link ChainLink
+	require.NoError(t, json.Unmarshal([]byte(chainLinkJSON(t, lines[2])), &link))
+
+	modified := strings.Replace(lines[2], `"loggedAs":"core"`, `"loggedAs":"nobody"`, 1)
+
+	canonical, err := canonicalJSON([]byte(modified))
+	require.NoError(t, err)
+
+	prevHash, err := hex.DecodeString(link.Prev)
+	require.NoError(t, err)
+
+	newHash := hex.EncodeToString(chainHash(prevHash, link, canonical))
+	lines[2] = strings.Replace(modified, link.Hash, newHash, 1)
+
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemBrokenLink}, problemKinds(report))
+	assert.Equal(t, 4, report.Problems[0].Line)
+}
+
+func TestHashChain_Gap(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 6, ChainConfig{})
+	lines = append(lines[:2], lines[4:]...)
+
+	report := verifyLines(t, nil, lines)
+	require.Equal(t, []string{ChainProblemGap}, problemKinds(report))
+	assert.Equal(t, "events 3 to 4 are missing", report.Problems[0].Message)
+}
+
+func TestHashChain_Reordered(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 5, ChainConfig{})
+	lines[2], lines[3] = lines[3], lines[2]
+
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemReordered}, problemKinds(report))
+	assert.Equal(t, 4, report.Problems[0].Line)
+}
+
+func TestHashChain_Unchained(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	require.NoError(t, NewWriterSink(&buf).Write(newSampleActionEvent()))
+
+	lines := writeChain(t, 2, ChainConfig{})
+	lines = append(lines, buf.String(), "not json\n")
+
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemUnchained, ChainProblemInvalid}, problemKinds(report))
+}
+
+func TestHashChain_Restart(t *testing.T) {
+	t.Parallel()
+
+	lines := append(writeChain(t, 3, ChainConfig{}), writeChain(t, 3, ChainConfig{})...)
+
+	// Without a signature, the restart could hide removed events.
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemRestart}, problemKinds(report))
+	assert.Equal(t, 4, report.Problems[0].Line)
+	assert.Equal(t, 2, report.Chains)
+}
+
+func TestHashChain_SignedRestart(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	config := ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 2,
+	}
+
+	lines := append(writeChain(t, 3, config), writeChain(t, 3, config)...)
+
+	report := verifyLines(t, publicKey, lines)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 2, report.Chains)
+	assert.Equal(t, 4, report.VerifiedAnchors)
+
+	report = verifyLines(t, nil, lines)
+	assert.True(t, report.OK(), report.Problems)
+}
+
+func TestHashChain_RestartAttack(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	lines := writeChain(t, 8, ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 4,
+	})
+
+	// Remove events 3 to 6 and restart the chain
+	// from event 7 as if audito-maldito restarted.
+	lines = append(lines[:2], rechain(t, lines[6:], 1, nil)...)
+
+	report := verifyLines(t, nil, lines)
+	assert.Equal(t, []string{ChainProblemRestart}, problemKinds(report))
+	assert.Equal(t, 3, report.Problems[0].Line)
+
+	report = verifyLines(t, publicKey, lines)
+	assert.Equal(t, []string{ChainProblemBadSignature, ChainProblemRestart}, problemKinds(report))
+}
+
+func TestHashChain_StartsMidChain(t *testing.T) {
+	t.Parallel()
+
+	lines := writeChain(t, 5, ChainConfig{})
+
+	report := verifyLines(t, nil, lines[2:])
+	assert.True(t, report.OK(), report.Problems)
+}
+
+func TestHashChain_SignedAnchors(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	lines := writeChain(t, 5, ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 2,
+	})
+
+	report := verifyLines(t, publicKey, lines)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 3, report.VerifiedAnchors)
+
+	report = verifyLines(t, nil, lines)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 3, report.UnverifiedAnchors)
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	report = verifyLines(t, otherKey, lines)
+	assert.Equal(t, []string{
+		ChainProblemBadSignature, ChainProblemBadSignature, ChainProblemBadSignature,
+	}, problemKinds(report))
+}
+
+func TestHashChain_UnanchoredEvents(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	lines := writeChain(t, 7, ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 3,
+	})
+
+	// Event 7 is the last anchor, and removing the events
+	// after it cannot be detected.
+	report := verifyLines(t, publicKey, lines)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 0, report.UnanchoredEvents)
+
+	report = verifyLines(t, publicKey, lines[:6])
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 2, report.UnanchoredEvents)
+}
+
+func TestHashChain_StrippedSignatureAttack(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	lines := writeChain(t, 6, ChainConfig{
+		SigningKey:     privateKey,
+		AnchorInterval: 2,
+	})
+
+	// Modify the second event, then re-hash the events
+	// after it and remove the signatures of anchors 3 and 5.
+	var link ChainLink
+	require.NoError(t, json.Unmarshal([]byte(chainLinkJSON(t, lines[0])), &link))
+
+	prevHash, err := hex.DecodeString(link.Hash)
+	require.NoError(t, err)
+
+	modified := strings.Replace(lines[1], `"loggedAs":"core"`, `"loggedAs":"nobody"`, 1)
+	require.NotEqual(t, lines[1], modified)
+
+	lines = append(lines[:1], rechain(t, append([]string{modified}, lines[2:]...), 2, prevHash)...)
+
+	report := verifyLines(t, publicKey, lines)
+	assert.Equal(t, []string{ChainProblemBadSignature, ChainProblemBadSignature}, problemKinds(report))
+	assert.Equal(t, "anchor 3 is not signed", report.Problems[0].Message)
+	assert.Equal(t, 5, report.UnanchoredEvents)
+}
+
+func TestReadSigningKey(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	privatePath := filepath.Join(dir, "private.pem")
+	require.NoError(t, os.WriteFile(privatePath,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600))
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	publicPath := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(publicPath,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600))
+
+	readPrivate, err := ReadSigningKey(privatePath)
+	require.NoError(t, err)
+	assert.Equal(t, privateKey, readPrivate)
+
+	readPublic, err := ReadVerifyingKey(publicPath)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, readPublic)
+
+	_, err = ReadSigningKey(publicPath)
+	assert.Error(t, err)
+}
+
+// chainLinkJSON returns the encoded ChainLink of a JSON-encoded event.
+func chainLinkJSON(t *testing.T, line string) string {
+	t.Helper()
+
+	var event struct {
+		Metadata struct {
+			Extra map[string]json.RawMessage `json:"extra"`
+		} `json:"metadata"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(line), &event))
+
+	return string(event.Metadata.Extra[ChainExtraKey])
+}
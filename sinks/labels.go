@@ -0,0 +1,54 @@
+package sinks
+
+import (
+	"github.com/metal-toolbox/auditevent"
+)
+
+// LabelSource provides labels that are added to the Target of events.
+type LabelSource interface {
+	// Labels returns the current labels. The caller
+	// must not modify the returned map.
+	Labels() map[string]string
+}
+
+var _ EventSink = &LabelingSink{}
+
+// NewLabelingSink returns a new LabelingSink that writes events
+// to next after adding the labels provided by source.
+func NewLabelingSink(next EventSink, source LabelSource) *LabelingSink {
+	return &LabelingSink{
+		next:   next,
+		source: source,
+	}
+}
+
+// LabelingSink is an EventSink that merges labels into the Target of
+// a copy of each event before writing it to another EventSink. Target
+// fields set by the event's producer (e.g., "host" and "machine-id")
+// take precedence over labels with the same name.
+type LabelingSink struct {
+	next   EventSink
+	source LabelSource
+}
+
+// Write adds the labels to a copy of the event and writes it
+// to the next EventSink. The original event is not modified.
+func (o *LabelingSink) Write(event *auditevent.AuditEvent) error {
+	labels := o.source.Labels()
+	if len(labels) == 0 {
+		return o.next.Write(event)
+	}
+
+	labeled := *event
+	labeled.Target = make(map[string]string, len(event.Target)+len(labels))
+
+	for k, v := range labels {
+		labeled.Target[k] = v
+	}
+
+	for k, v := range event.Target {
+		labeled.Target[k] = v
+	}
+
+	return o.next.Write(&labeled)
+}
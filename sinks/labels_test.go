@@ -0,0 +1,55 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+type staticLabels map[string]string
+
+func (o staticLabels) Labels() map[string]string {
+	return o
+}
+
+func TestLabelingSink_Write(t *testing.T) {
+	t.Parallel()
+
+	next := &testSink{}
+	sink := NewLabelingSink(next, staticLabels{
+		"cluster": "prod-1",
+		"host":    "overridden",
+	})
+
+	event := testtools.NewEventBuilder("UserLogin").Build().WithTarget(map[string]string{
+		"host":       "node-1",
+		"machine-id": "abc",
+	})
+
+	require.NoError(t, sink.Write(event))
+	require.Equal(t, 1, next.numEvents())
+
+	assert.Equal(t, map[string]string{
+		"cluster":    "prod-1",
+		"host":       "node-1",
+		"machine-id": "abc",
+	}, next.events[0].Target)
+
+	// The original event is not modified.
+	assert.Len(t, event.Target, 2)
+}
+
+func TestLabelingSink_WriteWithoutLabels(t *testing.T) {
+	t.Parallel()
+
+	next := &testSink{}
+	sink := NewLabelingSink(next, staticLabels{})
+
+	event := testtools.NewEventBuilder("UserLogin").Build()
+	require.NoError(t, sink.Write(event))
+
+	assert.Same(t, event, next.events[0])
+}
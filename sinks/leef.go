@@ -0,0 +1,127 @@
+package sinks
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+var _ Encoder = &LEEFEncoder{}
+
+// NewLEEFEncoder returns a new LEEFEncoder.
+func NewLEEFEncoder() *LEEFEncoder {
+	return &LEEFEncoder{
+		version: common.Version(),
+	}
+}
+
+// LEEFEncoder encodes audit events using the QRadar Log Event Extended
+// Format (LEEF) version 2.0, with attributes delimited by tabs:
+//
+//	LEEF:2.0|metal-toolbox|audito-maldito|<version>|<type>|x09|<attributes>
+//
+// The event's type (e.g., "UserLogin") is the Event ID. Audit event
+// fields are mapped to the following attributes:
+//
+//	devTime         - loggedAt (milliseconds since the unix epoch)
+//	cat             - type
+//	sev             - severity (refer to the README)
+//	outcome         - outcome
+//	src             - source.value, if it is an IP address
+//	srcHost         - source.value, if it is not an IP address
+//	srcPort         - source.extra.port
+//	usrName         - subjects.loggedAs (the local account)
+//	userID          - subjects.userID (the SSH certificate identity)
+//	pid             - subjects.pid
+//	identHostName   - target.host
+//	machineID       - target.machine-id
+//	auditID         - metadata.auditId
+//	action          - metadata.extra.action
+//	resource        - metadata.extra.object.primary
+//	commandLine     - metadata.extra.command_line
+//	ruleCategory    - metadata.extra.category
+//	isLoginEvent    - "true" for successful UserLogin events
+//
+// Unknown values (e.g., an "unknown" userID) are omitted.
+type LEEFEncoder struct {
+	version string
+}
+
+func (o *LEEFEncoder) Encode(event *auditevent.AuditEvent) ([]byte, error) {
+	f := newSIEMFields(event)
+
+	var sb strings.Builder
+
+	sb.WriteString("LEEF:2.0|")
+	for _, v := range []string{siemVendor, siemProduct, o.version, f.eventType, "x09"} {
+		sb.WriteString(leefEscapeHeader(v))
+		sb.WriteByte('|')
+	}
+
+	attrs := extensionWriter{
+		sb:     &sb,
+		sep:    "\t",
+		escape: leefEscapeAttribute,
+	}
+
+	attrs.add("devTime", strconv.FormatInt(f.timestampMillis, 10))
+	attrs.add("cat", f.eventType)
+	attrs.add("sev", strconv.Itoa(f.severity))
+	attrs.add("outcome", f.outcome)
+	attrs.add("src", f.sourceIP)
+	attrs.add("srcHost", f.sourceHost)
+	attrs.add("srcPort", f.sourcePort)
+	attrs.add("usrName", f.loggedAs)
+	attrs.add("userID", f.userID)
+	attrs.add("pid", f.pid)
+	attrs.add("identHostName", f.host)
+	attrs.add("machineID", f.machineID)
+	attrs.add("auditID", f.auditID)
+	attrs.add("action", f.action)
+	attrs.add("resource", f.object)
+	attrs.add("commandLine", f.commandLine)
+	attrs.add("ruleCategory", f.category)
+
+	if f.eventType == common.ActionLoginIdentifier && f.outcome == auditevent.OutcomeSucceeded {
+		attrs.add("isLoginEvent", "true")
+	}
+
+	return []byte(sb.String()), nil
+}
+
+func (o *LEEFEncoder) FileExt() string {
+	return ".leef"
+}
+
+func (o *LEEFEncoder) ContentType() string {
+	return "text/plain"
+}
+
+// leefHeaderEscaper escapes LEEF header fields.
+var leefHeaderEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	"\t", " ",
+	"\r", " ",
+	"\n", " ",
+)
+
+func leefEscapeHeader(s string) string {
+	return leefHeaderEscaper.Replace(s)
+}
+
+// leefAttributeEscaper escapes LEEF attribute values. The delimiter
+// (a tab) and newlines are replaced with backslash escape sequences.
+var leefAttributeEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+func leefEscapeAttribute(s string) string {
+	return leefAttributeEscaper.Replace(s)
+}
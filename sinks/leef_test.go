@@ -0,0 +1,80 @@
+package sinks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLEEFEncoder_Encode(t *testing.T) {
+	t.Parallel()
+
+	enc := &LEEFEncoder{version: "1.0"}
+
+	tests := []struct {
+		name string
+		evt  *auditevent.AuditEvent
+		exp  []string
+	}{
+		{
+			name: "login",
+			evt:  newSampleLoginEvent(),
+			exp: []string{
+				"LEEF:2.0|metal-toolbox|audito-maldito|1.0|UserLogin|x09|devTime=1679060221952",
+				"cat=UserLogin", "sev=3", "outcome=succeeded", "src=6.6.6.2", "srcPort=59145",
+				"usrName=core", "userID=user@foo.com", "pid=3076344", "identHostName=blam",
+				"machineID=deadbeef", "auditID=ffffffff-ffff-ffff-ffff-ffffffffffff",
+				"isLoginEvent=true",
+			},
+		},
+		{
+			name: "failed login",
+			evt:  newSampleFailedLoginEvent(),
+			exp: []string{
+				"LEEF:2.0|metal-toolbox|audito-maldito|1.0|UserLogin|x09|devTime=1679060221952",
+				"cat=UserLogin", "sev=5", "outcome=failed", "src=6.6.6.2", "srcPort=59145",
+				"usrName=root", "pid=3076344", "identHostName=blam",
+				"machineID=deadbeef", "auditID=ffffffff-ffff-ffff-ffff-ffffffffffff",
+			},
+		},
+		{
+			name: "action",
+			evt:  newSampleActionEvent(),
+			exp: []string{
+				"LEEF:2.0|metal-toolbox|audito-maldito|1.0|UserAction|x09|devTime=1679060221952",
+				"cat=UserAction", "sev=8", "outcome=succeeded", "src=6.6.6.2", "srcPort=59145",
+				"usrName=core", "userID=user@foo.com", "pid=3076344", "identHostName=blam",
+				"machineID=deadbeef", "auditID=67", "action=executed", "resource=/usr/bin/echo",
+				`commandLine=echo 'a=b|c\\d'\n`, "ruleCategory=identity",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			record, err := enc.Encode(test.evt)
+			require.NoError(t, err)
+			assert.Equal(t, strings.Join(test.exp, "\t"), string(record))
+		})
+	}
+}
+
+func TestLEEFEncoder_SourceHost(t *testing.T) {
+	t.Parallel()
+
+	evt := newSampleFailedLoginEvent()
+	evt.Source.Value = "evil.example.com"
+
+	record, err := (&LEEFEncoder{version: "1.0"}).Encode(evt)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(record), "\tsrcHost=evil.example.com\t")
+	assert.NotContains(t, string(record), "\tsrc=")
+}
@@ -0,0 +1,76 @@
+package sinks
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDialTimeout is the default timeout for connecting
+// to a network destination.
+const DefaultDialTimeout = 5 * time.Second
+
+// NewNetWriter returns a NetWriter that writes to the given
+// network address. Refer to net.Dial for the supported
+// network types and address formats.
+//
+// The connection is established on the first call to Write.
+func NewNetWriter(network string, address string) *NetWriter {
+	return &NetWriter{
+		network:     network,
+		address:     address,
+		dialTimeout: DefaultDialTimeout,
+	}
+}
+
+// NetWriter is an io.Writer that writes to a network connection.
+// It transparently reconnects when a write fails. The caller is
+// expected to retry the failed write if desired.
+type NetWriter struct {
+	network     string
+	address     string
+	dialTimeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Write writes p to the network connection, connecting
+// first if there is no connection.
+func (o *NetWriter) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		conn, err := net.DialTimeout(o.network, o.address, o.dialTimeout)
+		if err != nil {
+			return 0, err
+		}
+
+		o.conn = conn
+	}
+
+	n, err := o.conn.Write(p)
+	if err != nil {
+		// Force a reconnect on the next write.
+		_ = o.conn.Close()
+		o.conn = nil
+	}
+
+	return n, err
+}
+
+// Close closes the network connection, if any.
+func (o *NetWriter) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.Close()
+	o.conn = nil
+
+	return err
+}
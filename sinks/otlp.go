@@ -0,0 +1,447 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+// OTLPProtocol is the transport used to export logs to
+// an OpenTelemetry collector.
+type OTLPProtocol string
+
+const (
+	// OTLPProtocolGRPC exports logs with the LogsService/Export gRPC
+	// method. The gRPC requests are sent over HTTP/2, which the Go
+	// standard library only negotiates over TLS, so the endpoint must
+	// use the https scheme.
+	OTLPProtocolGRPC OTLPProtocol = "grpc"
+
+	// OTLPProtocolHTTP POSTs protobuf-encoded logs to an HTTP endpoint.
+	OTLPProtocolHTTP OTLPProtocol = "http/protobuf"
+)
+
+const (
+	// otlpGRPCPath is the path of the LogsService/Export gRPC method.
+	otlpGRPCPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+	// otlpScope is the name of the instrumentation
+	// scope of the exported log records.
+	otlpScope = "github.com/metal-toolbox/audito-maldito"
+
+	otlpMaxResponseBytes = 64 * 1024
+)
+
+// OTLPConfig configures an OTLPExporter.
+type OTLPConfig struct {
+	// Endpoint is the collector's URL. For OTLPProtocolHTTP,
+	// it is the URL that logs are POSTed to (for example,
+	// "http://collector:4318/v1/logs"). For OTLPProtocolGRPC,
+	// it is the collector's base URL (for example,
+	// "https://collector:4317").
+	Endpoint string
+
+	// Protocol is the transport. It defaults to OTLPProtocolHTTP.
+	Protocol OTLPProtocol
+
+	// Header contains additional request headers (or gRPC metadata).
+	Header http.Header
+
+	// Client is the HTTP client used to send requests.
+	// It defaults to a new http.Client.
+	Client *http.Client
+
+	// ResourceAttributes describe the entity that produced
+	// the logs (for example, "host.name"). The service.name
+	// and service.version attributes are always set.
+	ResourceAttributes map[string]string
+
+	BatchConfig
+}
+
+func (o *OTLPConfig) setDefaults() {
+	if o.Protocol == "" {
+		o.Protocol = OTLPProtocolHTTP
+	}
+
+	if o.Client == nil {
+		o.Client = &http.Client{}
+	}
+
+	o.BatchConfig.setDefaults()
+}
+
+var (
+	_ EventSink = &OTLPExporter{}
+	_ Runner    = &OTLPExporter{}
+)
+
+// NewOTLPExporter returns a new OTLPExporter that identifies itself
+// as name in logs and metrics. The pprov argument may be nil.
+func NewOTLPExporter(name string, config OTLPConfig, l *zap.SugaredLogger,
+	pprov *metrics.PrometheusMetricsProvider,
+) (*OTLPExporter, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("otlp endpoint is empty")
+	}
+
+	config.setDefaults()
+
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse otlp endpoint: %w", err)
+	}
+
+	switch config.Protocol {
+	case OTLPProtocolHTTP:
+	case OTLPProtocolGRPC:
+		if u.Scheme != "https" {
+			return nil, errors.New("otlp grpc endpoint must use TLS (plaintext HTTP/2 is not supported)")
+		}
+
+		u.Path = otlpGRPCPath
+		u.RawQuery = ""
+		config.Endpoint = u.String()
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol: %q", config.Protocol)
+	}
+
+	o := &OTLPExporter{
+		config: config,
+	}
+
+	o.batcher, err = newBatcher("otlp exporter", name, config.BatchConfig,
+		newOTLPFormat(config.ResourceAttributes), o, l, pprov)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// OTLPExporter is an EventSink that exports events as OpenTelemetry
+// log records to a collector, using either OTLP/gRPC or OTLP/HTTP
+// with protobuf encoding. Each event becomes a LogRecord whose
+// attributes are the event's fields. It batches, retries and
+// spools events like Webhook does.
+type OTLPExporter struct {
+	*batcher
+	config OTLPConfig
+}
+
+func (o *OTLPExporter) send(ctx context.Context, b *eventBatch) error {
+	if o.config.Protocol == OTLPProtocolGRPC {
+		return o.sendGRPC(ctx, b)
+	}
+
+	return o.sendHTTP(ctx, b)
+}
+
+func (o *OTLPExporter) closeIdleConnections() {
+	o.config.Client.CloseIdleConnections()
+}
+
+// sendHTTP POSTs a batch to an OTLP/HTTP endpoint. Retryable
+// status codes are defined by the OTLP specification.
+func (o *OTLPExporter) sendHTTP(ctx context.Context, b *eventBatch) error {
+	req, err := o.newRequest(ctx, bytes.NewReader(b.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if b.gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := o.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, otlpMaxResponseBytes))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		o.logPartialSuccess(body)
+		return nil
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("unexpected http status: %s", resp.Status)
+	default:
+		return backoff.Permanent(fmt.Errorf("unexpected http status: %s", resp.Status))
+	}
+}
+
+// sendGRPC calls the LogsService/Export gRPC method with a batch.
+func (o *OTLPExporter) sendGRPC(ctx context.Context, b *eventBatch) error {
+	// A gRPC message is prefixed with a compression flag
+	// and its length as a big-endian uint32.
+	msg := make([]byte, 5, 5+len(b.body))
+	if b.gzipped {
+		msg[0] = 1
+	}
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(b.body)))
+	msg = append(msg, b.body...)
+
+	req, err := o.newRequest(ctx, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if b.gzipped {
+		req.Header.Set("Grpc-Encoding", "gzip")
+	}
+
+	resp, err := o.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		return backoff.Permanent(fmt.Errorf("otlp grpc endpoint responded with %s instead of HTTP/2", resp.Proto))
+	}
+
+	// The trailers are only available once the body is read.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, otlpMaxResponseBytes))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return grpcHTTPStatusError(resp.StatusCode)
+	}
+
+	// A response without a message carries
+	// its status in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("invalid grpc status: %q", status)
+	}
+
+	if code != grpcCodeOK {
+		message, _ = url.PathUnescape(message)
+		return grpcStatusError(code, message)
+	}
+
+	if len(body) >= 5 && body[0] == 0 {
+		o.logPartialSuccess(body[5:])
+	}
+
+	return nil
+}
+
+func (o *OTLPExporter) newRequest(ctx context.Context, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.Endpoint, body)
+	if err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	for k, v := range o.config.Header {
+		req.Header[k] = v
+	}
+
+	return req, nil
+}
+
+func (o *OTLPExporter) logPartialSuccess(resp []byte) {
+	rejected, message := parseOTLPPartialSuccess(resp)
+	if rejected > 0 || message != "" {
+		o.l.Warnf("otlp exporter %q: collector rejected %d log records - %s",
+			o.name, rejected, message)
+	}
+}
+
+// The following gRPC status codes are used by OTLPExporter.
+const (
+	grpcCodeOK                = 0
+	grpcCodeCanceled          = 1
+	grpcCodeDeadlineExceeded  = 4
+	grpcCodeResourceExhausted = 8
+	grpcCodeAborted           = 10
+	grpcCodeOutOfRange        = 11
+	grpcCodeUnavailable       = 14
+	grpcCodeDataLoss          = 15
+)
+
+// grpcStatusError returns an error for a gRPC status. It is
+// a *backoff.PermanentError unless the OTLP specification
+// marks the status code as retryable.
+func grpcStatusError(code int, message string) error {
+	err := fmt.Errorf("grpc status %d: %s", code, message)
+
+	switch code {
+	case grpcCodeCanceled, grpcCodeDeadlineExceeded, grpcCodeResourceExhausted,
+		grpcCodeAborted, grpcCodeOutOfRange, grpcCodeUnavailable, grpcCodeDataLoss:
+		return err
+	default:
+		return backoff.Permanent(err)
+	}
+}
+
+// grpcHTTPStatusError returns an error for a gRPC response whose HTTP
+// status is not 200 (for example, from a proxy in front of the collector).
+func grpcHTTPStatusError(statusCode int) error {
+	err := fmt.Errorf("unexpected http status: %d %s", statusCode, http.StatusText(statusCode))
+
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return err
+	default:
+		return backoff.Permanent(err)
+	}
+}
+
+// otlpFormat is a batchFormat that encodes events as LogRecord
+// messages. The records of a batch are the log_records field of a
+// ScopeLogs message, which batchBody wraps in an
+// ExportLogsServiceRequest.
+type otlpFormat struct {
+	// resource is the encoded Resource message.
+	resource []byte
+
+	// scope is the encoded InstrumentationScope message.
+	scope []byte
+}
+
+func newOTLPFormat(attributes map[string]string) otlpFormat {
+	attrs := map[string]string{
+		"service.name":    "audito-maldito",
+		"service.version": common.Version(),
+	}
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var f otlpFormat
+
+	for _, k := range keys {
+		if attrs[k] != "" {
+			f.resource = appendKeyValue(f.resource, otlpResourceAttributes, k, attrs[k])
+		}
+	}
+
+	f.scope = appendStringField(f.scope, otlpScopeName, otlpScope)
+	f.scope = appendStringField(f.scope, otlpScopeVersion, common.Version())
+
+	return f
+}
+
+func (o otlpFormat) appendEvent(records *bytes.Buffer, event *auditevent.AuditEvent) error {
+	record, err := encodeLogRecord(event, time.Now())
+	if err != nil {
+		return err
+	}
+
+	b := protowire.AppendTag(nil, otlpScopeLogsLogRecords, protowire.BytesType)
+	b = protowire.AppendBytes(b, record)
+	records.Write(b)
+
+	return nil
+}
+
+func (o otlpFormat) batchBody(records []byte) []byte {
+	return appendMessage(nil, otlpRequestResourceLogs, func(rl []byte) []byte {
+		rl = protowire.AppendTag(rl, otlpResourceLogsResource, protowire.BytesType)
+		rl = protowire.AppendBytes(rl, o.resource)
+
+		return appendMessage(rl, otlpResourceLogsScopeLogs, func(sl []byte) []byte {
+			sl = protowire.AppendTag(sl, otlpScopeLogsScope, protowire.BytesType)
+			sl = protowire.AppendBytes(sl, o.scope)
+			return append(sl, records...)
+		})
+	})
+}
+
+func (o otlpFormat) spoolExt() string {
+	return ".pb"
+}
+
+// encodeLogRecord encodes event as a LogRecord message. The event's
+// fields (except its timestamp) are flattened into attributes, the body
+// is a short summary, and the severity is derived like siemSeverity.
+func encodeLogRecord(event *auditevent.AuditEvent, observed time.Time) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := decodeJSONObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(fields, "loggedAt")
+
+	severityNumber, severityText := otlpSeverity(siemSeverity(event.Outcome,
+		stringField(event.Metadata.Extra, "severity")))
+
+	var b []byte
+	b = appendFixed64Field(b, otlpLogTimeUnixNano, uint64(event.LoggedAt.UnixNano()))
+	b = appendVarintField(b, otlpLogSeverityNumber, severityNumber)
+	b = appendStringField(b, otlpLogSeverityText, severityText)
+	b = appendMessage(b, otlpLogBody, func(av []byte) []byte {
+		return appendAnyValue(av, strings.TrimSpace(event.Type+" "+event.Outcome))
+	})
+
+	flattenAttributes("", fields, func(key string, value any) {
+		b = appendKeyValue(b, otlpLogAttributes, key, value)
+	})
+
+	b = appendFixed64Field(b, otlpLogObservedTimeUnixNano, uint64(observed.UnixNano()))
+	b = appendStringField(b, otlpLogEventName, event.Type)
+
+	return b, nil
+}
+
+// otlpSeverity maps a severity from 0 to 10
+// to an OTLP severity number and text.
+func otlpSeverity(severity int) (uint64, string) {
+	switch {
+	case severity >= 10:
+		return otlpSeverityFatal, "FATAL"
+	case severity >= 8:
+		return otlpSeverityError, "ERROR"
+	case severity >= 5:
+		return otlpSeverityWarn, "WARN"
+	default:
+		return otlpSeverityInfo, "INFO"
+	}
+}
@@ -0,0 +1,571 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+// testLogRecord is a decoded OTLP LogRecord.
+type testLogRecord struct {
+	timeUnixNano   uint64
+	severityNumber uint64
+	severityText   string
+	body           any
+	eventName      string
+	attributes     map[string]any
+}
+
+// testOTLPRequest is a decoded ExportLogsServiceRequest
+// with a single ResourceLogs and ScopeLogs.
+type testOTLPRequest struct {
+	resource map[string]any
+	scope    string
+	records  []testLogRecord
+}
+
+// protoFields decodes the fields of a protobuf message. Varint and
+// fixed-size values are returned as uint64, others as []byte.
+func protoFields(t *testing.T, b []byte) []struct {
+	num   protowire.Number
+	value any
+} {
+	t.Helper()
+
+	var fields []struct {
+		num   protowire.Number
+		value any
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		var value any
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		fields = append(fields, struct {
+			num   protowire.Number
+			value any
+		}{num, value})
+	}
+
+	return fields
+}
+
+func decodeTestAnyValue(t *testing.T, b []byte) any {
+	t.Helper()
+
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case otlpAnyValueString:
+			return string(f.value.([]byte))
+		case otlpAnyValueBool:
+			return f.value.(uint64) != 0
+		case otlpAnyValueInt:
+			return int64(f.value.(uint64))
+		case otlpAnyValueDouble:
+			return math.Float64frombits(f.value.(uint64))
+		case otlpAnyValueArray:
+			var values []any
+			for _, elem := range protoFields(t, f.value.([]byte)) {
+				values = append(values, decodeTestAnyValue(t, elem.value.([]byte)))
+			}
+			return values
+		}
+	}
+
+	return nil
+}
+
+func decodeTestKeyValue(t *testing.T, b []byte, m map[string]any) {
+	t.Helper()
+
+	var key string
+	var value any
+
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case otlpKeyValueKey:
+			key = string(f.value.([]byte))
+		case otlpKeyValueValue:
+			value = decodeTestAnyValue(t, f.value.([]byte))
+		}
+	}
+
+	m[key] = value
+}
+
+func decodeTestOTLPRequest(t *testing.T, b []byte) testOTLPRequest {
+	t.Helper()
+
+	req := testOTLPRequest{resource: map[string]any{}}
+
+	resourceLogs := protoFields(t, b)
+	require.Len(t, resourceLogs, 1)
+
+	for _, rl := range protoFields(t, resourceLogs[0].value.([]byte)) {
+		switch rl.num {
+		case otlpResourceLogsResource:
+			for _, attr := range protoFields(t, rl.value.([]byte)) {
+				decodeTestKeyValue(t, attr.value.([]byte), req.resource)
+			}
+		case otlpResourceLogsScopeLogs:
+			for _, sl := range protoFields(t, rl.value.([]byte)) {
+				switch sl.num {
+				case otlpScopeLogsScope:
+					for _, scope := range protoFields(t, sl.value.([]byte)) {
+						if scope.num == otlpScopeName {
+							req.scope = string(scope.value.([]byte))
+						}
+					}
+				case otlpScopeLogsLogRecords:
+					req.records = append(req.records, decodeTestLogRecord(t, sl.value.([]byte)))
+				}
+			}
+		}
+	}
+
+	return req
+}
+
+func decodeTestLogRecord(t *testing.T, b []byte) testLogRecord {
+	t.Helper()
+
+	record := testLogRecord{attributes: map[string]any{}}
+
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case otlpLogTimeUnixNano:
+			record.timeUnixNano = f.value.(uint64)
+		case otlpLogSeverityNumber:
+			record.severityNumber = f.value.(uint64)
+		case otlpLogSeverityText:
+			record.severityText = string(f.value.([]byte))
+		case otlpLogBody:
+			record.body = decodeTestAnyValue(t, f.value.([]byte))
+		case otlpLogAttributes:
+			decodeTestKeyValue(t, f.value.([]byte), record.attributes)
+		case otlpLogEventName:
+			record.eventName = string(f.value.([]byte))
+		}
+	}
+
+	return record
+}
+
+// testOTLPReceiver is an in-process OTLP/HTTP and OTLP/gRPC
+// receiver that records the requests it receives.
+type testOTLPReceiver struct {
+	mu       sync.Mutex
+	requests []testOTLPRequest
+	headers  []http.Header
+
+	// failures are the HTTP status codes (for OTLP/HTTP) or the
+	// gRPC status codes (for OTLP/gRPC) of the next responses.
+	failures []int
+}
+
+func (o *testOTLPReceiver) failNext(codes ...int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.failures = append(o.failures, codes...)
+}
+
+func (o *testOTLPReceiver) nextFailure() int {
+	if len(o.failures) == 0 {
+		return 0
+	}
+
+	code := o.failures[0]
+	o.failures = o.failures[1:]
+
+	return code
+}
+
+func (o *testOTLPReceiver) records() []testLogRecord {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var records []testLogRecord
+	for _, req := range o.requests {
+		records = append(records, req.records...)
+	}
+
+	return records
+}
+
+func (o *testOTLPReceiver) serveHTTP(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		if code := o.nextFailure(); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		o.requests = append(o.requests, decodeTestOTLPRequest(t, b))
+		o.headers = append(o.headers, r.Header.Clone())
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+}
+
+func (o *testOTLPReceiver) serveGRPC(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		if r.ProtoMajor != 2 || r.URL.Path != otlpGRPCPath {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+
+		if code := o.nextFailure(); code != 0 {
+			w.Header().Set("Grpc-Status", strconv.Itoa(code))
+			w.Header().Set("Grpc-Message", "try%20again")
+			return
+		}
+
+		msg, err := io.ReadAll(r.Body)
+		if err != nil || len(msg) < 5 || int(binary.BigEndian.Uint32(msg[1:5])) != len(msg)-5 {
+			w.Header().Set("Grpc-Status", "13")
+			return
+		}
+
+		payload := msg[5:]
+		if msg[0] == 1 {
+			gz, err := gzip.NewReader(bytes.NewReader(payload))
+			if err == nil {
+				payload, err = io.ReadAll(gz)
+			}
+			if err != nil {
+				w.Header().Set("Grpc-Status", "13")
+				return
+			}
+		}
+
+		o.requests = append(o.requests, decodeTestOTLPRequest(t, payload))
+		o.headers = append(o.headers, r.Header.Clone())
+
+		// An empty ExportLogsServiceResponse.
+		_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+	}
+}
+
+func runOTLPExporter(t *testing.T, e *OTLPExporter) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		_ = e.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestOTLPExporter_HTTP(t *testing.T) {
+	t.Parallel()
+
+	receiver := &testOTLPReceiver{}
+	server := httptest.NewServer(receiver.serveHTTP(t))
+	defer server.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer secret")
+
+	e, err := NewOTLPExporter("test", OTLPConfig{
+		Endpoint: server.URL + "/v1/logs",
+		Header:   header,
+		ResourceAttributes: map[string]string{
+			"host.name": "node-1",
+			"host.id":   "machine-1",
+		},
+		BatchConfig: BatchConfig{
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			Gzip:          true,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	runOTLPExporter(t, e)
+
+	event := testtools.NewEventBuilder("UserLogin").
+		WithAuditID("1").
+		WithSource(auditevent.EventSource{
+			Type:  "IP",
+			Value: "192.0.2.10",
+			Extra: map[string]any{"port": 52000},
+		}).
+		WithTarget(map[string]string{"host": "node-1"}).
+		Build()
+	require.NoError(t, e.Write(event))
+	require.NoError(t, e.Write(testtools.NewEventBuilder("UserLogin").WithAuditID("2").Build()))
+
+	require.Eventually(t, func() bool {
+		return len(receiver.records()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	receiver.mu.Lock()
+	req := receiver.requests[0]
+	assert.Equal(t, "Bearer secret", receiver.headers[0].Get("Authorization"))
+	assert.Equal(t, "gzip", receiver.headers[0].Get("Content-Encoding"))
+	receiver.mu.Unlock()
+
+	assert.Equal(t, "node-1", req.resource["host.name"])
+	assert.Equal(t, "machine-1", req.resource["host.id"])
+	assert.Equal(t, "audito-maldito", req.resource["service.name"])
+	assert.Contains(t, req.resource, "service.version")
+	assert.Equal(t, otlpScope, req.scope)
+
+	record := req.records[0]
+	assert.Equal(t, uint64(event.LoggedAt.UnixNano()), record.timeUnixNano)
+	assert.Equal(t, "UserLogin", record.eventName)
+	assert.Equal(t, "UserLogin succeeded", record.body)
+	assert.Equal(t, uint64(otlpSeverityInfo), record.severityNumber)
+	assert.Equal(t, "INFO", record.severityText)
+	assert.Equal(t, "1", record.attributes["metadata.auditId"])
+	assert.Equal(t, "UserLogin", record.attributes["type"])
+	assert.Equal(t, "succeeded", record.attributes["outcome"])
+	assert.Equal(t, "sshd", record.attributes["component"])
+	assert.Equal(t, "IP", record.attributes["source.type"])
+	assert.Equal(t, "192.0.2.10", record.attributes["source.value"])
+	assert.Equal(t, int64(52000), record.attributes["source.extra.port"])
+	assert.Equal(t, "root", record.attributes["subjects.loggedAs"])
+	assert.Equal(t, "node-1", record.attributes["target.host"])
+	assert.NotContains(t, record.attributes, "loggedAt")
+}
+
+func TestOTLPExporter_HTTPRetry(t *testing.T) {
+	t.Parallel()
+
+	receiver := &testOTLPReceiver{}
+	receiver.failNext(http.StatusServiceUnavailable, http.StatusBadRequest)
+
+	server := httptest.NewServer(receiver.serveHTTP(t))
+	defer server.Close()
+
+	e, err := NewOTLPExporter("test", OTLPConfig{
+		Endpoint: server.URL + "/v1/logs",
+		BatchConfig: BatchConfig{
+			BatchSize:     1,
+			FlushInterval: time.Hour,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, e.Write(testtools.NewEventBuilder("UserLogin").WithAuditID(id).Build()))
+	}
+
+	// The first delivery fails with a retryable status.
+	require.Error(t, e.deliverQueued(context.Background()))
+
+	// The first batch is then rejected and dropped.
+	require.NoError(t, e.deliverQueued(context.Background()))
+
+	records := receiver.records()
+	require.Len(t, records, 2)
+	assert.Equal(t, "2", records[0].attributes["metadata.auditId"])
+	assert.Equal(t, "3", records[1].attributes["metadata.auditId"])
+}
+
+func TestOTLPExporter_GRPC(t *testing.T) {
+	t.Parallel()
+
+	receiver := &testOTLPReceiver{}
+	receiver.failNext(grpcCodeUnavailable)
+
+	server := httptest.NewUnstartedServer(receiver.serveGRPC(t))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	e, err := NewOTLPExporter("test", OTLPConfig{
+		Endpoint: server.URL,
+		Protocol: OTLPProtocolGRPC,
+		Client:   server.Client(),
+		BatchConfig: BatchConfig{
+			BatchSize:        1,
+			FlushInterval:    time.Hour,
+			MaxRetryInterval: 10 * time.Millisecond,
+			Gzip:             true,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	runOTLPExporter(t, e)
+
+	failed := testtools.NewEventBuilder("UserLogin").
+		WithAuditID("1").
+		WithOutcome(auditevent.OutcomeFailed).
+		Build()
+	require.NoError(t, e.Write(failed))
+
+	require.Eventually(t, func() bool {
+		return len(receiver.records()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	record := receiver.records()[0]
+	assert.Equal(t, "1", record.attributes["metadata.auditId"])
+	assert.Equal(t, uint64(otlpSeverityWarn), record.severityNumber)
+
+	receiver.mu.Lock()
+	assert.Equal(t, "gzip", receiver.headers[0].Get("Grpc-Encoding"))
+	assert.Equal(t, "trailers", receiver.headers[0].Get("Te"))
+	receiver.mu.Unlock()
+}
+
+func TestOTLPExporter_GRPCPermanentError(t *testing.T) {
+	t.Parallel()
+
+	receiver := &testOTLPReceiver{}
+	receiver.failNext(3) // INVALID_ARGUMENT
+
+	server := httptest.NewUnstartedServer(receiver.serveGRPC(t))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	e, err := NewOTLPExporter("test", OTLPConfig{
+		Endpoint: server.URL,
+		Protocol: OTLPProtocolGRPC,
+		Client:   server.Client(),
+		BatchConfig: BatchConfig{
+			BatchSize: 1,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Write(testtools.NewEventBuilder("UserLogin").WithAuditID("1").Build()))
+	require.NoError(t, e.Write(testtools.NewEventBuilder("UserLogin").WithAuditID("2").Build()))
+
+	require.NoError(t, e.deliverQueued(context.Background()))
+
+	records := receiver.records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "2", records[0].attributes["metadata.auditId"])
+}
+
+func TestNewOTLPExporter_GRPCRequiresTLS(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewOTLPExporter("test", OTLPConfig{
+		Endpoint: "http://127.0.0.1:4317",
+		Protocol: OTLPProtocolGRPC,
+	}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestEncodeLogRecord_Severity(t *testing.T) {
+	t.Parallel()
+
+	event := testtools.NewEventBuilder("UserLogin").
+		WithExtra(map[string]any{
+			"severity": "critical",
+			"paths":    []any{"/etc/passwd", "/etc/shadow"},
+		}).
+		Build()
+
+	b, err := encodeLogRecord(event, time.Now())
+	require.NoError(t, err)
+
+	record := decodeTestLogRecord(t, b)
+	assert.Equal(t, uint64(otlpSeverityFatal), record.severityNumber)
+	assert.Equal(t, "FATAL", record.severityText)
+	assert.Equal(t, []any{"/etc/passwd", "/etc/shadow"}, record.attributes["metadata.extra.paths"])
+}
+
+func TestParseOutput_OTLP(t *testing.T) {
+	t.Parallel()
+
+	opts := OutputOptions{
+		ResourceAttributes: map[string]string{"host.name": "node-1"},
+	}
+
+	output, err := ParseOutput(context.Background(),
+		"otlp+http://collector:4318?batch-size=10&types=UserLogin", opts)
+	require.NoError(t, err)
+
+	e, ok := output.Sink.(*OTLPExporter)
+	require.True(t, ok)
+	assert.Equal(t, OTLPProtocolHTTP, e.config.Protocol)
+	assert.Equal(t, "http://collector:4318/v1/logs", e.config.Endpoint)
+	assert.Equal(t, 10, e.config.BatchSize)
+	assert.Equal(t, "node-1", e.config.ResourceAttributes["host.name"])
+
+	output, err = ParseOutput(context.Background(), "otlp+https://collector/custom/logs", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "https://collector/custom/logs", output.Sink.(*OTLPExporter).config.Endpoint)
+
+	output, err = ParseOutput(context.Background(), "otlp+grpcs://collector:4317", opts)
+	require.NoError(t, err)
+	e = output.Sink.(*OTLPExporter)
+	assert.Equal(t, OTLPProtocolGRPC, e.config.Protocol)
+	assert.Equal(t, "https://collector:4317"+otlpGRPCPath, e.config.Endpoint)
+
+	_, err = ParseOutput(context.Background(), "otlp+grpc://collector:4317", opts)
+	assert.Error(t, err)
+}
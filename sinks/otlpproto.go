@@ -0,0 +1,258 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The following are the numbers of the OTLP protobuf fields that
+// are used by OTLPExporter. They are defined in the logs, common and
+// resource packages of opentelemetry-proto, which is not vendored.
+const (
+	// ExportLogsServiceRequest
+	otlpRequestResourceLogs protowire.Number = 1
+
+	// ExportLogsServiceResponse
+	otlpResponsePartialSuccess protowire.Number = 1
+
+	// ExportLogsPartialSuccess
+	otlpPartialSuccessRejected protowire.Number = 1
+	otlpPartialSuccessMessage  protowire.Number = 2
+
+	// ResourceLogs
+	otlpResourceLogsResource  protowire.Number = 1
+	otlpResourceLogsScopeLogs protowire.Number = 2
+
+	// Resource
+	otlpResourceAttributes protowire.Number = 1
+
+	// ScopeLogs
+	otlpScopeLogsScope      protowire.Number = 1
+	otlpScopeLogsLogRecords protowire.Number = 2
+
+	// InstrumentationScope
+	otlpScopeName    protowire.Number = 1
+	otlpScopeVersion protowire.Number = 2
+
+	// LogRecord
+	otlpLogTimeUnixNano         protowire.Number = 1
+	otlpLogSeverityNumber       protowire.Number = 2
+	otlpLogSeverityText         protowire.Number = 3
+	otlpLogBody                 protowire.Number = 5
+	otlpLogAttributes           protowire.Number = 6
+	otlpLogObservedTimeUnixNano protowire.Number = 11
+	otlpLogEventName            protowire.Number = 12
+
+	// KeyValue
+	otlpKeyValueKey   protowire.Number = 1
+	otlpKeyValueValue protowire.Number = 2
+
+	// AnyValue
+	otlpAnyValueString protowire.Number = 1
+	otlpAnyValueBool   protowire.Number = 2
+	otlpAnyValueInt    protowire.Number = 3
+	otlpAnyValueDouble protowire.Number = 4
+	otlpAnyValueArray  protowire.Number = 5
+
+	// ArrayValue
+	otlpArrayValueValues protowire.Number = 1
+)
+
+// OTLP severity numbers (SeverityNumber).
+const (
+	otlpSeverityInfo  = 9
+	otlpSeverityWarn  = 13
+	otlpSeverityError = 17
+	otlpSeverityFatal = 21
+)
+
+// appendMessage appends an embedded message field whose
+// contents are appended to b by fn.
+func appendMessage(b []byte, num protowire.Number, fn func([]byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, fn(nil))
+}
+
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendFixed64Field(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendKeyValue appends a KeyValue field whose value is
+// converted from a value decoded from JSON.
+func appendKeyValue(b []byte, num protowire.Number, key string, value any) []byte {
+	return appendMessage(b, num, func(kv []byte) []byte {
+		kv = appendStringField(kv, otlpKeyValueKey, key)
+		return appendMessage(kv, otlpKeyValueValue, func(av []byte) []byte {
+			return appendAnyValue(av, value)
+		})
+	})
+}
+
+// appendAnyValue appends the fields of an AnyValue message
+// converted from a value decoded from JSON.
+func appendAnyValue(b []byte, value any) []byte {
+	switch v := value.(type) {
+	case string:
+		b = protowire.AppendTag(b, otlpAnyValueString, protowire.BytesType)
+		return protowire.AppendString(b, v)
+	case bool:
+		return appendVarintField(b, otlpAnyValueBool, protowire.EncodeBool(v))
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendVarintField(b, otlpAnyValueInt, uint64(i))
+		}
+
+		f, _ := v.Float64()
+		b = protowire.AppendTag(b, otlpAnyValueDouble, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(f))
+	case []any:
+		return appendMessage(b, otlpAnyValueArray, func(arr []byte) []byte {
+			for _, elem := range v {
+				arr = appendMessage(arr, otlpArrayValueValues, func(av []byte) []byte {
+					return appendAnyValue(av, elem)
+				})
+			}
+			return arr
+		})
+	case map[string]any:
+		// Maps within arrays are uncommon in audit events,
+		// so they are kept as a JSON string.
+		s, _ := json.Marshal(v)
+		b = protowire.AppendTag(b, otlpAnyValueString, protowire.BytesType)
+		return protowire.AppendBytes(b, s)
+	default:
+		// A null value is an empty AnyValue.
+		return b
+	}
+}
+
+// flattenAttributes flattens the nested objects of a value decoded
+// from JSON into attributes whose keys are joined with dots (e.g.,
+// "source.extra.port"). Keys are sorted so the encoding is stable.
+func flattenAttributes(prefix string, m map[string]any, fn func(key string, value any)) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := m[k].(type) {
+		case nil:
+			continue
+		case map[string]any:
+			flattenAttributes(key, v, fn)
+		default:
+			fn(key, v)
+		}
+	}
+}
+
+// decodeJSONObject decodes a JSON object, preserving the
+// precision of its numbers.
+func decodeJSONObject(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var m map[string]any
+	err := dec.Decode(&m)
+
+	return m, err
+}
+
+// parseOTLPPartialSuccess returns the number of rejected log records
+// and the error message found in an ExportLogsServiceResponse.
+func parseOTLPPartialSuccess(resp []byte) (int64, string) {
+	partial := findBytesField(resp, otlpResponsePartialSuccess)
+	if partial == nil {
+		return 0, ""
+	}
+
+	var rejected int64
+	var message string
+
+	for len(partial) > 0 {
+		num, typ, n := protowire.ConsumeTag(partial)
+		if n < 0 {
+			break
+		}
+		partial = partial[n:]
+
+		switch {
+		case num == otlpPartialSuccessRejected && typ == protowire.VarintType:
+			v, m := protowire.ConsumeVarint(partial)
+			if m < 0 {
+				return rejected, message
+			}
+			rejected = int64(v)
+			n = m
+		case num == otlpPartialSuccessMessage && typ == protowire.BytesType:
+			v, m := protowire.ConsumeBytes(partial)
+			if m < 0 {
+				return rejected, message
+			}
+			message = string(v)
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, partial)
+			if n < 0 {
+				return rejected, message
+			}
+		}
+
+		partial = partial[n:]
+	}
+
+	return rejected, message
+}
+
+// findBytesField returns the value of the first length-delimited
+// field numbered num in the encoded message b, or nil.
+func findBytesField(b []byte, num protowire.Number) []byte {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil
+		}
+		b = b[l:]
+
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(b)
+			return v
+		}
+
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			return nil
+		}
+		b = b[l:]
+	}
+
+	return nil
+}
@@ -0,0 +1,453 @@
+package sinks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+// OutputOptions contains the dependencies of the
+// outputs created by ParseOutput.
+type OutputOptions struct {
+	// Logger is an optional logger.
+	Logger *zap.SugaredLogger
+
+	// Metrics is an optional metrics provider.
+	Metrics *metrics.PrometheusMetricsProvider
+
+	// ResourceAttributes describe the host that produces the
+	// events (refer to OTLPConfig.ResourceAttributes).
+	ResourceAttributes map[string]string
+}
+
+// ParseOutput creates an Output from a URL-like specification string.
+// The URL's scheme selects the destination:
+//
+//	file:///path/to/file  - a regular file or named pipe (which must exist)
+//	stdout:               - the standard output
+//	stderr:               - the standard error
+//	tcp://host:port       - a TCP connection
+//	udp://host:port       - UDP datagrams
+//	unix:///path/to/sock  - a unix socket connection
+//	http(s)://host/path   - an HTTP endpoint (refer to Webhook)
+//	otlp+http(s)://host   - an OTLP/HTTP collector (refer to OTLPExporter);
+//	                        the path defaults to "/v1/logs"
+//	otlp+grpcs://host     - an OTLP/gRPC collector, over TLS
+//
+// The following query parameters configure the Output:
+//
+//	name      - the output's name (defaults to the specification
+//	            without its query parameters)
+//	types     - comma-separated list of event types to write
+//	            (defaults to all types)
+//	buffer    - the output's buffer size (defaults to zero)
+//	on-error  - the output's ErrorPolicy (defaults to "fail", like
+//	            Output.OnError, so that a misconfigured output
+//	            stops the daemon instead of dropping events)
+//	format    - the events' Format (defaults to "json")
+//
+// For example:
+//
+//	tcp://collector:5140?types=UserLogin,UserAction&buffer=1000
+//
+// File outputs accept the following additional query parameters:
+//
+//	max-size   - RotationConfig.MaxSize in bytes
+//	max-age    - RotationConfig.MaxAge (e.g., "24h")
+//	compress   - RotationConfig.Compress
+//	max-files  - RotationConfig.MaxFiles
+//	retention  - RotationConfig.Retention (e.g., "720h")
+//
+// HTTP and OTLP outputs accept the following additional query
+// parameters, which are removed from the URL the events are sent to:
+//
+//	batch-size          - BatchConfig.BatchSize
+//	flush-interval      - BatchConfig.FlushInterval (e.g., "5s")
+//	gzip                - BatchConfig.Gzip (defaults to true)
+//	request-timeout     - BatchConfig.RequestTimeout
+//	max-retry-interval  - BatchConfig.MaxRetryInterval
+//	max-queued-batches  - BatchConfig.MaxQueuedBatches
+//	spool-dir           - BatchConfig.SpoolDir
+//	spool-max-bytes     - BatchConfig.SpoolMaxBytes (defaults to
+//	                      DefaultSpoolMaxBytes)
+//	bearer-token-file   - file containing a bearer token that is sent
+//	                      in the Authorization header
+//	ca-file             - PEM-encoded CA certificates used to verify
+//	                      the endpoint's certificate
+//	cert-file, key-file - PEM-encoded client certificate and key
+//	                      used for mutual TLS
+//
+// Opening a file blocks until the file exists or ctx is marked as done.
+func ParseOutput(ctx context.Context, spec string, opts OutputOptions) (*Output, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output %q: %w", spec, err)
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop().Sugar()
+	}
+
+	query := u.Query()
+
+	output := &Output{
+		Name:   query.Get("name"),
+		Filter: NewTypeFilter(splitList(query.Get("types"))),
+	}
+
+	if output.Name == "" {
+		withoutQuery := *u
+		withoutQuery.RawQuery = ""
+		withoutQuery.User = nil
+		output.Name = withoutQuery.String()
+	}
+
+	if s := query.Get("buffer"); s != "" {
+		output.BufferSize, err = strconv.Atoi(s)
+		if err != nil || output.BufferSize < 0 {
+			return nil, fmt.Errorf("invalid buffer size for output %q: %q", output.Name, s)
+		}
+	}
+
+	if s := query.Get("on-error"); s != "" {
+		output.OnError, err = ParseErrorPolicy(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error policy for output %q: %w", output.Name, err)
+		}
+	}
+
+	enc, err := NewEncoder(Format(query.Get("format")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid format for output %q: %w", output.Name, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		output.Sink, err = newWebhookFromURL(output.Name, u, enc, opts)
+	case "otlp+http", "otlp+https", "otlp+grpc", "otlp+grpcs":
+		output.Sink, err = newOTLPExporterFromURL(output.Name, u, opts)
+	default:
+		var w io.Writer
+		w, err = openOutputWriter(ctx, u, opts.Logger)
+		if err == nil {
+			output.Sink = NewEncodingWriterSink(w, enc)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output %q: %w", output.Name, err)
+	}
+
+	return output, nil
+}
+
+func parseRotationConfig(query url.Values) (RotationConfig, error) {
+	var config RotationConfig
+	var err error
+
+	if s := query.Get("max-size"); s != "" {
+		config.MaxSize, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid max-size: %q", s)
+		}
+	}
+
+	if s := query.Get("max-age"); s != "" {
+		config.MaxAge, err = time.ParseDuration(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid max-age: %q", s)
+		}
+	}
+
+	if s := query.Get("compress"); s != "" {
+		config.Compress, err = strconv.ParseBool(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid compress: %q", s)
+		}
+	}
+
+	if s := query.Get("max-files"); s != "" {
+		config.MaxFiles, err = strconv.Atoi(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid max-files: %q", s)
+		}
+	}
+
+	if s := query.Get("retention"); s != "" {
+		config.Retention, err = time.ParseDuration(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid retention: %q", s)
+		}
+	}
+
+	return config, nil
+}
+
+// outputQueryParams are the query parameters that apply to any output.
+var outputQueryParams = []string{"name", "types", "buffer", "on-error", "format"}
+
+// batchQueryParams are the query parameters that configure a BatchConfig.
+var batchQueryParams = []string{
+	"batch-size", "flush-interval", "gzip", "request-timeout",
+	"max-retry-interval", "max-queued-batches", "spool-dir", "spool-max-bytes",
+}
+
+func parseBatchConfig(query url.Values) (BatchConfig, error) {
+	config := BatchConfig{
+		Gzip:          true,
+		SpoolDir:      query.Get("spool-dir"),
+		SpoolMaxBytes: DefaultSpoolMaxBytes,
+	}
+
+	var err error
+
+	intParams := map[string]*int{
+		"batch-size":         &config.BatchSize,
+		"max-queued-batches": &config.MaxQueuedBatches,
+	}
+	for param, value := range intParams {
+		if s := query.Get(param); s != "" {
+			*value, err = strconv.Atoi(s)
+			if err != nil {
+				return config, fmt.Errorf("invalid %s: %q", param, s)
+			}
+		}
+	}
+
+	durationParams := map[string]*time.Duration{
+		"flush-interval":     &config.FlushInterval,
+		"request-timeout":    &config.RequestTimeout,
+		"max-retry-interval": &config.MaxRetryInterval,
+	}
+	for param, value := range durationParams {
+		if s := query.Get(param); s != "" {
+			*value, err = time.ParseDuration(s)
+			if err != nil {
+				return config, fmt.Errorf("invalid %s: %q", param, s)
+			}
+		}
+	}
+
+	if s := query.Get("gzip"); s != "" {
+		config.Gzip, err = strconv.ParseBool(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid gzip: %q", s)
+		}
+	}
+
+	if s := query.Get("spool-max-bytes"); s != "" {
+		config.SpoolMaxBytes, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid spool-max-bytes: %q", s)
+		}
+	}
+
+	return config, nil
+}
+
+// httpQueryParams are the query parameters that
+// configure the requests of HTTP-based outputs.
+var httpQueryParams = []string{"bearer-token-file", "ca-file", "cert-file", "key-file"}
+
+// parseHTTPClientConfig returns the request headers and the HTTP client
+// configured by httpQueryParams. The client is nil if the defaults apply.
+func parseHTTPClientConfig(query url.Values) (http.Header, *http.Client, error) {
+	header := make(http.Header)
+
+	if s := query.Get("bearer-token-file"); s != "" {
+		token, err := os.ReadFile(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bearer token file: %w", err)
+		}
+
+		header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	caFile := query.Get("ca-file")
+	certFile := query.Get("cert-file")
+	keyFile := query.Get("key-file")
+
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return header, nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("ca file %q contains no certificates", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return header, &http.Client{Transport: transport}, nil
+}
+
+// endpointURL returns u without the query parameters in params.
+func endpointURL(u *url.URL, params ...[]string) *url.URL {
+	query := u.Query()
+	for _, list := range params {
+		for _, param := range list {
+			query.Del(param)
+		}
+	}
+
+	endpoint := *u
+	endpoint.RawQuery = query.Encode()
+
+	return &endpoint
+}
+
+func newWebhookFromURL(name string, u *url.URL, enc Encoder, opts OutputOptions) (*Webhook, error) {
+	query := u.Query()
+
+	batchConfig, err := parseBatchConfig(query)
+	if err != nil {
+		return nil, err
+	}
+
+	header, client, err := parseHTTPClientConfig(query)
+	if err != nil {
+		return nil, err
+	}
+
+	config := WebhookConfig{
+		URL:         endpointURL(u, batchQueryParams, httpQueryParams, outputQueryParams).String(),
+		Header:      header,
+		Encoder:     enc,
+		Client:      client,
+		BatchConfig: batchConfig,
+	}
+
+	return NewWebhook(name, config, opts.Logger, opts.Metrics)
+}
+
+func newOTLPExporterFromURL(name string, u *url.URL, opts OutputOptions) (*OTLPExporter, error) {
+	query := u.Query()
+
+	batchConfig, err := parseBatchConfig(query)
+	if err != nil {
+		return nil, err
+	}
+
+	header, client, err := parseHTTPClientConfig(query)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := endpointURL(u, batchQueryParams, httpQueryParams, outputQueryParams)
+
+	config := OTLPConfig{
+		Header:             header,
+		Client:             client,
+		ResourceAttributes: opts.ResourceAttributes,
+		BatchConfig:        batchConfig,
+	}
+
+	switch u.Scheme {
+	case "otlp+http", "otlp+https":
+		config.Protocol = OTLPProtocolHTTP
+		endpoint.Scheme = strings.TrimPrefix(u.Scheme, "otlp+")
+
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = "/v1/logs"
+		}
+	case "otlp+grpcs":
+		config.Protocol = OTLPProtocolGRPC
+		endpoint.Scheme = "https"
+	case "otlp+grpc":
+		return nil, errors.New("plaintext otlp grpc is not supported, use otlp+grpcs or otlp+http")
+	}
+
+	config.Endpoint = endpoint.String()
+
+	return NewOTLPExporter(name, config, opts.Logger, opts.Metrics)
+}
+
+func openOutputWriter(ctx context.Context, u *url.URL, l *zap.SugaredLogger) (io.Writer, error) {
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("file path is empty")
+		}
+
+		rotation, err := parseRotationConfig(u.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		return OpenRotatingFile(ctx, u.Path, rotation, l)
+	case "stdout":
+		return nopCloser{Writer: os.Stdout}, nil
+	case "stderr":
+		return nopCloser{Writer: os.Stderr}, nil
+	case "tcp", "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s address is empty", u.Scheme)
+		}
+
+		return NewNetWriter(u.Scheme, u.Host), nil
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("unix socket path is empty")
+		}
+
+		return NewNetWriter(u.Scheme, u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported output type: %q", u.Scheme)
+	}
+}
+
+// nopCloser prevents WriterSink from closing
+// the standard output and standard error.
+type nopCloser struct {
+	io.Writer
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
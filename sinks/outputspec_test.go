@@ -0,0 +1,109 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestParseOutput(t *testing.T) {
+	t.Parallel()
+
+	output, err := ParseOutput(context.Background(),
+		"stdout:?types=UserLogin,%20UserAction&buffer=100&on-error=fail", OutputOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "stdout:", output.Name)
+	assert.Equal(t, 100, output.BufferSize)
+	assert.Equal(t, ErrorPolicyFail, output.OnError)
+	assert.True(t, output.allows(testtools.NewEventBuilder("UserAction").Build()))
+	assert.False(t, output.allows(testtools.NewEventBuilder("UserLogout").Build()))
+
+	output, err = ParseOutput(context.Background(), "tcp://127.0.0.1:1?name=collector", OutputOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "collector", output.Name)
+	assert.Nil(t, output.Filter)
+}
+
+func TestParseOutput_DefaultErrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	output, err := ParseOutput(context.Background(), "stdout:", OutputOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ErrorPolicyFail, output.errorPolicy())
+
+	// An output without on-error fails like an Output without OnError.
+	output.Sink = &testSink{err: errTest}
+	assert.ErrorIs(t, NewFanOut(nil, output).Write(testtools.NewEventBuilder("UserLogin").Build()), errTest)
+
+	output, err = ParseOutput(context.Background(), "stdout:?on-error=log", OutputOptions{})
+	require.NoError(t, err)
+
+	output.Sink = &testSink{err: errTest}
+	assert.NoError(t, NewFanOut(nil, output).Write(testtools.NewEventBuilder("UserLogin").Build()))
+}
+
+func TestParseOutput_Invalid(t *testing.T) {
+	t.Parallel()
+
+	specs := []string{
+		"ftp://example.com",
+		"tcp://",
+		"unix://",
+		"stdout:?buffer=-1",
+		"stdout:?buffer=abc",
+		"stdout:?on-error=ignore",
+	}
+
+	for _, spec := range specs {
+		_, err := ParseOutput(context.Background(), spec, OutputOptions{})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseOutput_TCP(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan *auditevent.AuditEvent, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var event auditevent.AuditEvent
+		scanner := bufio.NewScanner(conn)
+		if scanner.Scan() && json.Unmarshal(scanner.Bytes(), &event) == nil {
+			received <- &event
+		}
+	}()
+
+	output, err := ParseOutput(context.Background(), "tcp://"+ln.Addr().String(), OutputOptions{})
+	require.NoError(t, err)
+
+	fo := NewFanOut(nil, output)
+	defer fo.Close()
+
+	require.NoError(t, fo.Write(testtools.NewEventBuilder("UserLogin").Build()))
+
+	event := <-received
+	assert.Equal(t, "UserLogin", event.Type)
+	assert.Equal(t, "root", event.Subjects["loggedAs"])
+}
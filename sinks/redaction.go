@@ -0,0 +1,354 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+const (
+	// defaultRedactionReplacement is the default
+	// replacement of RedactionRule.
+	defaultRedactionReplacement = "[redacted]"
+
+	// PseudonymPrefix prefixes the pseudonyms of field values.
+	PseudonymPrefix = "pseudo:"
+
+	// minPseudonymizationKeyLen is the minimum length of the
+	// HMAC key used for pseudonymization.
+	minPseudonymizationKeyLen = 16
+
+	// pseudonymLen is the number of bytes of the HMAC
+	// that are kept in a pseudonym.
+	pseudonymLen = 16
+)
+
+// DefaultRedactionFields are the fields that RedactionRules
+// apply to if RedactionRule.Fields is empty.
+var DefaultRedactionFields = []string{
+	"metadata.extra.process_args",
+	"metadata.extra.command_line",
+	"metadata.extra.transcript",
+}
+
+// RedactionRule replaces the text that matches a regular
+// expression in string fields (or arrays of strings).
+type RedactionRule struct {
+	// Pattern is a regular expression (RE2 syntax).
+	Pattern string `json:"pattern"`
+
+	// Replacement replaces the matching text. It may refer to
+	// capture groups (e.g., "${1}"). It defaults to "[redacted]".
+	Replacement string `json:"replacement,omitempty"`
+
+	// Fields are the paths of the fields that the rule applies
+	// to. It defaults to DefaultRedactionFields.
+	Fields []string `json:"fields,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RedactionPolicy describes how events are redacted before they are
+// written. Fields are referred to by the dot-separated path of their
+// JSON encoding (e.g., "source.value", "subjects.loggedAs" or
+// "metadata.extra.command_line").
+type RedactionPolicy struct {
+	// Redact are the regex-based redaction rules.
+	Redact []RedactionRule `json:"redact,omitempty"`
+
+	// Pseudonymize are the paths of the fields whose values are
+	// replaced by a keyed hash. A given value always results in the
+	// same pseudonym, which allows correlating events without
+	// revealing the value.
+	Pseudonymize []string `json:"pseudonymize,omitempty"`
+
+	// PseudonymizationKeyFile is the path to a file containing the
+	// HMAC key used for pseudonymization. It is required if
+	// Pseudonymize is not empty.
+	PseudonymizationKeyFile string `json:"pseudonymizationKeyFile,omitempty"`
+
+	// Drop are the paths of the fields that are removed.
+	Drop []string `json:"drop,omitempty"`
+
+	key []byte
+}
+
+// ParseRedactionPolicy parses a JSON-encoded RedactionPolicy. For example:
+//
+//	{
+//	  "redact": [
+//	    {"pattern": "(--password[= ])\\S+", "replacement": "${1}[redacted]"},
+//	    {"pattern": "://[^/@\\s]+@", "replacement": "://[redacted]@"}
+//	  ],
+//	  "pseudonymize": ["source.value", "subjects.loggedAs"],
+//	  "pseudonymizationKeyFile": "/etc/audito-maldito/pseudonymization.key",
+//	  "drop": ["metadata.extra.cwd"]
+//	}
+//
+// The pseudonymization key is not read. Refer to ReadRedactionPolicy.
+func ParseRedactionPolicy(r io.Reader) (*RedactionPolicy, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var policy RedactionPolicy
+
+	err := dec.Decode(&policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode redaction policy - %w", err)
+	}
+
+	for i := range policy.Redact {
+		rule := &policy.Redact[i]
+
+		rule.re, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q - %w", rule.Pattern, err)
+		}
+
+		if rule.Replacement == "" {
+			rule.Replacement = defaultRedactionReplacement
+		}
+
+		if len(rule.Fields) == 0 {
+			rule.Fields = DefaultRedactionFields
+		}
+	}
+
+	if len(policy.Pseudonymize) > 0 && policy.PseudonymizationKeyFile == "" {
+		return nil, errors.New("pseudonymization requires a pseudonymizationKeyFile")
+	}
+
+	return &policy, nil
+}
+
+// ReadRedactionPolicy reads a JSON-encoded RedactionPolicy from
+// a file, as well as its pseudonymization key (if any).
+func ReadRedactionPolicy(filePath string) (*RedactionPolicy, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open redaction policy file: %w", err)
+	}
+	defer f.Close()
+
+	policy, err := ParseRedactionPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redaction policy file %q: %w", filePath, err)
+	}
+
+	if policy.PseudonymizationKeyFile != "" {
+		key, err := os.ReadFile(policy.PseudonymizationKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pseudonymization key file: %w", err)
+		}
+
+		err = policy.SetPseudonymizationKey(bytes.TrimSpace(key))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+// SetPseudonymizationKey sets the HMAC key used for pseudonymization.
+func (o *RedactionPolicy) SetPseudonymizationKey(key []byte) error {
+	if len(key) < minPseudonymizationKeyLen {
+		return fmt.Errorf("pseudonymization key must be at least %d bytes long",
+			minPseudonymizationKeyLen)
+	}
+
+	o.key = key
+
+	return nil
+}
+
+// Apply returns a copy of the event to which the policy was applied.
+func (o *RedactionPolicy) Apply(event *auditevent.AuditEvent) (*auditevent.AuditEvent, error) {
+	if len(o.Pseudonymize) > 0 && o.key == nil {
+		return nil, errors.New("pseudonymization key is not set")
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event for redaction: %w", err)
+	}
+
+	doc, err := decodeJSONObject(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event for redaction: %w", err)
+	}
+
+	o.redactDocument(doc)
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode redacted event: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var redacted auditevent.AuditEvent
+
+	err = dec.Decode(&redacted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode redacted event: %w", err)
+	}
+
+	return &redacted, nil
+}
+
+// ApplyToField applies the policy to value as if it were the value
+// of the event field at path (e.g., to redact a copy of the field
+// that is written elsewhere). It returns false if the policy drops
+// the field.
+func (o *RedactionPolicy) ApplyToField(path string, value string) (string, bool) {
+	keys := strings.Split(path, ".")
+
+	doc := make(map[string]any)
+	parent := doc
+	for _, key := range keys[:len(keys)-1] {
+		child := make(map[string]any)
+		parent[key] = child
+		parent = child
+	}
+
+	parent[keys[len(keys)-1]] = value
+
+	o.redactDocument(doc)
+
+	parent, key := lookupParent(doc, path)
+	redacted, ok := parent[key].(string)
+
+	return redacted, ok
+}
+
+// redactDocument redacts a JSON-decoded audit event in place.
+func (o *RedactionPolicy) redactDocument(doc map[string]any) {
+	for _, rule := range o.Redact {
+		for _, path := range rule.Fields {
+			updateField(doc, path, func(s string) string {
+				return rule.re.ReplaceAllString(s, rule.Replacement)
+			})
+		}
+	}
+
+	for _, path := range o.Pseudonymize {
+		updateField(doc, path, o.pseudonym)
+	}
+
+	for _, path := range o.Drop {
+		parent, key := lookupParent(doc, path)
+		if parent != nil {
+			delete(parent, key)
+		}
+	}
+}
+
+// pseudonym returns the pseudonym of s. Placeholders for
+// unknown values are kept, as they do not identify anyone.
+func (o *RedactionPolicy) pseudonym(s string) string {
+	if s == "" || knownValue(s) == "" {
+		return s
+	}
+
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write([]byte(s))
+
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:pseudonymLen])
+}
+
+// lookupParent returns the object that contains the field at path
+// and the field's key. The object is nil if it does not exist.
+func lookupParent(doc map[string]any, path string) (map[string]any, string) {
+	keys := strings.Split(path, ".")
+
+	parent := doc
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[string]any)
+		if !ok {
+			return nil, ""
+		}
+
+		parent = child
+	}
+
+	return parent, keys[len(keys)-1]
+}
+
+// updateField replaces the value of the field at path by the result
+// of fn. Strings and arrays of strings are updated, other values
+// (e.g., numbers) are formatted as strings first.
+func updateField(doc map[string]any, path string, fn func(string) string) {
+	parent, key := lookupParent(doc, path)
+	if parent == nil {
+		return
+	}
+
+	value, ok := parent[key]
+	if !ok || value == nil {
+		return
+	}
+
+	parent[key] = updateValue(value, fn)
+}
+
+func updateValue(value any, fn func(string) string) any {
+	switch v := value.(type) {
+	case string:
+		return fn(v)
+	case []any:
+		for i := range v {
+			v[i] = updateValue(v[i], fn)
+		}
+		return v
+	case map[string]any:
+		for k := range v {
+			v[k] = updateValue(v[k], fn)
+		}
+		return v
+	default:
+		return fn(fmt.Sprint(v))
+	}
+}
+
+var _ EventSink = &RedactingSink{}
+
+// NewRedactingSink returns a new RedactingSink that writes
+// events to next after applying policy to them.
+func NewRedactingSink(next EventSink, policy *RedactionPolicy) *RedactingSink {
+	return &RedactingSink{
+		next:   next,
+		policy: policy,
+	}
+}
+
+// RedactingSink is an EventSink that applies a RedactionPolicy to
+// a copy of each event before writing it to another EventSink. When
+// it is placed in front of a FanOut, every output receives the same
+// redacted event.
+type RedactingSink struct {
+	next   EventSink
+	policy *RedactionPolicy
+}
+
+// Write redacts a copy of the event and writes it to the next
+// EventSink. The original event is not modified.
+func (o *RedactingSink) Write(event *auditevent.AuditEvent) error {
+	redacted, err := o.policy.Apply(event)
+	if err != nil {
+		return err
+	}
+
+	return o.next.Write(redacted)
+}
@@ -0,0 +1,567 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/metal-toolbox/auditevent/helpers"
+	"go.uber.org/zap"
+)
+
+const (
+	rotatedFilePerms = 0o640
+
+	// rotatedTimeLayout is the layout of the timestamp appended to
+	// the names of rotated segments. It sorts chronologically.
+	rotatedTimeLayout = "20060102T150405.000000000"
+
+	compressedExt = ".gz"
+
+	// rotationRetryInterval is the delay before a
+	// rotation is attempted again after it failed.
+	rotationRetryInterval = time.Minute
+)
+
+// RotationConfig configures the rotation of a RotatingFile.
+type RotationConfig struct {
+	// MaxSize is the size in bytes at which the file is rotated.
+	// Zero disables rotation by size.
+	MaxSize int64
+
+	// MaxAge is the age at which the file is rotated, measured
+	// from when the file was opened. The file is rotated on the
+	// first write after it exceeds MaxAge. Zero disables rotation
+	// by age.
+	MaxAge time.Duration
+
+	// Compress enables gzip compression of rotated segments.
+	Compress bool
+
+	// MaxFiles is the maximum number of rotated segments to keep.
+	// Zero means no limit.
+	MaxFiles int
+
+	// Retention is the duration for which rotated segments are
+	// kept. Zero means no limit.
+	Retention time.Duration
+}
+
+// Enabled returns true if the configuration rotates the file.
+func (o RotationConfig) Enabled() bool {
+	return o.MaxSize > 0 || o.MaxAge > 0
+}
+
+// Reopener is implemented by outputs that can reopen their
+// destination, such as a file that was moved by logrotate.
+type Reopener interface {
+	Reopen() error
+}
+
+var (
+	_ io.WriteCloser = &RotatingFile{}
+	_ Reopener       = &RotatingFile{}
+)
+
+// OpenRotatingFile opens the file at filePath for appending.
+//
+// If rotation is enabled, the file is created if it does not exist.
+// Otherwise, OpenRotatingFile blocks until the file exists (e.g., a
+// named pipe created by another process) or ctx is marked as done.
+// Named pipes and other non-regular files are never rotated.
+func OpenRotatingFile(ctx context.Context, filePath string, config RotationConfig,
+	l *zap.SugaredLogger,
+) (*RotatingFile, error) {
+	if l == nil {
+		l = zap.NewNop().Sugar()
+	}
+
+	o := &RotatingFile{
+		path:   filePath,
+		config: config,
+		l:      l,
+	}
+
+	var f *os.File
+	var err error
+	if config.Enabled() {
+		f, err = os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, rotatedFilePerms)
+	} else {
+		f, err = helpers.OpenAuditLogFileUntilSuccessWithContext(ctx, filePath, zapr.NewLogger(l.Desugar()))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.setFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if o.rotatable {
+		// Finish the work of a previous process, which may
+		// have exited before compressing a segment.
+		o.startHousekeeping()
+	}
+
+	return o, nil
+}
+
+// RotatingFile is an io.Writer that appends to a file and rotates it
+// by size and age. Rotated segments are renamed by appending the time
+// of the rotation to the file's name (e.g., "events.log.20230317T133701.952000000"),
+// and are optionally compressed and deleted according to the configured
+// retention.
+//
+// Each call to Write is written to a single segment, which means that
+// records are never split across a rotation. Segments are synced to
+// disk before they are rotated, reopened or closed.
+type RotatingFile struct {
+	path   string
+	config RotationConfig
+	l      *zap.SugaredLogger
+
+	// housekeeping tracks the goroutines that compress
+	// and delete rotated segments. housekeepingMu ensures
+	// only one of them runs at a time.
+	housekeeping   sync.WaitGroup
+	housekeepingMu sync.Mutex
+
+	mu        sync.Mutex
+	f         *os.File
+	size      int64
+	openedAt  time.Time
+	regular   bool
+	rotatable bool
+
+	// retryRotationAt is the time before which rotation
+	// is not attempted again after it failed.
+	retryRotationAt time.Time
+}
+
+// setFile sets the current file. The caller must hold mu
+// (or have exclusive access to o).
+func (o *RotatingFile) setFile(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	o.f = f
+	o.size = info.Size()
+	o.openedAt = time.Now()
+	o.regular = info.Mode().IsRegular()
+	o.rotatable = o.config.Enabled() && o.regular
+
+	return nil
+}
+
+// Write writes p to the current segment, rotating
+// it first if it is too large or too old.
+func (o *RotatingFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if o.shouldRotate(len(p)) {
+		err := o.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("failed to rotate %q: %w", o.path, err)
+		}
+	}
+
+	n, err := o.f.Write(p)
+	o.size += int64(n)
+
+	return n, err
+}
+
+func (o *RotatingFile) shouldRotate(writeLen int) bool {
+	if !o.rotatable || o.size == 0 || time.Now().Before(o.retryRotationAt) {
+		return false
+	}
+
+	if o.config.MaxSize > 0 && o.size+int64(writeLen) > o.config.MaxSize {
+		return true
+	}
+
+	return o.config.MaxAge > 0 && time.Since(o.openedAt) >= o.config.MaxAge
+}
+
+// rotate syncs and renames the current segment, opens a new
+// one and starts compressing and deleting old segments.
+//
+// If the segment cannot be renamed, or the new segment cannot be
+// opened, the file at the configured path is reopened (or the
+// current file is kept) so that writes can go on, and rotation
+// is retried after rotationRetryInterval.
+//
+// The caller must hold mu.
+func (o *RotatingFile) rotate() error {
+	err := o.f.Sync()
+	if err != nil {
+		return err
+	}
+
+	rotatedPath := o.rotatedPath(time.Now())
+
+	err = os.Rename(o.path, rotatedPath)
+	if err != nil {
+		o.l.Errorf("failed to rename %q for rotation, appending to it instead - %s", o.path, err)
+		o.reopenAfterFailedRotation()
+
+		return nil
+	}
+
+	// The path may have been recreated in the
+	// meantime (e.g., by logrotate), in which
+	// case the new segment is appended to it.
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, rotatedFilePerms)
+	if err != nil {
+		o.l.Errorf("failed to open new segment %q, appending to %q instead - %s",
+			o.path, rotatedPath, err)
+		o.retryRotationAt = time.Now().Add(rotationRetryInterval)
+
+		return nil
+	}
+
+	previous := o.f
+
+	err = o.setFile(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if o.size > 0 {
+		o.l.Warnf("%q was recreated while it was rotated, appending to it", o.path)
+	}
+
+	err = previous.Close()
+	if err != nil {
+		o.l.Errorf("failed to close rotated segment %q - %s", rotatedPath, err)
+	}
+
+	// Persist the rename and the new file.
+	err = syncDir(filepath.Dir(o.path))
+	if err != nil {
+		return err
+	}
+
+	o.startHousekeeping()
+
+	return nil
+}
+
+// reopenAfterFailedRotation opens the file at the configured path
+// after the current segment could not be renamed (e.g., because the
+// file was deleted), and keeps the current file if that fails too.
+// Rotation is retried after rotationRetryInterval. The caller must
+// hold mu.
+func (o *RotatingFile) reopenAfterFailedRotation() {
+	o.retryRotationAt = time.Now().Add(rotationRetryInterval)
+
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, rotatedFilePerms)
+	if err != nil {
+		o.l.Errorf("failed to reopen %q, appending to the current file - %s", o.path, err)
+		return
+	}
+
+	previous := o.f
+
+	err = o.setFile(f)
+	if err != nil {
+		_ = f.Close()
+		o.l.Errorf("failed to reopen %q, appending to the current file - %s", o.path, err)
+
+		return
+	}
+
+	_ = previous.Close()
+}
+
+// rotatedPath returns an unused path for a segment rotated at t.
+func (o *RotatingFile) rotatedPath(t time.Time) string {
+	for {
+		p := o.path + "." + t.UTC().Format(rotatedTimeLayout)
+
+		_, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			_, err = os.Lstat(p + compressedExt)
+			if os.IsNotExist(err) {
+				return p
+			}
+		}
+
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+// Reopen syncs and closes the current file, and then opens the file
+// at the configured path, creating it if needed. It is meant to be
+// called after the file was moved by an external tool (e.g., logrotate).
+func (o *RotatingFile) Reopen() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.f == nil {
+		return os.ErrClosed
+	}
+
+	if !o.regular {
+		// Named pipes do not need to be reopened.
+		return nil
+	}
+
+	err := o.f.Sync()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, rotatedFilePerms)
+	if err != nil {
+		return err
+	}
+
+	err = o.f.Close()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return o.setFile(f)
+}
+
+// Close syncs and closes the current file, and waits for
+// rotated segments to be compressed.
+func (o *RotatingFile) Close() error {
+	o.mu.Lock()
+
+	var err error
+	if o.f != nil {
+		if o.regular {
+			err = o.f.Sync()
+		}
+
+		closeErr := o.f.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		o.f = nil
+	}
+
+	o.mu.Unlock()
+
+	o.housekeeping.Wait()
+
+	return err
+}
+
+// startHousekeeping compresses and deletes rotated
+// segments in the background.
+func (o *RotatingFile) startHousekeeping() {
+	o.housekeeping.Add(1)
+
+	go func() {
+		defer o.housekeeping.Done()
+
+		o.housekeepingMu.Lock()
+		defer o.housekeepingMu.Unlock()
+
+		err := o.doHousekeeping(time.Now())
+		if err != nil {
+			o.l.Errorf("failed to clean up rotated segments of %q - %s", o.path, err)
+		}
+	}()
+}
+
+// rotatedSegment is a segment that was rotated at a given time.
+type rotatedSegment struct {
+	path      string
+	rotatedAt time.Time
+}
+
+// doHousekeeping compresses rotated segments (if enabled) and
+// deletes the segments that exceed the configured retention.
+func (o *RotatingFile) doHousekeeping(now time.Time) error {
+	segments, err := o.rotatedSegments()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+
+	keep := make([]rotatedSegment, 0, len(segments))
+
+	for i, segment := range segments {
+		expired := o.config.Retention > 0 && now.Sub(segment.rotatedAt) > o.config.Retention
+		tooMany := o.config.MaxFiles > 0 && len(segments)-i > o.config.MaxFiles
+
+		if expired || tooMany {
+			err = os.Remove(segment.path)
+			if err != nil && !os.IsNotExist(err) && firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		keep = append(keep, segment)
+	}
+
+	if !o.config.Compress {
+		return firstErr
+	}
+
+	for _, segment := range keep {
+		if strings.HasSuffix(segment.path, compressedExt) {
+			continue
+		}
+
+		err = compressFile(segment.path)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// rotatedSegments returns the rotated segments, oldest first.
+// Incomplete compressed segments are removed.
+func (o *RotatingFile) rotatedSegments() ([]rotatedSegment, error) {
+	dir := filepath.Dir(o.path)
+	prefix := filepath.Base(o.path) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []rotatedSegment
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		timestamp := strings.TrimPrefix(name, prefix)
+
+		if strings.HasSuffix(timestamp, compressedExt+".tmp") {
+			// Left behind by a process that exited while compressing.
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		timestamp = strings.TrimSuffix(timestamp, compressedExt)
+
+		rotatedAt, err := time.Parse(rotatedTimeLayout, timestamp)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, rotatedSegment{
+			path:      filepath.Join(dir, name),
+			rotatedAt: rotatedAt,
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].rotatedAt.Before(segments[j].rotatedAt)
+	})
+
+	// A segment may be found both compressed and uncompressed if
+	// a process exited before removing the uncompressed segment.
+	deduped := segments[:0]
+	for _, segment := range segments {
+		last := len(deduped) - 1
+		if last < 0 || !segment.rotatedAt.Equal(deduped[last].rotatedAt) {
+			deduped = append(deduped, segment)
+			continue
+		}
+
+		uncompressed := segment.path
+		if strings.HasSuffix(segment.path, compressedExt) {
+			uncompressed = deduped[last].path
+			deduped[last] = segment
+		}
+
+		_ = os.Remove(uncompressed)
+	}
+
+	return deduped, nil
+}
+
+// compressFile replaces the file at filePath with a gzip-compressed
+// copy whose name ends with ".gz".
+func compressFile(filePath string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath := filePath + compressedExt
+	tmpPath := dstPath + ".tmp"
+
+	dst, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, rotatedFilePerms)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, dstPath)
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filePath)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
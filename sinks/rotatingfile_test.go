@@ -0,0 +1,296 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSegments returns the contents of the rotated segments
+// (oldest first) followed by the contents of the current file.
+func readSegments(t *testing.T, filePath string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filePath + ".*")
+	require.NoError(t, err)
+	sort.Strings(matches)
+
+	var contents []string
+	for _, match := range append(matches, filePath) {
+		f, err := os.Open(match)
+		require.NoError(t, err)
+
+		var r io.Reader = f
+		if strings.HasSuffix(match, ".gz") {
+			r, err = gzip.NewReader(f)
+			require.NoError(t, err)
+		}
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		contents = append(contents, string(b))
+	}
+
+	return contents
+}
+
+func testRecord(i int) string {
+	return fmt.Sprintf("{\"record\":%03d,\"padding\":\"xxxxxxxxxx\"}\n", i)
+}
+
+func TestRotatingFile_MaxSize(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxSize: 100,
+	}, nil)
+	require.NoError(t, err)
+
+	var expected strings.Builder
+	for i := 0; i < 10; i++ {
+		record := testRecord(i)
+		expected.WriteString(record)
+
+		_, err = rf.Write([]byte(record))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, rf.Close())
+
+	segments := readSegments(t, filePath)
+	require.Len(t, segments, 5)
+
+	for _, segment := range segments {
+		assert.LessOrEqual(t, len(segment), 100)
+		assert.True(t, strings.HasSuffix(segment, "\n"), "records must not be split")
+	}
+
+	assert.Equal(t, expected.String(), strings.Join(segments, ""))
+}
+
+func TestRotatingFile_MaxAge(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxAge: time.Millisecond,
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = rf.Write([]byte(testRecord(1)))
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = rf.Write([]byte(testRecord(2)))
+	require.NoError(t, err)
+
+	require.NoError(t, rf.Close())
+
+	assert.Equal(t, []string{testRecord(1), testRecord(2)}, readSegments(t, filePath))
+}
+
+func TestRotatingFile_RenameFails(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxSize: 50,
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = rf.Write([]byte(testRecord(1)))
+	require.NoError(t, err)
+
+	// The segment cannot be renamed once it was deleted:
+	// the file is recreated instead.
+	require.NoError(t, os.Remove(filePath))
+
+	_, err = rf.Write([]byte(testRecord(2)))
+	require.NoError(t, err)
+
+	// Rotation is not retried right away.
+	_, err = rf.Write([]byte(testRecord(3)))
+	require.NoError(t, err)
+
+	require.NoError(t, rf.Close())
+
+	assert.Equal(t, []string{testRecord(2) + testRecord(3)}, readSegments(t, filePath))
+}
+
+func TestRotatingFile_ReadOnlyDir(t *testing.T) {
+	t.Parallel()
+
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "events.log")
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxSize: 50,
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = rf.Write([]byte(testRecord(1)))
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(dir, 0o500))
+	defer func() {
+		require.NoError(t, os.Chmod(dir, 0o700))
+	}()
+
+	_, err = rf.Write([]byte(testRecord(2)))
+	require.NoError(t, err)
+
+	require.NoError(t, rf.Close())
+
+	assert.Equal(t, []string{testRecord(1) + testRecord(2)}, readSegments(t, filePath))
+}
+
+func TestRotatingFile_CompressAndMaxFiles(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxSize:  int64(len(testRecord(0))),
+		Compress: true,
+		MaxFiles: 2,
+	}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = rf.Write([]byte(testRecord(i)))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, rf.Close())
+
+	matches, err := filepath.Glob(filePath + ".*")
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+
+	for _, match := range matches {
+		assert.True(t, strings.HasSuffix(match, ".gz"), match)
+	}
+
+	assert.Equal(t, []string{testRecord(2), testRecord(3), testRecord(4)}, readSegments(t, filePath))
+}
+
+func TestRotatingFile_Retention(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "events.log")
+
+	now := time.Now().UTC()
+	old := filePath + "." + now.Add(-48*time.Hour).Format(rotatedTimeLayout) + ".gz"
+	recent := filePath + "." + now.Add(-time.Hour).Format(rotatedTimeLayout)
+	unrelated := filepath.Join(dir, "events.log.bak")
+	incomplete := recent + ".gz.tmp"
+
+	for _, p := range []string{old, recent, unrelated, incomplete} {
+		require.NoError(t, os.WriteFile(p, []byte("x\n"), 0o600))
+	}
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{
+		MaxSize:   1024,
+		Retention: 24 * time.Hour,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, rf.Close())
+
+	assert.NoFileExists(t, old)
+	assert.NoFileExists(t, incomplete)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, unrelated)
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+	require.NoError(t, os.WriteFile(filePath, nil, 0o600))
+
+	rf, err := OpenRotatingFile(context.Background(), filePath, RotationConfig{}, nil)
+	require.NoError(t, err)
+
+	_, err = rf.Write([]byte(testRecord(1)))
+	require.NoError(t, err)
+
+	// Simulate logrotate moving the file.
+	require.NoError(t, os.Rename(filePath, filePath+".1"))
+
+	_, err = rf.Write([]byte(testRecord(2)))
+	require.NoError(t, err)
+
+	require.NoError(t, rf.Reopen())
+
+	_, err = rf.Write([]byte(testRecord(3)))
+	require.NoError(t, err)
+
+	require.NoError(t, rf.Close())
+
+	moved, err := os.ReadFile(filePath + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, testRecord(1)+testRecord(2), string(moved))
+
+	current, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, testRecord(3), string(current))
+
+	_, err = rf.Write([]byte(testRecord(4)))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFile_WaitsForFile(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Without rotation, the file is not created.
+	_, err := OpenRotatingFile(ctx, filePath, RotationConfig{}, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoFileExists(t, filePath)
+}
+
+func TestFanOut_Reopen(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "events.log")
+
+	output, err := ParseOutput(context.Background(), "file://"+filePath+"?max-size=1000000", OutputOptions{})
+	require.NoError(t, err)
+
+	fo := NewFanOut(nil, output)
+	require.NoError(t, fo.Write(newSampleLoginEvent()))
+
+	require.NoError(t, os.Rename(filePath, filePath+".1"))
+	require.NoError(t, fo.Reopen())
+	require.NoError(t, fo.Write(newSampleLoginEvent()))
+	require.NoError(t, fo.Close())
+
+	assert.FileExists(t, filePath+".1")
+	assert.FileExists(t, filePath)
+}
@@ -0,0 +1,31 @@
+package sinks
+
+import (
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+)
+
+var _ EventSink = &SchemaVersionSink{}
+
+// NewSchemaVersionSink returns a new SchemaVersionSink
+// that writes events to next.
+func NewSchemaVersionSink(next EventSink) *SchemaVersionSink {
+	return &SchemaVersionSink{
+		next: next,
+	}
+}
+
+// SchemaVersionSink is an EventSink that sets the version of the
+// event type's schema in the Metadata.Extra of a copy of each event
+// (refer to the schemas package). Events whose type does not have
+// a schema are written as-is.
+type SchemaVersionSink struct {
+	next EventSink
+}
+
+// Write writes a copy of the event that includes its
+// schema version to the next EventSink.
+func (o *SchemaVersionSink) Write(event *auditevent.AuditEvent) error {
+	return o.next.Write(schemas.Stamp(event))
+}
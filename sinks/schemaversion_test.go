@@ -0,0 +1,41 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/schemas"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestSchemaVersionSink_Write(t *testing.T) {
+	t.Parallel()
+
+	next := &testSink{}
+	sink := NewSchemaVersionSink(next)
+
+	event := testtools.NewEventBuilder("UserLogin").Build()
+	require.NoError(t, sink.Write(event))
+	require.Equal(t, 1, next.numEvents())
+
+	version, ok := schemas.Version("UserLogin")
+	require.True(t, ok)
+	assert.Equal(t, version, next.events[0].Metadata.Extra[schemas.VersionExtraKey])
+
+	// The original event is not modified.
+	assert.NotContains(t, event.Metadata.Extra, schemas.VersionExtraKey)
+}
+
+func TestSchemaVersionSink_WriteUnknownType(t *testing.T) {
+	t.Parallel()
+
+	next := &testSink{}
+	sink := NewSchemaVersionSink(next)
+
+	event := testtools.NewEventBuilder("SomethingElse").Build()
+	require.NoError(t, sink.Write(event))
+
+	assert.Same(t, event, next.events[0])
+}
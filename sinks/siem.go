@@ -0,0 +1,173 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// The following values identify audito-maldito in CEF and LEEF headers.
+const (
+	siemVendor  = "metal-toolbox"
+	siemProduct = "audito-maldito"
+)
+
+// siemEventNames are human-readable names of the event types.
+var siemEventNames = map[string]string{
+	common.ActionLoginIdentifier:     "SSH login",
+	common.ActionUserAction:          "User action",
+	common.ActionUserNetworkActivity: "User network activity",
+	common.ActionSessionTranscript:   "Session transcript",
+	common.ActionSystemAction:        "System action",
+	common.ActionAgentLifecycle:      "Agent lifecycle",
+}
+
+// siemFields are the fields of an audit event that are mapped to
+// CEF and LEEF. Empty fields are omitted from the encoded record.
+type siemFields struct {
+	eventType string
+	name      string
+	severity  int
+	outcome   string
+
+	// timestampMillis is the event's timestamp in
+	// milliseconds since the unix epoch.
+	timestampMillis int64
+
+	sourceIP   string
+	sourceHost string
+	sourcePort string
+
+	// loggedAs is the local account the user logged in as.
+	loggedAs string
+
+	// userID is the user's identity from their SSH certificate.
+	userID string
+
+	pid         string
+	host        string
+	machineID   string
+	auditID     string
+	action      string
+	object      string
+	commandLine string
+	category    string
+}
+
+// newSIEMFields extracts the fields of event that are mapped to CEF
+// and LEEF. The severity is a number from 0 to 10, which is derived
+// from the outcome and from the severity set by audit rule key
+// classification (if any).
+func newSIEMFields(event *auditevent.AuditEvent) siemFields {
+	f := siemFields{
+		eventType:       event.Type,
+		name:            siemEventNames[event.Type],
+		outcome:         event.Outcome,
+		timestampMillis: event.LoggedAt.UnixMilli(),
+		loggedAs:        knownValue(event.Subjects["loggedAs"]),
+		userID:          knownValue(event.Subjects["userID"]),
+		pid:             event.Subjects["pid"],
+		host:            event.Target["host"],
+		machineID:       event.Target["machine-id"],
+		auditID:         event.Metadata.AuditID,
+	}
+
+	if f.name == "" {
+		f.name = event.Type
+	}
+
+	if source := knownValue(event.Source.Value); source != "" {
+		if net.ParseIP(source) != nil {
+			f.sourceIP = source
+		} else {
+			f.sourceHost = source
+		}
+	}
+
+	if port, ok := event.Source.Extra["port"]; ok {
+		f.sourcePort = fmt.Sprint(port)
+	}
+
+	extra := event.Metadata.Extra
+
+	f.action = stringField(extra, "action")
+	f.commandLine = stringField(extra, "command_line")
+	f.category = stringField(extra, "category")
+
+	if object, ok := extra["object"]; ok {
+		f.object = stringField(toMap(object), "primary")
+	}
+
+	f.severity = siemSeverity(event.Outcome, stringField(extra, "severity"))
+
+	return f
+}
+
+// siemSeverity maps an outcome and an optional severity
+// name (e.g., "high") to a severity from 0 to 10.
+func siemSeverity(outcome string, severity string) int {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return 10
+	case "high":
+		return 8
+	case "medium":
+		return 5
+	case "low":
+		return 3
+	}
+
+	if outcome == auditevent.OutcomeFailed {
+		return 5
+	}
+
+	return 3
+}
+
+// knownValue returns an empty string if s is a placeholder
+// for an unknown value.
+func knownValue(s string) string {
+	if s == common.UnknownUser || s == common.UnknownAddr {
+		return ""
+	}
+
+	return s
+}
+
+// stringField returns m[key] formatted as a string. It returns
+// an empty string if the key does not exist.
+func stringField(m map[string]any, key string) string {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return ""
+	}
+
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return fmt.Sprint(v)
+}
+
+// toMap converts v (e.g., a struct) to a map by
+// encoding it to JSON and decoding it again.
+func toMap(v any) map[string]any {
+	if m, ok := v.(map[string]any); ok {
+		return m
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+
+	return m
+}
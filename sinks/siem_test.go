@@ -0,0 +1,66 @@
+package sinks
+
+import (
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+var testSIEMTime = time.Date(2023, 3, 17, 13, 37, 1, 952000000, time.UTC)
+
+// newSampleLoginEvent returns a login event with fixed values,
+// whose encodings are compared with expected strings.
+func newSampleLoginEvent() *auditevent.AuditEvent {
+	return testtools.NewEventBuilder("UserLogin").
+		WithAuditID("ffffffff-ffff-ffff-ffff-ffffffffffff").
+		WithSource(auditevent.EventSource{
+			Type:  "IP",
+			Value: "6.6.6.2",
+			Extra: map[string]any{
+				"port": "59145",
+			},
+		}).
+		WithSubjects(map[string]string{
+			"loggedAs": "core",
+			"pid":      "3076344",
+			"userID":   "user@foo.com",
+		}).
+		WithTarget(map[string]string{
+			"host":       "blam",
+			"machine-id": "deadbeef",
+		}).
+		WithLoggedAt(testSIEMTime).
+		Build()
+}
+
+func newSampleFailedLoginEvent() *auditevent.AuditEvent {
+	evt := newSampleLoginEvent()
+	evt.Outcome = auditevent.OutcomeFailed
+	evt.Subjects["userID"] = "unknown"
+	evt.Subjects["loggedAs"] = "root"
+
+	return evt
+}
+
+func newSampleActionEvent() *auditevent.AuditEvent {
+	evt := newSampleLoginEvent()
+	evt.Type = "UserAction"
+	evt.Component = "auditd"
+	evt.Metadata.AuditID = "67"
+	evt.Metadata.Extra = map[string]any{
+		"action": "executed",
+		"how":    "bash",
+		"object": aucoalesce.Object{
+			Type:    "file",
+			Primary: "/usr/bin/echo",
+		},
+		"command_line": "echo 'a=b|c\\d'\n",
+		"severity":     "high",
+		"category":     "identity",
+	}
+
+	return evt
+}
@@ -0,0 +1,109 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+// EventSink is the interface that wraps the Write method.
+//
+// Write writes an audit event to the sink. Implementations must not
+// modify the event, as it may be shared with other sinks.
+//
+// *auditevent.EventWriter implements this interface.
+type EventSink interface {
+	Write(event *auditevent.AuditEvent) error
+}
+
+var _ EventSink = &auditevent.EventWriter{}
+
+// Runner is implemented by EventSinks that deliver events in the
+// background (e.g., in batches). FanOut.Run runs such EventSinks.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// ErrorPolicy determines what happens when an Output fails to write
+// an audit event.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyFail makes the error fatal. For unbuffered outputs,
+	// the error is returned to the code that produced the event.
+	// For buffered outputs, the error is returned by FanOut.Run.
+	ErrorPolicyFail ErrorPolicy = "fail"
+
+	// ErrorPolicyLog logs the error and drops the event.
+	ErrorPolicyLog ErrorPolicy = "log"
+)
+
+// ParseErrorPolicy parses s into an ErrorPolicy.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch p := ErrorPolicy(s); p {
+	case ErrorPolicyFail, ErrorPolicyLog:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown error policy: %q", s)
+	}
+}
+
+// Filter returns true if an audit event should be written to an Output.
+type Filter func(event *auditevent.AuditEvent) bool
+
+// NewTypeFilter returns a Filter that only allows audit events whose
+// type is found in types. An empty types slice allows all events.
+func NewTypeFilter(types []string) Filter {
+	if len(types) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		allowed[t] = struct{}{}
+	}
+
+	return func(event *auditevent.AuditEvent) bool {
+		_, hasIt := allowed[event.Type]
+		return hasIt
+	}
+}
+
+// Output is a named EventSink along with the settings that control
+// how events are written to it.
+type Output struct {
+	// Name identifies the output in logs.
+	Name string
+
+	// Sink is the EventSink to write events to.
+	Sink EventSink
+
+	// Filter optionally filters the events written to Sink.
+	// A nil Filter allows all events.
+	Filter Filter
+
+	// BufferSize is the number of events that can be queued for
+	// the output. A zero value means events are written to Sink
+	// synchronously by FanOut.Write. Otherwise, events are written
+	// by FanOut.Run and events are dropped when the buffer is full
+	// (unless OnError is ErrorPolicyFail, in which case FanOut.Write
+	// blocks until there is room in the buffer).
+	BufferSize int
+
+	// OnError determines what happens when Sink fails to write
+	// an event. It defaults to ErrorPolicyFail.
+	OnError ErrorPolicy
+}
+
+func (o *Output) allows(event *auditevent.AuditEvent) bool {
+	return o.Filter == nil || o.Filter(event)
+}
+
+func (o *Output) errorPolicy() ErrorPolicy {
+	if o.OnError == "" {
+		return ErrorPolicyFail
+	}
+
+	return o.OnError
+}
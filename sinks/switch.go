@@ -0,0 +1,58 @@
+package sinks
+
+import (
+	"sync"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+var _ EventSink = &SwitchSink{}
+
+// NewSwitchSink returns a new SwitchSink that writes events to next.
+func NewSwitchSink(next EventSink) *SwitchSink {
+	return &SwitchSink{
+		next: next,
+	}
+}
+
+// SwitchSink is an EventSink whose next EventSink can be replaced while
+// events are being written (e.g., when the configuration is reloaded).
+// Events may be written concurrently.
+type SwitchSink struct {
+	mu   sync.RWMutex
+	next EventSink
+}
+
+// Write writes the event to the current EventSink.
+func (o *SwitchSink) Write(event *auditevent.AuditEvent) error {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.next.Write(event)
+}
+
+// Swap replaces the EventSink that events are written to and returns
+// the previous one. Refer to Replace for details.
+func (o *SwitchSink) Swap(next EventSink) EventSink {
+	var previous EventSink
+
+	o.Replace(func(current EventSink) EventSink {
+		previous = current
+		return next
+	})
+
+	return previous
+}
+
+// Replace calls fn with the current EventSink and replaces it with
+// the EventSink that fn returns. Writes are paused while fn runs:
+// Replace waits for in-progress writes to complete before calling fn,
+// so the previous EventSink is no longer written to once Replace
+// returns. Since fn runs while writes are paused, it may also replace
+// EventSinks that are written to by the current EventSink.
+func (o *SwitchSink) Replace(fn func(current EventSink) EventSink) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.next = fn(o.next)
+}
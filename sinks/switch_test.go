@@ -0,0 +1,54 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestSwitchSink_Swap(t *testing.T) {
+	t.Parallel()
+
+	first := &testSink{}
+	second := &testSink{}
+
+	s := NewSwitchSink(first)
+
+	require.NoError(t, s.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	assert.Same(t, first, s.Swap(second))
+
+	require.NoError(t, s.Write(testtools.NewEventBuilder("UserAction").Build()))
+	require.NoError(t, s.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	assert.Equal(t, 1, first.numEvents())
+	assert.Equal(t, 2, second.numEvents())
+}
+
+func TestSwitchSink_Replace(t *testing.T) {
+	t.Parallel()
+
+	first := &testSink{}
+	second := &testSink{}
+
+	downstream := NewSwitchSink(first)
+	upstream := NewSwitchSink(downstream)
+
+	upstream.Replace(func(current EventSink) EventSink {
+		assert.Same(t, downstream, current)
+
+		// Writes to upstream are paused, so downstream
+		// can be replaced at the same time.
+		downstream.Swap(second)
+
+		return current
+	})
+
+	require.NoError(t, upstream.Write(testtools.NewEventBuilder("UserAction").Build()))
+
+	assert.Equal(t, 0, first.numEvents())
+	assert.Equal(t, 1, second.numEvents())
+}
@@ -0,0 +1,153 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+// WebhookConfig configures a Webhook.
+type WebhookConfig struct {
+	// URL is the URL that batches of events are POSTed to.
+	URL string
+
+	// Header contains additional HTTP request headers
+	// (e.g., "Authorization").
+	Header http.Header
+
+	// Encoder encodes the events in a batch.
+	// It defaults to JSONEncoder.
+	Encoder Encoder
+
+	// Client is the HTTP client used to send requests.
+	// It defaults to a new http.Client.
+	Client *http.Client
+
+	BatchConfig
+}
+
+func (o *WebhookConfig) setDefaults() {
+	if o.Encoder == nil {
+		o.Encoder = JSONEncoder{}
+	}
+
+	if o.Client == nil {
+		o.Client = &http.Client{}
+	}
+
+	o.BatchConfig.setDefaults()
+}
+
+var (
+	_ EventSink = &Webhook{}
+	_ Runner    = &Webhook{}
+)
+
+// NewWebhook returns a new Webhook that identifies itself as name
+// in logs and metrics. The pprov argument may be nil.
+func NewWebhook(name string, config WebhookConfig, l *zap.SugaredLogger,
+	pprov *metrics.PrometheusMetricsProvider,
+) (*Webhook, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+
+	config.setDefaults()
+
+	o := &Webhook{
+		config: config,
+	}
+
+	var err error
+	o.batcher, err = newBatcher("webhook", name, config.BatchConfig,
+		lineFormat{encoder: config.Encoder}, o, l, pprov)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Webhook is an EventSink that POSTs batches of newline-delimited
+// events (JSON by default) to an HTTP endpoint. Events are delivered by the Run
+// method, which retries failed deliveries with an exponential back-off.
+// Undelivered batches are queued in memory and, optionally, on disk.
+type Webhook struct {
+	*batcher
+	config WebhookConfig
+}
+
+// send POSTs a batch to the webhook's URL.
+func (o *Webhook) send(ctx context.Context, b *eventBatch) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.URL, bytes.NewReader(b.body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	for k, v := range o.config.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", o.config.Encoder.ContentType())
+	if b.gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := o.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected http status: %s", resp.Status)
+	default:
+		return backoff.Permanent(fmt.Errorf("unexpected http status: %s", resp.Status))
+	}
+}
+
+func (o *Webhook) closeIdleConnections() {
+	o.config.Client.CloseIdleConnections()
+}
+
+// lineFormat is a batchFormat that separates the
+// events encoded by an Encoder with newlines.
+type lineFormat struct {
+	encoder Encoder
+}
+
+func (o lineFormat) appendEvent(records *bytes.Buffer, event *auditevent.AuditEvent) error {
+	line, err := o.encoder.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	records.Write(line)
+	records.WriteByte('\n')
+
+	return nil
+}
+
+func (o lineFormat) batchBody(records []byte) []byte {
+	return records
+}
+
+func (o lineFormat) spoolExt() string {
+	return o.encoder.FileExt()
+}
@@ -0,0 +1,505 @@
+package sinks
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+// testCollector is an HTTP handler that records the
+// batches of events it receives.
+type testCollector struct {
+	mu       sync.Mutex
+	batches  [][]*auditevent.AuditEvent
+	statuses []int
+	headers  []http.Header
+}
+
+// failNext makes the collector respond to the next
+// requests with the given status codes.
+func (o *testCollector) failNext(statuses ...int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.statuses = append(o.statuses, statuses...)
+}
+
+func (o *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.statuses) > 0 {
+		w.WriteHeader(o.statuses[0])
+		o.statuses = o.statuses[1:]
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+
+	var batch []*auditevent.AuditEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var event auditevent.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, &event)
+	}
+
+	o.batches = append(o.batches, batch)
+	o.headers = append(o.headers, r.Header.Clone())
+}
+
+func (o *testCollector) numEvents() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for _, batch := range o.batches {
+		n += len(batch)
+	}
+
+	return n
+}
+
+// auditIDs returns the audit IDs of the received
+// events in the order they were received.
+func (o *testCollector) auditIDs() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var ids []string
+	for _, batch := range o.batches {
+		for _, event := range batch {
+			ids = append(ids, event.Metadata.AuditID)
+		}
+	}
+
+	return ids
+}
+
+func runWebhook(t *testing.T, w *Webhook) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		_ = w.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestWebhook_Batching(t *testing.T) {
+	t.Parallel()
+
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer secret")
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL:    server.URL,
+		Header: header,
+		BatchConfig: BatchConfig{
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			Gzip:          true,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	runWebhook(t, w)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID(id).Build()))
+	}
+
+	require.Eventually(t, func() bool {
+		return collector.numEvents() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	collector.mu.Lock()
+	assert.Len(t, collector.batches, 1)
+	assert.Equal(t, "gzip", collector.headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "application/x-ndjson", collector.headers[0].Get("Content-Type"))
+	assert.Equal(t, "Bearer secret", collector.headers[0].Get("Authorization"))
+	collector.mu.Unlock()
+
+	assert.Equal(t, []string{"1", "2"}, collector.auditIDs())
+}
+
+func TestWebhook_FlushInterval(t *testing.T) {
+	t.Parallel()
+
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: server.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:     100,
+			FlushInterval: 10 * time.Millisecond,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	runWebhook(t, w)
+
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("1").Build()))
+
+	require.Eventually(t, func() bool {
+		return collector.numEvents() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	collector.mu.Lock()
+	assert.Empty(t, collector.headers[0].Get("Content-Encoding"))
+	collector.mu.Unlock()
+}
+
+func TestWebhook_RetryAndSpool(t *testing.T) {
+	t.Parallel()
+
+	collector := &testCollector{}
+	collector.failNext(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	spoolDir := t.TempDir()
+	registry := prometheus.NewRegistry()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: server.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:        1,
+			FlushInterval:    time.Hour,
+			MaxRetryInterval: 10 * time.Millisecond,
+			Gzip:             true,
+			SpoolDir:         spoolDir,
+		},
+	}, nil, metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID(id).Build()))
+	}
+
+	assert.Equal(t, 3, gaugeValue(t, registry, "audito_maldito_sink_queue_depth"))
+
+	runWebhook(t, w)
+
+	require.Eventually(t, func() bool {
+		return collector.numEvents() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"1", "2", "3"}, collector.auditIDs())
+	assert.Equal(t, 0, gaugeValue(t, registry, "audito_maldito_sink_queue_depth"))
+	assert.Equal(t, 1, testutil.CollectAndCount(registry, "audito_maldito_sink_delivery_lag_seconds"))
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWebhook_DeliversOnStop(t *testing.T) {
+	t.Parallel()
+
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	spoolDir := t.TempDir()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: server.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:     100,
+			FlushInterval: time.Hour,
+			SpoolDir:      spoolDir,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID(id).Build()))
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.NoError(t, w.Close())
+
+	// The events are delivered rather than spooled.
+	assert.Equal(t, []string{"1", "2", "3"}, collector.auditIDs())
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWebhook_SpoolsOnStopWhenDown(t *testing.T) {
+	t.Parallel()
+
+	spoolDir := t.TempDir()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: down.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:      100,
+			FlushInterval:  time.Hour,
+			RequestTimeout: time.Second,
+			SpoolDir:       spoolDir,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("1").Build()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, w.Run(ctx), context.Canceled)
+	require.NoError(t, w.Close())
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWebhook_SpoolExtension(t *testing.T) {
+	t.Parallel()
+
+	spoolDir := t.TempDir()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL:     down.URL,
+		Encoder: NewCEFEncoder(),
+		BatchConfig: BatchConfig{
+			BatchSize:      100,
+			FlushInterval:  time.Hour,
+			RequestTimeout: time.Second,
+			SpoolDir:       spoolDir,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("1").Build()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, w.Run(ctx), context.Canceled)
+	require.NoError(t, w.Close())
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ".cef", filepath.Ext(entries[0].Name()))
+}
+
+func TestWebhook_SpoolSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	spoolDir := t.TempDir()
+
+	// The endpoint is down.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: down.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			Gzip:          true,
+			SpoolDir:      spoolDir,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID(id).Build()))
+	}
+
+	require.Error(t, w.deliverQueued(context.Background()))
+	require.NoError(t, w.Close())
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	w, err = NewWebhook("test", WebhookConfig{
+		URL: server.URL,
+		BatchConfig: BatchConfig{
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			SpoolDir:      spoolDir,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("4").Build()))
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("5").Build()))
+
+	require.NoError(t, w.deliverQueued(context.Background()))
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, collector.auditIDs())
+
+	entries, err = os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWebhook_SpoolIsBounded(t *testing.T) {
+	t.Parallel()
+
+	spoolDir := t.TempDir()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: "http://127.0.0.1:1",
+		BatchConfig: BatchConfig{
+			BatchSize:        1,
+			MaxQueuedBatches: 1,
+			SpoolDir:         spoolDir,
+			SpoolMaxBytes:    1,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID(id).Build()))
+	}
+
+	// The first batch is in memory, the others do not fit in the spool.
+	assert.Equal(t, 1, w.queue.numEvents())
+
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWebhook_RejectedBatchIsDropped(t *testing.T) {
+	t.Parallel()
+
+	collector := &testCollector{}
+	collector.failNext(http.StatusBadRequest)
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	w, err := NewWebhook("test", WebhookConfig{
+		URL: server.URL,
+		BatchConfig: BatchConfig{
+			BatchSize: 1,
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("1").Build()))
+	require.NoError(t, w.Write(testtools.NewEventBuilder("UserAction").WithAuditID("2").Build()))
+
+	require.NoError(t, w.deliverQueued(context.Background()))
+
+	assert.Equal(t, []string{"2"}, collector.auditIDs())
+}
+
+func TestParseOutput_Webhook(t *testing.T) {
+	t.Parallel()
+
+	tokenFile := t.TempDir() + "/token"
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+
+	output, err := ParseOutput(context.Background(),
+		"https://collector.example.com/ingest?tenant=a&batch-size=10&gzip=false&flush-interval=1s"+
+			"&types=UserLogin&bearer-token-file="+tokenFile, OutputOptions{})
+	require.NoError(t, err)
+
+	w, ok := output.Sink.(*Webhook)
+	require.True(t, ok)
+
+	assert.Equal(t, "https://collector.example.com/ingest?tenant=a", w.config.URL)
+	assert.Equal(t, 10, w.config.BatchSize)
+	assert.Equal(t, time.Second, w.config.FlushInterval)
+	assert.False(t, w.config.Gzip)
+	assert.Equal(t, "Bearer secret", w.config.Header.Get("Authorization"))
+	assert.True(t, strings.HasPrefix(output.Name, "https://collector.example.com/ingest"))
+
+	output, err = ParseOutput(context.Background(), "http://example.com?format=leef", OutputOptions{})
+	require.NoError(t, err)
+	assert.IsType(t, &LEEFEncoder{}, output.Sink.(*Webhook).config.Encoder)
+	assert.Equal(t, "http://example.com", output.Sink.(*Webhook).config.URL)
+
+	_, err = ParseOutput(context.Background(), "http://example.com?batch-size=abc", OutputOptions{})
+	assert.Error(t, err)
+}
+
+func gaugeValue(t *testing.T, registry *prometheus.Registry, name string) int {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() == name {
+			return int(family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+
+	t.Fatalf("metric %q not found", name)
+
+	return 0
+}
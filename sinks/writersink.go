@@ -0,0 +1,57 @@
+package sinks
+
+import (
+	"io"
+
+	"github.com/metal-toolbox/auditevent"
+)
+
+var (
+	_ EventSink = &WriterSink{}
+	_ Reopener  = &WriterSink{}
+)
+
+// NewWriterSink returns a WriterSink that writes JSON-encoded
+// audit events to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		EventWriter: auditevent.NewDefaultAuditEventWriter(w),
+		w:           w,
+	}
+}
+
+// NewEncodingWriterSink returns a WriterSink that writes audit events
+// encoded by enc to w. Each record is followed by a newline.
+func NewEncodingWriterSink(w io.Writer, enc Encoder) *WriterSink {
+	return &WriterSink{
+		EventWriter: auditevent.NewAuditEventWriter(newLineEncoder(w, enc)),
+		w:           w,
+	}
+}
+
+// WriterSink is an EventSink that encodes audit events to an io.Writer,
+// such as a file or a network connection.
+type WriterSink struct {
+	*auditevent.EventWriter
+	w io.Writer
+}
+
+// Reopen reopens the underlying io.Writer if it implements Reopener.
+func (o *WriterSink) Reopen() error {
+	reopener, ok := o.w.(Reopener)
+	if !ok {
+		return nil
+	}
+
+	return reopener.Reopen()
+}
+
+// Close closes the underlying io.Writer if it implements io.Closer.
+func (o *WriterSink) Close() error {
+	closer, ok := o.w.(io.Closer)
+	if !ok {
+		return nil
+	}
+
+	return closer.Close()
+}